@@ -0,0 +1,173 @@
+package redwood
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"redwood.dev/crypto"
+	"redwood.dev/tree"
+	"redwood.dev/types"
+)
+
+// CapabilityToken grants its audience the right to write to the given
+// keypaths (and everything beneath them) until it expires or is revoked.  A
+// token can be delegated further by issuing a new token with the original as
+// its Proof.  Each delegation can only narrow the rights that it was given:
+// its keypaths must lie beneath its proof's keypaths, and it can't outlive its
+// proof.
+//
+// Expiry isn't checked against the local clock, which nodes can't be trusted
+// to agree on, but against a time kept in the state (see
+// CapabilitiesClockKeypath).  Revocations are part of the state too, so every
+// node agrees on both.
+//
+// Keypaths are relative to the subtree governed by the validator/capabilities
+// that checks the token.
+type CapabilityToken struct {
+	Issuer   types.Address    `json:"issuer"`
+	Audience types.Address    `json:"audience"`
+	Keypaths []string         `json:"keypaths"`
+	Expires  int64            `json:"expires,omitempty"` // Unix seconds, or 0 for never
+	Proof    *CapabilityToken `json:"proof,omitempty"`
+	Sig      types.Signature  `json:"sig"`
+}
+
+// maxCapabilityChainLength limits how many times a capability can be
+// delegated.
+const maxCapabilityChainLength = 16
+
+var (
+	ErrInvalidCapability = errors.New("invalid capability")
+)
+
+// NewCapabilityToken creates and signs a token granting the audience write
+// access to the given keypaths.  To delegate a capability that the issuer
+// holds, pass it as the proof.  A zero expiry means that the token never
+// expires.
+func NewCapabilityToken(issuer *crypto.SigningKeypair, audience types.Address, keypaths []tree.Keypath, expires time.Time, proof *CapabilityToken) (CapabilityToken, error) {
+	token := CapabilityToken{
+		Issuer:   issuer.Address(),
+		Audience: audience,
+		Proof:    proof,
+	}
+	for _, keypath := range keypaths {
+		token.Keypaths = append(token.Keypaths, string(keypath))
+	}
+	if !expires.IsZero() {
+		token.Expires = expires.Unix()
+	}
+
+	sig, err := issuer.SignHash(token.Hash())
+	if err != nil {
+		return CapabilityToken{}, err
+	}
+	token.Sig = sig
+	return token, nil
+}
+
+// Hash returns the hash that the issuer signs.  It covers the proof's hash, so
+// a token can't be moved onto a different chain.
+func (token CapabilityToken) Hash() types.Hash {
+	var bs []byte
+	bs = append(bs, token.Issuer[:]...)
+	bs = append(bs, token.Audience[:]...)
+	for _, keypath := range token.Keypaths {
+		bs = append(bs, []byte(keypath)...)
+		bs = append(bs, 0)
+	}
+	var expires [8]byte
+	binary.BigEndian.PutUint64(expires[:], uint64(token.Expires))
+	bs = append(bs, expires[:]...)
+	if token.Proof != nil {
+		proofHash := token.Proof.Hash()
+		bs = append(bs, proofHash[:]...)
+	}
+	return types.HashBytes(bs)
+}
+
+// ID identifies the token in revocation lists.
+func (token CapabilityToken) ID() string {
+	return token.Hash().Hex()
+}
+
+func (token CapabilityToken) Copy() CapabilityToken {
+	cp := token
+	cp.Keypaths = append([]string(nil), token.Keypaths...)
+	cp.Sig = token.Sig.Copy()
+	if token.Proof != nil {
+		proof := token.Proof.Copy()
+		cp.Proof = &proof
+	}
+	return cp
+}
+
+// Covers returns true if the token grants write access to the given keypath.
+func (token CapabilityToken) Covers(keypath tree.Keypath) bool {
+	for _, kp := range token.Keypaths {
+		if keypath.StartsWith(tree.Keypath(kp)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Chain returns the token followed by each of its proofs, ending with the
+// token issued by a root.
+func (token CapabilityToken) Chain() []CapabilityToken {
+	var chain []CapabilityToken
+	for t := &token; t != nil; t = t.Proof {
+		chain = append(chain, *t)
+	}
+	return chain
+}
+
+// Verify checks that the token and each of its proofs is signed by its issuer,
+// unexpired as of now, and no broader or longer-lived than the token that it
+// was delegated from, and that the chain was started by the given root.  It
+// doesn't check revocations.
+func (token CapabilityToken) Verify(root types.Address, now time.Time) error {
+	chain := token.Chain()
+	if len(chain) > maxCapabilityChainLength {
+		return errors.Wrapf(ErrInvalidCapability, "chain is longer than %v tokens", maxCapabilityChainLength)
+	}
+
+	for i, t := range chain {
+		pubkey, err := crypto.RecoverSigningPubkey(t.Hash(), t.Sig)
+		if err != nil {
+			return errors.Wrapf(ErrInvalidCapability, "bad signature: %v", err)
+		} else if pubkey.Address() != t.Issuer {
+			return errors.Wrapf(ErrInvalidCapability, "token %v is not signed by its issuer", t.ID())
+		}
+
+		if t.Expires != 0 && now.Unix() >= t.Expires {
+			return errors.Wrapf(ErrInvalidCapability, "token %v has expired", t.ID())
+		}
+
+		if i == len(chain)-1 {
+			if t.Issuer != root {
+				return errors.Wrapf(ErrInvalidCapability, "chain was not started by %v", root.Hex())
+			}
+			continue
+		}
+
+		proof := chain[i+1]
+		if proof.Audience != t.Issuer {
+			return errors.Wrapf(ErrInvalidCapability, "token %v was issued by %v, who doesn't hold its proof", t.ID(), t.Issuer.Hex())
+		} else if proof.Expires != 0 && (t.Expires == 0 || t.Expires > proof.Expires) {
+			return errors.Wrapf(ErrInvalidCapability, "token %v outlives its proof", t.ID())
+		}
+		for _, kp := range t.Keypaths {
+			if !proof.Covers(tree.Keypath(kp)) {
+				return errors.Wrapf(ErrInvalidCapability, "token %v grants '%v', which its proof doesn't", t.ID(), kp)
+			}
+		}
+	}
+	return nil
+}
+
+func (token CapabilityToken) Equal(other CapabilityToken) bool {
+	return token.Hash() == other.Hash() && bytes.Equal(token.Sig, other.Sig)
+}
@@ -24,6 +24,7 @@ import (
 	"redwood.dev/ctx"
 	"redwood.dev/identity"
 	"redwood.dev/tree"
+	"redwood.dev/types"
 	"redwood.dev/utils"
 )
 
@@ -147,7 +148,7 @@ func run(configPath, passwordFile string, gui, dev bool) (err error) {
 		keyStore      = identity.NewBadgerKeyStore(db, identity.DefaultScryptParams)
 		refStore      = rw.NewRefStore(config.RefDataRoot())
 		peerStore     = rw.NewPeerStore(db)
-		controllerHub = rw.NewControllerHub(config.StateDBRoot(), txStore, refStore)
+		controllerHub = rw.NewControllerHub(config.StateDBRoot(), txStore, refStore, config.Node.States)
 	)
 
 	var transports []rw.Transport
@@ -417,6 +418,76 @@ var replCommands = map[string]struct {
 			return nil
 		},
 	},
+	"versions": {
+		"list the saved versions of a state URI and the space they use",
+		func(ctx context.Context, args []string, host rw.Host) error {
+			if len(args) < 1 {
+				return errors.New("missing argument: state URI")
+			}
+
+			versions, err := host.Controllers().Versions(args[0])
+			if err != nil {
+				return err
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
+			defer w.Flush()
+			fmt.Fprintf(w, "Version\tSaved\tKeys\tBytes\n")
+			for _, v := range versions {
+				fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", v.Version.Hex(), time.Now().Sub(v.SavedAt).String(), v.NumKeys, v.Size)
+			}
+			return nil
+		},
+	},
+	"blame": {
+		"show which txs last touched a keypath in a state URI",
+		func(ctx context.Context, args []string, host rw.Host) error {
+			if len(args) < 1 {
+				return errors.New("missing argument: state URI")
+			}
+
+			var keypath tree.Keypath
+			if len(args) > 1 {
+				var err error
+				_, keypath, _, err = rw.ParsePatchPath([]byte(args[1]))
+				if err != nil {
+					return err
+				}
+			}
+
+			provenance, err := host.Controllers().Provenance(args[0], keypath, 0, 0)
+			if err != nil {
+				return err
+			}
+
+			records := provenance.History
+			if len(records) == 0 {
+				records = []rw.ProvenanceRecord{provenance.Last}
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
+			defer w.Flush()
+			fmt.Fprintf(w, "Tx\tFrom\tApplied\tDeleted\n")
+			for _, record := range records {
+				fmt.Fprintf(w, "%v\t%v\t%v\t%v\n", record.TxID.Hex(), record.From.Hex(), record.Time.Local().Format(time.RFC3339), record.Deleted)
+			}
+			return nil
+		},
+	},
+	"compact": {
+		"replace the history of a state URI up to the given tx with a snapshot",
+		func(ctx context.Context, args []string, host rw.Host) error {
+			if len(args) < 2 {
+				return errors.New("missing arguments: state URI, tx ID")
+			}
+
+			txID, err := types.IDFromHex(args[1])
+			if err != nil {
+				return err
+			}
+			return host.CompactHistory(args[0], txID)
+		},
+	},
 	"peers": {
 		"list all known peers",
 		func(ctx context.Context, args []string, host redwood.Host) error {
@@ -10,6 +10,7 @@ import (
 
 	"gopkg.in/yaml.v3"
 
+	"redwood.dev/types"
 	"redwood.dev/utils"
 )
 
@@ -29,6 +30,54 @@ type NodeConfig struct {
 	MaxPeersPerSubscription uint64          `yaml:"MaxPeersPerSubscription"`
 	DataRoot                string          `yaml:"DataRoot"`
 	DevMode                 bool            `yaml:"DevMode"`
+	States                  StatesConfig    `yaml:"States"`
+}
+
+type StatesConfig struct {
+	Default   StateConfig            `yaml:"Default"`
+	StateURIs map[string]StateConfig `yaml:"StateURIs"`
+}
+
+// StateConfig controls when a state URI's state is checkpointed, how long
+// old versions are kept around, how many txs can wait in its mempool, when
+// the host merges its leaves, how much provenance is kept, and whose
+// snapshots are trusted.  Zero values disable the corresponding behavior.
+type StateConfig struct {
+	// Checkpoint the state after every N txs
+	CheckpointEveryNTxs uint64 `yaml:"CheckpointEveryNTxs"`
+	// Checkpoint the state on the first tx after this much time has passed
+	// since the last checkpoint
+	CheckpointInterval Duration `yaml:"CheckpointInterval"`
+	// Delete the oldest versions when there are more than this many
+	MaxVersions uint64 `yaml:"MaxVersions"`
+	// Delete versions that are older than this
+	MaxVersionAge Duration `yaml:"MaxVersionAge"`
+	// Evict the longest-waiting txs when more than this many are waiting in
+	// the mempool
+	MempoolMaxTxs uint64 `yaml:"MempoolMaxTxs"`
+	// Evict txs that have waited in the mempool for longer than this
+	MempoolTxTTL Duration `yaml:"MempoolTxTTL"`
+	// Send an empty tx merging all of the leaves when there are more than
+	// this many
+	MaxLeaves uint64 `yaml:"MaxLeaves"`
+	// Send an empty tx merging all of the leaves when there has been more
+	// than one for this long
+	MaxLeafAge Duration `yaml:"MaxLeafAge"`
+	// Remember every tx that touched each keypath, rather than only the most
+	// recent one
+	KeepProvenanceHistory bool `yaml:"KeepProvenanceHistory"`
+	// Accept a snapshot in place of the history before it only if it's signed
+	// by one of these addresses
+	SnapshotSigners []types.Address `yaml:"SnapshotSigners"`
+}
+
+// ForStateURI returns the settings for the given state URI.  Entries in
+// StateURIs replace the default settings entirely.
+func (c StatesConfig) ForStateURI(stateURI string) StateConfig {
+	if config, exists := c.StateURIs[stateURI]; exists {
+		return config
+	}
+	return c.Default
 }
 
 type BootstrapPeer struct {
@@ -22,24 +22,107 @@ type Indexer interface {
 	IndexNode(relKeypath tree.Keypath, state tree.Node) (tree.Keypath, tree.Node, error)
 }
 
+// View computes the value of a derived subtree from the current value of its
+// source subtree.  See ViewsKeypath.
+type View interface {
+	ComputeView(source tree.Node) (interface{}, error)
+}
+
+// IncrementalView may be implemented by a View that can bring its value up to
+// date from just the entries of its source that changed, rather than reading
+// the whole source.  The old and new maps hold the values of the changed
+// entries (keyed by their keys in the source) before and after the change.
+// An entry that's missing from one of them didn't exist at that point.  If ok
+// is false, the view is computed from the whole source instead.
+type IncrementalView interface {
+	UpdateView(current interface{}, old, new map[string]interface{}) (_ interface{}, ok bool)
+}
+
+// IndexKeyEncoder may be implemented by an Indexer that transforms the values
+// it indexes (for instance, to make them sort correctly).  It's used to
+// convert the bounds of range queries into the same form.
+type IndexKeyEncoder interface {
+	EncodeIndexKey(key tree.Keypath) (tree.Keypath, error)
+}
+
+// ResolverMigrator may be implemented by a Resolver that knows how to take
+// over from a resolver of a different type.  When the Content-Type of a
+// Merge-Type node changes, the controller hands the old resolver's internal
+// state to the new resolver via MigrateFrom.  Resolvers that don't implement
+// it have their internal state rebuilt by replaying the subtree's history.
+type ResolverMigrator interface {
+	MigrateFrom(oldContentType string, oldInternalState map[string]interface{}, state tree.Node) error
+}
+
+// ReadPermissioner may be implemented by a Validator that also restricts who
+// can read the subtree it's attached to.  The state is the current state of
+// that subtree, and the keypath is relative to it.  Being allowed to read a
+// keypath means being allowed to read everything beneath it, down to the next
+// ReadPermissioner.
+type ReadPermissioner interface {
+	CanRead(state tree.Node, readers []types.Address, keypath tree.Keypath) bool
+}
+
+// StatelessResolver may be implemented by a Resolver that keeps no internal
+// state, so that the controller doesn't bother replaying history through it
+// to catch it up.
+type StatelessResolver interface {
+	Stateless() bool
+}
+
+// TxParentsLookup returns the parents of the given tx.
+type TxParentsLookup func(txID types.ID) ([]types.ID, error)
+
+// TxDAGResolver may be implemented by a Resolver that needs to know how the
+// txs that it resolves are related to each other.  A resolver only sees the
+// txs that touch its subtree, so the parents of those txs may be txs that it
+// has never seen.  The controller gives it a way to look up the parents of any
+// tx, so that it can find the nearest ancestors that it has seen.
+type TxDAGResolver interface {
+	SetTxParentsLookup(lookup TxParentsLookup)
+}
+
 type ResolverConstructor func(config tree.Node, internalState map[string]interface{}) (Resolver, error)
 type ValidatorConstructor func(config tree.Node) (Validator, error)
 type IndexerConstructor func(config tree.Node) (Indexer, error)
+type ViewConstructor func(config tree.Node) (View, error)
 
 var resolverRegistry = map[string]ResolverConstructor{
-	"resolver/dumb": NewDumbResolver,
-	"resolver/lua":  NewLuaResolver,
-	"resolver/js":   NewJSResolver,
+	"resolver/dumb":  NewDumbResolver,
+	"resolver/lua":   NewLuaResolver,
+	"resolver/js":    NewJSResolver,
+	"resolver/sync9": NewSync9Resolver,
+	"resolver/wasm":  NewWASMResolver,
 	// "resolver/git":  NewGitResolver,
-	//"resolver/stack": NewStackResolver,
 }
 var validatorRegistry = map[string]ValidatorConstructor{
-	"validator/permissions": NewPermissionsValidator,
-	// "stack":       NewStackValidator,
+	"validator/permissions":  NewPermissionsValidator,
+	"validator/roles":        NewRolesValidator,
+	"validator/capabilities": NewCapabilitiesValidator,
+	"validator/multisig":     NewMultisigValidator,
+	"validator/js":           NewJSValidator,
+	"validator/jsonschema":   NewJSONSchemaValidator,
+	"validator/lua":          NewLuaValidator,
+	"validator/wasm":         NewWASMValidator,
 }
 var indexerRegistry = map[string]IndexerConstructor{
 	"indexer/keypath": NewKeypathIndexer,
 	"indexer/js":      NewJSIndexer,
+	"indexer/wasm":    NewWASMIndexer,
+}
+var viewRegistry = map[string]ViewConstructor{
+	"view/count":    NewCountView,
+	"view/sum":      NewSumView,
+	"view/group-by": NewGroupByView,
+	"view/js":       NewJSView,
+	"view/lua":      NewLuaView,
+}
+
+func init() {
+	// The stack behaviors look up their children in these registries, so they
+	// have to be registered after initialization to avoid a cycle.
+	resolverRegistry["resolver/stack"] = NewStackResolver
+	validatorRegistry["validator/stack"] = NewStackValidator
 }
 
 type behaviorTree struct {
@@ -48,15 +131,26 @@ type behaviorTree struct {
 	validators        map[string]Validator
 	resolverKeypaths  []tree.Keypath
 	resolvers         map[string]Resolver
+	resolverTypes     map[string]string
 	indexers          map[string]map[string]Indexer
+	views             map[string]map[string]*materializedView
+}
+
+// materializedView is a View along with the keypath of its source, relative
+// to the node that the view is attached to.
+type materializedView struct {
+	View
+	source tree.Keypath
 }
 
 func newBehaviorTree() *behaviorTree {
 	return &behaviorTree{
-		Logger:     ctx.NewLogger(""),
-		validators: make(map[string]Validator),
-		resolvers:  make(map[string]Resolver),
-		indexers:   make(map[string]map[string]Indexer),
+		Logger:        ctx.NewLogger(""),
+		validators:    make(map[string]Validator),
+		resolvers:     make(map[string]Resolver),
+		resolverTypes: make(map[string]string),
+		indexers:      make(map[string]map[string]Indexer),
+		views:         make(map[string]map[string]*materializedView),
 	}
 }
 
@@ -66,7 +160,9 @@ func (t *behaviorTree) copy() *behaviorTree {
 		validators:        make(map[string]Validator, len(t.validators)),
 		resolverKeypaths:  make([]tree.Keypath, len(t.resolverKeypaths)),
 		resolvers:         make(map[string]Resolver, len(t.resolvers)),
+		resolverTypes:     make(map[string]string, len(t.resolverTypes)),
 		indexers:          make(map[string]map[string]Indexer, len(t.indexers)),
+		views:             make(map[string]map[string]*materializedView, len(t.views)),
 	}
 	for i, v := range t.validatorKeypaths {
 		cp.validatorKeypaths[i] = v
@@ -80,12 +176,21 @@ func (t *behaviorTree) copy() *behaviorTree {
 	for k, v := range t.resolvers {
 		cp.resolvers[k] = v
 	}
+	for k, v := range t.resolverTypes {
+		cp.resolverTypes[k] = v
+	}
 	for k, v := range t.indexers {
 		cp.indexers[k] = make(map[string]Indexer, len(t.indexers[k]))
 		for kk, vv := range v {
 			cp.indexers[k][kk] = vv
 		}
 	}
+	for k, v := range t.views {
+		cp.views[k] = make(map[string]*materializedView, len(t.views[k]))
+		for kk, vv := range v {
+			cp.views[k][kk] = vv
+		}
+	}
 	return cp
 }
 
@@ -105,13 +210,14 @@ func (t *behaviorTree) debugPrint() {
 	}
 }
 
-func (t *behaviorTree) addResolver(keypath tree.Keypath, resolver Resolver) {
+func (t *behaviorTree) addResolver(keypath tree.Keypath, contentType string, resolver Resolver) {
 	if _, exists := t.resolvers[string(keypath)]; !exists {
 		t.resolverKeypaths = append(t.resolverKeypaths, keypath)
 		// @@TODO: sucks
 		sort.Slice(t.resolverKeypaths, func(i, j int) bool { return bytes.Compare(t.resolverKeypaths[i], t.resolverKeypaths[j]) < 0 })
 	}
 	t.resolvers[string(keypath)] = resolver
+	t.resolverTypes[string(keypath)] = contentType
 }
 
 func (t *behaviorTree) removeResolver(keypath tree.Keypath) {
@@ -119,6 +225,7 @@ func (t *behaviorTree) removeResolver(keypath tree.Keypath) {
 		return
 	}
 	delete(t.resolvers, string(keypath))
+	delete(t.resolverTypes, string(keypath))
 	var idx int
 	for i, kp := range t.resolverKeypaths {
 		if kp.Equals(keypath) {
@@ -169,6 +276,34 @@ func (t *behaviorTree) removeIndexer(keypath tree.Keypath, indexName tree.Keypat
 	delete(t.indexers[string(keypath)], string(indexName))
 }
 
+func (t *behaviorTree) addView(keypath tree.Keypath, viewName tree.Keypath, view *materializedView) {
+	if _, exists := t.views[string(keypath)]; !exists {
+		t.views[string(keypath)] = make(map[string]*materializedView)
+	}
+	t.views[string(keypath)][string(viewName)] = view
+}
+
+func (t *behaviorTree) removeView(keypath tree.Keypath, viewName tree.Keypath) {
+	if _, exists := t.views[string(keypath)]; !exists {
+		return
+	}
+	delete(t.views[string(keypath)], string(viewName))
+}
+
+// viewContainingKeypath returns the keypath of the view that the given
+// keypath lies within, if any.
+func (t *behaviorTree) viewContainingKeypath(keypath tree.Keypath) (tree.Keypath, bool) {
+	for kp, views := range t.views {
+		for viewName := range views {
+			viewKeypath := tree.Keypath(kp).Push(tree.Keypath(viewName))
+			if keypath.StartsWith(viewKeypath) {
+				return viewKeypath, true
+			}
+		}
+	}
+	return nil, false
+}
+
 func (t *behaviorTree) nearestResolverForKeypath(keypath tree.Keypath) (Resolver, tree.Keypath) {
 	for i := len(t.resolverKeypaths) - 1; i >= 0; i-- {
 		kp := t.resolverKeypaths[i]
@@ -188,3 +323,29 @@ func (t *behaviorTree) nearestValidatorForKeypath(keypath tree.Keypath) (Validat
 	}
 	return nil, nil
 }
+
+func (t *behaviorTree) nearestReadPermissionerForKeypath(keypath tree.Keypath) (ReadPermissioner, tree.Keypath) {
+	for i := len(t.validatorKeypaths) - 1; i >= 0; i-- {
+		kp := t.validatorKeypaths[i]
+		if keypath.StartsWith(kp) {
+			if rp, is := t.validators[string(kp)].(ReadPermissioner); is {
+				return rp, kp
+			}
+		}
+	}
+	return nil, nil
+}
+
+// readPermissionerKeypathsBelow returns the keypaths of the ReadPermissioners
+// strictly beneath the given keypath.
+func (t *behaviorTree) readPermissionerKeypathsBelow(keypath tree.Keypath) []tree.Keypath {
+	var keypaths []tree.Keypath
+	for _, kp := range t.validatorKeypaths {
+		if kp.StartsWith(keypath) && !kp.Equals(keypath) {
+			if _, is := t.validators[string(kp)].(ReadPermissioner); is {
+				keypaths = append(keypaths, kp)
+			}
+		}
+	}
+	return keypaths
+}
@@ -17,6 +17,8 @@ type ControllerHub interface {
 	Close()
 
 	AddTx(tx *Tx, force bool) error
+	AddTxs(stateURI string, txs []*Tx) error
+	SimulateTx(tx *Tx, keypath tree.Keypath) (*TxSimulation, error)
 	FetchTx(stateURI string, txID types.ID) (*Tx, error)
 	FetchTxs(stateURI string, fromTxID types.ID) TxIterator
 	HaveTx(stateURI string, txID types.ID) (bool, error)
@@ -25,15 +27,25 @@ type ControllerHub interface {
 	KnownStateURIs() ([]string, error)
 	StateAtVersion(stateURI string, version *types.ID) (tree.Node, error)
 	QueryIndex(stateURI string, version *types.ID, keypath tree.Keypath, indexName tree.Keypath, queryParam tree.Keypath, rng *tree.Range) (tree.Node, error)
+	QueryIndexRange(stateURI string, version *types.ID, keypath tree.Keypath, indexName tree.Keypath, query IndexRangeQuery) (tree.Node, string, error)
+	Provenance(stateURI string, keypath tree.Keypath, historyStart, historyLimit uint64) (*Provenance, error)
 	Leaves(stateURI string) ([]types.ID, error)
+	Versions(stateURI string) ([]VersionInfo, error)
+	Compact(stateURI string, snapshot *Tx) error
+	MissingParents(stateURI string) (map[types.ID][]types.ID, error)
+	TxLocator(stateURI string) ([]types.ID, error)
+	TxsMissingFromLocator(stateURI string, locator []types.ID) ([]*Tx, error)
 
 	IsPrivate(stateURI string) (bool, error)
 	IsMember(stateURI string, addr types.Address) (bool, error)
 	Members(stateURI string) ([]types.Address, error)
+	CanRead(stateURI string, keypath tree.Keypath, readers []types.Address) (bool, error)
+	ReadableState(stateURI string, state tree.Node, keypath tree.Keypath, readers []types.Address) (tree.Node, error)
 
 	RefObjectReader(refID types.RefID) (io.ReadCloser, int64, error)
 
-	OnNewState(fn func(tx *Tx, state tree.Node, leaves []types.ID))
+	OnNewState(fn func(txs []*Tx, state tree.Node, leaves []types.ID))
+	OnMissingParents(fn func(tx *Tx, parentIDs []types.ID))
 }
 
 type controllerHub struct {
@@ -45,23 +57,28 @@ type controllerHub struct {
 	txStore       TxStore
 	refStore      RefStore
 	dbRootPath    string
+	statesConfig  StatesConfig
 
-	newStateListeners   []func(tx *Tx, state tree.Node, leaves []types.ID)
+	newStateListeners   []func(txs []*Tx, state tree.Node, leaves []types.ID)
 	newStateListenersMu sync.RWMutex
+
+	missingParentsListeners   []func(tx *Tx, parentIDs []types.ID)
+	missingParentsListenersMu sync.RWMutex
 }
 
 var (
 	ErrNoController = errors.New("no controller for that stateURI")
 )
 
-func NewControllerHub(dbRootPath string, txStore TxStore, refStore RefStore) ControllerHub {
+func NewControllerHub(dbRootPath string, txStore TxStore, refStore RefStore, statesConfig StatesConfig) ControllerHub {
 	return &controllerHub{
-		Logger:      ctx.NewLogger("controller hub"),
-		chStop:      make(chan struct{}),
-		controllers: make(map[string]Controller),
-		dbRootPath:  dbRootPath,
-		txStore:     txStore,
-		refStore:    refStore,
+		Logger:       ctx.NewLogger("controller hub"),
+		chStop:       make(chan struct{}),
+		controllers:  make(map[string]Controller),
+		dbRootPath:   dbRootPath,
+		txStore:      txStore,
+		refStore:     refStore,
+		statesConfig: statesConfig,
 	}
 }
 
@@ -105,12 +122,13 @@ func (m *controllerHub) EnsureController(stateURI string) (Controller, error) {
 	if ctrl == nil {
 		// Set up the controller
 		var err error
-		ctrl, err = NewController(stateURI, m.dbRootPath, m, m.txStore, m.refStore)
+		ctrl, err = NewController(stateURI, m.dbRootPath, m, m.txStore, m.refStore, m.statesConfig.ForStateURI(stateURI))
 		if err != nil {
 			return nil, err
 		}
 
 		ctrl.OnNewState(m.notifyNewStateListeners)
+		ctrl.OnMissingParents(m.notifyMissingParentsListeners)
 
 		err = ctrl.Start()
 		if err != nil {
@@ -146,6 +164,46 @@ func (m *controllerHub) AddTx(tx *Tx, force bool) error {
 	return ctrl.AddTx(tx, force)
 }
 
+func (m *controllerHub) AddTxs(stateURI string, txs []*Tx) error {
+	for _, tx := range txs {
+		if tx.StateURI != stateURI {
+			return errors.Errorf("tx %v belongs to %v, not %v", tx.ID.Pretty(), tx.StateURI, stateURI)
+		} else if tx.IsPrivate() {
+			parts := strings.Split(tx.StateURI, "/")
+			if parts[len(parts)-1] != tx.PrivateRootKey() {
+				return errors.Wrapf(ErrInvalidPrivateRootKey, "got %v, expected %v", parts[len(parts)-1], tx.PrivateRootKey())
+			}
+		}
+	}
+
+	ctrl, err := m.EnsureController(stateURI)
+	if err != nil {
+		return err
+	}
+	return ctrl.AddTxs(txs)
+}
+
+// SimulateTx reports what would happen if the tx were applied to the current
+// state of its state URI, without changing anything (see
+// Controller.SimulateTx).
+func (m *controllerHub) SimulateTx(tx *Tx, keypath tree.Keypath) (*TxSimulation, error) {
+	if tx.IsPrivate() {
+		parts := strings.Split(tx.StateURI, "/")
+		if parts[len(parts)-1] != tx.PrivateRootKey() {
+			return &TxSimulation{Err: errors.Wrapf(ErrInvalidPrivateRootKey, "got %v, expected %v", parts[len(parts)-1], tx.PrivateRootKey())}, nil
+		}
+	}
+
+	m.controllersMu.RLock()
+	defer m.controllersMu.RUnlock()
+
+	ctrl := m.controllers[tx.StateURI]
+	if ctrl == nil {
+		return nil, errors.Wrapf(ErrNoController, tx.StateURI)
+	}
+	return ctrl.SimulateTx(tx, keypath)
+}
+
 func (m *controllerHub) FetchTxs(stateURI string, fromTxID types.ID) TxIterator {
 	return m.txStore.AllTxsForStateURI(stateURI, fromTxID)
 }
@@ -173,7 +231,7 @@ func (m *controllerHub) StateAtVersion(stateURI string, version *types.ID) (tree
 	if ctrl == nil {
 		return nil, errors.Wrapf(ErrNoController, stateURI)
 	}
-	return ctrl.StateAtVersion(version), nil
+	return ctrl.StateAtVersion(version)
 }
 
 func (m *controllerHub) QueryIndex(stateURI string, version *types.ID, keypath tree.Keypath, indexName tree.Keypath, queryParam tree.Keypath, rng *tree.Range) (tree.Node, error) {
@@ -187,6 +245,61 @@ func (m *controllerHub) QueryIndex(stateURI string, version *types.ID, keypath t
 	return ctrl.QueryIndex(version, keypath, indexName, queryParam, rng)
 }
 
+func (m *controllerHub) Provenance(stateURI string, keypath tree.Keypath, historyStart, historyLimit uint64) (*Provenance, error) {
+	m.controllersMu.RLock()
+	defer m.controllersMu.RUnlock()
+
+	ctrl := m.controllers[stateURI]
+	if ctrl == nil {
+		return nil, errors.Wrapf(ErrNoController, stateURI)
+	}
+	return ctrl.Provenance(keypath, historyStart, historyLimit)
+}
+
+func (m *controllerHub) QueryIndexRange(stateURI string, version *types.ID, keypath tree.Keypath, indexName tree.Keypath, query IndexRangeQuery) (tree.Node, string, error) {
+	m.controllersMu.RLock()
+	defer m.controllersMu.RUnlock()
+
+	ctrl := m.controllers[stateURI]
+	if ctrl == nil {
+		return nil, "", errors.Wrapf(ErrNoController, stateURI)
+	}
+	return ctrl.QueryIndexRange(version, keypath, indexName, query)
+}
+
+func (m *controllerHub) Versions(stateURI string) ([]VersionInfo, error) {
+	m.controllersMu.RLock()
+	defer m.controllersMu.RUnlock()
+
+	ctrl := m.controllers[stateURI]
+	if ctrl == nil {
+		return nil, errors.Wrapf(ErrNoController, stateURI)
+	}
+	return ctrl.Versions()
+}
+
+func (m *controllerHub) Compact(stateURI string, snapshot *Tx) error {
+	m.controllersMu.RLock()
+	defer m.controllersMu.RUnlock()
+
+	ctrl := m.controllers[stateURI]
+	if ctrl == nil {
+		return errors.Wrapf(ErrNoController, stateURI)
+	}
+	return ctrl.Compact(snapshot)
+}
+
+func (m *controllerHub) MissingParents(stateURI string) (map[types.ID][]types.ID, error) {
+	m.controllersMu.RLock()
+	defer m.controllersMu.RUnlock()
+
+	ctrl := m.controllers[stateURI]
+	if ctrl == nil {
+		return nil, errors.Wrapf(ErrNoController, stateURI)
+	}
+	return ctrl.MissingParents()
+}
+
 func (m *controllerHub) RefObjectReader(refID types.RefID) (io.ReadCloser, int64, error) {
 	return m.refStore.Object(refID)
 }
@@ -195,6 +308,14 @@ func (m *controllerHub) Leaves(stateURI string) ([]types.ID, error) {
 	return m.txStore.Leaves(stateURI)
 }
 
+func (m *controllerHub) TxLocator(stateURI string) ([]types.ID, error) {
+	return buildTxLocator(m.txStore, stateURI)
+}
+
+func (m *controllerHub) TxsMissingFromLocator(stateURI string, locator []types.ID) ([]*Tx, error) {
+	return txsMissingFromLocator(m.txStore, stateURI, locator)
+}
+
 func (m *controllerHub) IsPrivate(stateURI string) (bool, error) {
 	m.controllersMu.RLock()
 	defer m.controllersMu.RUnlock()
@@ -217,6 +338,28 @@ func (m *controllerHub) IsMember(stateURI string, addr types.Address) (bool, err
 	return ctrl.IsMember(addr)
 }
 
+func (m *controllerHub) CanRead(stateURI string, keypath tree.Keypath, readers []types.Address) (bool, error) {
+	m.controllersMu.RLock()
+	defer m.controllersMu.RUnlock()
+
+	ctrl := m.controllers[stateURI]
+	if ctrl == nil {
+		return false, errors.Wrapf(ErrNoController, stateURI)
+	}
+	return ctrl.CanRead(keypath, readers), nil
+}
+
+func (m *controllerHub) ReadableState(stateURI string, state tree.Node, keypath tree.Keypath, readers []types.Address) (tree.Node, error) {
+	m.controllersMu.RLock()
+	defer m.controllersMu.RUnlock()
+
+	ctrl := m.controllers[stateURI]
+	if ctrl == nil {
+		return nil, errors.Wrapf(ErrNoController, stateURI)
+	}
+	return ctrl.ReadableState(state, keypath, readers)
+}
+
 func (m *controllerHub) Members(stateURI string) ([]types.Address, error) {
 	m.controllersMu.RLock()
 	defer m.controllersMu.RUnlock()
@@ -228,13 +371,13 @@ func (m *controllerHub) Members(stateURI string) ([]types.Address, error) {
 	return ctrl.Members(), nil
 }
 
-func (m *controllerHub) OnNewState(fn func(tx *Tx, state tree.Node, leaves []types.ID)) {
+func (m *controllerHub) OnNewState(fn func(txs []*Tx, state tree.Node, leaves []types.ID)) {
 	m.newStateListenersMu.Lock()
 	defer m.newStateListenersMu.Unlock()
 	m.newStateListeners = append(m.newStateListeners, fn)
 }
 
-func (m *controllerHub) notifyNewStateListeners(tx *Tx, state tree.Node, leaves []types.ID) {
+func (m *controllerHub) notifyNewStateListeners(txs []*Tx, state tree.Node, leaves []types.ID) {
 	m.newStateListenersMu.RLock()
 	defer m.newStateListenersMu.RUnlock()
 
@@ -245,8 +388,23 @@ func (m *controllerHub) notifyNewStateListeners(tx *Tx, state tree.Node, leaves
 		handler := handler
 		go func() {
 			defer wg.Done()
-			handler(tx, state, leaves)
+			handler(txs, state, leaves)
 		}()
 	}
 	wg.Wait()
 }
+
+func (m *controllerHub) OnMissingParents(fn func(tx *Tx, parentIDs []types.ID)) {
+	m.missingParentsListenersMu.Lock()
+	defer m.missingParentsListenersMu.Unlock()
+	m.missingParentsListeners = append(m.missingParentsListeners, fn)
+}
+
+func (m *controllerHub) notifyMissingParentsListeners(tx *Tx, parentIDs []types.ID) {
+	m.missingParentsListenersMu.RLock()
+	defer m.missingParentsListenersMu.RUnlock()
+
+	for _, handler := range m.missingParentsListeners {
+		handler(tx, parentIDs)
+	}
+}
@@ -1,10 +1,15 @@
 package redwood
 
 import (
+	"encoding/hex"
+	"encoding/json"
+	goerrors "errors"
 	"fmt"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -21,17 +26,27 @@ type Controller interface {
 	Close()
 
 	AddTx(tx *Tx, force bool) error
+	AddTxs(txs []*Tx) error
 	HaveTx(txID types.ID) (bool, error)
 
-	StateAtVersion(version *types.ID) tree.Node
+	StateAtVersion(version *types.ID) (tree.Node, error)
 	QueryIndex(version *types.ID, keypath tree.Keypath, indexName tree.Keypath, queryParam tree.Keypath, rng *tree.Range) (tree.Node, error)
+	QueryIndexRange(version *types.ID, keypath tree.Keypath, indexName tree.Keypath, query IndexRangeQuery) (tree.Node, string, error)
+	Provenance(keypath tree.Keypath, historyStart, historyLimit uint64) (*Provenance, error)
 	Leaves() ([]types.ID, error)
+	Versions() ([]VersionInfo, error)
+	Compact(snapshot *Tx) error
+	MissingParents() (map[types.ID][]types.ID, error)
 
 	IsPrivate() (bool, error)
 	IsMember(addr types.Address) (bool, error)
 	Members() []types.Address
+	CanRead(keypath tree.Keypath, readers []types.Address) bool
+	ReadableState(state tree.Node, keypath tree.Keypath, readers []types.Address) (tree.Node, error)
+	SimulateTx(tx *Tx, keypath tree.Keypath) (*TxSimulation, error)
 
-	OnNewState(fn func(tx *Tx, state tree.Node, leaves []types.ID))
+	OnNewState(fn func(txs []*Tx, state tree.Node, leaves []types.ID))
+	OnMissingParents(fn func(tx *Tx, parentIDs []types.ID))
 }
 
 type controller struct {
@@ -45,30 +60,62 @@ type controller struct {
 	txStore       TxStore
 	refStore      RefStore
 
-	behaviorTree *behaviorTree
+	// The behavior tree is replaced (never modified) by the mempool goroutine
+	// whenever a tx changes it, and read by everything else
+	behaviorTree   *behaviorTree
+	behaviorTreeMu sync.RWMutex
 
-	states  *tree.VersionedDBTree
-	indices *tree.VersionedDBTree
+	states         *tree.VersionedDBTree
+	indices        *tree.VersionedDBTree
+	provenance     *tree.VersionedDBTree
+	resolverStates *tree.VersionedDBTree
 
-	newStateListeners   []func(tx *Tx, state tree.Node, leaves []types.ID)
+	newStateListeners   []func(txs []*Tx, state tree.Node, leaves []types.ID)
 	newStateListenersMu sync.RWMutex
 
+	missingParentsListeners   []func(tx *Tx, parentIDs []types.ID)
+	missingParentsListenersMu sync.RWMutex
+
 	mempool Mempool
 	addTxMu sync.Mutex
+
+	rebuildVersionMu sync.Mutex
+
+	config             StateConfig
+	txsSinceCheckpoint uint64
+	lastCheckpointAt   time.Time
+}
+
+// VersionInfo describes a saved version of a state URI's state and the space
+// that it occupies.
+type VersionInfo struct {
+	tree.VersionInfo
+	tree.VersionStats
 }
 
 var (
 	MergeTypeKeypath = tree.Keypath("Merge-Type")
 	ValidatorKeypath = tree.Keypath("Validator")
 	MembersKeypath   = tree.Keypath("Members")
+	IndicesKeypath   = tree.Keypath("Indices")
+
+	// ViewsKeypath holds the configs of a node's views, by name.  Each view's
+	// value is kept up to date at <node>/<name>, computed from the subtree at
+	// <node>/<source>.  Txs can't write to views.
+	ViewsKeypath = tree.Keypath("Views")
 )
 
+// behaviorKeypaths are the keys that hold a node's behavior configs rather
+// than its data.
+var behaviorKeypaths = []tree.Keypath{MergeTypeKeypath, ValidatorKeypath, IndicesKeypath, ViewsKeypath}
+
 func NewController(
 	stateURI string,
 	stateDBRootPath string,
 	controllerHub ControllerHub,
 	txStore TxStore,
 	refStore RefStore,
+	config StateConfig,
 ) (Controller, error) {
 	c := &controller{
 		Logger:          ctx.NewLogger("controller"),
@@ -79,6 +126,7 @@ func NewController(
 		txStore:         txStore,
 		refStore:        refStore,
 		behaviorTree:    newBehaviorTree(),
+		config:          config,
 	}
 	return c, nil
 }
@@ -103,18 +151,52 @@ func (c *controller) Start() (err error) {
 	}
 	c.indices = indices
 
-	// Add root resolver
-	c.behaviorTree.addResolver(tree.Keypath(nil), &dumbResolver{})
+	provenance, err := tree.NewVersionedDBTree(filepath.Join(c.stateDBRootPath, stateURIClean+"_provenance"))
+	if err != nil {
+		return err
+	}
+	c.provenance = provenance
+
+	resolverStates, err := tree.NewVersionedDBTree(filepath.Join(c.stateDBRootPath, stateURIClean+"_resolvers"))
+	if err != nil {
+		return err
+	}
+	c.resolverStates = resolverStates
+
+	// Pick the behavior tree back up from the state that we left off with
+	err = c.loadBehaviorTree()
+	if err != nil {
+		return err
+	}
+
+	// Pick up the checkpoint timer where we left off
+	versions, err := c.states.Versions()
+	if err != nil {
+		return err
+	} else if len(versions) > 0 {
+		c.lastCheckpointAt = versions[len(versions)-1].SavedAt
+	} else {
+		c.lastCheckpointAt = time.Now()
+	}
+	if c.config.MaxVersionAge > 0 {
+		go c.expireVersionsLoop()
+	}
 
 	// Start mempool
-	c.mempool = NewMempool(c.processMempoolTx)
+	c.mempool = NewMempool(c.processMempoolTx, c.processMempoolTxBatch, c.evictMempoolTx, c.config.MempoolMaxTxs, time.Duration(c.config.MempoolTxTTL))
 	err = c.mempool.Start()
 	if err != nil {
 		return err
 	}
 
+	// Resume processing the txs that were still waiting when we last shut down
+	err = c.reloadMempool()
+	if err != nil {
+		return err
+	}
+
 	// Listen for new refs
-	c.refStore.OnRefsSaved(c.mempool.ForceReprocess)
+	c.refStore.OnRefsSaved(c.mempool.RefsSaved)
 
 	return nil
 }
@@ -139,10 +221,35 @@ func (c *controller) Close() {
 			c.Errorf("error closing index db: %v", err)
 		}
 	}
+
+	if c.provenance != nil {
+		err := c.provenance.Close()
+		if err != nil {
+			c.Errorf("error closing provenance db: %v", err)
+		}
+	}
+
+	if c.resolverStates != nil {
+		err := c.resolverStates.Close()
+		if err != nil {
+			c.Errorf("error closing resolver db: %v", err)
+		}
+	}
 }
 
-func (c *controller) StateAtVersion(version *types.ID) tree.Node {
-	return c.states.StateAtVersion(version, false)
+// StateAtVersion returns the state as of the given tx (or the current state,
+// if version is nil), which is the result of applying the tx and its causal
+// past.  Only checkpoint txs have their state saved when they're applied (and
+// only when nothing concurrent has been applied), so the state at any other tx
+// is rebuilt on demand and cached.
+func (c *controller) StateAtVersion(version *types.ID) (tree.Node, error) {
+	if version != nil {
+		err := c.ensureVersion(*version)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.states.StateAtVersion(version, false), nil
 }
 
 func (c *controller) Leaves() ([]types.ID, error) {
@@ -150,7 +257,7 @@ func (c *controller) Leaves() ([]types.ID, error) {
 }
 
 func (c *controller) IsPrivate() (bool, error) {
-	state := c.StateAtVersion(nil)
+	state := c.states.StateAtVersion(nil, false)
 	defer state.Close()
 
 	nodeType, _, length, err := state.NodeInfo(MembersKeypath)
@@ -163,7 +270,7 @@ func (c *controller) IsPrivate() (bool, error) {
 }
 
 func (c *controller) IsMember(addr types.Address) (bool, error) {
-	state := c.StateAtVersion(nil)
+	state := c.states.StateAtVersion(nil, false)
 	defer state.Close()
 
 	is, ok, err := state.BoolValue(MembersKeypath.Pushs(addr.Hex()))
@@ -175,10 +282,116 @@ func (c *controller) IsMember(addr types.Address) (bool, error) {
 	return is, nil
 }
 
+func (c *controller) currentBehaviorTree() *behaviorTree {
+	c.behaviorTreeMu.RLock()
+	defer c.behaviorTreeMu.RUnlock()
+	return c.behaviorTree
+}
+
+func (c *controller) setBehaviorTree(behaviorTree *behaviorTree) {
+	c.behaviorTreeMu.Lock()
+	defer c.behaviorTreeMu.Unlock()
+	c.behaviorTree = behaviorTree
+}
+
+// CanRead returns true if any of the readers may read everything at and
+// beneath the given keypath.
+func (c *controller) CanRead(keypath tree.Keypath, readers []types.Address) bool {
+	current := c.states.StateAtVersion(nil, false)
+	defer current.Close()
+
+	return canReadSubtree(c.currentBehaviorTree(), current, keypath, readers)
+}
+
+// ReadableState returns the parts of the given state (found at the given
+// keypath) that the readers are allowed to see.  If anything has to be
+// hidden, the state is copied into memory and the hidden subtrees are
+// pruned from the copy.  If none of it is readable, it returns types.Err403.
+func (c *controller) ReadableState(state tree.Node, keypath tree.Keypath, readers []types.Address) (tree.Node, error) {
+	current := c.states.StateAtVersion(nil, false)
+	defer current.Close()
+
+	behaviorTree := c.currentBehaviorTree()
+	if canReadSubtree(behaviorTree, current, keypath, readers) {
+		return state, nil
+	}
+
+	copied, err := state.CopyToMemory(nil, nil)
+	if errors.Cause(err) == types.Err404 {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	anyReadable, err := pruneUnreadable(behaviorTree, current, copied, keypath, readers, false)
+	if err != nil {
+		return nil, err
+	} else if !anyReadable {
+		return nil, errors.Wrapf(types.Err403, "cannot read %v", keypath)
+	}
+	return copied, nil
+}
+
+// canReadKeypath asks the nearest ReadPermissioner above the keypath whether
+// the readers may read it.  current is the current state of the whole tree.
+func canReadKeypath(behaviorTree *behaviorTree, current tree.Node, keypath tree.Keypath, readers []types.Address) bool {
+	rp, rpKeypath := behaviorTree.nearestReadPermissionerForKeypath(keypath)
+	return rp == nil || rp.CanRead(current.NodeAt(rpKeypath, nil), readers, keypath.RelativeTo(rpKeypath))
+}
+
+func canReadSubtree(behaviorTree *behaviorTree, current tree.Node, keypath tree.Keypath, readers []types.Address) bool {
+	if !canReadKeypath(behaviorTree, current, keypath, readers) {
+		return false
+	}
+	for _, kp := range behaviorTree.readPermissionerKeypathsBelow(keypath) {
+		if !canReadKeypath(behaviorTree, current, kp, readers) {
+			return false
+		}
+	}
+	return true
+}
+
+// pruneUnreadable deletes the parts of node (found at keypath) that the
+// readers may not see, and returns false if none of it is readable.
+// Permission to read a node extends to its descendants unless a nested
+// ReadPermissioner says otherwise.
+func pruneUnreadable(behaviorTree *behaviorTree, current tree.Node, node tree.Node, keypath tree.Keypath, readers []types.Address, inherited bool) (bool, error) {
+	rp, rpKeypath := behaviorTree.nearestReadPermissionerForKeypath(keypath)
+	if rp != nil && rpKeypath.Equals(keypath) {
+		inherited = false
+	}
+	readable := inherited || canReadKeypath(behaviorTree, current, keypath, readers)
+	if readable && len(behaviorTree.readPermissionerKeypathsBelow(keypath)) == 0 {
+		return true, nil
+	}
+
+	subkeys := node.Subkeys()
+	if len(subkeys) == 0 {
+		return readable, nil
+	}
+
+	var anyKept bool
+	// Go backwards so that deleting slice elements doesn't shift the ones we haven't visited yet
+	for i := len(subkeys) - 1; i >= 0; i-- {
+		keep, err := pruneUnreadable(behaviorTree, current, node.NodeAt(subkeys[i], nil), keypath.Push(subkeys[i]), readers, readable)
+		if err != nil {
+			return false, err
+		} else if !keep {
+			err = node.Delete(subkeys[i], nil)
+			if err != nil {
+				return false, err
+			}
+		} else {
+			anyKept = true
+		}
+	}
+	return readable || anyKept, nil
+}
+
 func (c *controller) Members() []types.Address {
 	var addrs []types.Address
 
-	state := c.StateAtVersion(nil)
+	state := c.states.StateAtVersion(nil, false)
 	defer state.Close()
 
 	iter := state.ChildIterator(MembersKeypath, true, 10)
@@ -229,6 +442,55 @@ func (c *controller) AddTx(tx *Tx, force bool) error {
 	return nil
 }
 
+// AddTxs adds several txs to the mempool at once.  When they're given in
+// causal order (as they are when catching up on history from a peer), the
+// mempool can apply them in batches.
+func (c *controller) AddTxs(txs []*Tx) error {
+	c.addTxMu.Lock()
+	defer c.addTxMu.Unlock()
+
+	var added []*Tx
+	for _, tx := range txs {
+		// Ignore duplicates
+		exists, err := c.txStore.TxExists(tx.StateURI, tx.ID)
+		if err != nil {
+			return err
+		} else if exists {
+			continue
+		}
+
+		tx.Status = TxStatusInMempool
+		err = c.txStore.AddTx(tx)
+		if err != nil {
+			return err
+		}
+		added = append(added, tx)
+	}
+	c.Infof(0, "%v new txs (of %v)", len(added), len(txs))
+
+	c.mempool.AddMany(added)
+	return nil
+}
+
+func (c *controller) reloadMempool() error {
+	txIDs, err := c.txStore.TxIDsWithStatus(c.stateURI, TxStatusInMempool)
+	if err != nil {
+		return err
+	}
+	txs := make([]*Tx, len(txIDs))
+	for i, txID := range txIDs {
+		txs[i], err = c.txStore.FetchTx(c.stateURI, txID)
+		if err != nil {
+			return err
+		}
+	}
+	c.mempool.AddMany(sortTxsCausally(txs))
+	if len(txIDs) > 0 {
+		c.Infof(0, "reloaded %v txs into the mempool", len(txIDs))
+	}
+	return nil
+}
+
 func (c *controller) Mempool() *txSortedSet {
 	return c.mempool.Get()
 }
@@ -243,27 +505,98 @@ var (
 	ErrMissingCriticalRefs = errors.New("missing critical refs")
 )
 
-func (c *controller) processMempoolTx(tx *Tx) processTxOutcome {
+// txBlockedError is returned when a tx can't be applied until some of its
+// parents have been applied or some refs have been fetched.  Its cause is
+// ErrNoParentYet, ErrPendingParent or ErrMissingCriticalRefs.
+// missingParents lists the parents that we haven't received at all.
+type txBlockedError struct {
+	cause          error
+	deps           txDeps
+	missingParents []types.ID
+}
+
+func (err *txBlockedError) Error() string {
+	var parents []string
+	for _, parentID := range err.deps.Parents {
+		parents = append(parents, parentID.Pretty())
+	}
+	var refs []string
+	for _, refID := range err.deps.Refs {
+		refs = append(refs, refID.String())
+	}
+	return fmt.Sprintf("%v (parents=%v refs=%v)", err.cause, parents, refs)
+}
+
+func (err *txBlockedError) Cause() error { return err.cause }
+
+func (c *controller) processMempoolTxBatch(txs []*Tx) int {
+	n := c.tryApplyTxBatch(txs)
+	if n > 0 {
+		c.Successf("batch of %v txs added to chain (%v) %v..%v", n, c.stateURI, txs[0].ID.Pretty(), txs[n-1].ID.Pretty())
+	}
+	return n
+}
+
+func (c *controller) processMempoolTx(tx *Tx) (processTxOutcome, txDeps) {
 	err := c.tryApplyTx(tx)
 
 	if err == nil {
 		c.Successf("tx added to chain (%v) %v", tx.StateURI, tx.ID.Pretty())
-		return processTxOutcome_Succeeded
+		return processTxOutcome_Succeeded, txDeps{}
 	}
 
 	switch errors.Cause(err) {
 	case ErrTxMissingParents, ErrInvalidParent, ErrInvalidSignature, ErrInvalidTx:
 		c.Errorf("invalid tx %v: %+v: %v", tx.ID.Pretty(), err, PrettyJSON(tx))
-		return processTxOutcome_Failed
+		return processTxOutcome_Failed, txDeps{}
 
 	case ErrPendingParent, ErrMissingCriticalRefs, ErrNoParentYet:
 		c.Infof(0, "readding to mempool %v (%v)", tx.ID.Pretty(), err)
-		return processTxOutcome_Retry
+		var blocked *txBlockedError
+		if goerrors.As(err, &blocked) {
+			if len(blocked.missingParents) > 0 {
+				c.notifyMissingParentsListeners(tx, blocked.missingParents)
+			}
+			return processTxOutcome_Retry, blocked.deps
+		}
+		return processTxOutcome_Retry, txDeps{}
 
 	default:
 		c.Errorf("error processing tx %v: %+v: %v", tx.ID.Pretty(), err, PrettyJSON(tx))
-		return processTxOutcome_Failed
+		return processTxOutcome_Failed, txDeps{}
+	}
+}
+
+// evictMempoolTx forgets a tx that waited in the mempool for too long, so
+// that it can be received again later.
+func (c *controller) evictMempoolTx(tx *Tx) {
+	err := c.txStore.RemoveTx(c.stateURI, tx.ID)
+	if err != nil {
+		c.Errorf("error removing evicted tx %v: %v", tx.ID.Pretty(), err)
+	}
+}
+
+// missingRefsError lists the refs linked from the given node that haven't
+// been fetched yet, so that the mempool can retry the tx when they arrive.
+func (c *controller) missingRefsError(node tree.Node) error {
+	var missing []types.RefID
+
+	iter := node.DepthFirstIterator(nil, false, 0)
+	defer iter.Close()
+
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		refID, isRef := refLinkAt(node, iter.Node().Keypath().RelativeTo(node.Keypath()))
+		if !isRef {
+			continue
+		}
+		have, err := c.refStore.HaveObject(refID)
+		if err != nil {
+			return err
+		} else if !have {
+			missing = append(missing, refID)
+		}
 	}
+	return errors.WithStack(&txBlockedError{cause: ErrMissingCriticalRefs, deps: txDeps{Refs: missing}})
 }
 
 func (c *controller) tryApplyTx(tx *Tx) (err error) {
@@ -272,30 +605,62 @@ func (c *controller) tryApplyTx(tx *Tx) (err error) {
 	//
 	// Validate the tx's intrinsics
 	//
-	if len(tx.Parents) == 0 && tx.ID != GenesisTxID {
-		return ErrTxMissingParents
+	isRootSnapshot := len(tx.Parents) == 0 && tx.ID != GenesisTxID
+	if isRootSnapshot {
+		// A snapshot can only stand in for history that we don't have yet
+		if !tx.IsSnapshot() {
+			return ErrTxMissingParents
+		}
+		leaves, err := c.txStore.Leaves(c.stateURI)
+		if err != nil {
+			return err
+		} else if len(leaves) > 0 {
+			return ErrTxMissingParents
+		}
 	}
 
-	for _, parentID := range tx.Parents {
+	parents, err := c.resolvedParents(tx)
+	if err != nil {
+		return err
+	}
+
+	var missingParents, pendingParents []types.ID
+	for _, parentID := range parents {
 		parentTx, err := c.txStore.FetchTx(tx.StateURI, parentID)
 		if errors.Cause(err) == types.Err404 {
-			return errors.Wrapf(ErrNoParentYet, "parent=%v", parentID.Pretty())
+			missingParents = append(missingParents, parentID)
 		} else if err != nil {
 			return errors.Wrapf(err, "parent=%v", parentID.Pretty())
 		} else if parentTx.Status == TxStatusInvalid {
 			return errors.Wrapf(ErrInvalidParent, "parent=%v", parentID.Pretty())
 		} else if parentTx.Status == TxStatusInMempool {
-			return errors.Wrapf(ErrPendingParent, "parent=%v", parentID.Pretty())
+			pendingParents = append(pendingParents, parentID)
+		}
+	}
+	if len(missingParents) > 0 {
+		return &txBlockedError{
+			cause:          ErrNoParentYet,
+			deps:           txDeps{Parents: append(append([]types.ID(nil), missingParents...), pendingParents...)},
+			missingParents: missingParents,
 		}
+	} else if len(pendingParents) > 0 {
+		return &txBlockedError{cause: ErrPendingParent, deps: txDeps{Parents: pendingParents}}
 	}
 
-	sigPubKey, err := crypto.RecoverSigningPubkey(tx.Hash(), tx.Sig)
+	err = verifyTxSignature(tx)
 	if err != nil {
-		return errors.Wrap(ErrInvalidSignature, err.Error())
-	} else if sigPubKey.VerifySignature(tx.Hash(), tx.Sig) == false {
-		return ErrInvalidSignature
-	} else if sigPubKey.Address() != tx.From {
-		return errors.Wrapf(ErrInvalidSignature, "address doesn't match (expected=%v received=%v)", tx.From.Hex(), sigPubKey.Address().Hex())
+		return err
+	}
+
+	// Anyone can sign a snapshot, so only the configured signers' snapshots
+	// are trusted to stand in for the history before them
+	if isRootSnapshot && !c.isSnapshotSigner(tx.From) {
+		tx.Status = TxStatusInvalid
+		err := c.txStore.AddTx(tx)
+		if err != nil {
+			return err
+		}
+		return errors.Wrapf(ErrInvalidTx, "snapshot signed by untrusted address %v", tx.From.Hex())
 	}
 
 	state := c.states.StateAtVersion(nil, true)
@@ -304,124 +669,54 @@ func (c *controller) tryApplyTx(tx *Tx) (err error) {
 	//
 	// Validate the tx's extrinsics
 	//
-	{
-		// @@TODO: sort patches and use ordering to cut down on number of ops
-
-		patches := tx.Patches
-		for i := len(c.behaviorTree.validatorKeypaths) - 1; i >= 0; i-- {
-			validatorKeypath := c.behaviorTree.validatorKeypaths[i]
-
-			var unprocessedPatches []Patch
-			var patchesTrimmed []Patch
-			for _, patch := range patches {
-				if patch.Keypath.StartsWith(validatorKeypath) {
-					patchesTrimmed = append(patchesTrimmed, Patch{
-						Keypath: patch.Keypath.RelativeTo(validatorKeypath),
-						Range:   patch.Range,
-						Val:     patch.Val,
-					})
-				} else {
-					unprocessedPatches = append(unprocessedPatches, patch)
-				}
-			}
-
-			txCopy := *tx
-			txCopy.Patches = patchesTrimmed
-
-			validator := c.behaviorTree.validators[string(validatorKeypath)]
-			err := validator.ValidateTx(state.NodeAt(validatorKeypath, nil), &txCopy)
-			if err != nil {
-				// Mark the tx invalid and save it to the DB
-				tx.Status = TxStatusInvalid
-				err2 := c.txStore.AddTx(tx)
-				if err2 != nil {
-					return err2
-				}
-				return errors.Wrap(ErrInvalidTx, err.Error())
-			}
-
-			patches = unprocessedPatches
+	err = c.runValidators(c.currentBehaviorTree(), state, tx)
+	if err != nil {
+		// Mark the tx invalid and save it to the DB
+		tx.Status = TxStatusInvalid
+		err2 := c.txStore.AddTx(tx)
+		if err2 != nil {
+			return err2
 		}
+		return errors.Wrap(ErrInvalidTx, err.Error())
 	}
 
 	//
 	// Apply changes to the state tree
 	//
-	{
-		// @@TODO: sort patches and use ordering to cut down on number of ops
-
-		patches := tx.Patches
-		for i := len(c.behaviorTree.resolverKeypaths) - 1; i >= 0; i-- {
-			resolverKeypath := c.behaviorTree.resolverKeypaths[i]
-
-			var unprocessedPatches []Patch
-			var patchesTrimmed []Patch
-			for _, patch := range patches {
-				if patch.Keypath.StartsWith(resolverKeypath) {
-					patchesTrimmed = append(patchesTrimmed, Patch{
-						Keypath: patch.Keypath.RelativeTo(resolverKeypath),
-						Range:   patch.Range,
-						Val:     patch.Val,
-					})
-				} else {
-					unprocessedPatches = append(unprocessedPatches, patch)
-				}
-			}
-			if len(patchesTrimmed) == 0 {
-				patches = unprocessedPatches
-				continue
-			}
-
-			resolverState, err := state.CopyToMemory(resolverKeypath.Push(MergeTypeKeypath), nil)
-			if err != nil && errors.Cause(err) != types.Err404 {
-				return err
-			}
-			validatorState, err := state.CopyToMemory(resolverKeypath.Push(ValidatorKeypath), nil)
-			if err != nil && errors.Cause(err) != types.Err404 {
-				return err
-			}
+	// The tx is resolved by a fork of the resolvers, which only replaces the
+	// current ones once the state has been saved
+	forkedBehaviorTree, err := c.forkBehaviorTree(state, tx.Patches)
+	if err != nil {
+		return err
+	}
 
-			stateToResolve := state.NodeAt(resolverKeypath, nil)
+	viewSources, err := c.snapshotViewSources(state, forkedBehaviorTree, tx.Patches)
+	if err != nil {
+		return err
+	}
 
-			stateToResolve.Diff().SetEnabled(false)
-			err = state.Delete(resolverKeypath.Push(MergeTypeKeypath), nil)
-			if err != nil && errors.Cause(err) != types.Err404 {
-				return err
-			}
-			err = state.Delete(resolverKeypath.Push(ValidatorKeypath), nil)
-			if err != nil && errors.Cause(err) != types.Err404 {
-				return err
-			}
-			stateToResolve.Diff().SetEnabled(true)
+	err = c.applyTxPatches(forkedBehaviorTree, state, tx)
+	if err != nil {
+		return err
+	}
 
-			resolver := c.behaviorTree.resolvers[string(resolverKeypath)]
-			err = resolver.ResolveState(stateToResolve, c.refStore, tx.From, tx.ID, tx.Parents, patchesTrimmed)
-			if err != nil {
-				return errors.Wrapf(ErrInvalidTx, "%+v", err)
-			}
+	c.handleNewRefs(state)
 
-			stateToResolve.Diff().SetEnabled(false)
-			if resolverState != nil {
-				err = stateToResolve.Set(MergeTypeKeypath, nil, resolverState)
-				if err != nil {
-					return err
-				}
-			}
-			if validatorState != nil {
-				err = stateToResolve.Set(ValidatorKeypath, nil, validatorState)
-				if err != nil {
-					return err
-				}
-			}
-			stateToResolve.Diff().SetEnabled(true)
+	oldBehaviorTree := c.currentBehaviorTree()
+	newBehaviorTree, err := c.updateBehaviorTree(forkedBehaviorTree, state, c.appliedHistory(tx))
+	if err != nil {
+		return err
+	}
 
-			patches = unprocessedPatches
-		}
+	err = c.updateViews(state, state.Diff(), viewSources, oldBehaviorTree, newBehaviorTree)
+	if err != nil {
+		return err
 	}
 
-	c.handleNewRefs(state)
+	provenance := c.provenance.StateAtVersion(nil, true)
+	defer provenance.Close()
 
-	err = c.updateBehaviorTree(state)
+	err = c.updateProvenance(provenance, tx, state.Diff())
 	if err != nil {
 		return err
 	}
@@ -430,312 +725,2145 @@ func (c *controller) tryApplyTx(tx *Tx) (err error) {
 	if err != nil {
 		return err
 	}
+	c.setBehaviorTree(newBehaviorTree)
 
-	if tx.Checkpoint {
-		err = c.states.CopyVersion(tx.ID, tree.CurrentVersion)
-		if err != nil {
-			return err
-		}
+	// The tx has been applied, so there's no going back
+	err = provenance.Save()
+	if err != nil {
+		c.Errorf("error saving provenance of tx %v: %v", tx.ID.Pretty(), err)
 	}
 
-	// Unmark parents as leaves
-	for _, parentID := range tx.Parents {
-		err := c.txStore.UnmarkLeaf(c.stateURI, parentID)
-		if err != nil {
-			return err
-		}
+	newState := c.states.StateAtVersion(nil, false)
+	defer newState.Close()
+
+	err = c.updateIndices(nodeWithDiff{newState, state.Diff()}, oldBehaviorTree, newBehaviorTree)
+	if err != nil {
+		c.Errorf("error updating indices after tx %v: %v", tx.ID.Pretty(), err)
 	}
 
-	// Mark this tx as a leaf
-	err = c.txStore.MarkLeaf(c.stateURI, tx.ID)
+	err = c.markTxApplied(tx)
 	if err != nil {
 		return err
 	}
 
-	// Mark the tx valid and save it to the DB
-	tx.Status = TxStatusValid
-	err = c.txStore.AddTx(tx)
+	err = c.checkpointIfNeeded(tx, 1)
 	if err != nil {
 		return err
 	}
 
+	// Requests for history from the genesis tx now start at the snapshot
+	if isRootSnapshot {
+		err = c.txStore.PruneTxs(tx, []types.ID{GenesisTxID})
+		if err != nil {
+			return err
+		}
+	}
+
 	leaves, err := c.txStore.Leaves(c.stateURI)
 	if err != nil {
 		return err
 	}
 
-	state = c.states.StateAtVersion(nil, false)
-	defer state.Close()
-	c.notifyNewStateListeners(tx, state, leaves)
+	err = c.saveResolverStates(nil, oldBehaviorTree, newBehaviorTree, leaves)
+	if err != nil {
+		c.Errorf("error saving resolver states after tx %v: %v", tx.ID.Pretty(), err)
+	}
+
+	c.notifyNewStateListeners([]*Tx{tx}, nodeWithDiff{newState, state.Diff()}, leaves)
 
 	return nil
 }
 
-func (c *controller) handleNewRefs(state tree.Node) {
-	var refs []types.RefID
-	defer func() {
-		if len(refs) > 0 {
-			c.refStore.MarkRefsAsNeeded(refs)
-		}
-	}()
+// nodeWithDiff attaches the changes that produced a state to a read-only
+// view of it, so that new state listeners can see what changed.
+type nodeWithDiff struct {
+	tree.Node
+	diff *tree.Diff
+}
 
-	diff := state.Diff()
+func (n nodeWithDiff) Diff() *tree.Diff { return n.diff }
+
+// TxSimulation describes what would happen if a tx were applied to the
+// current state.
+type TxSimulation struct {
+	// Err is the reason that the tx would be rejected, or nil if it would be
+	// accepted.
+	Err     error
+	Added   []tree.Keypath
+	Removed []tree.Keypath
+	// State is the requested subtree as it would be after the tx.  It's nil
+	// if the subtree wouldn't exist.
+	State tree.Node
+}
 
-	// Find all refs in the tree and notify the Host to start fetching them
+type txSimulationJSON struct {
+	Error   string      `json:"error,omitempty"`
+	Added   []string    `json:"added"`
+	Removed []string    `json:"removed"`
+	State   interface{} `json:"state"`
+}
+
+func (sim TxSimulation) MarshalJSON() ([]byte, error) {
+	j := txSimulationJSON{
+		Added:   make([]string, len(sim.Added)),
+		Removed: make([]string, len(sim.Removed)),
+	}
+	if sim.Err != nil {
+		j.Error = sim.Err.Error()
+	}
+	for i, kp := range sim.Added {
+		j.Added[i] = string(kp)
+	}
+	for i, kp := range sim.Removed {
+		j.Removed[i] = string(kp)
+	}
+	if sim.State != nil {
+		val, _, err := sim.State.Value(nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		j.State = val
+	}
+	return json.Marshal(j)
+}
+
+func (sim *TxSimulation) UnmarshalJSON(bs []byte) error {
+	var j txSimulationJSON
+	err := json.Unmarshal(bs, &j)
+	if err != nil {
+		return err
+	}
+
+	*sim = TxSimulation{}
+	if j.Error != "" {
+		sim.Err = goerrors.New(j.Error)
+	}
+	for _, kp := range j.Added {
+		sim.Added = append(sim.Added, tree.Keypath(kp))
+	}
+	for _, kp := range j.Removed {
+		sim.Removed = append(sim.Removed, tree.Keypath(kp))
+	}
+	if j.State != nil {
+		sim.State = tree.NewMemoryNode()
+		err = sim.State.Set(nil, nil, j.State)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SimulateTx runs a tx through the same checks, validators, resolvers and
+// behavior tree updates as tryApplyTx, against the current state, without
+// saving anything.  A tx without a signature is simulated as though it had
+// been signed by tx.From, so that callers can check an edit before signing
+// it.  The tx's parents are ignored.
+//
+// The returned error only reports failures of the simulation itself.  Why
+// the tx would be rejected is reported in TxSimulation.Err.
+func (c *controller) SimulateTx(tx *Tx, keypath tree.Keypath) (_ *TxSimulation, err error) {
+	defer utils.Annotate(&err, "stateURI=%v tx=%v", tx.StateURI, tx.ID.Pretty())
+
+	sim := &TxSimulation{}
+	if len(tx.Sig) > 0 {
+		sim.Err = verifyTxSignature(tx)
+		if sim.Err != nil {
+			return sim, nil
+		}
+	}
+
+	// This transaction is never saved
+	state := c.states.StateAtVersion(nil, true)
+	defer state.Close()
+
+	behaviorTree, err := c.forkBehaviorTree(state, tx.Patches)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.runValidators(behaviorTree, state, tx)
+	if err != nil {
+		sim.Err = errors.Wrap(ErrInvalidTx, err.Error())
+		return sim, nil
+	}
+
+	err = c.applyTxPatches(behaviorTree, state, tx)
+	if err != nil {
+		sim.Err = err
+		return sim, nil
+	}
+
+	newBehaviorTree, err := c.updateBehaviorTree(behaviorTree, state, c.appliedHistory(tx))
+	if err != nil {
+		sim.Err = err
+		return sim, nil
+	}
+
+	err = c.updateViews(state, state.Diff(), nil, behaviorTree, newBehaviorTree)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := state.Diff()
+	sim.Added = append(sim.Added, diff.AddedList...)
+	sim.Removed = append(sim.Removed, diff.RemovedList...)
+
+	sim.State, err = state.CopyToMemory(keypath, nil)
+	if errors.Cause(err) == types.Err404 {
+		sim.State = nil
+	} else if err != nil {
+		return nil, err
+	}
+	return sim, nil
+}
+
+// forkBehaviorTree copies the current behavior tree, replacing each resolver
+// that could handle one of the given patches with a new one initialized from
+// its internal state, so that the copy can resolve those patches without
+// affecting the original.
+func (c *controller) forkBehaviorTree(state tree.Node, patches []Patch) (*behaviorTree, error) {
+	behaviorTree := c.currentBehaviorTree().copy()
+	for _, resolverKeypath := range append([]tree.Keypath(nil), behaviorTree.resolverKeypaths...) {
+		var touched bool
+		for _, patch := range patches {
+			if patch.Keypath.StartsWith(resolverKeypath) {
+				touched = true
+				break
+			}
+		}
+		if !touched {
+			continue
+		}
+
+		resolverConfigKeypath := resolverKeypath.Push(MergeTypeKeypath)
+		exists, err := state.Exists(resolverConfigKeypath)
+		if err != nil {
+			return nil, err
+		} else if !exists {
+			// The default resolver is stateless
+			continue
+		}
+		err = c.initializeResolver(behaviorTree, state, resolverConfigKeypath, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return behaviorTree, nil
+}
+
+// tryApplyTxBatch applies as many of the given txs as it can, in order, in a
+// single state transaction, and returns how many it applied.  It only batches
+// txs whose parents are already valid (or earlier in the batch).  Anything
+// else, including a tx that fails partway through a batch, is left for
+// tryApplyTx, which knows how to report it.
+//
+// New state listeners are notified once per batch, with all of its txs in
+// order, the combined diff and the final leaves.
+func (c *controller) tryApplyTxBatch(txs []*Tx) int {
+	batch := c.readyTxBatch(txs)
+	for len(batch) >= 2 {
+		n, failedAt := c.applyTxBatch(batch)
+		if failedAt < 0 {
+			return n
+		}
+		// Everything before the failing tx might still go through together
+		batch = batch[:failedAt]
+	}
+	return 0
+}
+
+// readyTxBatch returns the longest run of txs at the start of the given
+// slice that can be applied together.
+func (c *controller) readyTxBatch(txs []*Tx) []*Tx {
+	inBatch := make(map[types.ID]bool, len(txs))
+	var batch []*Tx
+	for _, tx := range txs {
+		if len(tx.Parents) == 0 || tx.StateURI != c.stateURI || verifyTxSignature(tx) != nil {
+			break
+		}
+		exists, err := c.txStore.TxExists(c.stateURI, tx.ID)
+		if err != nil || !exists {
+			break
+		}
+
+		parents, err := c.resolvedParents(tx)
+		if err != nil {
+			break
+		}
+
+		ready := true
+		for _, parentID := range parents {
+			if inBatch[parentID] {
+				continue
+			}
+			parentTx, err := c.txStore.FetchTx(c.stateURI, parentID)
+			if err != nil || parentTx.Status != TxStatusValid {
+				ready = false
+				break
+			}
+		}
+		if !ready {
+			break
+		}
+
+		inBatch[tx.ID] = true
+		batch = append(batch, tx)
+
+		// Checkpoints can only be taken at the end of a batch
+		if tx.Checkpoint || (c.config.CheckpointEveryNTxs > 0 && c.txsSinceCheckpoint+uint64(len(batch)) >= c.config.CheckpointEveryNTxs) {
+			break
+		}
+	}
+	return batch
+}
+
+// applyTxBatch applies the given txs in a single state transaction.  If one
+// of them fails, nothing is applied and failedAt is its index.  If the batch
+// fails as a whole, failedAt is 0.  The txs are resolved by a fork of the
+// behavior tree (see forkBehaviorTree), which only replaces the current one
+// once the state has been saved, so that a failed batch leaves no trace in the
+// resolvers' internal state.
+func (c *controller) applyTxBatch(batch []*Tx) (n int, failedAt int) {
+	state := c.states.StateAtVersion(nil, true)
+	defer state.Close()
+
+	var patches []Patch
+	for _, tx := range batch {
+		patches = append(patches, tx.Patches...)
+	}
+
+	startBehaviorTree := c.currentBehaviorTree()
+	behaviorTree, err := c.forkBehaviorTree(state, patches)
+	if err != nil {
+		c.Errorf("error forking behavior tree: %v", err)
+		return 0, 0
+	}
+
+	combinedDiff := tree.NewDiff()
+	txDiffs := make([]*tree.Diff, len(batch))
+
+	for i, tx := range batch {
+		state.ResetDiff()
+
+		err := c.runValidators(behaviorTree, state, tx)
+		if err != nil {
+			return 0, i
+		}
+
+		viewSources, err := c.snapshotViewSources(state, behaviorTree, tx.Patches)
+		if err != nil {
+			return 0, i
+		}
+
+		err = c.applyTxPatches(behaviorTree, state, tx)
+		if err != nil {
+			return 0, i
+		}
+
+		prevBehaviorTree := behaviorTree
+		behaviorTree, err = c.updateBehaviorTree(behaviorTree, state, c.appliedHistory(batch[:i+1]...))
+		if err != nil {
+			return 0, i
+		}
+
+		// The views are brought up to date after every tx, so that each tx
+		// sees them just as it would if it weren't part of a batch
+		err = c.updateViews(state, state.Diff(), viewSources, prevBehaviorTree, behaviorTree)
+		if err != nil {
+			return 0, i
+		}
+
+		txDiffs[i] = state.Diff().Copy()
+		combinedDiff.AddMany(state.Diff().AddedList)
+		combinedDiff.RemoveMany(state.Diff().RemovedList)
+	}
+
+	c.handleNewRefs(nodeWithDiff{state, combinedDiff})
+
+	provenance := c.provenance.StateAtVersion(nil, true)
+	defer provenance.Close()
+
+	for i, tx := range batch {
+		err = c.updateProvenance(provenance, tx, txDiffs[i])
+		if err != nil {
+			return 0, 0
+		}
+	}
+
+	err = state.Save()
+	if err != nil {
+		c.Errorf("error saving batch of %v txs: %v", len(batch), err)
+		return 0, 0
+	}
+	c.setBehaviorTree(behaviorTree)
+
+	err = provenance.Save()
+	if err != nil {
+		c.Errorf("error saving provenance of batch of %v txs: %v", len(batch), err)
+	}
+
+	newState := c.states.StateAtVersion(nil, false)
+	defer newState.Close()
+
+	err = c.updateIndices(nodeWithDiff{newState, combinedDiff}, startBehaviorTree, behaviorTree)
+	if err != nil {
+		c.Errorf("error updating indices after batch of %v txs: %v", len(batch), err)
+	}
+
+	for i, tx := range batch {
+		err := c.markTxApplied(tx)
+		if err != nil {
+			// The state already includes the whole batch, so there's no going
+			// back.  The rest of the txs stay in the mempool.
+			c.Errorf("error marking tx %v applied: %v", tx.ID.Pretty(), err)
+			return i, -1
+		}
+	}
+
+	lastTx := batch[len(batch)-1]
+	err = c.checkpointIfNeeded(lastTx, uint64(len(batch)))
+	if err != nil {
+		c.Errorf("error saving checkpoint at tx %v: %v", lastTx.ID.Pretty(), err)
+	}
+
+	leaves, err := c.txStore.Leaves(c.stateURI)
+	if err != nil {
+		c.Errorf("error fetching leaves: %v", err)
+		return len(batch), -1
+	}
+
+	err = c.saveResolverStates(nil, startBehaviorTree, behaviorTree, leaves)
+	if err != nil {
+		c.Errorf("error saving resolver states after batch of %v txs: %v", len(batch), err)
+	}
+
+	c.notifyNewStateListeners(batch, nodeWithDiff{newState, combinedDiff}, leaves)
+
+	return len(batch), -1
+}
+
+// runValidators checks a tx against the validators in the given behavior
+// tree.  It returns the first validator's error, if any.
+func (c *controller) runValidators(behaviorTree *behaviorTree, state tree.Node, tx *Tx) error {
+	for _, patch := range tx.Patches {
+		if viewKeypath, isView := behaviorTree.viewContainingKeypath(patch.Keypath); isView {
+			return errors.Wrapf(types.Err403, "'%v' is a view and can't be written to", viewKeypath)
+		}
+	}
+
+	// @@TODO: sort patches and use ordering to cut down on number of ops
+
+	patches := tx.Patches
+	for i := len(behaviorTree.validatorKeypaths) - 1; i >= 0; i-- {
+		validatorKeypath := behaviorTree.validatorKeypaths[i]
+
+		var unprocessedPatches []Patch
+		var patchesTrimmed []Patch
+		for _, patch := range patches {
+			if patch.Keypath.StartsWith(validatorKeypath) {
+				patchesTrimmed = append(patchesTrimmed, Patch{
+					Keypath: patch.Keypath.RelativeTo(validatorKeypath),
+					Range:   patch.Range,
+					Val:     patch.Val,
+				})
+			} else {
+				unprocessedPatches = append(unprocessedPatches, patch)
+			}
+		}
+
+		txCopy := *tx
+		txCopy.Patches = patchesTrimmed
+		// Signatures cover the tx as it was sent, not the trimmed patches
+		txCopy.hash = tx.Hash()
+
+		validator := behaviorTree.validators[string(validatorKeypath)]
+		err := validator.ValidateTx(state.NodeAt(validatorKeypath, nil), &txCopy)
+		if err != nil {
+			return err
+		}
+
+		patches = unprocessedPatches
+	}
+	return nil
+}
+
+// checkpointIfNeeded saves the current state as the version at the given
+// (just applied) tx if it's a checkpoint, or if the automatic checkpoint
+// policy calls for one after numTxs more txs have been applied.
+//
+// The current state only matches the tx's version when the tx is the only
+// leaf, since otherwise the state also includes txs that are concurrent with
+// it.  In that case, nothing is saved, and the version will be rebuilt from
+// the tx's causal past if it's ever needed.
+func (c *controller) checkpointIfNeeded(tx *Tx, numTxs uint64) error {
+	c.txsSinceCheckpoint += numTxs
+	if !tx.Checkpoint && !c.shouldAutoCheckpoint() {
+		return nil
+	}
+
+	leaves, err := c.txStore.Leaves(c.stateURI)
+	if err != nil {
+		return err
+	} else if len(leaves) != 1 || leaves[0] != tx.ID {
+		return nil
+	}
+
+	err = c.states.CopyVersion(tx.ID, tree.CurrentVersion)
+	if err != nil {
+		return err
+	}
+	// The resolvers are in sync with the checkpoint, which is the only leaf
+	err = c.saveResolverStates(&tx.ID, newBehaviorTree(), c.currentBehaviorTree(), leaves)
+	if err != nil {
+		c.Errorf("error saving resolver states at checkpoint %v: %v", tx.ID.Pretty(), err)
+	}
+	c.txsSinceCheckpoint = 0
+	c.lastCheckpointAt = time.Now()
+
+	err = c.collectVersionGarbage()
+	if err != nil {
+		c.Errorf("error collecting old versions: %v", err)
+	}
+	return nil
+}
+
+// markTxApplied moves the leaves forward to the given tx and marks it valid.
+func (c *controller) markTxApplied(tx *Tx) error {
+	parents, err := c.resolvedParents(tx)
+	if err != nil {
+		return err
+	}
+
+	// Unmark parents as leaves
+	for _, parentID := range parents {
+		err := c.txStore.UnmarkLeaf(c.stateURI, parentID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Mark this tx as a leaf
+	err = c.txStore.MarkLeaf(c.stateURI, tx.ID)
+	if err != nil {
+		return err
+	}
+
+	// Mark the tx valid and save it to the DB
+	tx.Status = TxStatusValid
+	return c.txStore.AddTx(tx)
+}
+
+// resolvedParents returns the IDs of the given tx's parents, replacing any
+// that have been pruned with the snapshot that stands in for them.  The tx
+// itself keeps its original parents, since they're covered by its signature.
+func (c *controller) resolvedParents(tx *Tx) ([]types.ID, error) {
+	parents := make([]types.ID, 0, len(tx.Parents))
+	seen := make(map[types.ID]bool, len(tx.Parents))
+	for _, parentID := range tx.Parents {
+		parentID, err := c.txStore.ResolvePrunedTxID(c.stateURI, parentID)
+		if err != nil {
+			return nil, err
+		} else if !seen[parentID] {
+			seen[parentID] = true
+			parents = append(parents, parentID)
+		}
+	}
+	return parents, nil
+}
+
+// isSnapshotSigner returns true if the state URI's config trusts the given
+// address to sign snapshots.
+func (c *controller) isSnapshotSigner(addr types.Address) bool {
+	for _, signer := range c.config.SnapshotSigners {
+		if signer == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func verifyTxSignature(tx *Tx) error {
+	sigPubKey, err := crypto.RecoverSigningPubkey(tx.Hash(), tx.Sig)
+	if err != nil {
+		return errors.Wrap(ErrInvalidSignature, err.Error())
+	} else if sigPubKey.VerifySignature(tx.Hash(), tx.Sig) == false {
+		return ErrInvalidSignature
+	} else if sigPubKey.Address() != tx.From {
+		return errors.Wrapf(ErrInvalidSignature, "address doesn't match (expected=%v received=%v)", tx.From.Hex(), sigPubKey.Address().Hex())
+	}
+	return nil
+}
+
+// applyTxPatches runs a tx's patches through the resolvers in the given
+// behavior tree, applying them to the state.
+func (c *controller) applyTxPatches(behaviorTree *behaviorTree, state tree.Node, tx *Tx) error {
+	// @@TODO: sort patches and use ordering to cut down on number of ops
+
+	patches := tx.Patches
+	for i := len(behaviorTree.resolverKeypaths) - 1; i >= 0; i-- {
+		resolverKeypath := behaviorTree.resolverKeypaths[i]
+
+		var unprocessedPatches []Patch
+		var patchesTrimmed []Patch
+		for _, patch := range patches {
+			if patch.Keypath.StartsWith(resolverKeypath) {
+				patchesTrimmed = append(patchesTrimmed, Patch{
+					Keypath: patch.Keypath.RelativeTo(resolverKeypath),
+					Range:   patch.Range,
+					Val:     patch.Val,
+				})
+			} else {
+				unprocessedPatches = append(unprocessedPatches, patch)
+			}
+		}
+		if len(patchesTrimmed) == 0 {
+			patches = unprocessedPatches
+			continue
+		}
+
+		resolverState, err := state.CopyToMemory(resolverKeypath.Push(MergeTypeKeypath), nil)
+		if err != nil && errors.Cause(err) != types.Err404 {
+			return err
+		}
+		validatorState, err := state.CopyToMemory(resolverKeypath.Push(ValidatorKeypath), nil)
+		if err != nil && errors.Cause(err) != types.Err404 {
+			return err
+		}
+
+		stateToResolve := state.NodeAt(resolverKeypath, nil)
+
+		stateToResolve.Diff().SetEnabled(false)
+		err = state.Delete(resolverKeypath.Push(MergeTypeKeypath), nil)
+		if err != nil && errors.Cause(err) != types.Err404 {
+			return err
+		}
+		err = state.Delete(resolverKeypath.Push(ValidatorKeypath), nil)
+		if err != nil && errors.Cause(err) != types.Err404 {
+			return err
+		}
+		stateToResolve.Diff().SetEnabled(true)
+
+		resolver := behaviorTree.resolvers[string(resolverKeypath)]
+		err = resolver.ResolveState(stateToResolve, c.refStore, tx.From, tx.ID, tx.Parents, patchesTrimmed)
+		if err != nil {
+			return errors.Wrapf(ErrInvalidTx, "%+v", err)
+		}
+
+		stateToResolve.Diff().SetEnabled(false)
+		if resolverState != nil {
+			err = stateToResolve.Set(MergeTypeKeypath, nil, resolverState)
+			if err != nil {
+				return err
+			}
+		}
+		if validatorState != nil {
+			err = stateToResolve.Set(ValidatorKeypath, nil, validatorState)
+			if err != nil {
+				return err
+			}
+		}
+		stateToResolve.Diff().SetEnabled(true)
+
+		patches = unprocessedPatches
+	}
+	return nil
+}
+
+func (c *controller) handleNewRefs(state tree.Node) {
+	var refs []types.RefID
+	defer func() {
+		if len(refs) > 0 {
+			c.refStore.MarkRefsAsNeeded(refs)
+		}
+	}()
+
+	diff := state.Diff()
+
+	// Find all refs in the tree and notify the Host to start fetching them
+	for kp := range diff.Added {
+		refID, isRef := refLinkAt(state, tree.Keypath(kp))
+		if isRef {
+			refs = append(refs, refID)
+		}
+	}
+}
+
+// refLinkAt returns the ref that the given keypath links to, if it's the
+// value of a NelSON link frame pointing to a ref.
+func refLinkAt(state tree.Node, keypath tree.Keypath) (types.RefID, bool) {
+	parentKeypath, key := keypath.Pop()
+	if !key.Equals(nelson.ValueKey) {
+		return types.RefID{}, false
+	}
+
+	contentType, err := nelson.GetContentType(state.NodeAt(parentKeypath, nil))
+	if err != nil || contentType != "link" {
+		return types.RefID{}, false
+	}
+
+	linkStr, _, err := state.StringValue(keypath)
+	if err != nil {
+		return types.RefID{}, false
+	}
+	linkType, linkValue := nelson.DetermineLinkType(linkStr)
+	if linkType != nelson.LinkTypeRef {
+		return types.RefID{}, false
+	}
+
+	var refID types.RefID
+	err = refID.UnmarshalText([]byte(linkValue))
+	if err != nil {
+		return types.RefID{}, false
+	}
+	return refID, true
+}
+
+// updateBehaviorTree returns a copy of the given behavior tree that reflects
+// the changes to the behaviors' configs in the state's diff.  history returns
+// the txs that produced the state, which are needed to migrate a resolver
+// whose type changes (see migrateResolver).
+func (c *controller) updateBehaviorTree(oldBehaviorTree *behaviorTree, state tree.Node, history func() ([]*Tx, error)) (*behaviorTree, error) {
+	// Walk the tree and initialize validators and resolvers (@@TODO: inefficient)
+
+	// We need to be able to roll back in case of error, so we make a copy
+	newBehaviorTree := oldBehaviorTree.copy()
+
+	diff := state.Diff()
+
+	// Remove deleted resolvers, validators, indexers and views
+	for kp := range diff.Removed {
+		parentKeypath, key := tree.Keypath(kp).Pop()
+		switch {
+		case key.Equals(MergeTypeKeypath):
+			// A resolver that's replaced by one of a different type (by a patch to
+			// one of its ancestors) is migrated by initializeResolver instead.  The
+			// type is checked against the old tree, since the migration may already
+			// have happened while walking up from another removed keypath.
+			changed, err := c.resolverTypeChanged(oldBehaviorTree, state, parentKeypath)
+			if err != nil {
+				return nil, err
+			} else if !changed {
+				newBehaviorTree.removeResolver(parentKeypath)
+			}
+		case key.Equals(ValidatorKeypath):
+			newBehaviorTree.removeValidator(parentKeypath)
+		case key.Equals(IndicesKeypath):
+			for indexName := range newBehaviorTree.indexers[string(parentKeypath)] {
+				newBehaviorTree.removeIndexer(parentKeypath, tree.Keypath(indexName))
+			}
+		case parentKeypath.Part(-1).Equals(IndicesKeypath):
+			indexedKeypath, _ := parentKeypath.Pop()
+			newBehaviorTree.removeIndexer(indexedKeypath, key)
+		case key.Equals(ViewsKeypath):
+			for viewName := range newBehaviorTree.views[string(parentKeypath)] {
+				newBehaviorTree.removeView(parentKeypath, tree.Keypath(viewName))
+			}
+		case parentKeypath.Part(-1).Equals(ViewsKeypath):
+			viewParentKeypath, _ := parentKeypath.Pop()
+			newBehaviorTree.removeView(viewParentKeypath, key)
+		}
+
+		for parentKeypath != nil {
+			nextParentKeypath, key := parentKeypath.Pop()
+			switch {
+			case key.Equals(MergeTypeKeypath):
+				err := c.initializeResolver(newBehaviorTree, state, parentKeypath, nil, history)
+				if err != nil {
+					return nil, err
+				}
+			case key.Equals(ValidatorKeypath):
+				err := c.initializeValidator(newBehaviorTree, state, parentKeypath)
+				if err != nil {
+					return nil, err
+				}
+			case key.Equals(IndicesKeypath):
+				err := c.initializeIndexer(newBehaviorTree, state, parentKeypath)
+				if err != nil {
+					return nil, err
+				}
+			case key.Equals(ViewsKeypath):
+				err := c.initializeViews(newBehaviorTree, state, parentKeypath)
+				if err != nil {
+					return nil, err
+				}
+			}
+			parentKeypath = nextParentKeypath
+		}
+	}
+
+	// Attach added resolvers, validators, indexers and views
 	for kp := range diff.Added {
 		keypath := tree.Keypath(kp)
 		parentKeypath, key := keypath.Pop()
 		switch {
-		case key.Equals(nelson.ValueKey):
-			contentType, err := nelson.GetContentType(state.NodeAt(parentKeypath, nil))
-			if err != nil && errors.Cause(err) != types.Err404 {
-				c.Errorf("error getting ref content type: %v", err)
+		case key.Equals(MergeTypeKeypath):
+			err := c.initializeResolver(newBehaviorTree, state, keypath, nil, history)
+			if err != nil {
+				return nil, err
+			}
+
+		case key.Equals(ValidatorKeypath):
+			err := c.initializeValidator(newBehaviorTree, state, keypath)
+			if err != nil {
+				return nil, err
+			}
+
+		case key.Equals(IndicesKeypath):
+			err := c.initializeIndexer(newBehaviorTree, state, keypath)
+			if err != nil {
+				return nil, err
+			}
+
+		case key.Equals(ViewsKeypath):
+			err := c.initializeViews(newBehaviorTree, state, keypath)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for parentKeypath != nil {
+			nextParentKeypath, key := parentKeypath.Pop()
+			switch {
+			case key.Equals(MergeTypeKeypath):
+				err := c.initializeResolver(newBehaviorTree, state, parentKeypath, nil, history)
+				if err != nil {
+					return nil, err
+				}
+			case key.Equals(ValidatorKeypath):
+				err := c.initializeValidator(newBehaviorTree, state, parentKeypath)
+				if err != nil {
+					return nil, err
+				}
+			case key.Equals(IndicesKeypath):
+				err := c.initializeIndexer(newBehaviorTree, state, parentKeypath)
+				if err != nil {
+					return nil, err
+				}
+			case key.Equals(ViewsKeypath):
+				err := c.initializeViews(newBehaviorTree, state, parentKeypath)
+				if err != nil {
+					return nil, err
+				}
+			}
+			parentKeypath = nextParentKeypath
+		}
+	}
+	return newBehaviorTree, nil
+}
+
+// resolverTypeChanged returns true if the state has a resolver at the given
+// keypath whose type differs from the one in the behavior tree.
+func (c *controller) resolverTypeChanged(behaviorTree *behaviorTree, state tree.Node, resolverNodeKeypath tree.Keypath) (bool, error) {
+	oldContentType, exists := behaviorTree.resolverTypes[string(resolverNodeKeypath)]
+	if !exists {
+		return false, nil
+	}
+	configKeypath := resolverNodeKeypath.Push(MergeTypeKeypath)
+	exists, err := state.Exists(configKeypath)
+	if err != nil || !exists {
+		return false, err
+	}
+	contentType, err := nelson.GetContentType(state.NodeAt(configKeypath, nil))
+	if err != nil {
+		return false, err
+	}
+	return contentType != oldContentType, nil
+}
+
+// initializeResolver (re)creates the resolver configured at the given
+// keypath.  history is only needed if the resolver's type may have changed
+// (see updateBehaviorTree).
+func (c *controller) initializeResolver(
+	behaviorTree *behaviorTree,
+	state tree.Node,
+	resolverConfigKeypath tree.Keypath,
+	internalState map[string]interface{},
+	history func() ([]*Tx, error),
+) error {
+	// Resolve any refs (to code) in the resolver config object.  We copy the config so
+	// that we don't inject any refs into the state tree itself
+	config, err := state.CopyToMemory(resolverConfigKeypath, nil)
+	if err != nil {
+		return err
+	}
+
+	config, anyMissing, err := nelson.Resolve(config, c.controllerHub)
+	if err != nil {
+		return err
+	} else if anyMissing {
+		return c.missingRefsError(state.NodeAt(resolverConfigKeypath, nil))
+	}
+
+	contentType, err := nelson.GetContentType(config)
+	if err != nil {
+		return err
+	} else if contentType == "" {
+		return errors.New("cannot initialize resolver without a 'Content-Type' key")
+	}
+
+	ctor, exists := resolverRegistry[contentType]
+	if !exists {
+		return errors.Errorf("unknown resolver type '%v'", contentType)
+	}
+
+	resolverNodeKeypath, _ := resolverConfigKeypath.Pop()
+
+	var resolver Resolver
+	oldResolver, oldResolverKeypath := behaviorTree.nearestResolverForKeypath(resolverNodeKeypath)
+	oldContentType := behaviorTree.resolverTypes[string(oldResolverKeypath)]
+
+	if oldResolver == nil || !oldResolverKeypath.Equals(resolverNodeKeypath) {
+		if internalState == nil {
+			internalState = make(map[string]interface{})
+		}
+		resolver, err = c.newResolver(ctor, config, internalState)
+		if err != nil {
+			return err
+		}
+
+	} else if oldContentType == contentType {
+		resolver, err = c.newResolver(ctor, config, oldResolver.InternalState())
+		if err != nil {
+			return err
+		}
+
+	} else {
+		resolver, err = c.migrateResolver(behaviorTree, state, resolverNodeKeypath, oldResolver, oldContentType, contentType, ctor, config, history)
+		if err != nil {
+			c.Errorf("resolver migration failed (keypath=%v from=%v to=%v): %v", resolverNodeKeypath, oldContentType, contentType, err)
+			return errors.Wrapf(ErrInvalidTx, "cannot migrate resolver at '%v' from %v to %v: %v", resolverNodeKeypath, oldContentType, contentType, err)
+		}
+	}
+
+	behaviorTree.addResolver(resolverNodeKeypath, contentType, resolver)
+	return nil
+}
+
+// newResolver constructs a resolver and, if it needs to know how txs are
+// related, gives it access to the tx DAG.
+func (c *controller) newResolver(ctor ResolverConstructor, config tree.Node, internalState map[string]interface{}) (Resolver, error) {
+	resolver, err := ctor(config, internalState)
+	if err != nil {
+		return nil, err
+	}
+	if resolver, is := resolver.(TxDAGResolver); is {
+		resolver.SetTxParentsLookup(c.txParents)
+	}
+	return resolver, nil
+}
+
+func (c *controller) txParents(txID types.ID) ([]types.ID, error) {
+	tx, err := c.txStore.FetchTx(c.stateURI, txID)
+	if err != nil {
+		return nil, err
+	}
+	return tx.Parents, nil
+}
+
+// migrateResolver constructs a resolver of a new type in place of one whose
+// Merge-Type Content-Type has changed.  If the new resolver implements
+// ResolverMigrator, it's given the old resolver's internal state.  Otherwise,
+// its internal state is rebuilt by replaying the history of the resolver's
+// subtree, which has to reproduce the subtree as it is in the given state.
+func (c *controller) migrateResolver(
+	behaviorTree *behaviorTree,
+	state tree.Node,
+	resolverNodeKeypath tree.Keypath,
+	oldResolver Resolver,
+	oldContentType string,
+	newContentType string,
+	ctor ResolverConstructor,
+	config tree.Node,
+	history func() ([]*Tx, error),
+) (_ Resolver, err error) {
+	defer utils.Annotate(&err, "migrateResolver")
+
+	c.Warnf("resolver type at '%v' changed from %v to %v, migrating", resolverNodeKeypath, oldContentType, newContentType)
+
+	resolver, err := c.newResolver(ctor, config, make(map[string]interface{}))
+	if err != nil {
+		return nil, err
+	}
+
+	if migrator, is := resolver.(ResolverMigrator); is {
+		err = migrator.MigrateFrom(oldContentType, oldResolver.InternalState(), state.NodeAt(resolverNodeKeypath, nil))
+		if err != nil {
+			return nil, err
+		}
+		return resolver, nil
+	}
+
+	if history == nil {
+		return nil, errors.New("no history to replay")
+	}
+	txs, err := history()
+	if err != nil {
+		return nil, err
+	}
+	err = c.replayResolverHistory(behaviorTree, txs, resolverNodeKeypath, resolver, state.NodeAt(resolverNodeKeypath, nil))
+	if err != nil {
+		return nil, err
+	}
+	return resolver, nil
+}
+
+// appliedHistory returns a function that returns the txs that produced the
+// current state once the given txs (which are being applied, in order) have
+// been applied to it.
+func (c *controller) appliedHistory(pending ...*Tx) func() ([]*Tx, error) {
+	return func() ([]*Tx, error) {
+		txs, err := c.validTxsInCausalOrder()
+		if err != nil {
+			return nil, err
+		}
+		return append(txs, pending...), nil
+	}
+}
+
+// replayResolverHistory feeds the patches from each of the given txs (in
+// order) that touched the given resolver's subtree through the resolver,
+// against a scratch state.  Patches to an ancestor of the subtree are trimmed
+// down to the part that lies within it.  Patches to the behavior configs at
+// the root of the subtree and to the subtrees of more deeply nested resolvers
+// aren't the resolver's to handle, so they're skipped.
+//
+// Only the resolver's internal state is kept, but the scratch state has to
+// match the expected subtree (which the same txs produced), or else the
+// resolver can't be trusted to carry on from there.
+func (c *controller) replayResolverHistory(behaviorTree *behaviorTree, txs []*Tx, resolverNodeKeypath tree.Keypath, resolver Resolver, expected tree.Node) error {
+	var nestedResolverKeypaths []tree.Keypath
+	for _, kp := range behaviorTree.resolverKeypaths {
+		if len(kp) > len(resolverNodeKeypath) && kp.StartsWith(resolverNodeKeypath) {
+			nestedResolverKeypaths = append(nestedResolverKeypaths, kp.RelativeTo(resolverNodeKeypath))
+		}
+	}
+
+	scratch := tree.NewMemoryNode()
+
+	for _, tx := range txs {
+		var patches []Patch
+	PatchLoop:
+		for _, patch := range tx.Patches {
+			var relPatch Patch
+			if patch.Keypath.StartsWith(resolverNodeKeypath) {
+				relPatch = Patch{Keypath: patch.Keypath.RelativeTo(resolverNodeKeypath), Range: patch.Range, Val: patch.Val}
+			} else if resolverNodeKeypath.StartsWith(patch.Keypath) && patch.Range == nil {
+				// The patch replaces an ancestor of the subtree, and with it the subtree
+				val, _ := getValue(patch.Val, resolverNodeKeypath.RelativeTo(patch.Keypath).PartStrings())
+				relPatch = Patch{Val: val}
+			} else {
 				continue
-			} else if contentType != "link" {
+			}
+
+			if relPatch.Keypath.StartsWith(MergeTypeKeypath) || relPatch.Keypath.StartsWith(ValidatorKeypath) {
 				continue
 			}
+			for _, kp := range nestedResolverKeypaths {
+				if relPatch.Keypath.StartsWith(kp) {
+					continue PatchLoop
+				}
+			}
+			if len(relPatch.Keypath) == 0 && relPatch.Range == nil {
+				relPatch.Val = replayedValue(relPatch.Val, nestedResolverKeypaths)
+			}
+			patches = append(patches, relPatch)
+		}
+		if len(patches) == 0 {
+			continue
+		}
+
+		err := resolver.ResolveState(scratch, c.refStore, tx.From, tx.ID, tx.Parents, patches)
+		if err != nil {
+			return errors.Wrapf(err, "while replaying tx %v", tx.ID.Pretty())
+		}
+	}
+
+	replayedVal, _, err := scratch.Value(nil, nil)
+	if err != nil {
+		return err
+	}
+	expectedVal, _, err := expected.Value(nil, nil)
+	if err != nil {
+		return err
+	}
+	replayed, err := comparableReplayedValue(replayedVal, nestedResolverKeypaths)
+	if err != nil {
+		return err
+	}
+	current, err := comparableReplayedValue(expectedVal, nestedResolverKeypaths)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(replayed, current) {
+		return errors.Errorf("replaying the history of '%v' doesn't reproduce its current state", resolverNodeKeypath)
+	}
+	return nil
+}
+
+// replayedValue returns a copy of a value written to the root of a resolver's
+// subtree, minus the parts that the resolver doesn't handle: the behavior
+// configs at its root and the subtrees of nested resolvers.
+func replayedValue(val interface{}, nestedResolverKeypaths []tree.Keypath) interface{} {
+	asMap, isMap := val.(map[string]interface{})
+	if !isMap {
+		return val
+	}
+	val = DeepCopyJSValue(asMap)
+	asMap = val.(map[string]interface{})
+
+	delete(asMap, string(MergeTypeKeypath))
+	delete(asMap, string(ValidatorKeypath))
+	for _, kp := range nestedResolverKeypaths {
+		parent, key := kp.Pop()
+		if parentMap, isMap := getValue(asMap, parent.PartStrings()); isMap {
+			if parentMap, isMap := parentMap.(map[string]interface{}); isMap {
+				delete(parentMap, string(key))
+			}
+		}
+	}
+	return asMap
+}
+
+// comparableReplayedValue prepares the value of a resolver's subtree to be
+// compared with the result of replaying its history.  Besides the parts that
+// replayedValue removes, it removes the values of views, which are computed
+// rather than resolved, and normalizes the types of the values.
+func comparableReplayedValue(val interface{}, nestedResolverKeypaths []tree.Keypath) (interface{}, error) {
+	bs, err := json.Marshal(replayedValue(val, nestedResolverKeypaths))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var plain interface{}
+	err = json.Unmarshal(bs, &plain)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var removeViews func(val interface{})
+	removeViews = func(val interface{}) {
+		switch val := val.(type) {
+		case map[string]interface{}:
+			if views, isMap := val[string(ViewsKeypath)].(map[string]interface{}); isMap {
+				for name := range views {
+					delete(val, name)
+				}
+			}
+			for _, child := range val {
+				removeViews(child)
+			}
+		case []interface{}:
+			for _, child := range val {
+				removeViews(child)
+			}
+		}
+	}
+	removeViews(plain)
+	return plain, nil
+}
+
+// validTxsInCausalOrder returns all of the valid txs for this controller's
+// state URI, ordered such that every tx comes after all of its parents.
+func (c *controller) validTxsInCausalOrder() ([]*Tx, error) {
+	iter := c.txStore.AllTxsForStateURI(c.stateURI, GenesisTxID)
+	defer iter.Cancel()
+
+	var all []*Tx
+	for {
+		tx := iter.Next()
+		if tx == nil {
+			break
+		} else if tx.Status != TxStatusValid {
+			continue
+		}
+		all = append(all, tx)
+	}
+	if iter.Error() != nil {
+		return nil, iter.Error()
+	}
+	return sortTxsCausally(all), nil
+}
+
+// causalPast returns the given tx and all of its ancestors, ordered such that
+// every tx comes after all of its parents.
+func (c *controller) causalPast(txID types.ID) ([]*Tx, error) {
+	var all []*Tx
+	seen := map[types.ID]bool{txID: true}
+	queue := []types.ID{txID}
+	for len(queue) > 0 {
+		tx, err := c.txStore.FetchTx(c.stateURI, queue[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "tx=%v", queue[0].Pretty())
+		}
+		queue = queue[1:]
+		all = append(all, tx)
+
+		parents, err := c.resolvedParents(tx)
+		if err != nil {
+			return nil, err
+		}
+		for _, parentID := range parents {
+			if !seen[parentID] {
+				seen[parentID] = true
+				queue = append(queue, parentID)
+			}
+		}
+	}
+	return sortTxsCausally(all), nil
+}
+
+// sortTxsCausally orders txs such that every tx comes after all of its parents.
+// Parents that aren't in the given set are ignored.
+func sortTxsCausally(txs []*Tx) []*Tx {
+	txsByID := make(map[types.ID]*Tx, len(txs))
+	for _, tx := range txs {
+		txsByID[tx.ID] = tx
+	}
+
+	sorted := make([]*Tx, 0, len(txs))
+	visited := make(map[types.ID]bool, len(txs))
+	var visit func(tx *Tx)
+	visit = func(tx *Tx) {
+		if visited[tx.ID] {
+			return
+		}
+		visited[tx.ID] = true
+		for _, parentID := range tx.Parents {
+			if parent, exists := txsByID[parentID]; exists {
+				visit(parent)
+			}
+		}
+		sorted = append(sorted, tx)
+	}
+	for _, tx := range txs {
+		visit(tx)
+	}
+	return sorted
+}
+
+func (c *controller) haveVersion(version types.ID) (bool, error) {
+	state := c.states.StateAtVersion(&version, false)
+	defer state.Close()
+	return state.Exists(nil)
+}
+
+// ensureVersion rebuilds the state at the given tx if it isn't already in the
+// DB.  Txs that aren't valid have no state, so they're reported as missing.
+func (c *controller) ensureVersion(version types.ID) (err error) {
+	defer utils.Annotate(&err, "version=%v", version.Pretty())
+
+	c.rebuildVersionMu.Lock()
+	defer c.rebuildVersionMu.Unlock()
+
+	have, err := c.haveVersion(version)
+	if err != nil {
+		return err
+	} else if have {
+		return nil
+	}
+
+	tx, err := c.txStore.FetchTx(c.stateURI, version)
+	if err != nil {
+		return err
+	} else if tx.Status != TxStatusValid {
+		return types.Err404
+	}
+	return c.rebuildVersion(version)
+}
+
+// rebuildVersion reconstructs the state at the given tx by starting from its
+// nearest ancestor with a saved version (whether it was saved as a checkpoint,
+// automatically, or by an earlier rebuild) and replaying the rest of its
+// causal past through the behavior tree.  The txs have already been
+// validated, so validators are skipped.  Saved versions only ever contain
+// their own causal past (see checkpointIfNeeded), so they can be built on.
+// The resolvers start from the internal states that were saved along with
+// the checkpoint.  If there aren't any (say, because the checkpoint was
+// rebuilt from an older one), they're caught up by replaying the checkpoint's
+// history instead.  The rebuilt version gets the resolvers' internal states
+// too, so that later rebuilds can start from it.
+func (c *controller) rebuildVersion(version types.ID) (err error) {
+	past, err := c.causalPast(version)
+	if err != nil {
+		return err
+	}
+
+	var checkpoint *Tx
+	for i := len(past) - 2; i >= 0; i-- {
+		have, err := c.haveVersion(past[i].ID)
+		if err != nil {
+			return err
+		} else if have {
+			checkpoint = past[i]
+			break
+		}
+	}
+
+	// Don't leave a partially rebuilt version behind, or it'll be mistaken
+	// for the real thing
+	defer func() {
+		if err != nil {
+			err2 := c.deleteVersion(version)
+			if err2 != nil {
+				c.Errorf("error deleting partially rebuilt version %v: %v", version.Pretty(), err2)
+			}
+		}
+	}()
+
+	alreadyApplied := make(map[types.ID]bool)
+	var checkpointPast []*Tx
+	if checkpoint != nil {
+		err = c.states.CopyVersion(version, checkpoint.ID)
+		if err != nil {
+			return err
+		}
+
+		checkpointPast, err = c.causalPast(checkpoint.ID)
+		if err != nil {
+			return err
+		}
+		for _, tx := range checkpointPast {
+			alreadyApplied[tx.ID] = true
+		}
+	}
+
+	var internalStates map[string]map[string]interface{}
+	if checkpoint != nil {
+		internalStates, err = c.loadResolverStates(&checkpoint.ID, []types.ID{checkpoint.ID})
+		if err != nil {
+			return err
+		}
+	}
+
+	state := c.states.StateAtVersion(&version, false)
+	behaviorTree, err := c.behaviorTreeForState(state, internalStates)
+	if err == nil && checkpoint != nil && internalStates == nil {
+		err = c.replayResolvers(behaviorTree, state, checkpointPast)
+	}
+	state.Close()
+	if err != nil {
+		return err
+	}
+
+	var replayed int
+	for _, tx := range past {
+		if alreadyApplied[tx.ID] {
+			continue
+		}
+		behaviorTree, err = c.replayTx(behaviorTree, version, tx)
+		if err != nil {
+			return errors.Wrapf(err, "while replaying tx %v", tx.ID.Pretty())
+		}
+		replayed++
+	}
+
+	// Make sure that the version has a root node so that it's found next time
+	have, err := c.haveVersion(version)
+	if err != nil {
+		return err
+	} else if !have {
+		state := c.states.StateAtVersion(&version, true)
+		defer state.Close()
+
+		err = state.Set(nil, nil, map[string]interface{}{})
+		if err != nil {
+			return err
+		}
+		err = state.Save()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.states.RecordVersion(version, time.Now())
+	if err != nil {
+		return err
+	}
+
+	err = c.saveResolverStates(&version, newBehaviorTree(), behaviorTree, []types.ID{version})
+	if err != nil {
+		c.Errorf("error saving resolver states at version %v: %v", version.Pretty(), err)
+	}
+
+	c.Debugf("rebuilt state at version %v (replayed %v txs)", version.Pretty(), replayed)
+	return nil
+}
+
+// Compact replaces the history of the state URI up to and including one of
+// its txs with a snapshot of the state at that tx (see Tx.IsSnapshot).  The
+// snapshot has the same ID as the tx that it replaces, so the txs that come
+// after it don't need to change.  Every other valid tx must be either an
+// ancestor or a descendant of that tx, since a concurrent tx would lose part
+// of its history.
+func (c *controller) Compact(snapshot *Tx) (err error) {
+	defer utils.Annotate(&err, "stateURI=%v snapshot=%v", c.stateURI, snapshot.ID.Pretty())
+
+	if !snapshot.IsSnapshot() {
+		return errors.Wrap(ErrInvalidTx, "not a snapshot")
+	} else if snapshot.StateURI != c.stateURI {
+		return errors.Wrapf(ErrInvalidTx, "snapshot is for a different state URI (%v)", snapshot.StateURI)
+	}
+	err = verifyTxSignature(snapshot)
+	if err != nil {
+		return err
+	}
+
+	c.addTxMu.Lock()
+	defer c.addTxMu.Unlock()
+
+	// Saving the version also ensures that the tx is valid
+	err = c.ensureVersion(snapshot.ID)
+	if err != nil {
+		return err
+	}
+
+	c.rebuildVersionMu.Lock()
+	defer c.rebuildVersionMu.Unlock()
+
+	past, err := c.causalPast(snapshot.ID)
+	if err != nil {
+		return err
+	}
+	isPruned := make(map[types.ID]bool, len(past))
+	var prunedTxIDs []types.ID
+	for _, tx := range past {
+		if tx.ID != snapshot.ID {
+			isPruned[tx.ID] = true
+			prunedTxIDs = append(prunedTxIDs, tx.ID)
+		}
+	}
+
+	isDescendant := map[types.ID]bool{snapshot.ID: true}
+	queue := []types.ID{snapshot.ID}
+	for len(queue) > 0 {
+		tx, err := c.txStore.FetchTx(c.stateURI, queue[0])
+		if err != nil {
+			return err
+		}
+		queue = queue[1:]
+		for _, childID := range tx.Children {
+			if !isDescendant[childID] {
+				isDescendant[childID] = true
+				queue = append(queue, childID)
+			}
+		}
+	}
+
+	all, err := c.validTxsInCausalOrder()
+	if err != nil {
+		return err
+	}
+	for _, tx := range all {
+		if !isPruned[tx.ID] && !isDescendant[tx.ID] {
+			return errors.Errorf("tx %v is concurrent with %v", tx.ID.Pretty(), snapshot.ID.Pretty())
+		}
+	}
+
+	snapshot.Status = TxStatusValid
+	err = c.txStore.PruneTxs(snapshot, prunedTxIDs)
+	if err != nil {
+		return err
+	}
+
+	// The pruned txs' states can't be rebuilt anymore, so don't keep them
+	for _, txID := range prunedTxIDs {
+		err := c.deleteVersion(txID)
+		if err != nil {
+			return err
+		}
+		err = c.indices.DeleteVersion(txID)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.Successf("compacted %v txs into snapshot %v", len(prunedTxIDs), snapshot.ID.Pretty())
+	return nil
+}
+
+func (c *controller) shouldAutoCheckpoint() bool {
+	if c.config.CheckpointEveryNTxs > 0 && c.txsSinceCheckpoint >= c.config.CheckpointEveryNTxs {
+		return true
+	} else if c.config.CheckpointInterval > 0 && time.Since(c.lastCheckpointAt) >= time.Duration(c.config.CheckpointInterval) {
+		return true
+	}
+	return false
+}
+
+// Versions returns the saved versions of the state (not including the current
+// state), oldest first, along with the space that each one occupies.
+func (c *controller) Versions() ([]VersionInfo, error) {
+	versions, err := c.states.Versions()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]VersionInfo, len(versions))
+	for i, version := range versions {
+		stats, err := c.states.VersionStats(version.Version)
+		if err != nil {
+			return nil, err
+		}
+		infos[i] = VersionInfo{VersionInfo: version, VersionStats: stats}
+	}
+	return infos, nil
+}
+
+// collectVersionGarbage deletes saved versions according to the state URI's
+// retention policy.  The current state is never deleted.  Deleted versions
+// can still be rebuilt on demand by StateAtVersion.
+func (c *controller) collectVersionGarbage() error {
+	if c.config.MaxVersions == 0 && c.config.MaxVersionAge == 0 {
+		return nil
+	}
+
+	c.rebuildVersionMu.Lock()
+	defer c.rebuildVersionMu.Unlock()
+
+	versions, err := c.states.Versions()
+	if err != nil {
+		return err
+	}
 
-			linkStr, _, err := state.StringValue(keypath)
+	var numToDelete int
+	if c.config.MaxVersionAge > 0 {
+		cutoff := time.Now().Add(-time.Duration(c.config.MaxVersionAge))
+		for numToDelete < len(versions) && versions[numToDelete].SavedAt.Before(cutoff) {
+			numToDelete++
+		}
+	}
+	if c.config.MaxVersions > 0 && uint64(len(versions)-numToDelete) > c.config.MaxVersions {
+		numToDelete = len(versions) - int(c.config.MaxVersions)
+	}
+
+	for _, version := range versions[:numToDelete] {
+		err := c.deleteVersion(version.Version)
+		if err != nil {
+			return err
+		}
+		c.Infof(0, "deleted old version %v (saved %v)", version.Version.Pretty(), version.SavedAt)
+	}
+	return nil
+}
+
+// deleteVersion deletes a saved version of the state along with the resolver
+// states that were saved with it.
+func (c *controller) deleteVersion(version types.ID) error {
+	err := c.states.DeleteVersion(version)
+	if err != nil {
+		return err
+	}
+	return c.resolverStates.DeleteVersion(version)
+}
+
+func (c *controller) expireVersionsLoop() {
+	interval := time.Duration(c.config.MaxVersionAge) / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.chStop:
+			return
+		case <-ticker.C:
+			err := c.collectVersionGarbage()
 			if err != nil {
-				c.Errorf("error getting ref link value: %v", err)
-				continue
+				c.Errorf("error collecting old versions: %v", err)
 			}
-			linkType, linkValue := nelson.DetermineLinkType(linkStr)
-			if linkType == nelson.LinkTypeRef {
-				var refID types.RefID
-				err := refID.UnmarshalText([]byte(linkValue))
-				if err != nil {
-					c.Errorf("error unmarshaling refID: %v", err)
-					continue
-				}
-				refs = append(refs, refID)
+		}
+	}
+}
+
+// replayResolvers catches the resolvers in a behavior tree built from
+// scratch up on the history that produced the given state.
+func (c *controller) replayResolvers(behaviorTree *behaviorTree, state tree.Node, history []*Tx) error {
+	for _, resolverKeypath := range behaviorTree.resolverKeypaths {
+		resolver := behaviorTree.resolvers[string(resolverKeypath)]
+		if resolver, is := resolver.(StatelessResolver); is && resolver.Stateless() {
+			continue
+		}
+		err := c.replayResolverHistory(behaviorTree, history, resolverKeypath, resolver, state.NodeAt(resolverKeypath, nil))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replayTx applies an already-validated tx to the state at the given version.
+func (c *controller) replayTx(behaviorTree *behaviorTree, version types.ID, tx *Tx) (*behaviorTree, error) {
+	state := c.states.StateAtVersion(&version, true)
+	defer state.Close()
+
+	err := c.applyTxPatches(behaviorTree, state, tx)
+	if err != nil {
+		return nil, err
+	}
+	// The state at the version is the result of the tx's causal past
+	history := func() ([]*Tx, error) { return c.causalPast(tx.ID) }
+
+	newBehaviorTree, err := c.updateBehaviorTree(behaviorTree, state, history)
+	if err != nil {
+		return nil, err
+	}
+	err = c.updateViews(state, state.Diff(), nil, behaviorTree, newBehaviorTree)
+	if err != nil {
+		return nil, err
+	}
+	return newBehaviorTree, state.Save()
+}
+
+// behaviorTreeForState builds a behavior tree from scratch by initializing
+// every resolver, validator, indexer and view found in the given state.  The
+// resolvers start out with the given internal states (by keypath), if any.
+func (c *controller) behaviorTreeForState(state tree.Node, internalStates map[string]map[string]interface{}) (*behaviorTree, error) {
+	behaviorTree := newBehaviorTree()
+
+	var resolverConfigs, validatorConfigs, indexerConfigs, viewConfigs []tree.Keypath
+	iter := state.Iterator(nil, false, 0)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		keypath := iter.Node().Keypath().Copy()
+		if len(keypath) == 0 {
+			continue
+		}
+		switch {
+		case keypath.Part(-1).Equals(MergeTypeKeypath):
+			resolverConfigs = append(resolverConfigs, keypath)
+		case keypath.Part(-1).Equals(ValidatorKeypath):
+			validatorConfigs = append(validatorConfigs, keypath)
+		case keypath.Part(-1).Equals(IndicesKeypath):
+			indexerConfigs = append(indexerConfigs, keypath)
+		case keypath.Part(-1).Equals(ViewsKeypath):
+			viewConfigs = append(viewConfigs, keypath)
+		}
+	}
+	iter.Close()
+
+	for _, keypath := range resolverConfigs {
+		resolverNodeKeypath, _ := keypath.Pop()
+		err := c.initializeResolver(behaviorTree, state, keypath, internalStates[string(resolverNodeKeypath)], nil)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, keypath := range validatorConfigs {
+		err := c.initializeValidator(behaviorTree, state, keypath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, keypath := range indexerConfigs {
+		err := c.initializeIndexer(behaviorTree, state, keypath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, keypath := range viewConfigs {
+		err := c.initializeViews(behaviorTree, state, keypath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if _, exists := behaviorTree.resolvers[""]; !exists {
+		behaviorTree.addResolver(nil, "resolver/dumb", &dumbResolver{})
+	}
+	return behaviorTree, nil
+}
+
+func debugPrint(inFormat string, args ...interface{}) {
+	fmt.Printf(inFormat, args...)
+}
+
+func (c *controller) initializeValidator(behaviorTree *behaviorTree, state tree.Node, validatorConfigKeypath tree.Keypath) error {
+	// Resolve any refs (to code) in the validator config object.  We copy the config so
+	// that we don't inject any refs into the state tree itself
+	config, err := state.CopyToMemory(validatorConfigKeypath, nil)
+	if err != nil {
+		return err
+	}
+
+	config, anyMissing, err := nelson.Resolve(config, c.controllerHub)
+	if err != nil {
+		return err
+	} else if anyMissing {
+		return c.missingRefsError(state.NodeAt(validatorConfigKeypath, nil))
+	}
+
+	contentType, err := nelson.GetContentType(config)
+	if err != nil {
+		return err
+	} else if contentType == "" {
+		return errors.New("cannot initialize validator without a 'Content-Type' key")
+	}
+
+	ctor, exists := validatorRegistry[contentType]
+	if !exists {
+		return errors.Errorf("unknown validator type '%v'", contentType)
+	}
+
+	validator, err := ctor(config)
+	if err != nil {
+		return err
+	}
+
+	validatorNodeKeypath, _ := validatorConfigKeypath.Pop()
+
+	behaviorTree.addValidator(validatorNodeKeypath, validator)
+	return nil
+}
+
+func (c *controller) initializeIndexer(behaviorTree *behaviorTree, state tree.Node, indexerConfigKeypath tree.Keypath) error {
+	// Resolve any refs (to code) in the indexer config object.  We copy the config so
+	// that we don't inject any refs into the state tree itself
+	indexConfigs, err := state.CopyToMemory(indexerConfigKeypath, nil)
+	if errors.Cause(err) == types.Err404 {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	indexerNodeKeypath, _ := indexerConfigKeypath.Pop()
+	subkeys := indexConfigs.Subkeys()
+
+	// Remove any indexers that are no longer present in the config
+	for indexName := range behaviorTree.indexers[string(indexerNodeKeypath)] {
+		var found bool
+		for _, subkey := range subkeys {
+			if subkey.Equals(tree.Keypath(indexName)) {
+				found = true
+				break
 			}
 		}
+		if !found {
+			behaviorTree.removeIndexer(indexerNodeKeypath, tree.Keypath(indexName))
+		}
+	}
+
+	for _, indexName := range subkeys {
+		config, anyMissing, err := nelson.Resolve(indexConfigs.NodeAt(indexName, nil), c.controllerHub)
+		if err != nil {
+			return err
+		} else if anyMissing {
+			return c.missingRefsError(state.NodeAt(indexerConfigKeypath.Push(indexName), nil))
+		}
+
+		contentType, err := nelson.GetContentType(config)
+		if err != nil {
+			return err
+		} else if contentType == "" {
+			return errors.New("cannot initialize indexer without a 'Content-Type' key")
+		}
+
+		ctor, exists := indexerRegistry[contentType]
+		if !exists {
+			return errors.Errorf("unknown indexer type '%v'", contentType)
+		}
+
+		indexer, err := ctor(config)
+		if err != nil {
+			return err
+		}
+
+		behaviorTree.addIndexer(indexerNodeKeypath, indexName, indexer)
 	}
+	return nil
 }
 
-func (c *controller) updateBehaviorTree(state tree.Node) error {
-	// Walk the tree and initialize validators and resolvers (@@TODO: inefficient)
+func (c *controller) initializeViews(behaviorTree *behaviorTree, state tree.Node, viewsConfigKeypath tree.Keypath) error {
+	// Resolve any refs (to code) in the view config object.  We copy the config so
+	// that we don't inject any refs into the state tree itself
+	viewConfigs, err := state.CopyToMemory(viewsConfigKeypath, nil)
+	if errors.Cause(err) == types.Err404 {
+		return nil
+	} else if err != nil {
+		return err
+	}
 
-	// We need to be able to roll back in case of error, so we make a copy
-	newBehaviorTree := c.behaviorTree.copy()
+	viewParentKeypath, _ := viewsConfigKeypath.Pop()
+	subkeys := viewConfigs.Subkeys()
 
-	diff := state.Diff()
+	// Remove any views that are no longer present in the config
+	for viewName := range behaviorTree.views[string(viewParentKeypath)] {
+		var found bool
+		for _, subkey := range subkeys {
+			if subkey.Equals(tree.Keypath(viewName)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			behaviorTree.removeView(viewParentKeypath, tree.Keypath(viewName))
+		}
+	}
 
-	// Remove deleted resolvers and validators
-	for kp := range diff.Removed {
-		parentKeypath, key := tree.Keypath(kp).Pop()
+	for _, viewName := range subkeys {
 		switch {
-		case key.Equals(MergeTypeKeypath):
-			c.behaviorTree.removeResolver(parentKeypath)
-		case key.Equals(ValidatorKeypath):
-			c.behaviorTree.removeValidator(parentKeypath)
-		case parentKeypath.Part(-1).Equals(tree.Keypath("Indices")):
-			//indicesKeypath, _ := parentKeypath.Pop()
-			//c.behaviorTree.removeIndexer()
+		case viewName.Equals(MergeTypeKeypath), viewName.Equals(ValidatorKeypath), viewName.Equals(MembersKeypath),
+			viewName.Equals(IndicesKeypath), viewName.Equals(ViewsKeypath):
+			return errors.Errorf("'%v' can't be used as the name of a view", viewName)
 		}
 
-		for parentKeypath != nil {
-			nextParentKeypath, key := parentKeypath.Pop()
-			switch {
-			case key.Equals(MergeTypeKeypath):
-				err := c.initializeResolver(newBehaviorTree, state, parentKeypath)
-				if err != nil {
-					return err
+		config, anyMissing, err := nelson.Resolve(viewConfigs.NodeAt(viewName, nil), c.controllerHub)
+		if err != nil {
+			return err
+		} else if anyMissing {
+			return c.missingRefsError(state.NodeAt(viewsConfigKeypath.Push(viewName), nil))
+		}
+
+		contentType, err := nelson.GetContentType(config)
+		if err != nil {
+			return err
+		} else if contentType == "" {
+			return errors.New("cannot initialize view without a 'Content-Type' key")
+		}
+
+		ctor, exists := viewRegistry[contentType]
+		if !exists {
+			return errors.Errorf("unknown view type '%v'", contentType)
+		}
+
+		source, exists, err := config.StringValue(tree.Keypath("source"))
+		if err != nil {
+			return err
+		} else if !exists || source == "" {
+			return errors.Errorf("view '%v' needs a 'source' param", viewName)
+		} else if tree.Keypath(source).StartsWith(viewName) {
+			return errors.Errorf("view '%v' can't be its own source", viewName)
+		}
+
+		view, err := ctor(config)
+		if err != nil {
+			return err
+		}
+
+		behaviorTree.addView(viewParentKeypath, viewName, &materializedView{View: view, source: tree.Keypath(source)})
+	}
+	return nil
+}
+
+// viewSourceSnapshot holds the values that the entries of each view's source
+// had before a tx was applied, keyed by the keypath of the view, so that
+// views implementing IncrementalView can be updated from just the entries that
+// the tx changed.  Views that aren't in the snapshot are computed from their
+// whole source.
+type viewSourceSnapshot map[string]viewSourceEntries
+
+type viewSourceEntries struct {
+	sourceKeypath tree.Keypath
+	old           map[string]interface{}
+	touched       map[string]bool
+}
+
+// snapshotViewSources records the values of the entries of each incremental
+// view's source that the given patches could change.  Views whose sources
+// aren't maps, or that the patches could replace wholesale, are left out.
+func (c *controller) snapshotViewSources(state tree.Node, behaviorTree *behaviorTree, patches []Patch) (_ viewSourceSnapshot, err error) {
+	defer utils.Annotate(&err, "snapshotViewSources")
+
+	snapshot := make(viewSourceSnapshot)
+	for keypathStr, views := range behaviorTree.views {
+		keypath := tree.Keypath(keypathStr)
+
+	ViewLoop:
+		for viewName, view := range views {
+			if _, is := view.View.(IncrementalView); !is {
+				continue
+			}
+
+			node, _, err := nelson.Unwrap(state.NodeAt(keypath.Push(view.source), nil))
+			if err != nil {
+				return nil, err
+			}
+			nodeType, _, _, err := node.NodeInfo(nil)
+			if errors.Cause(err) == types.Err404 {
+				continue
+			} else if err != nil {
+				return nil, err
+			} else if nodeType != tree.NodeTypeMap {
+				continue
+			}
+
+			entries := viewSourceEntries{
+				sourceKeypath: node.Keypath().Copy(),
+				old:           make(map[string]interface{}),
+				touched:       make(map[string]bool),
+			}
+			for _, patch := range patches {
+				if entries.sourceKeypath.StartsWith(patch.Keypath) {
+					continue ViewLoop
+				} else if !patch.Keypath.StartsWith(entries.sourceKeypath) {
+					continue
 				}
-			case key.Equals(ValidatorKeypath):
-				err := c.initializeValidator(newBehaviorTree, state, parentKeypath)
+				key, _ := patch.Keypath.RelativeTo(entries.sourceKeypath).Shift()
+				if entries.touched[string(key)] {
+					continue
+				}
+				entries.touched[string(key)] = true
+
+				val, exists, err := node.Value(key, nil)
 				if err != nil {
-					return err
+					return nil, err
+				} else if exists {
+					entries.old[string(key)] = val
 				}
 			}
-			parentKeypath = nextParentKeypath
+			snapshot[keypath.Push(tree.Keypath(viewName)).String()] = entries
 		}
 	}
+	return snapshot, nil
+}
 
-	// Attach added resolvers and validators
-	for kp := range diff.Added {
-		keypath := tree.Keypath(kp)
-		parentKeypath, key := keypath.Pop()
-		switch {
-		case key.Equals(MergeTypeKeypath):
-			err := c.initializeResolver(newBehaviorTree, state, keypath)
-			if err != nil {
+// updateViews writes the new values of the views whose sources appear in the
+// given diff, along with any views that were (re)initialized, and deletes the
+// values of views that were removed.  Views that are in the given snapshot are
+// updated incrementally when the diff doesn't reach beyond the entries that
+// were recorded in it.
+//
+// A view that fails to compute keeps its previous value, so that a broken
+// view can't block txs that touch its source.
+func (c *controller) updateViews(state tree.Node, diff *tree.Diff, viewSources viewSourceSnapshot, oldBehaviorTree, newBehaviorTree *behaviorTree) (err error) {
+	defer utils.Annotate(&err, "updateViews")
+
+	for keypath, views := range oldBehaviorTree.views {
+		for viewName := range views {
+			if _, exists := newBehaviorTree.views[keypath][viewName]; exists {
+				continue
+			}
+			err := state.Delete(tree.Keypath(keypath).Push(tree.Keypath(viewName)), nil)
+			if err != nil && errors.Cause(err) != types.Err404 {
 				return err
 			}
+		}
+	}
 
-		case key.Equals(ValidatorKeypath):
-			err := c.initializeValidator(newBehaviorTree, state, keypath)
+	// Writing the views adds to the diff, so we take a copy of its contents first
+	changed := make([]tree.Keypath, 0, len(diff.AddedList)+len(diff.RemovedList))
+	changed = append(changed, diff.AddedList...)
+	changed = append(changed, diff.RemovedList...)
+
+	for keypathStr, views := range newBehaviorTree.views {
+		keypath := tree.Keypath(keypathStr)
+
+		for viewName, view := range views {
+			viewKeypath := keypath.Push(tree.Keypath(viewName))
+
+			node, _, err := nelson.Unwrap(state.NodeAt(keypath.Push(view.source), nil))
 			if err != nil {
 				return err
 			}
+			sourceKeypath := node.Keypath()
 
-		case key.Equals(tree.Keypath("Indices")):
-			err := c.initializeIndexer(newBehaviorTree, state, keypath)
-			if err != nil {
-				return err
+			initialized := oldBehaviorTree.views[keypathStr][viewName] != view
+			recompute := initialized
+			for _, kp := range changed {
+				if recompute {
+					break
+				}
+				recompute = kp.StartsWith(sourceKeypath) || sourceKeypath.StartsWith(kp) || viewKeypath.StartsWith(kp)
+			}
+			if !recompute {
+				continue
 			}
-		}
 
-		for parentKeypath != nil {
-			nextParentKeypath, key := parentKeypath.Pop()
-			switch {
-			case key.Equals(MergeTypeKeypath):
-				err := c.initializeResolver(newBehaviorTree, state, parentKeypath)
+			var val interface{}
+			var updated bool
+			if entries, exists := viewSources[viewKeypath.String()]; exists && !initialized && entries.sourceKeypath.Equals(sourceKeypath) {
+				val, updated, err = c.updateViewIncrementally(state, view, viewKeypath, node, entries, changed)
 				if err != nil {
 					return err
 				}
-			case key.Equals(ValidatorKeypath):
-				err := c.initializeValidator(newBehaviorTree, state, parentKeypath)
-				if err != nil {
+			}
+
+			if !updated {
+				source, err := node.CopyToMemory(nil, nil)
+				if errors.Cause(err) == types.Err404 {
+					source = tree.NewMemoryNode()
+				} else if err != nil {
 					return err
 				}
+
+				val, err = view.ComputeView(source)
+				if err != nil {
+					c.Errorf("error computing view '%v': %v", viewKeypath, err)
+					continue
+				}
+			}
+
+			if val == nil {
+				err = state.Delete(viewKeypath, nil)
+				if errors.Cause(err) == types.Err404 {
+					err = nil
+				}
+			} else {
+				err = state.Set(viewKeypath, nil, val)
+			}
+			if err != nil {
+				return err
 			}
-			parentKeypath = nextParentKeypath
 		}
 	}
-	c.behaviorTree = newBehaviorTree
 	return nil
 }
 
-func (c *controller) initializeResolver(behaviorTree *behaviorTree, state tree.Node, resolverConfigKeypath tree.Keypath) error {
-	// Resolve any refs (to code) in the resolver config object.  We copy the config so
-	// that we don't inject any refs into the state tree itself
-	config, err := state.CopyToMemory(resolverConfigKeypath, nil)
-	if err != nil {
-		return err
+// updateViewIncrementally hands an IncrementalView the old and new values of
+// the entries of its source that changed.  It returns false if the changes
+// reach beyond the entries in the snapshot, or if the view can't be updated
+// that way.
+func (c *controller) updateViewIncrementally(
+	state tree.Node,
+	view *materializedView,
+	viewKeypath tree.Keypath,
+	source tree.Node,
+	entries viewSourceEntries,
+	changed []tree.Keypath,
+) (interface{}, bool, error) {
+	for _, kp := range changed {
+		if !kp.StartsWith(entries.sourceKeypath) {
+			continue
+		} else if kp.Equals(entries.sourceKeypath) {
+			return nil, false, nil
+		}
+		key, _ := kp.RelativeTo(entries.sourceKeypath).Shift()
+		if !entries.touched[string(key)] {
+			return nil, false, nil
+		}
 	}
 
-	config, anyMissing, err := nelson.Resolve(config, c.controllerHub)
-	if err != nil {
-		return err
-	} else if anyMissing {
-		return errors.WithStack(ErrMissingCriticalRefs)
+	nodeType, _, _, err := source.NodeInfo(nil)
+	if errors.Cause(err) == types.Err404 {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	} else if nodeType != tree.NodeTypeMap {
+		return nil, false, nil
 	}
 
-	contentType, err := nelson.GetContentType(config)
+	current, exists, err := state.Value(viewKeypath, nil)
 	if err != nil {
-		return err
-	} else if contentType == "" {
-		return errors.New("cannot initialize resolver without a 'Content-Type' key")
-	}
-
-	ctor, exists := resolverRegistry[contentType]
-	if !exists {
-		return errors.Errorf("unknown resolver type '%v'", contentType)
-	}
-
-	// @@TODO: if the resolver type changes, this totally breaks everything
-	var internalState map[string]interface{}
-	oldResolver, oldResolverKeypath := behaviorTree.nearestResolverForKeypath(resolverConfigKeypath)
-	if !oldResolverKeypath.Equals(resolverConfigKeypath) {
-		internalState = make(map[string]interface{})
-	} else {
-		internalState = oldResolver.InternalState()
+		return nil, false, err
+	} else if !exists {
+		return nil, false, nil
 	}
 
-	resolver, err := ctor(config, internalState)
-	if err != nil {
-		return err
+	new := make(map[string]interface{}, len(entries.touched))
+	for key := range entries.touched {
+		val, exists, err := source.Value(tree.Keypath(key), nil)
+		if err != nil {
+			return nil, false, err
+		} else if exists {
+			new[key] = val
+		}
 	}
 
-	resolverNodeKeypath, _ := resolverConfigKeypath.Pop()
-
-	behaviorTree.addResolver(resolverNodeKeypath, resolver)
-	return nil
-}
-
-func debugPrint(inFormat string, args ...interface{}) {
-	fmt.Printf(inFormat, args...)
+	val, ok := view.View.(IncrementalView).UpdateView(current, entries.old, new)
+	return val, ok, nil
 }
 
-func (c *controller) initializeValidator(behaviorTree *behaviorTree, state tree.Node, validatorConfigKeypath tree.Keypath) error {
-	// Resolve any refs (to code) in the validator config object.  We copy the config so
-	// that we don't inject any refs into the state tree itself
-	config, err := state.CopyToMemory(validatorConfigKeypath, nil)
-	if err != nil {
-		return err
-	}
-
-	config, anyMissing, err := nelson.Resolve(config, c.controllerHub)
-	if err != nil {
-		return err
-	} else if anyMissing {
-		return errors.WithStack(ErrMissingCriticalRefs)
-	}
-
-	contentType, err := nelson.GetContentType(config)
-	if err != nil {
-		return err
-	} else if contentType == "" {
-		return errors.New("cannot initialize validator without a 'Content-Type' key")
-	}
-
-	ctor, exists := validatorRegistry[contentType]
-	if !exists {
-		return errors.Errorf("unknown validator type '%v'", contentType)
-	}
-
-	validator, err := ctor(config)
-	if err != nil {
-		return err
+// updateIndices keeps the indices of the current state in sync with the
+// changes made by a tx.  Indices whose indexer was (re)initialized are rebuilt
+// from scratch, indices that were removed are deleted, and the rest only
+// re-index the children that appear in the state's diff.
+//
+// The indices live in their own db, which can't be rolled back along with the
+// state, so this is only called once the state has been saved.
+func (c *controller) updateIndices(state tree.Node, oldBehaviorTree, newBehaviorTree *behaviorTree) (err error) {
+	defer utils.Annotate(&err, "updateIndices")
+
+	for keypath, indexers := range oldBehaviorTree.indexers {
+		for indexName := range indexers {
+			if _, exists := newBehaviorTree.indexers[keypath][indexName]; exists {
+				continue
+			}
+			err := c.indices.DeleteIndex(nil, tree.Keypath(keypath), tree.Keypath(indexName))
+			if err != nil {
+				return err
+			}
+		}
 	}
 
-	validatorNodeKeypath, _ := validatorConfigKeypath.Pop()
-
-	behaviorTree.addValidator(validatorNodeKeypath, validator)
-	return nil
-}
-
-func (c *controller) initializeIndexer(behaviorTree *behaviorTree, state tree.Node, indexerConfigKeypath tree.Keypath) error {
-	// Resolve any refs (to code) in the indexer config object.  We copy the config so
-	// that we don't inject any refs into the state tree itself
-	indexConfigs, err := state.CopyToMemory(indexerConfigKeypath, nil)
-	if err != nil {
-		return err
-	}
+	diff := state.Diff()
+	changed := make([]tree.Keypath, 0, len(diff.AddedList)+len(diff.RemovedList))
+	changed = append(changed, diff.AddedList...)
+	changed = append(changed, diff.RemovedList...)
 
-	subkeys := indexConfigs.Subkeys()
+	for keypathStr, indexers := range newBehaviorTree.indexers {
+		keypath := tree.Keypath(keypathStr)
 
-	for _, indexName := range subkeys {
-		config, anyMissing, err := nelson.Resolve(indexConfigs.NodeAt(indexName, nil), c.controllerHub)
+		node, _, err := nelson.Unwrap(state.NodeAt(keypath, nil))
 		if err != nil {
 			return err
-		} else if anyMissing {
-			return errors.WithStack(ErrMissingCriticalRefs)
 		}
-
-		contentType, err := nelson.GetContentType(config)
-		if err != nil {
-			return err
-		} else if contentType == "" {
-			return errors.New("cannot initialize indexer without a 'Content-Type' key")
+		nodeKeypath := node.Keypath()
+
+		var rebuild bool
+		childKeys := make(map[string]tree.Keypath)
+		for _, kp := range changed {
+			if nodeKeypath.StartsWith(kp) {
+				rebuild = true
+				break
+			} else if kp.StartsWith(nodeKeypath) {
+				childKey := kp.RelativeTo(nodeKeypath).Part(0)
+				childKeys[string(childKey)] = childKey
+			}
 		}
 
-		ctor, exists := indexerRegistry[contentType]
-		if !exists {
-			return errors.Errorf("unknown indexer type '%v'", contentType)
-		}
+		for indexName, indexer := range indexers {
+			fullRebuild := rebuild || oldBehaviorTree.indexers[keypathStr][indexName] != indexer
+			if !fullRebuild && len(childKeys) == 0 {
+				continue
+			}
 
-		indexer, err := ctor(config)
-		if err != nil {
-			return err
-		}
+			nodeType, _, _, err := node.NodeInfo(nil)
+			if err != nil && errors.Cause(err) != types.Err404 {
+				return err
+			}
 
-		indexerNodeKeypath, _ := indexerConfigKeypath.Pop()
+			var nodeToIndex tree.Node = node
+			if fullRebuild || nodeType != tree.NodeTypeMap {
+				// Full rebuilds iterate over the node's children, so they work
+				// from a copy rather than holding the state's db txn open
+				nodeToIndex, err = node.CopyToMemory(nil, nil)
+				if errors.Cause(err) == types.Err404 {
+					err = c.indices.DeleteIndex(nil, keypath, tree.Keypath(indexName))
+					if err != nil {
+						return err
+					}
+					continue
+				} else if err != nil {
+					return err
+				}
+			}
 
-		behaviorTree.addIndexer(indexerNodeKeypath, indexName, indexer)
+			if fullRebuild {
+				err = c.indices.BuildIndex(nil, keypath, nodeToIndex, tree.Keypath(indexName), indexer)
+			} else {
+				keys := make([]tree.Keypath, 0, len(childKeys))
+				for _, childKey := range childKeys {
+					keys = append(keys, childKey)
+				}
+				err = c.indices.UpdateIndex(nil, keypath, nodeToIndex, tree.Keypath(indexName), indexer, keys)
+			}
+			if err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
-func (c *controller) OnNewState(fn func(tx *Tx, state tree.Node, leaves []types.ID)) {
+// OnNewState registers a callback that's called with the txs that produced
+// each new state.  There's more than one tx when a batch was applied at once.
+func (c *controller) OnNewState(fn func(txs []*Tx, state tree.Node, leaves []types.ID)) {
 	c.newStateListenersMu.Lock()
 	defer c.newStateListenersMu.Unlock()
 	c.newStateListeners = append(c.newStateListeners, fn)
 }
 
-func (c *controller) notifyNewStateListeners(tx *Tx, state tree.Node, leaves []types.ID) {
+func (c *controller) notifyNewStateListeners(txs []*Tx, state tree.Node, leaves []types.ID) {
 	c.newStateListenersMu.RLock()
 	defer c.newStateListenersMu.RUnlock()
 
@@ -746,12 +2874,61 @@ func (c *controller) notifyNewStateListeners(tx *Tx, state tree.Node, leaves []t
 		handler := handler
 		go func() {
 			defer wg.Done()
-			handler(tx, state, leaves)
+			handler(txs, state, leaves)
 		}()
 	}
 	wg.Wait()
 }
 
+// OnMissingParents registers a callback that's called with each tx that
+// can't be applied because we've never received some of its parents.  It's
+// called from the mempool's goroutine, so it mustn't block.
+func (c *controller) OnMissingParents(fn func(tx *Tx, parentIDs []types.ID)) {
+	c.missingParentsListenersMu.Lock()
+	defer c.missingParentsListenersMu.Unlock()
+	c.missingParentsListeners = append(c.missingParentsListeners, fn)
+}
+
+func (c *controller) notifyMissingParentsListeners(tx *Tx, parentIDs []types.ID) {
+	c.missingParentsListenersMu.RLock()
+	defer c.missingParentsListenersMu.RUnlock()
+
+	for _, handler := range c.missingParentsListeners {
+		handler(tx, parentIDs)
+	}
+}
+
+// MissingParents returns the parents that we've never received of each tx
+// waiting in the mempool, keyed by the waiting tx's ID.
+func (c *controller) MissingParents() (_ map[types.ID][]types.ID, err error) {
+	defer utils.Annotate(&err, "stateURI=%v", c.stateURI)
+
+	txIDs, err := c.txStore.TxIDsWithStatus(c.stateURI, TxStatusInMempool)
+	if err != nil {
+		return nil, err
+	}
+
+	missing := make(map[types.ID][]types.ID)
+	for _, txID := range txIDs {
+		tx, err := c.txStore.FetchTx(c.stateURI, txID)
+		if errors.Cause(err) == types.Err404 {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, parentID := range tx.Parents {
+			exists, err := c.txStore.TxExists(c.stateURI, parentID)
+			if err != nil {
+				return nil, err
+			} else if !exists {
+				missing[txID] = append(missing[txID], parentID)
+			}
+		}
+	}
+	return missing, nil
+}
+
 func (c *controller) HaveTx(txID types.ID) (bool, error) {
 	return c.txStore.TxExists(c.stateURI, txID)
 }
@@ -759,53 +2936,141 @@ func (c *controller) HaveTx(txID types.ID) (bool, error) {
 func (c *controller) QueryIndex(version *types.ID, keypath tree.Keypath, indexName tree.Keypath, queryParam tree.Keypath, rng *tree.Range) (node tree.Node, err error) {
 	defer utils.Annotate(&err, "keypath=%v index=%v index_arg=%v rng=%v", keypath, indexName, queryParam, rng)
 
+	err = c.ensureIndex(version, keypath, indexName)
+	if err != nil {
+		return nil, err
+	}
+
 	indexNode := c.indices.IndexAtVersion(version, keypath, indexName, false)
 
 	exists, err := indexNode.Exists(queryParam)
 	if err != nil {
 		return nil, err
-
 	} else if !exists {
-		indexNode.Close()
-		indexNode = c.indices.IndexAtVersion(version, keypath, indexName, true)
+		return nil, types.Err404
+	}
+	return indexNode.NodeAt(queryParam, rng), nil
+}
 
-		indices, exists := c.behaviorTree.indexers[string(keypath)]
-		if !exists {
-			return nil, types.Err404
-		}
-		indexer, exists := indices[string(indexName)]
-		if !exists {
-			return nil, types.Err404
-		}
+// IndexRangeQuery describes a sorted scan over the entries of an index.
+// Prefix, Start and End are "/"-separated index key parts (for a compound
+// index, the leading parts), written the way that they appear in the indexed
+// nodes.  They're encoded by the indexer if it implements IndexKeyEncoder.
+type IndexRangeQuery struct {
+	Prefix  tree.Keypath
+	Start   tree.Keypath
+	End     tree.Keypath
+	Reverse bool
+	Limit   uint64
+	Cursor  string
+}
 
-		if version == nil {
-			version = &tree.CurrentVersion
-		}
+// QueryIndexRange returns a slice of {"key": ..., "value": ...} objects for
+// the index entries matching the query, in index key order.  If there are
+// more entries than the query's limit, it also returns a cursor that can be
+// passed back in to fetch the next page.
+func (c *controller) QueryIndexRange(version *types.ID, keypath tree.Keypath, indexName tree.Keypath, query IndexRangeQuery) (_ tree.Node, _ string, err error) {
+	defer utils.Annotate(&err, "keypath=%v index=%v query=%+v", keypath, indexName, query)
+
+	err = c.ensureIndex(version, keypath, indexName)
+	if err != nil {
+		return nil, "", err
+	}
 
-		nodeToIndex, err := c.states.StateAtVersion(version, false).NodeAt(keypath, nil).CopyToMemory(nil, nil)
+	scan := tree.IndexScan{
+		Prefix:  query.Prefix,
+		Start:   query.Start,
+		End:     query.End,
+		Reverse: query.Reverse,
+		Limit:   query.Limit,
+	}
+	if query.Cursor != "" {
+		scan.Cursor, err = hex.DecodeString(query.Cursor)
 		if err != nil {
-			return nil, err
+			return nil, "", errors.Wrap(err, "bad cursor")
 		}
+	}
 
-		nodeToIndex, relKeypath, err := nelson.Unwrap(nodeToIndex)
-		if err != nil {
-			return nil, err
+	if encoder, is := c.currentBehaviorTree().indexers[string(keypath)][string(indexName)].(IndexKeyEncoder); is {
+		for _, key := range []*tree.Keypath{&scan.Prefix, &scan.Start, &scan.End} {
+			if len(*key) == 0 {
+				continue
+			}
+			*key, err = encoder.EncodeIndexKey(*key)
+			if err != nil {
+				return nil, "", err
+			}
 		}
+	}
+
+	entries, cursor, err := c.indices.ScanIndex(version, keypath, indexName, scan)
+	if err != nil {
+		return nil, "", err
+	}
 
-		err = c.indices.BuildIndex(version, relKeypath, nodeToIndex, indexName, indexer)
+	results := make([]interface{}, len(entries))
+	for i, entry := range entries {
+		val, _, err := entry.Node.Value(nil, nil)
 		if err != nil {
-			return nil, err
+			return nil, "", err
 		}
+		results[i] = map[string]interface{}{
+			"key":   entry.ChildKey.String(),
+			"value": val,
+		}
+	}
+
+	node := tree.NewMemoryNode()
+	err = node.Set(nil, nil, results)
+	if err != nil {
+		return nil, "", err
+	}
+	return node, hex.EncodeToString(cursor), nil
+}
 
-		indexNode = c.indices.IndexAtVersion(version, keypath, indexName, false)
+// ensureIndex builds the given index if it doesn't exist yet.  Indices on the
+// current state are maintained as txs are applied.  Indices on older versions
+// are built the first time that they're queried.
+func (c *controller) ensureIndex(version *types.ID, keypath tree.Keypath, indexName tree.Keypath) error {
+	indexNode := c.indices.IndexAtVersion(version, keypath, indexName, false)
+	defer indexNode.Close()
+
+	indexExists, err := indexNode.Exists(nil)
+	if err != nil {
+		return err
+	} else if indexExists {
+		return nil
+	}
+
+	indices, exists := c.currentBehaviorTree().indexers[string(keypath)]
+	if !exists {
+		return types.Err404
+	}
+	indexer, exists := indices[string(indexName)]
+	if !exists {
+		return types.Err404
+	}
 
-		exists, err = indexNode.Exists(queryParam)
+	if version == nil {
+		version = &tree.CurrentVersion
+	} else {
+		err = c.ensureVersion(*version)
 		if err != nil {
-			return nil, err
-		} else if !exists {
-			return nil, types.Err404
+			return err
 		}
 	}
 
-	return indexNode.NodeAt(queryParam, rng), nil
+	state := c.states.StateAtVersion(version, false)
+	defer state.Close()
+
+	nodeToIndex, err := state.NodeAt(keypath, nil).CopyToMemory(nil, nil)
+	if err != nil {
+		return err
+	}
+
+	nodeToIndex, _, err = nelson.Unwrap(nodeToIndex)
+	if err != nil {
+		return err
+	}
+	return c.indices.BuildIndex(version, keypath, nodeToIndex, indexName, indexer)
 }
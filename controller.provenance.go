@@ -0,0 +1,181 @@
+package redwood
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"redwood.dev/tree"
+	"redwood.dev/types"
+	"redwood.dev/utils"
+)
+
+// ProvenanceRecord describes a tx that touched a keypath, either by writing
+// to it or to something beneath it.
+type ProvenanceRecord struct {
+	TxID types.ID      `json:"txID"`
+	From types.Address `json:"from"`
+	// Time is when this node applied the tx, which isn't necessarily when
+	// the sender created it.
+	Time time.Time `json:"time"`
+	// Deleted is true if the tx removed the keypath.
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// Provenance describes which txs touched a keypath.
+type Provenance struct {
+	Last ProvenanceRecord `json:"last"`
+	// History holds the txs that touched the keypath, oldest first, starting
+	// at the requested offset.  It's only kept when
+	// StateConfig.KeepProvenanceHistory is set.
+	History []ProvenanceRecord `json:"history,omitempty"`
+	// HistoryCount is the total number of records in the history, including
+	// the ones that weren't requested.
+	HistoryCount uint64 `json:"historyCount,omitempty"`
+}
+
+var (
+	provenanceLastKey    = tree.Keypath("last")
+	provenanceCountKey   = tree.Keypath("count")
+	provenanceHistoryKey = tree.Keypath("history")
+)
+
+// provenanceKeypath maps a state keypath to a single key in the provenance
+// db, so that the records of a node and of its children don't overlap.
+func provenanceKeypath(keypath tree.Keypath) tree.Keypath {
+	return tree.Keypath("k" + hex.EncodeToString(keypath))
+}
+
+// updateProvenance records that the given tx touched the keypaths in the diff
+// and all of their ancestors.  The records are written to the given
+// provenance node, which the caller saves once the state itself has been
+// saved, so that the provenance never describes txs that weren't applied.
+func (c *controller) updateProvenance(node tree.Node, tx *Tx, diff *tree.Diff) (err error) {
+	defer utils.Annotate(&err, "updateProvenance")
+
+	now := time.Now().UTC()
+	touched := make(map[string]bool) // keypath -> deleted
+	for _, kp := range diff.RemovedList {
+		touched[string(kp)] = true
+	}
+	// A keypath that was overwritten shows up in both lists
+	for _, kp := range diff.AddedList {
+		touched[string(kp)] = false
+	}
+	for _, kp := range append(append([]tree.Keypath(nil), diff.AddedList...), diff.RemovedList...) {
+		for len(kp) > 0 {
+			kp, _ = kp.Pop()
+			if _, exists := touched[string(kp)]; !exists {
+				touched[string(kp)] = false
+			}
+		}
+	}
+
+	for kp, deleted := range touched {
+		record, err := provenanceRecordValue(ProvenanceRecord{TxID: tx.ID, From: tx.From, Time: now, Deleted: deleted})
+		if err != nil {
+			return err
+		}
+		key := provenanceKeypath(tree.Keypath(kp))
+
+		err = node.Set(key.Push(provenanceLastKey), nil, record)
+		if err != nil {
+			return err
+		}
+
+		if c.config.KeepProvenanceHistory {
+			count, _, err := node.UintValue(key.Push(provenanceCountKey))
+			if err != nil {
+				return err
+			}
+			err = node.Set(provenanceHistoryRecordKeypath(key, count), nil, record)
+			if err != nil {
+				return err
+			}
+			err = node.Set(key.Push(provenanceCountKey), nil, count+1)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func provenanceHistoryRecordKeypath(key tree.Keypath, i uint64) tree.Keypath {
+	return key.Push(provenanceHistoryKey).Pushs(fmt.Sprintf("%020d", i))
+}
+
+// Provenance returns the txs that touched the given keypath, or a 404 if
+// none have.  Only the part of the history that starts at historyStart and
+// holds up to historyLimit records (or the rest of it, if historyLimit is 0)
+// is returned.
+func (c *controller) Provenance(keypath tree.Keypath, historyStart, historyLimit uint64) (_ *Provenance, err error) {
+	defer utils.Annotate(&err, "keypath=%v", keypath)
+
+	node := c.provenance.StateAtVersion(nil, false)
+	defer node.Close()
+
+	key := provenanceKeypath(keypath)
+
+	var provenance Provenance
+	lastVal, exists, err := node.Value(key.Push(provenanceLastKey), nil)
+	if err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, errors.Wrapf(types.Err404, "no provenance for keypath '%v'", keypath)
+	}
+	err = decodeProvenanceRecord(lastVal, &provenance.Last)
+	if err != nil {
+		return nil, err
+	}
+
+	count, _, err := node.UintValue(key.Push(provenanceCountKey))
+	if err != nil {
+		return nil, err
+	}
+	provenance.HistoryCount = count
+
+	end := count
+	if historyLimit > 0 && historyStart+historyLimit < count {
+		end = historyStart + historyLimit
+	}
+	for i := historyStart; i < end; i++ {
+		recordVal, exists, err := node.Value(provenanceHistoryRecordKeypath(key, i), nil)
+		if err != nil {
+			return nil, err
+		} else if !exists {
+			return nil, errors.Errorf("missing provenance record %v for keypath '%v'", i, keypath)
+		}
+		var record ProvenanceRecord
+		err = decodeProvenanceRecord(recordVal, &record)
+		if err != nil {
+			return nil, err
+		}
+		provenance.History = append(provenance.History, record)
+	}
+	return &provenance, nil
+}
+
+func provenanceRecordValue(record ProvenanceRecord) (interface{}, error) {
+	bs, err := json.Marshal(record)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var val interface{}
+	err = json.Unmarshal(bs, &val)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return val, nil
+}
+
+func decodeProvenanceRecord(val interface{}, record *ProvenanceRecord) error {
+	bs, err := json.Marshal(val)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(json.Unmarshal(bs, record))
+}
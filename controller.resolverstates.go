@@ -0,0 +1,176 @@
+package redwood
+
+import (
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"redwood.dev/tree"
+	"redwood.dev/types"
+	"redwood.dev/utils"
+)
+
+// The internal state of each resolver is saved to the resolver db whenever a
+// tx changes it, along with the leaves that it's in sync with, so that a
+// restarted controller can pick up where it left off instead of replaying the
+// history of every resolver's subtree.  The same goes for checkpoints: the
+// resolvers' internal states are saved under the checkpoint's version, so
+// that rebuildVersion can start from them.
+
+var (
+	resolverStatesLeavesKey    = tree.Keypath("leaves")
+	resolverStatesResolversKey = tree.Keypath("resolvers")
+)
+
+// resolverStateKeypath maps the keypath of a resolver to a single key in the
+// resolver db, so that the states of nested resolvers don't overlap.
+func resolverStateKeypath(keypath tree.Keypath) tree.Keypath {
+	return resolverStatesResolversKey.Push(tree.Keypath("k" + hex.EncodeToString(keypath)))
+}
+
+// saveResolverStates saves the internal state of each resolver in the new
+// behavior tree that isn't shared with the old one (which is to say, each one
+// that resolved a tx since the old tree was current) and forgets the ones that
+// have been removed.  A nil version means the current state.
+func (c *controller) saveResolverStates(version *types.ID, oldBehaviorTree, newBehaviorTree *behaviorTree, leaves []types.ID) (err error) {
+	defer utils.Annotate(&err, "saveResolverStates")
+
+	node := c.resolverStates.StateAtVersion(version, true)
+	defer node.Close()
+
+	for _, keypath := range oldBehaviorTree.resolverKeypaths {
+		if _, exists := newBehaviorTree.resolvers[string(keypath)]; !exists {
+			err = node.Delete(resolverStateKeypath(keypath), nil)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, keypath := range newBehaviorTree.resolverKeypaths {
+		resolver := newBehaviorTree.resolvers[string(keypath)]
+		if resolver == oldBehaviorTree.resolvers[string(keypath)] {
+			continue
+		}
+		// Internal states are stored as JSON so that their keys aren't taken
+		// for keypaths
+		bs, err := json.Marshal(resolver.InternalState())
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		err = node.Set(resolverStateKeypath(keypath), nil, string(bs))
+		if err != nil {
+			return err
+		}
+	}
+
+	leafStrs := make([]interface{}, len(leaves))
+	for i, leaf := range leaves {
+		leafStrs[i] = leaf.Hex()
+	}
+	err = node.Set(resolverStatesLeavesKey, nil, leafStrs)
+	if err != nil {
+		return err
+	}
+	return node.Save()
+}
+
+// loadResolverStates returns the internal state of each resolver, by keypath,
+// saved at the given version, or nil if they aren't in sync with the given
+// leaves.  A nil version means the current state.
+func (c *controller) loadResolverStates(version *types.ID, leaves []types.ID) (_ map[string]map[string]interface{}, err error) {
+	defer utils.Annotate(&err, "loadResolverStates")
+
+	node := c.resolverStates.StateAtVersion(version, false)
+	defer node.Close()
+
+	savedLeaves, _, err := node.Value(resolverStatesLeavesKey, nil)
+	if err != nil {
+		return nil, err
+	}
+	leafSet := make(map[string]bool, len(leaves))
+	for _, leaf := range leaves {
+		leafSet[leaf.Hex()] = true
+	}
+	savedLeafStrs, _ := savedLeaves.([]interface{})
+	if len(savedLeafStrs) != len(leafSet) {
+		return nil, nil
+	}
+	for _, leaf := range savedLeafStrs {
+		leafStr, _ := leaf.(string)
+		if !leafSet[leafStr] {
+			return nil, nil
+		}
+	}
+
+	internalStates := make(map[string]map[string]interface{})
+	iter := node.ChildIterator(resolverStatesResolversKey, true, 10)
+	defer iter.Close()
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		child := iter.Node()
+		_, key := child.Keypath().Pop()
+		keypath, err := hex.DecodeString(string(key)[1:])
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		str, _, err := child.StringValue(nil)
+		if err != nil {
+			return nil, err
+		}
+		var internalState map[string]interface{}
+		err = json.Unmarshal([]byte(str), &internalState)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		internalStates[string(keypath)] = internalState
+	}
+	return internalStates, nil
+}
+
+// loadBehaviorTree rebuilds the behavior tree from the current state when the
+// controller starts.  The resolvers get back the internal state that was
+// saved to the resolver db.  If that's out of date (say, because we shut down
+// between saving the state and saving the resolver db), they're caught up by
+// replaying the history of their subtrees instead.
+func (c *controller) loadBehaviorTree() (err error) {
+	defer utils.Annotate(&err, "loadBehaviorTree")
+
+	leaves, err := c.Leaves()
+	if err != nil {
+		return err
+	}
+	internalStates, err := c.loadResolverStates(nil, leaves)
+	if err != nil {
+		return err
+	}
+
+	state := c.states.StateAtVersion(nil, false)
+	defer state.Close()
+
+	behaviorTree, err := c.behaviorTreeForState(state, internalStates)
+	if err != nil {
+		return err
+	}
+
+	if internalStates == nil {
+		history, err := c.validTxsInCausalOrder()
+		if err != nil {
+			return err
+		}
+		err = c.replayResolvers(behaviorTree, state, history)
+		if err != nil {
+			// The resolvers can still handle new txs, but the ones that keep
+			// internal state may do so differently than their peers
+			c.Errorf("could not catch resolvers up on history: %v", err)
+		}
+		err = c.saveResolverStates(nil, newBehaviorTree(), behaviorTree, leaves)
+		if err != nil {
+			c.Errorf("error saving resolver states: %v", err)
+		}
+	}
+
+	c.setBehaviorTree(behaviorTree)
+	return nil
+}
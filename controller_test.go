@@ -0,0 +1,1112 @@
+package redwood_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"redwood.dev"
+	"redwood.dev/crypto"
+	"redwood.dev/tree"
+	"redwood.dev/types"
+)
+
+const testStateURI = "test.dev/controller"
+
+type M = map[string]interface{}
+
+type testController struct {
+	redwood.Controller
+	txStore  redwood.TxStore
+	refStore redwood.RefStore
+	signer   *crypto.SigningKeypair
+	dir      string
+}
+
+func setupTestController(t *testing.T, config redwood.StateConfig) *testController {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "redwood-controller-test")
+	require.NoError(t, err)
+
+	txStore := redwood.NewBadgerTxStore(filepath.Join(dir, "txs"))
+	err = txStore.Start()
+	require.NoError(t, err)
+
+	for _, subdir := range []string{"refs", "states"} {
+		err = os.MkdirAll(filepath.Join(dir, subdir), 0700)
+		require.NoError(t, err)
+	}
+
+	refStore := redwood.NewRefStore(filepath.Join(dir, "refs"))
+	err = refStore.Start()
+	require.NoError(t, err)
+
+	c, err := redwood.NewController(testStateURI, filepath.Join(dir, "states"), nil, txStore, refStore, config)
+	require.NoError(t, err)
+	err = c.Start()
+	require.NoError(t, err)
+
+	signer, err := crypto.GenerateSigningKeypair()
+	require.NoError(t, err)
+
+	return &testController{Controller: c, txStore: txStore, refStore: refStore, signer: signer, dir: dir}
+}
+
+func (c *testController) Close() {
+	c.Controller.Close()
+	c.txStore.Close()
+	c.refStore.Close()
+	os.RemoveAll(c.dir)
+}
+
+// addTx signs a tx containing the given patches, adds it to the controller and
+// waits for it to be processed.
+func (c *testController) addTx(t *testing.T, id string, parents []types.ID, checkpoint bool, patchStrs ...string) *redwood.Tx {
+	t.Helper()
+
+	tx := c.newTx(t, id, parents, checkpoint, patchStrs...)
+
+	err := c.AddTx(tx, false)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		stored, err := c.txStore.FetchTx(testStateURI, tx.ID)
+		return err == nil && stored.Status != redwood.TxStatusInMempool
+	}, 5*time.Second, 10*time.Millisecond)
+	return tx
+}
+
+func (c *testController) newTx(t *testing.T, id string, parents []types.ID, checkpoint bool, patchStrs ...string) *redwood.Tx {
+	t.Helper()
+
+	var patches []redwood.Patch
+	for _, s := range patchStrs {
+		patch, err := redwood.ParsePatch([]byte(s))
+		require.NoError(t, err)
+		patches = append(patches, patch)
+	}
+
+	tx := &redwood.Tx{
+		ID:         types.IDFromString(id),
+		Parents:    parents,
+		From:       c.signer.Address(),
+		StateURI:   testStateURI,
+		Patches:    patches,
+		Checkpoint: checkpoint,
+	}
+	if id == "genesis" {
+		tx.ID = redwood.GenesisTxID
+	}
+
+	sig, err := c.signer.SignHash(tx.Hash())
+	require.NoError(t, err)
+	tx.Sig = sig
+	return tx
+}
+
+func (c *testController) stateAt(t *testing.T, version *types.ID) interface{} {
+	t.Helper()
+
+	state, err := c.StateAtVersion(version)
+	require.NoError(t, err)
+	defer state.Close()
+
+	val, _, err := state.Value(nil, nil)
+	require.NoError(t, err)
+	return val
+}
+
+func TestController_StateAtVersion(t *testing.T) {
+	c := setupTestController(t, redwood.StateConfig{})
+	defer c.Close()
+
+	genesis := c.addTx(t, "genesis", nil, true, `.a = 1`)
+	tx1 := c.addTx(t, "one", []types.ID{genesis.ID}, false, `.b = "x"`)
+	tx2 := c.addTx(t, "two", []types.ID{genesis.ID}, false, `.c = true`)
+	tx3 := c.addTx(t, "three", []types.ID{tx1.ID, tx2.ID}, false, `.a = 2`)
+
+	require.Equal(t, M{"a": 2.0, "b": "x", "c": true}, c.stateAt(t, nil))
+	require.Equal(t, M{"a": 1.0}, c.stateAt(t, &genesis.ID))
+	require.Equal(t, M{"a": 1.0, "b": "x"}, c.stateAt(t, &tx1.ID))
+	require.Equal(t, M{"a": 1.0, "c": true}, c.stateAt(t, &tx2.ID))
+	require.Equal(t, M{"a": 2.0, "b": "x", "c": true}, c.stateAt(t, &tx3.ID))
+
+	// Rebuilt versions are cached
+	require.Equal(t, M{"a": 1.0, "b": "x"}, c.stateAt(t, &tx1.ID))
+
+	unknown := types.IDFromString("unknown")
+	_, err := c.StateAtVersion(&unknown)
+	require.Equal(t, types.Err404, errors.Cause(err))
+
+	// Indices can be queried at rebuilt versions too
+	_, err = c.QueryIndex(&tx1.ID, tree.Keypath("b"), tree.Keypath("nope"), nil, nil)
+	require.Equal(t, types.Err404, errors.Cause(err))
+}
+
+func TestController_StateAtVersionWithConcurrentBranches(t *testing.T) {
+	c := setupTestController(t, redwood.StateConfig{})
+	defer c.Close()
+
+	genesis := c.addTx(t, "genesis", nil, true, `.doc = {"Merge-Type": {"Content-Type": "resolver/sync9", "value": {}}}`)
+	tx1 := c.addTx(t, "one", []types.ID{genesis.ID}, false, `.doc.text = "abc"`)
+	tx2 := c.addTx(t, "two", []types.ID{tx1.ID}, true, `.doc.text[0:0] = "X"`)
+	tx3 := c.addTx(t, "three", []types.ID{tx1.ID}, true, `.doc.text[3:3] = "d"`)
+	tx4 := c.addTx(t, "four", []types.ID{tx2.ID, tx3.ID}, false, `.doc.text[5:5] = "e"`)
+
+	text := func(version *types.ID) interface{} {
+		return c.stateAt(t, version).(M)["doc"].(M)["text"]
+	}
+	require.Equal(t, "Xabcde", text(nil))
+
+	// Rebuilding from tx2's checkpoint has to replay tx3, which sync9 can only
+	// place correctly if it knows about tx1
+	require.Equal(t, "Xabcde", text(&tx4.ID))
+
+	// tx3 was applied on top of tx2, which isn't in its causal past
+	require.Equal(t, "abcd", text(&tx3.ID))
+}
+
+func TestController_CheckpointPolicy(t *testing.T) {
+	c := setupTestController(t, redwood.StateConfig{
+		CheckpointEveryNTxs: 2,
+		MaxVersions:         2,
+	})
+	defer c.Close()
+
+	versionIDs := func() []types.ID {
+		versions, err := c.Versions()
+		require.NoError(t, err)
+
+		var ids []types.ID
+		for _, v := range versions {
+			require.NotZero(t, v.NumKeys)
+			require.NotZero(t, v.Size)
+			ids = append(ids, v.Version)
+		}
+		return ids
+	}
+
+	genesis := c.addTx(t, "genesis", nil, false, `.a = 1`)
+	require.Len(t, versionIDs(), 0)
+
+	tx1 := c.addTx(t, "one", []types.ID{genesis.ID}, false, `.a = 2`)
+	require.Equal(t, []types.ID{tx1.ID}, versionIDs())
+
+	tx2 := c.addTx(t, "two", []types.ID{tx1.ID}, false, `.a = 3`)
+	tx3 := c.addTx(t, "three", []types.ID{tx2.ID}, false, `.a = 4`)
+	require.Equal(t, []types.ID{tx1.ID, tx3.ID}, versionIDs())
+
+	tx4 := c.addTx(t, "four", []types.ID{tx3.ID}, true, `.a = 5`)
+	require.Equal(t, []types.ID{tx3.ID, tx4.ID}, versionIDs())
+
+	// Deleted versions can still be rebuilt
+	require.Equal(t, M{"a": 2.0}, c.stateAt(t, &tx1.ID))
+}
+
+func TestController_Compact(t *testing.T) {
+	c := setupTestController(t, redwood.StateConfig{})
+	defer c.Close()
+
+	genesis := c.addTx(t, "genesis", nil, false, `.a = 1`)
+	tx1 := c.addTx(t, "one", []types.ID{genesis.ID}, false, `.b = "x"`)
+	tx2 := c.addTx(t, "two", []types.ID{tx1.ID}, false, `.c = true`)
+	side := c.addTx(t, "side", []types.ID{genesis.ID}, false, `.d = 4`)
+
+	snapshotAt := func(txID types.ID) *redwood.Tx {
+		val := c.stateAt(t, &txID)
+		snapshot := &redwood.Tx{
+			ID:         txID,
+			From:       c.signer.Address(),
+			StateURI:   testStateURI,
+			Patches:    []redwood.Patch{{Val: val}},
+			Checkpoint: true,
+		}
+		sig, err := c.signer.SignHash(snapshot.Hash())
+		require.NoError(t, err)
+		snapshot.Sig = sig
+		return snapshot
+	}
+
+	// tx2 is concurrent with `side`, so compacting at tx2 would lose some of its history
+	err := c.Compact(snapshotAt(tx2.ID))
+	require.Error(t, err)
+
+	tx3 := c.addTx(t, "three", []types.ID{tx2.ID, side.ID}, false, `.a = 2`)
+	tx4 := c.addTx(t, "four", []types.ID{tx3.ID}, false, `.e = 5`)
+
+	snapshot := snapshotAt(tx3.ID)
+	err = c.Compact(snapshot)
+	require.NoError(t, err)
+
+	for _, tx := range []*redwood.Tx{genesis, tx1, tx2, side} {
+		_, err := c.txStore.FetchTx(testStateURI, tx.ID)
+		require.Equal(t, types.Err404, errors.Cause(err))
+
+		exists, err := c.txStore.TxExists(testStateURI, tx.ID)
+		require.NoError(t, err)
+		require.True(t, exists)
+	}
+
+	// History from before the prune point starts at the snapshot
+	var history []*redwood.Tx
+	iter := c.txStore.AllTxsForStateURI(testStateURI, tx1.ID)
+	for tx := iter.Next(); tx != nil; tx = iter.Next() {
+		history = append(history, tx)
+	}
+	require.NoError(t, iter.Error())
+	require.Len(t, history, 2)
+	require.True(t, history[0].IsSnapshot())
+	require.Equal(t, tx3.ID, history[0].ID)
+	require.Equal(t, tx4.ID, history[1].ID)
+
+	expected := M{"a": 2.0, "b": "x", "c": true, "d": 4.0, "e": 5.0}
+	require.Equal(t, expected, c.stateAt(t, nil))
+	require.Equal(t, expected, c.stateAt(t, &tx4.ID))
+
+	// A new node only trusts snapshots from the configured signers
+	c3 := setupTestController(t, redwood.StateConfig{})
+	defer c3.Close()
+
+	forged := *history[0]
+	forged.Status = redwood.TxStatusUnknown
+	err = c3.AddTx(&forged, false)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		stored, err := c3.txStore.FetchTx(testStateURI, forged.ID)
+		return err == nil && stored.Status == redwood.TxStatusInvalid
+	}, 5*time.Second, 10*time.Millisecond)
+	leaves, err := c3.Leaves()
+	require.NoError(t, err)
+	require.Empty(t, leaves)
+
+	// A new node can start from the snapshot
+	c2 := setupTestController(t, redwood.StateConfig{SnapshotSigners: []types.Address{c.signer.Address()}})
+	defer c2.Close()
+
+	for _, tx := range history {
+		tx.Status = redwood.TxStatusUnknown
+		err := c2.AddTx(tx, false)
+		require.NoError(t, err)
+	}
+	require.Eventually(t, func() bool {
+		leaves, err := c2.Leaves()
+		return err == nil && len(leaves) == 1 && leaves[0] == tx4.ID
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, expected, c2.stateAt(t, nil))
+
+	exists, err := c2.txStore.TxExists(testStateURI, redwood.GenesisTxID)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	// Txs can still name pruned txs as parents, and keep their signed parents
+	tx5 := c.addTx(t, "five", []types.ID{tx4.ID, tx2.ID}, false, `.f = 6`)
+	stored, err := c.txStore.FetchTx(testStateURI, tx5.ID)
+	require.NoError(t, err)
+	require.Equal(t, redwood.TxStatusValid, stored.Status)
+	require.Equal(t, tx5.Parents, stored.Parents)
+	require.Equal(t, tx5.Hash(), stored.Hash())
+
+	leaves, err = c.Leaves()
+	require.NoError(t, err)
+	require.Equal(t, []types.ID{tx5.ID}, leaves)
+}
+
+func TestController_MempoolSurvivesRestart(t *testing.T) {
+	c := setupTestController(t, redwood.StateConfig{})
+	defer c.Close()
+
+	genesis := c.addTx(t, "genesis", nil, false, `.a = 1`)
+	tx1 := c.newTx(t, "one", []types.ID{genesis.ID}, false, `.a = 2`)
+	tx2 := c.newTx(t, "two", []types.ID{tx1.ID}, false, `.b = 3`)
+
+	// tx2 has to wait for its parent
+	err := c.AddTx(tx2, false)
+	require.NoError(t, err)
+
+	inMempool, err := c.txStore.TxIDsWithStatus(testStateURI, redwood.TxStatusInMempool)
+	require.NoError(t, err)
+	require.Equal(t, []types.ID{tx2.ID}, inMempool)
+
+	c.Controller.Close()
+	c.Controller, err = redwood.NewController(testStateURI, filepath.Join(c.dir, "states"), nil, c.txStore, c.refStore, redwood.StateConfig{})
+	require.NoError(t, err)
+	err = c.Start()
+	require.NoError(t, err)
+
+	err = c.AddTx(tx1, false)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		leaves, err := c.Leaves()
+		return err == nil && len(leaves) == 1 && leaves[0] == tx2.ID
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, M{"a": 2.0, "b": 3.0}, c.stateAt(t, nil))
+
+	inMempool, err = c.txStore.TxIDsWithStatus(testStateURI, redwood.TxStatusInMempool)
+	require.NoError(t, err)
+	require.Len(t, inMempool, 0)
+
+	valid, err := c.txStore.TxIDsWithStatus(testStateURI, redwood.TxStatusValid)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []types.ID{genesis.ID, tx1.ID, tx2.ID}, valid)
+}
+
+func TestController_MissingParents(t *testing.T) {
+	c := setupTestController(t, redwood.StateConfig{})
+	defer c.Close()
+
+	chMissing := make(chan []types.ID, 10)
+	c.OnMissingParents(func(tx *redwood.Tx, parentIDs []types.ID) {
+		chMissing <- parentIDs
+	})
+
+	genesis := c.addTx(t, "genesis", nil, false, `.a = 1`)
+	tx1 := c.newTx(t, "one", []types.ID{genesis.ID}, false, `.a = 2`)
+	tx2 := c.newTx(t, "two", []types.ID{tx1.ID}, false, `.b = 3`)
+
+	err := c.AddTx(tx2, false)
+	require.NoError(t, err)
+
+	select {
+	case parentIDs := <-chMissing:
+		require.Equal(t, []types.ID{tx1.ID}, parentIDs)
+	case <-time.After(5 * time.Second):
+		t.Fatal("missing parents listener was never called")
+	}
+
+	missing, err := c.MissingParents()
+	require.NoError(t, err)
+	require.Equal(t, map[types.ID][]types.ID{tx2.ID: {tx1.ID}}, missing)
+
+	err = c.AddTx(tx1, false)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		missing, err := c.MissingParents()
+		return err == nil && len(missing) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestController_CatchesUpOnManyTxs(t *testing.T) {
+	c := setupTestController(t, redwood.StateConfig{})
+	defer c.Close()
+
+	genesis := c.addTx(t, "genesis", nil, false, `.n = 0`, `.checkpoint = -1`)
+	c.Controller.Close()
+
+	// Queue up a long chain of txs as though they'd just been synced from a peer
+	const n = 50
+	var txs []*redwood.Tx
+	parent := genesis.ID
+	for i := 1; i <= n; i++ {
+		checkpoint := i == n/2
+		patches := []string{fmt.Sprintf(`.n = %v`, i)}
+		if checkpoint {
+			patches = append(patches, fmt.Sprintf(`.checkpoint = %v`, i))
+		}
+		tx := c.newTx(t, fmt.Sprintf("tx-%v", i), []types.ID{parent}, checkpoint, patches...)
+		tx.Status = redwood.TxStatusInMempool
+		err := c.txStore.AddTx(tx)
+		require.NoError(t, err)
+
+		txs = append(txs, tx)
+		parent = tx.ID
+	}
+
+	var err error
+	c.Controller, err = redwood.NewController(testStateURI, filepath.Join(c.dir, "states"), nil, c.txStore, c.refStore, redwood.StateConfig{})
+	require.NoError(t, err)
+
+	var notifiedMu sync.Mutex
+	var notified []types.ID
+	c.OnNewState(func(txs []*redwood.Tx, state tree.Node, leaves []types.ID) {
+		notifiedMu.Lock()
+		defer notifiedMu.Unlock()
+		for _, tx := range txs {
+			notified = append(notified, tx.ID)
+		}
+	})
+
+	err = c.Start()
+	require.NoError(t, err)
+
+	last := txs[n-1]
+	require.Eventually(t, func() bool {
+		leaves, err := c.Leaves()
+		return err == nil && len(leaves) == 1 && leaves[0] == last.ID
+	}, 10*time.Second, 10*time.Millisecond)
+
+	require.Equal(t, M{"n": float64(n), "checkpoint": float64(n / 2)}, c.stateAt(t, nil))
+
+	// The checkpoint tx's version was saved at the right point in the chain
+	checkpointTx := txs[n/2-1]
+	versions, err := c.Versions()
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	require.Equal(t, checkpointTx.ID, versions[0].Version)
+	require.Equal(t, M{"n": float64(n / 2), "checkpoint": float64(n / 2)}, c.stateAt(t, &checkpointTx.ID))
+
+	valid, err := c.txStore.TxIDsWithStatus(testStateURI, redwood.TxStatusValid)
+	require.NoError(t, err)
+	require.Len(t, valid, n+1)
+
+	// Listeners hear about every tx, even when they're applied in batches
+	var expected []types.ID
+	for _, tx := range txs {
+		expected = append(expected, tx.ID)
+	}
+	require.Eventually(t, func() bool {
+		notifiedMu.Lock()
+		defer notifiedMu.Unlock()
+		return len(notified) == len(expected)
+	}, 5*time.Second, 10*time.Millisecond)
+	notifiedMu.Lock()
+	defer notifiedMu.Unlock()
+	require.Equal(t, expected, notified)
+}
+
+func TestController_FailedTxsLeaveResolversUntouched(t *testing.T) {
+	c := setupTestController(t, redwood.StateConfig{})
+	defer c.Close()
+
+	genesis := c.addTx(t, "genesis", nil, false, `.doc = {"Merge-Type": {"Content-Type": "resolver/sync9", "value": {}}}`)
+
+	// tx2 fails after the sync9 resolver has already resolved its edit
+	tx1 := c.newTx(t, "one", []types.ID{genesis.ID}, false, `.doc.text = "abc"`)
+	tx2 := c.newTx(t, "two", []types.ID{tx1.ID}, false,
+		`.doc.text[1:1] = "X"`,
+		`.other = {"Merge-Type": {"Content-Type": "resolver/nonexistent"}}`,
+	)
+	tx3 := c.newTx(t, "three", []types.ID{tx1.ID}, false, `.doc.text[3:3] = "d"`)
+	err := c.AddTxs([]*redwood.Tx{tx1, tx2, tx3})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		stored, err := c.txStore.FetchTx(testStateURI, tx3.ID)
+		return err == nil && stored.Status == redwood.TxStatusValid
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.Equal(t, "abcd", c.stateAt(t, nil).(M)["doc"].(M)["text"])
+	require.Nil(t, c.stateAt(t, nil).(M)["other"])
+}
+
+// jsResolverConfig returns the Merge-Type config of a JS resolver that counts
+// the patches beneath its root that it has resolved, and writes each patched
+// value (or, for a patch to its root, each value in the patch) through the
+// given expression of 'val'.
+func jsResolverConfig(t *testing.T, resolve string) string {
+	t.Helper()
+
+	src := `global.init = function(internalState) { global.count = (internalState && internalState.count) || 0 };
+		var resolve = function(val) { return ` + resolve + ` };
+		global.resolve_state = function(state, sender, txID, parents, patches) {
+			state = state || {};
+			for (var i = 0; i < patches.length; i++) {
+				var p = patches[i];
+				if (!p.keys) {
+					for (var k in p.val) { state[k] = resolve(p.val[k]) }
+					continue
+				}
+				global.count++;
+				state[p.keys[0]] = resolve(p.val);
+			}
+			return JSON.stringify({state: state, internalState: {count: global.count}});
+		}`
+	bs, err := json.Marshal(M{"Content-Type": "resolver/js", "value": M{"src": src}})
+	require.NoError(t, err)
+	return string(bs)
+}
+
+func TestController_ResolverMigration(t *testing.T) {
+	c := setupTestController(t, redwood.StateConfig{})
+	defer c.Close()
+
+	status := func(tx *redwood.Tx) redwood.TxStatus {
+		stored, err := c.txStore.FetchTx(testStateURI, tx.ID)
+		require.NoError(t, err)
+		return stored.Status
+	}
+
+	// The first version of the subtree is written by a patch to its parent
+	genesis := c.addTx(t, "genesis", nil, false, ` = {"doc": {"Merge-Type": {"Content-Type": "resolver/dumb"}, "a": "x"}}`)
+	tx1 := c.addTx(t, "one", []types.ID{genesis.ID}, false, `.doc.b = "y"`)
+
+	// A resolver that doesn't reproduce the subtree's history is rejected
+	tx2 := c.newTx(t, "two", []types.ID{tx1.ID}, false, ` = {"doc": {"Merge-Type": `+jsResolverConfig(t, `val.toUpperCase()`)+`, "a": "x", "b": "y"}}`)
+	err := c.AddTx(tx2, false)
+	require.NoError(t, err)
+
+	// Otherwise, it picks up where the history leaves off
+	tx3 := c.addTx(t, "three", []types.ID{tx1.ID}, false, ` = {"doc": {"Merge-Type": `+jsResolverConfig(t, `val === '$count' ? global.count : val`)+`, "a": "x", "b": "y"}}`)
+	require.Equal(t, redwood.TxStatusValid, status(tx3))
+	require.NotEqual(t, redwood.TxStatusValid, status(tx2))
+
+	tx4 := c.addTx(t, "four", []types.ID{tx3.ID}, false, `.doc.n = "$count"`)
+	require.Equal(t, redwood.TxStatusValid, status(tx4))
+
+	doc := c.stateAt(t, nil).(M)["doc"].(M)
+	require.Equal(t, "x", doc["a"])
+	require.Equal(t, "y", doc["b"])
+	require.Equal(t, 2.0, doc["n"])
+}
+
+func TestController_ResolverStateSurvivesRestart(t *testing.T) {
+	c := setupTestController(t, redwood.StateConfig{})
+	defer c.Close()
+
+	doc := func() M {
+		doc := c.stateAt(t, nil).(M)["doc"].(M)
+		delete(doc, "Merge-Type")
+		return doc
+	}
+	restart := func(whileStopped func()) {
+		t.Helper()
+		c.Controller.Close()
+		whileStopped()
+		var err error
+		c.Controller, err = redwood.NewController(testStateURI, filepath.Join(c.dir, "states"), nil, c.txStore, c.refStore, redwood.StateConfig{})
+		require.NoError(t, err)
+		err = c.Start()
+		require.NoError(t, err)
+	}
+
+	genesis := c.addTx(t, "genesis", nil, false, ` = {"doc": {"Merge-Type": `+jsResolverConfig(t, `val === '$count' ? global.count : val`)+`}}`)
+	tx1 := c.addTx(t, "one", []types.ID{genesis.ID}, false, `.doc.a = "$count"`)
+	tx2 := c.addTx(t, "two", []types.ID{tx1.ID}, false, `.doc.b = "$count"`)
+
+	// The resolver picks up its saved internal state
+	restart(func() {})
+	tx3 := c.addTx(t, "three", []types.ID{tx2.ID}, false, `.doc.c = "$count"`)
+	require.Equal(t, M{"a": 1.0, "b": 2.0, "c": 3.0}, doc())
+
+	// Without it, the resolver is caught up on the history of its subtree
+	restart(func() {
+		err := os.RemoveAll(filepath.Join(c.dir, "states", strings.NewReplacer(":", "_", "/", "_").Replace(testStateURI)+"_resolvers"))
+		require.NoError(t, err)
+	})
+	c.addTx(t, "four", []types.ID{tx3.ID}, false, `.doc.d = "$count"`)
+	require.Equal(t, M{"a": 1.0, "b": 2.0, "c": 3.0, "d": 4.0}, doc())
+}
+
+func TestController_StateAtVersionWithStatefulResolver(t *testing.T) {
+	c := setupTestController(t, redwood.StateConfig{})
+	defer c.Close()
+
+	genesis := c.addTx(t, "genesis", nil, false, ` = {"doc": {"Merge-Type": `+jsResolverConfig(t, `val === '$count' ? global.count : val`)+`}}`)
+	tx1 := c.addTx(t, "one", []types.ID{genesis.ID}, false, `.doc.a = "$count"`)
+	tx2 := c.addTx(t, "two", []types.ID{tx1.ID}, true, `.doc.b = "$count"`)
+	tx3 := c.addTx(t, "three", []types.ID{tx2.ID}, false, `.doc.c = "$count"`)
+	c.addTx(t, "four", []types.ID{tx3.ID}, false, `.doc.d = "$count"`)
+
+	// Rebuilding from tx2's checkpoint picks up the resolver's internal state
+	// as of the checkpoint
+	doc := c.stateAt(t, &tx3.ID).(M)["doc"].(M)
+	delete(doc, "Merge-Type")
+	require.Equal(t, M{"a": 1.0, "b": 2.0, "c": 3.0}, doc)
+}
+
+func TestController_ReadableState(t *testing.T) {
+	c := setupTestController(t, redwood.StateConfig{})
+	defer c.Close()
+
+	c.addTx(t, "genesis", nil, true,
+		`.Validator = {
+			"Content-Type": "validator/permissions",
+			"value": {
+				"`+c.signer.Address().Hex()+`": {"^.*$": {"read": true, "write": true}},
+				"*": {"^\\.public": {"read": true}}
+			}
+		}`,
+		`.public = {"x": 1}`,
+		`.secret = {"y": 2}`,
+	)
+
+	other, err := crypto.GenerateSigningKeypair()
+	require.NoError(t, err)
+
+	state, err := c.StateAtVersion(nil)
+	require.NoError(t, err)
+	defer state.Close()
+
+	require.True(t, c.CanRead(tree.Keypath("public"), []types.Address{other.Address()}))
+	require.False(t, c.CanRead(nil, []types.Address{other.Address()}))
+	require.True(t, c.CanRead(nil, []types.Address{c.signer.Address()}))
+
+	// Hidden subtrees are pruned
+	readable, err := c.ReadableState(state, nil, []types.Address{other.Address()})
+	require.NoError(t, err)
+	val, _, err := readable.Value(nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, M{"public": M{"x": 1.0}}, val)
+
+	readable, err = c.ReadableState(state, nil, nil)
+	require.NoError(t, err)
+	val, _, err = readable.Value(nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, M{"public": M{"x": 1.0}}, val)
+
+	_, err = c.ReadableState(state.NodeAt(tree.Keypath("secret"), nil), tree.Keypath("secret"), []types.Address{other.Address()})
+	require.Equal(t, types.Err403, errors.Cause(err))
+
+	// The owner sees everything
+	readable, err = c.ReadableState(state, nil, []types.Address{c.signer.Address()})
+	require.NoError(t, err)
+	val, _, err = readable.Value(nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, M{"x": 1.0}, val.(M)["public"])
+	require.Equal(t, M{"y": 2.0}, val.(M)["secret"])
+}
+
+func TestController_RolesValidator(t *testing.T) {
+	c := setupTestController(t, redwood.StateConfig{})
+	defer c.Close()
+
+	other, err := crypto.GenerateSigningKeypair()
+	require.NoError(t, err)
+
+	genesis := c.addTx(t, "genesis", nil, true,
+		`.Validator = {
+			"Content-Type": "validator/roles",
+			"value": {
+				"admins":  {"^.*$": {"write": true}},
+				"members": {"^\\.messages": {"write": true}}
+			}
+		}`,
+		`.Roles = {"admins": {"`+c.signer.Address().Hex()+`": true}}`,
+	)
+
+	send := func(signer *crypto.SigningKeypair, id string, parent types.ID, keypath string, val interface{}) redwood.TxStatus {
+		tx := &redwood.Tx{
+			ID:       types.IDFromString(id),
+			Parents:  []types.ID{parent},
+			From:     signer.Address(),
+			StateURI: testStateURI,
+			Patches:  []redwood.Patch{{Keypath: tree.Keypath(keypath), Val: val}},
+		}
+		tx.Sig, err = signer.SignHash(tx.Hash())
+		require.NoError(t, err)
+		err = c.AddTx(tx, false)
+		require.NoError(t, err)
+
+		var stored *redwood.Tx
+		require.Eventually(t, func() bool {
+			stored, err = c.txStore.FetchTx(testStateURI, tx.ID)
+			return err == nil && stored.Status != redwood.TxStatusInMempool
+		}, 5*time.Second, 10*time.Millisecond)
+		return stored.Status
+	}
+
+	// Without a role, only the rules for "*" apply (and there aren't any)
+	require.Equal(t, redwood.TxStatusInvalid, send(other, "one", genesis.ID, "messages/a", "hi"))
+
+	// Admins can grant roles
+	require.Equal(t, redwood.TxStatusValid, send(c.signer, "two", genesis.ID, "Roles/members/"+other.Address().Hex(), true))
+	require.Equal(t, redwood.TxStatusValid, send(other, "three", types.IDFromString("two"), "messages/a", "hi"))
+
+	// Members can't
+	require.Equal(t, redwood.TxStatusInvalid, send(other, "four", types.IDFromString("three"), "Roles/admins/"+other.Address().Hex(), true))
+}
+
+func TestController_CapabilitiesValidator(t *testing.T) {
+	c := setupTestController(t, redwood.StateConfig{})
+	defer c.Close()
+
+	alice, err := crypto.GenerateSigningKeypair()
+	require.NoError(t, err)
+	bot, err := crypto.GenerateSigningKeypair()
+	require.NoError(t, err)
+
+	genesis := c.addTx(t, "genesis", nil, true,
+		`.Validator = {
+			"Content-Type": "validator/capabilities",
+			"value": {"roots": ["`+c.signer.Address().Hex()+`"]}
+		}`,
+	)
+
+	send := func(signer *crypto.SigningKeypair, id string, parent string, capabilities []redwood.CapabilityToken, keypath string, val interface{}) redwood.TxStatus {
+		parentID := genesis.ID
+		if parent != "" {
+			parentID = types.IDFromString(parent)
+		}
+		tx := &redwood.Tx{
+			ID:           types.IDFromString(id),
+			Parents:      []types.ID{parentID},
+			From:         signer.Address(),
+			StateURI:     testStateURI,
+			Patches:      []redwood.Patch{{Keypath: tree.Keypath(keypath), Val: val}},
+			Capabilities: capabilities,
+		}
+		tx.Sig, err = signer.SignHash(tx.Hash())
+		require.NoError(t, err)
+		err = c.AddTx(tx, false)
+		require.NoError(t, err)
+
+		var stored *redwood.Tx
+		require.Eventually(t, func() bool {
+			stored, err = c.txStore.FetchTx(testStateURI, tx.ID)
+			return err == nil && stored.Status != redwood.TxStatusInMempool
+		}, 5*time.Second, 10*time.Millisecond)
+		return stored.Status
+	}
+
+	expires := time.Unix(2000, 0)
+	forAlice, err := redwood.NewCapabilityToken(c.signer, alice.Address(), []tree.Keypath{tree.Keypath("messages")}, expires, nil)
+	require.NoError(t, err)
+	forBot, err := redwood.NewCapabilityToken(alice, bot.Address(), []tree.Keypath{tree.Keypath("messages/bot")}, expires, &forAlice)
+	require.NoError(t, err)
+
+	// Without a capability, only roots can write
+	require.Equal(t, redwood.TxStatusInvalid, send(alice, "one", "", nil, "messages/a", "hi"))
+	require.Equal(t, redwood.TxStatusValid, send(alice, "two", "", []redwood.CapabilityToken{forAlice}, "messages/a", "hi"))
+
+	// Delegated capabilities are limited to their own keypaths
+	require.Equal(t, redwood.TxStatusValid, send(bot, "three", "two", []redwood.CapabilityToken{forBot}, "messages/bot/a", "beep"))
+	require.Equal(t, redwood.TxStatusInvalid, send(bot, "four", "three", []redwood.CapabilityToken{forBot}, "messages/a", "beep"))
+
+	// ... and can't be broader or longer-lived than their proofs
+	broader, err := redwood.NewCapabilityToken(alice, bot.Address(), []tree.Keypath{tree.Keypath("settings")}, expires, &forAlice)
+	require.NoError(t, err)
+	require.Equal(t, redwood.TxStatusInvalid, send(bot, "five", "three", []redwood.CapabilityToken{broader}, "settings/a", "beep"))
+
+	longer, err := redwood.NewCapabilityToken(alice, bot.Address(), []tree.Keypath{tree.Keypath("messages/bot")}, expires.Add(time.Second), &forAlice)
+	require.NoError(t, err)
+	require.Equal(t, redwood.TxStatusInvalid, send(bot, "five-b", "three", []redwood.CapabilityToken{longer}, "messages/bot/b", "beep"))
+
+	forever, err := redwood.NewCapabilityToken(alice, bot.Address(), []tree.Keypath{tree.Keypath("messages/bot")}, time.Time{}, &forAlice)
+	require.NoError(t, err)
+	require.Equal(t, redwood.TxStatusInvalid, send(bot, "five-c", "three", []redwood.CapabilityToken{forever}, "messages/bot/b", "beep"))
+
+	// Tokens can't be used by anyone but their audience
+	require.Equal(t, redwood.TxStatusInvalid, send(alice, "six", "three", []redwood.CapabilityToken{forBot}, "messages/bot/b", "beep"))
+
+	// Issuers can revoke the tokens that they've delegated
+	bs, err := json.Marshal(forBot)
+	require.NoError(t, err)
+	var revocation interface{}
+	err = json.Unmarshal(bs, &revocation)
+	require.NoError(t, err)
+
+	require.Equal(t, redwood.TxStatusValid, send(alice, "eight", "three", nil, "Revoked/"+forBot.ID(), revocation))
+	require.Equal(t, redwood.TxStatusInvalid, send(bot, "nine", "eight", []redwood.CapabilityToken{forBot}, "messages/bot/b", "beep"))
+
+	// ... but can't claim to have issued a token by forging it
+	forged, err := redwood.NewCapabilityToken(bot, alice.Address(), []tree.Keypath{tree.Keypath("messages")}, expires, &forAlice)
+	require.NoError(t, err)
+	bs, err = json.Marshal(forged)
+	require.NoError(t, err)
+	err = json.Unmarshal(bs, &revocation)
+	require.NoError(t, err)
+
+	require.Equal(t, redwood.TxStatusInvalid, send(bot, "ten", "eight", nil, "Revoked/"+forged.ID(), revocation))
+
+	// Tokens expire once a root moves the clock in the state past their expiry
+	require.Equal(t, redwood.TxStatusValid, send(c.signer, "eleven", "eight", nil, "Clock", 1999))
+	require.Equal(t, redwood.TxStatusValid, send(alice, "twelve", "eleven", []redwood.CapabilityToken{forAlice}, "messages/b", "hi"))
+	require.Equal(t, redwood.TxStatusValid, send(c.signer, "thirteen", "twelve", nil, "Clock", 2000))
+	require.Equal(t, redwood.TxStatusInvalid, send(alice, "fourteen", "thirteen", []redwood.CapabilityToken{forAlice}, "messages/c", "hi"))
+
+	// ... and nobody else can move the clock
+	everything, err := redwood.NewCapabilityToken(c.signer, alice.Address(), []tree.Keypath{nil}, time.Time{}, nil)
+	require.NoError(t, err)
+	require.Equal(t, redwood.TxStatusInvalid, send(alice, "fifteen", "thirteen", []redwood.CapabilityToken{everything}, "Clock", 0))
+	require.Equal(t, redwood.TxStatusValid, send(alice, "sixteen", "thirteen", []redwood.CapabilityToken{everything}, "messages/c", "hi"))
+}
+
+func TestController_MultisigValidator(t *testing.T) {
+	c := setupTestController(t, redwood.StateConfig{})
+	defer c.Close()
+
+	alice, err := crypto.GenerateSigningKeypair()
+	require.NoError(t, err)
+	outsider, err := crypto.GenerateSigningKeypair()
+	require.NoError(t, err)
+
+	genesis := c.addTx(t, "genesis", nil, true,
+		`.Validator = {
+			"Content-Type": "validator/multisig",
+			"value": {
+				"signers": ["`+c.signer.Address().Hex()+`", "`+alice.Address().Hex()+`"],
+				"threshold": 2
+			}
+		}`,
+	)
+
+	send := func(id string, parent types.ID, coSigners ...*crypto.SigningKeypair) redwood.TxStatus {
+		tx := &redwood.Tx{
+			ID:       types.IDFromString(id),
+			Parents:  []types.ID{parent},
+			From:     c.signer.Address(),
+			StateURI: testStateURI,
+			Patches:  []redwood.Patch{{Keypath: tree.Keypath("release"), Val: id}},
+		}
+		tx.Sig, err = c.signer.SignHash(tx.Hash())
+		require.NoError(t, err)
+		for _, coSigner := range coSigners {
+			sig, err := coSigner.SignHash(tx.Hash())
+			require.NoError(t, err)
+			tx.CoSigs = append(tx.CoSigs, sig)
+		}
+		err = c.AddTx(tx, false)
+		require.NoError(t, err)
+
+		var stored *redwood.Tx
+		require.Eventually(t, func() bool {
+			stored, err = c.txStore.FetchTx(testStateURI, tx.ID)
+			return err == nil && stored.Status != redwood.TxStatusInMempool
+		}, 5*time.Second, 10*time.Millisecond)
+		return stored.Status
+	}
+
+	require.Equal(t, redwood.TxStatusInvalid, send("one", genesis.ID))
+	require.Equal(t, redwood.TxStatusInvalid, send("two", genesis.ID, outsider))
+	require.Equal(t, redwood.TxStatusInvalid, send("three", genesis.ID, c.signer))
+	require.Equal(t, redwood.TxStatusValid, send("four", genesis.ID, alice))
+}
+
+func TestController_MultisigValidatorOnSubtree(t *testing.T) {
+	c := setupTestController(t, redwood.StateConfig{})
+	defer c.Close()
+
+	alice, err := crypto.GenerateSigningKeypair()
+	require.NoError(t, err)
+
+	genesis := c.addTx(t, "genesis", nil, true,
+		`.releases = {
+			"Validator": {
+				"Content-Type": "validator/multisig",
+				"value": {
+					"signers": ["`+c.signer.Address().Hex()+`", "`+alice.Address().Hex()+`"],
+					"threshold": 2
+				}
+			}
+		}`,
+	)
+
+	// Writes outside of the multisig's subtree don't need approvals
+	tx1 := c.addTx(t, "one", []types.ID{genesis.ID}, false, `.notes = "hi"`)
+	stored, err := c.txStore.FetchTx(testStateURI, tx1.ID)
+	require.NoError(t, err)
+	require.Equal(t, redwood.TxStatusValid, stored.Status)
+
+	tx2 := c.addTx(t, "two", []types.ID{tx1.ID}, false, `.releases.latest = "1.0"`)
+	stored, err = c.txStore.FetchTx(testStateURI, tx2.ID)
+	require.NoError(t, err)
+	require.Equal(t, redwood.TxStatusInvalid, stored.Status)
+
+	// Co-signatures cover the whole tx, not just the patches in the subtree
+	tx3 := c.newTx(t, "three", []types.ID{tx1.ID}, false, `.releases.latest = "1.0"`)
+	sig, err := alice.SignHash(tx3.Hash())
+	require.NoError(t, err)
+	tx3.CoSigs = append(tx3.CoSigs, sig)
+	err = c.AddTx(tx3, false)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		stored, err = c.txStore.FetchTx(testStateURI, tx3.ID)
+		return err == nil && stored.Status == redwood.TxStatusValid
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, "1.0", c.stateAt(t, nil).(M)["releases"].(M)["latest"])
+}
+
+func TestController_SimulateTx(t *testing.T) {
+	c := setupTestController(t, redwood.StateConfig{})
+	defer c.Close()
+
+	outsider, err := crypto.GenerateSigningKeypair()
+	require.NoError(t, err)
+
+	genesis := c.addTx(t, "genesis", nil, true,
+		`.Validator = {
+			"Content-Type": "validator/permissions",
+			"value": {"`+c.signer.Address().Hex()+`": {"^.*$": {"write": true}}}
+		}`,
+		`.messages = {"a": "hi"}`,
+	)
+
+	tx := c.newTx(t, "one", []types.ID{genesis.ID}, false, `.messages.b = "there"`)
+	sim, err := c.SimulateTx(tx, tree.Keypath("messages"))
+	require.NoError(t, err)
+	require.NoError(t, sim.Err)
+	require.Contains(t, sim.Added, tree.Keypath("messages/b"))
+	val, _, err := sim.State.Value(nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, M{"a": "hi", "b": "there"}, val)
+
+	// Nothing was saved
+	require.Equal(t, M{"a": "hi"}, c.stateAt(t, nil).(M)["messages"])
+
+	// Unsigned txs are simulated as though they came from tx.From
+	tx = &redwood.Tx{
+		ID:       types.IDFromString("two"),
+		Parents:  []types.ID{genesis.ID},
+		From:     outsider.Address(),
+		StateURI: testStateURI,
+		Patches:  []redwood.Patch{{Keypath: tree.Keypath("messages/b"), Val: "there"}},
+	}
+	sim, err = c.SimulateTx(tx, tree.Keypath("messages"))
+	require.NoError(t, err)
+	require.True(t, errors.Cause(sim.Err) == redwood.ErrInvalidTx)
+	require.Nil(t, sim.State)
+
+	bs, err := json.Marshal(sim)
+	require.NoError(t, err)
+	var decoded redwood.TxSimulation
+	err = json.Unmarshal(bs, &decoded)
+	require.NoError(t, err)
+	require.Equal(t, sim.Err.Error(), decoded.Err.Error())
+}
+
+func TestController_EmptyMergeTxCollapsesLeaves(t *testing.T) {
+	c := setupTestController(t, redwood.StateConfig{})
+	defer c.Close()
+
+	genesis := c.addTx(t, "genesis", nil, true, `.a = 1`)
+	tx1 := c.addTx(t, "one", []types.ID{genesis.ID}, false, `.b = "x"`)
+	tx2 := c.addTx(t, "two", []types.ID{genesis.ID}, false, `.c = true`)
+
+	leaves, err := c.Leaves()
+	require.NoError(t, err)
+	require.ElementsMatch(t, []types.ID{tx1.ID, tx2.ID}, leaves)
+
+	merge := c.addTx(t, "merge", leaves, false)
+
+	leaves, err = c.Leaves()
+	require.NoError(t, err)
+	require.Equal(t, []types.ID{merge.ID}, leaves)
+	require.Equal(t, M{"a": 1.0, "b": "x", "c": true}, c.stateAt(t, nil))
+}
+
+func TestController_Views(t *testing.T) {
+	c := setupTestController(t, redwood.StateConfig{})
+	defer c.Close()
+
+	genesis := c.addTx(t, "genesis", nil, true,
+		`.chat = {
+			"Views": {
+				"unread": {"Content-Type": "view/count", "source": "messages", "where": {"read": false}},
+				"byUser": {"Content-Type": "view/group-by", "source": "messages", "groupBy": "user"}
+			},
+			"messages": {
+				"a": {"user": "alice", "read": true},
+				"b": {"user": "bob", "read": false}
+			}
+		}`,
+	)
+	chat := c.stateAt(t, nil).(M)["chat"].(M)
+	require.Equal(t, 1.0, chat["unread"])
+	require.Equal(t, M{"alice": 1.0, "bob": 1.0}, chat["byUser"])
+
+	tx1 := c.addTx(t, "one", []types.ID{genesis.ID}, false, `.chat.messages.c = {"user": "bob", "read": false}`)
+	chat = c.stateAt(t, nil).(M)["chat"].(M)
+	require.Equal(t, 2.0, chat["unread"])
+	require.Equal(t, M{"alice": 1.0, "bob": 2.0}, chat["byUser"])
+
+	// Views can't be written to directly
+	tx2 := c.addTx(t, "two", []types.ID{tx1.ID}, false, `.chat.unread = 0`)
+	stored, err := c.txStore.FetchTx(testStateURI, tx2.ID)
+	require.NoError(t, err)
+	require.Equal(t, redwood.TxStatusInvalid, stored.Status)
+	require.Equal(t, 2.0, c.stateAt(t, nil).(M)["chat"].(M)["unread"])
+
+	// Removing a view removes its value
+	c.addTx(t, "three", []types.ID{tx1.ID}, false, `.chat.Views = {"unread": {"Content-Type": "view/count", "source": "messages"}}`)
+	chat = c.stateAt(t, nil).(M)["chat"].(M)
+	require.Equal(t, 3.0, chat["unread"])
+	require.NotContains(t, chat, "byUser")
+}
+
+func TestController_ViewsInBatch(t *testing.T) {
+	c := setupTestController(t, redwood.StateConfig{})
+	defer c.Close()
+
+	genesis := c.addTx(t, "genesis", nil, true,
+		`.chat = {
+			"Views": {
+				"unread": {"Content-Type": "view/count", "source": "messages", "where": {"read": false}},
+				"byUser": {"Content-Type": "view/group-by", "source": "messages", "groupBy": "user"}
+			},
+			"messages": {
+				"a": {"user": "alice", "read": true},
+				"b": {"user": "bob", "read": false}
+			}
+		}`,
+	)
+
+	tx1 := c.newTx(t, "one", []types.ID{genesis.ID}, false, `.chat.messages.c = {"user": "carol", "read": false}`)
+	tx2 := c.newTx(t, "two", []types.ID{tx1.ID}, false, `.chat.messages.b.read = true`)
+	tx3 := c.newTx(t, "three", []types.ID{tx2.ID}, false, `.chat.messages.a = null`)
+	err := c.AddTxs([]*redwood.Tx{tx1, tx2, tx3})
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		stored, err := c.txStore.FetchTx(testStateURI, tx3.ID)
+		return err == nil && stored.Status == redwood.TxStatusValid
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Each tx in the batch sees the views as they'd be if it were applied alone
+	expected := map[types.ID][]interface{}{
+		tx1.ID: {2.0, M{"alice": 1.0, "bob": 1.0, "carol": 1.0}},
+		tx2.ID: {1.0, M{"alice": 1.0, "bob": 1.0, "carol": 1.0}},
+		tx3.ID: {1.0, M{"bob": 1.0, "carol": 1.0}},
+	}
+	for txID, views := range expected {
+		txID := txID
+		chat := c.stateAt(t, &txID).(M)["chat"].(M)
+		require.Equal(t, views[0], chat["unread"])
+		require.Equal(t, views[1], chat["byUser"])
+	}
+}
+
+func TestController_Provenance(t *testing.T) {
+	c := setupTestController(t, redwood.StateConfig{KeepProvenanceHistory: true})
+	defer c.Close()
+
+	genesis := c.addTx(t, "genesis", nil, true, `.docs = {"readme": {"title": "hello"}, "license": "MIT"}`)
+	tx1 := c.addTx(t, "one", []types.ID{genesis.ID}, false, `.docs.readme.title = "hi"`)
+	tx2 := c.addTx(t, "two", []types.ID{tx1.ID}, false, `.other = true`)
+
+	provenance, err := c.Provenance(tree.Keypath("docs/readme/title"), 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, tx1.ID, provenance.Last.TxID)
+	require.Equal(t, c.signer.Address(), provenance.Last.From)
+	require.Len(t, provenance.History, 2)
+	require.Equal(t, genesis.ID, provenance.History[0].TxID)
+	require.Equal(t, tx1.ID, provenance.History[1].TxID)
+
+	// History can be fetched a page at a time
+	provenance, err = c.Provenance(tree.Keypath("docs/readme/title"), 1, 1)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), provenance.HistoryCount)
+	require.Len(t, provenance.History, 1)
+	require.Equal(t, tx1.ID, provenance.History[0].TxID)
+
+	provenance, err = c.Provenance(tree.Keypath("docs/readme/title"), 2, 1)
+	require.NoError(t, err)
+	require.Len(t, provenance.History, 0)
+
+	// Writing to a keypath touches its ancestors
+	provenance, err = c.Provenance(tree.Keypath("docs"), 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, tx1.ID, provenance.Last.TxID)
+
+	provenance, err = c.Provenance(tree.Keypath("docs/license"), 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, genesis.ID, provenance.Last.TxID)
+	require.Len(t, provenance.History, 1)
+
+	provenance, err = c.Provenance(nil, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, tx2.ID, provenance.Last.TxID)
+
+	_, err = c.Provenance(tree.Keypath("nope"), 0, 0)
+	require.True(t, errors.Cause(err) == types.Err404)
+}
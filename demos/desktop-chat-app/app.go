@@ -95,7 +95,7 @@ func (app *appType) Start() (err error) {
 		keyStore      = identity.NewBadgerKeyStore(db, identity.DefaultScryptParams)
 		refStore      = redwood.NewRefStore(config.RefDataRoot())
 		peerStore     = redwood.NewPeerStore(db)
-		controllerHub = redwood.NewControllerHub(config.StateDBRoot(), txStore, refStore)
+		controllerHub = redwood.NewControllerHub(config.StateDBRoot(), txStore, refStore, config.Node.States)
 	)
 	app.keyStore = keyStore
 
@@ -37,7 +37,9 @@ require (
 	github.com/pkg/errors v0.9.1
 	github.com/powerman/rpc-codec v1.2.2
 	github.com/rs/cors v1.7.0
+	github.com/santhosh-tekuri/jsonschema/v5 v5.3.1
 	github.com/stretchr/testify v1.6.1
+	github.com/tetratelabs/wazero v1.0.0
 	github.com/tyler-smith/go-bip39 v1.0.1-0.20181017060643-dbb3b84ba2ef
 	github.com/urfave/cli v1.22.1
 	github.com/yhat/wsutil v0.0.0-20170731153501-1d66fa95c997
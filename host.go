@@ -25,12 +25,18 @@ type Host interface {
 	Subscribe(ctx context.Context, stateURI string, subscriptionType SubscriptionType, keypath tree.Keypath, fetchHistoryOpts *FetchHistoryOpts) (ReadableSubscription, error)
 	Unsubscribe(stateURI string) error
 	SendTx(ctx context.Context, tx Tx) error
+	ProposeTx(tx Tx) (Tx, error)
+	PendingTx(stateURI string, txID types.ID) (Tx, error)
+	CoSignTx(stateURI string, txID types.ID, sig types.Signature) (Tx, error)
+	SendPendingTx(ctx context.Context, stateURI string, txID types.ID) error
+	CompactHistory(stateURI string, txID types.ID) error
 	AddRef(reader io.ReadCloser) (types.Hash, types.Hash, error)
 	FetchRef(ctx context.Context, ref types.RefID)
 	AddPeer(dialInfo PeerDialInfo)
 	Transport(name string) Transport
 	Controllers() ControllerHub
 	ChallengePeerIdentity(ctx context.Context, peer Peer) error
+	SyncWithPeer(ctx context.Context, stateURI string, dialInfo PeerDialInfo) error
 
 	Identities() ([]identity.Identity, error)
 	NewIdentity(public bool) (identity.Identity, error)
@@ -48,6 +54,8 @@ type Host interface {
 	HandleAckReceived(stateURI string, txID types.ID, peer Peer)
 	HandleChallengeIdentity(challengeMsg types.ChallengeMsg, peer Peer) error
 	HandleFetchRefReceived(refID types.RefID, peer Peer)
+	HandleFetchTxsReceived(stateURI string, txIDs []types.ID, peer Peer)
+	HandleSyncTxsReceived(stateURI string, locator []types.ID, peer Peer)
 }
 
 type host struct {
@@ -73,6 +81,20 @@ type host struct {
 	keyStore      identity.KeyStore
 
 	chRefsNeeded chan []types.RefID
+
+	chParentsNeeded       chan missingParents
+	parentsBeingFetched   map[string]map[types.ID]struct{} // map[stateURI]map[tx.ID]
+	parentsBeingFetchedMu sync.Mutex
+
+	pendingTxs   map[string]map[types.ID]*Tx // map[stateURI]map[tx.ID]
+	pendingTxsMu sync.Mutex
+
+	stateDiffResyncs   map[WritableSubscription]*stateDiffResync
+	stateDiffResyncsMu sync.Mutex
+
+	// Jobs that write states and diffs to writable subscribers, run one at a
+	// time (see processSubscriberBroadcasts)
+	subscriberBroadcasts *utils.Mailbox
 }
 
 var (
@@ -106,6 +128,11 @@ func NewHost(
 		refStore:              refStore,
 		keyStore:              keyStore,
 		chRefsNeeded:          make(chan []types.RefID, 100),
+		chParentsNeeded:       make(chan missingParents, 100),
+		parentsBeingFetched:   make(map[string]map[types.ID]struct{}),
+		pendingTxs:            make(map[string]map[types.ID]*Tx),
+		stateDiffResyncs:      make(map[WritableSubscription]*stateDiffResync),
+		subscriberBroadcasts:  utils.NewMailbox(0),
 		config:                config,
 	}
 	return h, nil
@@ -120,6 +147,7 @@ func (h *host) Start() error {
 
 	// Set up the controller Hub
 	h.controllerHub.OnNewState(h.handleNewState)
+	h.controllerHub.OnMissingParents(h.handleMissingParents)
 	err := h.controllerHub.Start()
 	if err != nil {
 		return err
@@ -138,10 +166,34 @@ func (h *host) Start() error {
 	}
 
 	go h.periodicallyFetchMissingRefs()
+	go h.periodicallyFetchMissingParents()
+	go h.periodicallyConsolidateLeaves()
+	go h.processSubscriberBroadcasts()
 
 	return nil
 }
 
+// processSubscriberBroadcasts runs the queued jobs that write to writable
+// subscribers one at a time, in the order that they were queued.  Since new
+// states are queued in the order that they're produced, each subscriber
+// receives its states and diffs in that order too.
+func (h *host) processSubscriberBroadcasts() {
+	for {
+		select {
+		case <-h.chStop:
+			return
+		case <-h.subscriberBroadcasts.Notify():
+			for {
+				job := h.subscriberBroadcasts.Retrieve()
+				if job == nil {
+					break
+				}
+				job.(func())()
+			}
+		}
+	}
+}
+
 func (h *host) Close() {
 	close(h.chStop)
 
@@ -456,6 +508,384 @@ func (h *host) txSeenByPeer(peer Peer, stateURI string, txID types.ID) bool {
 	return h.peerSeenTxs[dialInfo][stateURI][txID]
 }
 
+// missingParents describes txs that can't be applied until we receive some of
+// their parents.
+type missingParents struct {
+	stateURI  string
+	childIDs  []types.ID
+	parentIDs []types.ID
+}
+
+func (h *host) handleMissingParents(tx *Tx, parentIDs []types.ID) {
+	select {
+	case h.chParentsNeeded <- missingParents{tx.StateURI, []types.ID{tx.ID}, parentIDs}:
+	default:
+		// The periodic sweep will pick these up
+	}
+}
+
+func (h *host) periodicallyFetchMissingParents() {
+	tick := time.NewTicker(10 * time.Second) // @@TODO: make configurable
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-h.chStop:
+			return
+
+		case needed := <-h.chParentsNeeded:
+			go h.fetchMissingParents(needed.stateURI, needed.childIDs, needed.parentIDs)
+
+		case <-tick.C:
+			stateURIs, err := h.controllerHub.KnownStateURIs()
+			if err != nil {
+				h.Errorf("error fetching list of known state URIs: %v", err)
+				continue
+			}
+
+			for _, stateURI := range stateURIs {
+				missing, err := h.controllerHub.MissingParents(stateURI)
+				if errors.Cause(err) == ErrNoController {
+					continue
+				} else if err != nil {
+					h.Errorf("error fetching missing parents: %v", err)
+					continue
+				} else if len(missing) == 0 {
+					continue
+				}
+
+				var childIDs, parentIDs []types.ID
+				for childID, ids := range missing {
+					childIDs = append(childIDs, childID)
+					parentIDs = append(parentIDs, ids...)
+				}
+				go h.fetchMissingParents(stateURI, childIDs, parentIDs)
+			}
+		}
+	}
+}
+
+// fetchMissingParents asks the peers that sent us the given child txs, and
+// then any other providers of the state URI, for parent txs that we've never
+// received.  The fetched parents go through the mempool like any other tx, so
+// if they're missing parents of their own, those are fetched in turn.
+func (h *host) fetchMissingParents(stateURI string, childIDs []types.ID, parentIDs []types.ID) {
+	parentIDs = h.claimParentsToFetch(stateURI, parentIDs)
+	if len(parentIDs) == 0 {
+		return
+	}
+	defer h.releaseParentsToFetch(stateURI, parentIDs)
+
+	ctx, cancel := utils.CombinedContext(h.chStop, 30*time.Second)
+	defer cancel()
+
+	remaining := make(map[types.ID]struct{}, len(parentIDs))
+	for _, parentID := range parentIDs {
+		remaining[parentID] = struct{}{}
+	}
+
+	fetchFromPeer := func(peer Peer) {
+		defer peer.Close()
+
+		err := peer.EnsureConnected(ctx)
+		if err != nil {
+			h.Errorf("error connecting to peer: %v", err)
+			return
+		}
+
+		txIDs := make([]types.ID, 0, len(remaining))
+		for txID := range remaining {
+			txIDs = append(txIDs, txID)
+		}
+
+		err = peer.FetchTxs(stateURI, txIDs)
+		if err != nil {
+			h.Errorf("error writing to peer: %v", err)
+			return
+		}
+
+		txs, err := peer.ReceiveTxs()
+		if err != nil {
+			h.Errorf("error reading from peer: %v", err)
+			return
+		}
+
+		for _, tx := range txs {
+			if _, wanted := remaining[tx.ID]; !wanted || tx.StateURI != stateURI {
+				continue
+			}
+			delete(remaining, tx.ID)
+			h.addFetchedTx(tx, peer)
+		}
+	}
+
+	for _, peer := range h.peersThatHaveSeenTxs(ctx, stateURI, childIDs) {
+		fetchFromPeer(peer)
+		if len(remaining) == 0 {
+			return
+		}
+	}
+
+	for peer := range h.ProvidersOfStateURI(ctx, stateURI) {
+		fetchFromPeer(peer)
+		if len(remaining) == 0 {
+			return
+		}
+	}
+
+	h.Warnf("could not find %v missing parent(s) in %v", len(remaining), stateURI)
+}
+
+// claimParentsToFetch filters out the txs that another goroutine is already
+// fetching and marks the rest as being fetched.
+func (h *host) claimParentsToFetch(stateURI string, txIDs []types.ID) []types.ID {
+	h.parentsBeingFetchedMu.Lock()
+	defer h.parentsBeingFetchedMu.Unlock()
+
+	if h.parentsBeingFetched[stateURI] == nil {
+		h.parentsBeingFetched[stateURI] = make(map[types.ID]struct{})
+	}
+
+	var claimed []types.ID
+	for _, txID := range txIDs {
+		if _, exists := h.parentsBeingFetched[stateURI][txID]; exists {
+			continue
+		}
+		h.parentsBeingFetched[stateURI][txID] = struct{}{}
+		claimed = append(claimed, txID)
+	}
+	return claimed
+}
+
+func (h *host) releaseParentsToFetch(stateURI string, txIDs []types.ID) {
+	h.parentsBeingFetchedMu.Lock()
+	defer h.parentsBeingFetchedMu.Unlock()
+
+	for _, txID := range txIDs {
+		delete(h.parentsBeingFetched[stateURI], txID)
+	}
+	if len(h.parentsBeingFetched[stateURI]) == 0 {
+		delete(h.parentsBeingFetched, stateURI)
+	}
+}
+
+// peersThatHaveSeenTxs returns connections to the peers that sent us, or
+// acknowledged, any of the given txs.
+func (h *host) peersThatHaveSeenTxs(ctx context.Context, stateURI string, txIDs []types.ID) []Peer {
+	var dialInfos []PeerDialInfo
+	func() {
+		h.peerSeenTxsMu.RLock()
+		defer h.peerSeenTxsMu.RUnlock()
+
+		for dialInfo, seenTxs := range h.peerSeenTxs {
+			for _, txID := range txIDs {
+				if seenTxs[stateURI][txID] {
+					dialInfos = append(dialInfos, dialInfo)
+					break
+				}
+			}
+		}
+	}()
+
+	var peers []Peer
+	for _, dialInfo := range dialInfos {
+		tpt := h.Transport(dialInfo.TransportName)
+		if tpt == nil || dialInfo.DialAddr == "" {
+			continue
+		}
+
+		peer, err := tpt.NewPeerConn(ctx, dialInfo.DialAddr)
+		if errors.Cause(err) == ErrPeerIsSelf {
+			continue
+		} else if err != nil {
+			h.Warnf("error creating new peer conn (transport: %v, dialAddr: %v)", dialInfo.TransportName, dialInfo.DialAddr)
+			continue
+		}
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+// HandleFetchTxsReceived responds to a peer's request for specific txs,
+// usually the missing parents of a tx that we sent it.  Only valid txs are
+// sent, the txs of a private state URI are only sent to its members, and txs
+// that patch keypaths the peer can't read are skipped.
+func (h *host) HandleFetchTxsReceived(stateURI string, txIDs []types.ID, peer Peer) {
+	var txs []Tx
+
+	isAllowed, err := h.peerIsAllowedToRead(stateURI, peer)
+	if err != nil {
+		h.Errorf("error checking whether peer %v can read %v: %v", peer.DialInfo(), stateURI, err)
+	} else if isAllowed {
+		for _, txID := range txIDs {
+			tx, err := h.controllerHub.FetchTx(stateURI, txID)
+			if errors.Cause(err) == types.Err404 {
+				continue
+			} else if err != nil {
+				h.Errorf("error fetching tx %v: %v", txID.Pretty(), err)
+				continue
+			} else if tx.Status != TxStatusValid {
+				continue
+			}
+
+			canRead, err := h.txIsReadable(tx, peer.Addresses())
+			if err != nil {
+				h.Errorf("error determining if peer '%v' can read tx %v: %v", peer.Addresses(), tx.ID.Pretty(), err)
+				continue
+			} else if !canRead {
+				continue
+			}
+			txs = append(txs, *tx)
+		}
+	}
+
+	err = peer.SendTxs(txs)
+	if err != nil {
+		h.Errorf("error sending txs to peer: %v", err)
+	}
+}
+
+// addFetchedTx adds a tx that a peer sent us from its history.
+func (h *host) addFetchedTx(tx Tx, peer Peer) {
+	h.markTxSeenByPeer(peer, tx.StateURI, tx.ID)
+
+	// We track a tx's children ourselves as they're applied
+	tx.Children = nil
+	tx.Status = TxStatusUnknown
+
+	err := h.controllerHub.AddTx(&tx, false)
+	if err != nil {
+		h.Errorf("error adding tx to controllerHub: %v", err)
+	}
+}
+
+// SyncWithPeer fetches the txs that a peer has for the given state URI and
+// that we're missing.  Only the difference between the two histories is sent
+// (see sync.go).
+func (h *host) SyncWithPeer(ctx context.Context, stateURI string, dialInfo PeerDialInfo) (err error) {
+	defer utils.Annotate(&err, "stateURI=%v peer=%v", stateURI, dialInfo)
+
+	tpt := h.Transport(dialInfo.TransportName)
+	if tpt == nil {
+		return errors.Errorf("unknown transport '%v'", dialInfo.TransportName)
+	}
+
+	locator, err := h.controllerHub.TxLocator(stateURI)
+	if err != nil {
+		return err
+	}
+
+	peer, err := tpt.NewPeerConn(ctx, dialInfo.DialAddr)
+	if err != nil {
+		return err
+	}
+	defer peer.Close()
+
+	err = peer.EnsureConnected(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = peer.SyncTxs(stateURI, locator)
+	if err != nil {
+		return err
+	}
+
+	txs, err := peer.ReceiveTxs()
+	if err != nil {
+		return err
+	}
+
+	var fetched []*Tx
+	for _, tx := range txs {
+		if tx.StateURI != stateURI {
+			continue
+		}
+		h.markTxSeenByPeer(peer, stateURI, tx.ID)
+
+		// We track a tx's children ourselves as they're applied
+		tx := tx
+		tx.Children = nil
+		tx.Status = TxStatusUnknown
+		fetched = append(fetched, &tx)
+	}
+
+	// Adding them together lets the controller apply them in batches
+	err = h.controllerHub.AddTxs(stateURI, fetched)
+	if err != nil {
+		return err
+	}
+	h.Infof(0, "synced %v with %v: received %v txs", stateURI, dialInfo, len(txs))
+	return nil
+}
+
+// HandleSyncTxsReceived responds to a peer that wants to catch up with our
+// history by sending it the txs that are missing from its locator, except for
+// those that patch keypaths the peer can't read.
+func (h *host) HandleSyncTxsReceived(stateURI string, locator []types.ID, peer Peer) {
+	var txs []Tx
+
+	isAllowed, err := h.peerIsAllowedToRead(stateURI, peer)
+	if err != nil {
+		h.Errorf("error checking whether peer %v can read %v: %v", peer.DialInfo(), stateURI, err)
+	} else if isAllowed {
+		missing, err := h.controllerHub.TxsMissingFromLocator(stateURI, locator)
+		if err != nil {
+			h.Errorf("error finding txs missing from peer %v: %v", peer.DialInfo(), err)
+		}
+		for _, tx := range missing {
+			canRead, err := h.txIsReadable(tx, peer.Addresses())
+			if err != nil {
+				h.Errorf("error determining if peer '%v' can read tx %v: %v", peer.Addresses(), tx.ID.Pretty(), err)
+				continue
+			} else if !canRead {
+				continue
+			}
+			txs = append(txs, *tx)
+		}
+	}
+
+	err = peer.SendTxs(txs)
+	if err != nil {
+		h.Errorf("error sending txs to peer: %v", err)
+	}
+}
+
+// txIsReadable returns true if the readers may read everything that the tx
+// patches.
+func (h *host) txIsReadable(tx *Tx, readers []types.Address) (bool, error) {
+	for _, patch := range tx.Patches {
+		canRead, err := h.controllerHub.CanRead(tx.StateURI, patch.Keypath, readers)
+		if err != nil {
+			return false, err
+		} else if !canRead {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// peerIsAllowedToRead returns true if the state URI is public or if the peer
+// has proven that it holds one of the state URI's member addresses.
+func (h *host) peerIsAllowedToRead(stateURI string, peer Peer) (bool, error) {
+	isPrivate, err := h.controllerHub.IsPrivate(stateURI)
+	if err != nil {
+		return false, err
+	} else if !isPrivate {
+		return true, nil
+	}
+
+	for _, addr := range peer.Addresses() {
+		isMember, err := h.controllerHub.IsMember(stateURI, addr)
+		if err != nil {
+			return false, err
+		} else if isMember {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 func (h *host) AddPeer(dialInfo PeerDialInfo) {
 	h.peerStore.AddDialInfos([]PeerDialInfo{dialInfo})
 	h.processPeersTask.Enqueue()
@@ -563,8 +993,34 @@ type FetchHistoryOpts struct {
 }
 
 func (h *host) HandleFetchHistoryRequest(stateURI string, opts FetchHistoryOpts, writeSub WritableSubscription) error {
-	// @@TODO: respect the `opts.ToTxID` param
-	// @@TODO: if .FromTxID == 0, set it to GenesisTxID
+	isAllowed := true
+	peer, isPeer := peerForSubscription(writeSub)
+	if isPeer {
+		var err error
+		isAllowed, err = h.peerIsAllowedToRead(stateURI, peer)
+		if err != nil {
+			h.Errorf("error determining if peer '%v' can read private state URI '%v': %v", peer.Addresses(), stateURI, err)
+			return err
+		}
+	} // In-process subscriptions are trusted
+	if !isAllowed {
+		return nil
+	}
+
+	// If the history is bounded, only send the txs in the causal past of .ToTxID
+	var wanted map[types.ID]bool
+	if opts.ToTxID != (types.ID{}) {
+		var err error
+		wanted, err = h.txsBetween(stateURI, opts.FromTxID, opts.ToTxID)
+		if err != nil {
+			return err
+		}
+	}
+
+	leaves, err := h.controllerHub.Leaves(stateURI)
+	if err != nil {
+		return err
+	}
 
 	iter := h.controllerHub.FetchTxs(stateURI, opts.FromTxID)
 	defer iter.Cancel()
@@ -577,43 +1033,57 @@ func (h *host) HandleFetchHistoryRequest(stateURI string, opts FetchHistoryOpts,
 			return nil
 		}
 
-		leaves, err := h.controllerHub.Leaves(stateURI)
-		if err != nil {
-			return err
+		if wanted != nil {
+			if !wanted[tx.ID] {
+				continue
+			}
+			delete(wanted, tx.ID)
 		}
 
-		isPrivate, err := h.controllerHub.IsPrivate(tx.StateURI)
-		if err != nil {
-			return err
+		if isPeer {
+			canRead, err := h.txIsReadable(tx, peer.Addresses())
+			if err != nil {
+				return err
+			} else if !canRead {
+				continue
+			}
 		}
 
-		if isPrivate {
-			var isAllowed bool
-			if peer, isPeer := writeSub.(Peer); isPeer {
-				for _, addr := range peer.Addresses() {
-					isAllowed, err = h.controllerHub.IsMember(tx.StateURI, addr)
-					if err != nil {
-						h.Errorf("error determining if peer '%v' is a member of private state URI '%v': %v", addr, tx.StateURI, err)
-						return err
-					}
-					if isAllowed {
-						break
-					}
-				}
-			} else {
-				// In-process subscriptions are trusted
-				isAllowed = true
-			}
+		writeSub.EnqueueWrite(tx, nil, nil, leaves)
 
-			if isAllowed {
-				writeSub.EnqueueWrite(tx, nil, leaves)
-			}
+		if wanted != nil && len(wanted) == 0 {
+			return nil
+		}
+	}
+}
 
-		} else {
-			writeSub.EnqueueWrite(tx, nil, leaves)
+// txsBetween returns the IDs of toTxID and its ancestors, stopping at fromTxID.
+func (h *host) txsBetween(stateURI string, fromTxID, toTxID types.ID) (map[types.ID]bool, error) {
+	txIDs := map[types.ID]bool{toTxID: true}
+	queue := []types.ID{toTxID}
+	for len(queue) > 0 {
+		txID := queue[0]
+		queue = queue[1:]
+		if txID == fromTxID {
+			continue
+		}
+
+		tx, err := h.controllerHub.FetchTx(stateURI, txID)
+		if errors.Cause(err) == types.Err404 {
+			// Pruned by a snapshot
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, parentID := range tx.Parents {
+			if !txIDs[parentID] {
+				txIDs[parentID] = true
+				queue = append(queue, parentID)
+			}
 		}
 	}
-	return nil
+	return txIDs, nil
 }
 
 func (h *host) HandleWritableSubscriptionOpened(writeSub WritableSubscription, fetchHistoryOpts *FetchHistoryOpts) {
@@ -621,42 +1091,86 @@ func (h *host) HandleWritableSubscriptionOpened(writeSub WritableSubscription, f
 		h.HandleFetchHistoryRequest(writeSub.StateURI(), *fetchHistoryOpts, writeSub)
 	}
 
-	if writeSub.Type().Includes(SubscriptionType_States) {
-		// Normalize empty keypaths
-		keypath := writeSub.Keypath()
-		if keypath.Equals(tree.KeypathSeparator) {
-			keypath = nil
+	// The initial state is queued behind the broadcasts of earlier states, so
+	// that it isn't followed by an older diff
+	h.subscriberBroadcasts.Deliver(func() {
+		if writeSub.Type().Includes(SubscriptionType_States) || writeSub.Type().Includes(SubscriptionType_StateDiffs) {
+			h.writeInitialState(writeSub)
 		}
 
-		// Immediately write the current state to the subscriber
-		state, err := h.Controllers().StateAtVersion(writeSub.StateURI(), nil)
-		if err != nil && errors.Cause(err) != ErrNoController {
-			h.Errorf("error writing initial state to peer: %v", err)
-			return
-		} else if err == nil {
-			defer state.Close()
+		h.writableSubscriptionsMu.Lock()
+		defer h.writableSubscriptionsMu.Unlock()
 
-			leaves, err := h.Controllers().Leaves(writeSub.StateURI())
-			if err != nil {
-				h.Errorf("error writing initial state to peer (%v): %v", writeSub.StateURI(), err)
-			} else {
-				node, err := state.CopyToMemory(keypath, nil)
-				if err != nil {
-					h.Errorf("error writing initial state to peer (%v): %v", writeSub.StateURI(), err)
-				} else {
-					writeSub.EnqueueWrite(nil, node, leaves)
-				}
-			}
+		if _, exists := h.writableSubscriptions[writeSub.StateURI()]; !exists {
+			h.writableSubscriptions[writeSub.StateURI()] = make(map[WritableSubscription]struct{})
 		}
+		h.writableSubscriptions[writeSub.StateURI()][writeSub] = struct{}{}
+	})
+}
+
+// writeInitialState writes the current state to a new subscriber that wants
+// states.
+func (h *host) writeInitialState(writeSub WritableSubscription) {
+	// Normalize empty keypaths
+	keypath := writeSub.Keypath()
+	if keypath.Equals(tree.KeypathSeparator) {
+		keypath = nil
 	}
 
-	h.writableSubscriptionsMu.Lock()
-	defer h.writableSubscriptionsMu.Unlock()
+	state, err := h.Controllers().StateAtVersion(writeSub.StateURI(), nil)
+	if errors.Cause(err) == ErrNoController {
+		return
+	} else if err != nil {
+		h.Errorf("error writing initial state to peer: %v", err)
+		return
+	}
+	defer state.Close()
 
-	if _, exists := h.writableSubscriptions[writeSub.StateURI()]; !exists {
-		h.writableSubscriptions[writeSub.StateURI()] = make(map[WritableSubscription]struct{})
+	leaves, err := h.Controllers().Leaves(writeSub.StateURI())
+	if err != nil {
+		h.Errorf("error writing initial state to peer (%v): %v", writeSub.StateURI(), err)
+		return
+	}
+
+	node, err := state.CopyToMemory(keypath, nil)
+	if err != nil {
+		h.Errorf("error writing initial state to peer (%v): %v", writeSub.StateURI(), err)
+		return
+	}
+
+	node, err = h.readableStateForSubscriber(writeSub, node, keypath)
+	if errors.Cause(err) == types.Err403 {
+		h.Warnf("not writing initial state to subscriber (%v): %v", writeSub.StateURI(), err)
+		return
+	} else if err != nil {
+		h.Errorf("error writing initial state to peer (%v): %v", writeSub.StateURI(), err)
+		return
+	}
+
+	var diff *StateDiff
+	if writeSub.Type().Includes(SubscriptionType_StateDiffs) {
+		seq, _ := h.nextStateDiffSeq(writeSub, true)
+		diff = &StateDiff{Seq: seq}
 	}
-	h.writableSubscriptions[writeSub.StateURI()][writeSub] = struct{}{}
+	writeSub.EnqueueWrite(nil, node, diff, leaves)
+}
+
+// readableStateForSubscriber hides the parts of the state (found at the given
+// keypath) that a subscriber isn't allowed to read.  In-process subscriptions
+// are trusted.
+func (h *host) readableStateForSubscriber(writeSub WritableSubscription, state tree.Node, keypath tree.Keypath) (tree.Node, error) {
+	peer, isPeer := peerForSubscription(writeSub)
+	if !isPeer {
+		return state, nil
+	}
+
+	isAllowed, err := h.peerIsAllowedToRead(writeSub.StateURI(), peer)
+	if err != nil {
+		return nil, err
+	} else if !isAllowed {
+		return nil, errors.Wrapf(types.Err403, "peer %v is not a member of %v", peer.Addresses(), writeSub.StateURI())
+	}
+	return h.controllerHub.ReadableState(writeSub.StateURI(), state, keypath, peer.Addresses())
 }
 
 func (h *host) HandleWritableSubscriptionClosed(writeSub WritableSubscription) {
@@ -666,6 +1180,10 @@ func (h *host) HandleWritableSubscriptionClosed(writeSub WritableSubscription) {
 	if _, exists := h.writableSubscriptions[writeSub.StateURI()]; exists {
 		delete(h.writableSubscriptions[writeSub.StateURI()], writeSub)
 	}
+
+	h.stateDiffResyncsMu.Lock()
+	defer h.stateDiffResyncsMu.Unlock()
+	delete(h.stateDiffResyncs, writeSub)
 }
 
 func (h *host) HandleReadableSubscriptionClosed(stateURI string) {
@@ -799,27 +1317,49 @@ func (h *host) HandleChallengeIdentity(challengeMsg types.ChallengeMsg, peer Pee
 	return peer.RespondChallengeIdentity(responses)
 }
 
-func (h *host) handleNewState(tx *Tx, state tree.Node, leaves []types.ID) {
+// handleNewState broadcasts the txs that produced a new state.  Every tx is
+// sent to providers, recipients and tx subscribers.  Subscribers that want
+// states get the new state along with the last tx.  Writes to subscribers are
+// queued, so that they go out in the order that the states were produced
+// without holding up the controller while they're sent.
+func (h *host) handleNewState(txs []*Tx, state tree.Node, leaves []types.ID) {
+	diff := state.Diff().Copy()
+	leaves = append([]types.ID(nil), leaves...)
+
+	// The state is only valid until we return, so the queued job gets a copy
 	state, err := state.CopyToMemory(nil, nil)
 	if err != nil {
 		h.Errorf("handleNewState: couldn't copy state to memory: %v", err)
 		state = tree.NewMemoryNode() // give subscribers an empty state
 	}
 
+	h.subscriberBroadcasts.Deliver(func() {
+		ctx, cancel := utils.CombinedContext(h.chStop, 10*time.Second)
+		defer cancel()
+
+		var wg sync.WaitGroup
+		var alreadySentSubscribers sync.Map
+		wg.Add(1)
+		h.broadcastToWritableSubscribers(ctx, txs, state, diff, leaves, &alreadySentSubscribers, &wg)
+		wg.Wait()
+	})
+
 	// @@TODO: don't do this, this is stupid.  store ungossiped txs in the DB and create a
 	// PeerManager that gossips them on a SleeperTask-like trigger.
 	go func() {
-		// If this is the genesis tx of a private state URI, ensure that we subscribe to that state URI
-		// @@TODO: allow blacklisting of senders
-		if tx.IsPrivate() && tx.ID == GenesisTxID && !h.config.Node.SubscribedStateURIs.Contains(tx.StateURI) {
-			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-			defer cancel()
-
-			sub, err := h.Subscribe(ctx, tx.StateURI, 0, nil, nil)
-			if err != nil {
-				h.Errorf("error subscribing to state URI %v: %v", tx.StateURI, err)
+		for _, tx := range txs {
+			// If this is the genesis tx of a private state URI, ensure that we subscribe to that state URI
+			// @@TODO: allow blacklisting of senders
+			if tx.IsPrivate() && tx.ID == GenesisTxID && !h.config.Node.SubscribedStateURIs.Contains(tx.StateURI) {
+				ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+				defer cancel()
+
+				sub, err := h.Subscribe(ctx, tx.StateURI, 0, nil, nil)
+				if err != nil {
+					h.Errorf("error subscribing to state URI %v: %v", tx.StateURI, err)
+				}
+				sub.Close() // We don't need the in-process subscription
 			}
-			sub.Close() // We don't need the in-process subscription
 		}
 
 		// If this state URI isn't meant to be shared, don't broadcast
@@ -827,17 +1367,17 @@ func (h *host) handleNewState(tx *Tx, state tree.Node, leaves []types.ID) {
 		// 	return
 		// }
 
-		// Broadcast state and tx to others
+		// Broadcast state and txs to others
 		ctx, cancel := utils.CombinedContext(h.chStop, 10*time.Second)
 		defer cancel()
 
 		var wg sync.WaitGroup
-		var alreadySentPeers sync.Map
-
-		wg.Add(3)
-		go h.broadcastToWritableSubscribers(ctx, tx, state, leaves, &alreadySentPeers, &wg)
-		go h.broadcastToStateURIProviders(ctx, tx, leaves, &alreadySentPeers, &wg)
-		go h.broadcastToPrivateRecipients(ctx, tx, leaves, &alreadySentPeers, &wg)
+		for _, tx := range txs {
+			alreadySentPeers := &sync.Map{}
+			wg.Add(2)
+			go h.broadcastToStateURIProviders(ctx, tx, leaves, alreadySentPeers, &wg)
+			go h.broadcastToPrivateRecipients(ctx, tx, leaves, alreadySentPeers, &wg)
+		}
 
 		wg.Wait()
 	}()
@@ -956,6 +1496,22 @@ Outer:
 			}
 			defer peer.Close()
 
+			// Only send the tx if the peer is allowed to read everything in it
+			isAllowed, err := h.peerIsAllowedToRead(tx.StateURI, peer)
+			if err != nil {
+				h.Errorf("error determining if peer '%v' can read state URI '%v': %v", peer.Addresses(), tx.StateURI, err)
+				return
+			} else if !isAllowed {
+				return
+			}
+			canRead, err := h.txIsReadable(tx, peer.Addresses())
+			if err != nil {
+				h.Errorf("error determining if peer '%v' can read tx %v: %v", peer.Addresses(), tx.ID.Pretty(), err)
+				return
+			} else if !canRead {
+				return
+			}
+
 			err = peer.Put(ctx, tx, nil, leaves)
 			if err != nil {
 				h.Errorf("error writing tx to peer: %v", err)
@@ -965,10 +1521,13 @@ Outer:
 	}
 }
 
+// broadcastToWritableSubscribers writes the given txs to each subscriber, in
+// order.  The new state (or its diff) goes out with the last tx.
 func (h *host) broadcastToWritableSubscribers(
 	ctx context.Context,
-	tx *Tx,
+	txs []*Tx,
 	state tree.Node,
+	diff *tree.Diff,
 	leaves []types.ID,
 	alreadySentPeers *sync.Map,
 	wg *sync.WaitGroup,
@@ -978,10 +1537,16 @@ func (h *host) broadcastToWritableSubscribers(
 	h.writableSubscriptionsMu.RLock()
 	defer h.writableSubscriptionsMu.RUnlock()
 
-	for writeSub := range h.writableSubscriptions[tx.StateURI] {
-		if peer, isPeer := writeSub.(Peer); isPeer {
+	lastTx := txs[len(txs)-1]
+	earlierTxs := txs[:len(txs)-1]
+
+	for writeSub := range h.writableSubscriptions[lastTx.StateURI] {
+		wantsTxs := writeSub.Type().Includes(SubscriptionType_Txs)
+		wantsStates := writeSub.Type().Includes(SubscriptionType_States) || writeSub.Type().Includes(SubscriptionType_StateDiffs)
+
+		if peer, isPeer := peerForSubscription(writeSub); isPeer {
 			// If the subscriber wants us to send states, we never skip sending
-			if h.txSeenByPeer(peer, tx.StateURI, tx.ID) && !writeSub.Type().Includes(SubscriptionType_States) {
+			if !wantsStates && h.allTxsSeenByPeer(peer, txs) {
 				continue
 			}
 		}
@@ -996,48 +1561,110 @@ func (h *host) broadcastToWritableSubscribers(
 				return
 			}
 
-			isPrivate, err := h.controllerHub.IsPrivate(tx.StateURI)
-			if err != nil {
-				h.Errorf("error determining if state URI '%v' is private: %v", tx.StateURI, err)
-				return
-			}
-
 			// Drill down to the part of the state that the subscriber is interested in
 			keypath := writeSub.Keypath()
 			if keypath.Equals(tree.KeypathSeparator) {
 				keypath = nil
 			}
-			state = state.NodeAt(keypath, nil)
-
-			if isPrivate {
-				var isAllowed bool
-				if peer, isPeer := writeSub.(Peer); isPeer {
-					for _, addr := range peer.Addresses() {
-						isAllowed, err = h.controllerHub.IsMember(tx.StateURI, addr)
-						if err != nil {
-							h.Errorf("error determining if peer '%v' is a member of private state URI '%v': %v", addr, tx.StateURI, err)
-							return
-						}
-						if isAllowed {
-							break
-						}
+			subState := state.NodeAt(keypath, nil)
+
+			// In-process subscriptions are trusted
+			peer, isPeer := peerForSubscription(writeSub)
+			if !isPeer {
+				if wantsTxs {
+					for _, tx := range earlierTxs {
+						writeSub.EnqueueWrite(tx, nil, nil, leaves)
 					}
-				} else {
-					// In-process subscriptions are trusted
-					isAllowed = true
 				}
+				stateToSend, diffToSend, err := h.stateOrDiffForSubscriber(writeSub, diff, keypath, subState, subState)
+				if err != nil {
+					h.Errorf("error computing state diff for subscriber: %v", err)
+					return
+				}
+				writeSub.EnqueueWrite(lastTx, stateToSend, diffToSend, leaves)
+				return
+			}
+
+			isAllowed, err := h.peerIsAllowedToRead(lastTx.StateURI, peer)
+			if err != nil {
+				h.Errorf("error determining if peer '%v' can read state URI '%v': %v", peer.Addresses(), lastTx.StateURI, err)
+				return
+			} else if !isAllowed {
+				return
+			}
+
+			// Only send the parts of the txs and the state that the peer is allowed to read
+			readableTx := func(tx *Tx) (*Tx, error) {
+				if !wantsTxs {
+					return nil, nil
+				}
+				canRead, err := h.txIsReadable(tx, peer.Addresses())
+				if err != nil {
+					return nil, errors.Wrapf(err, "error determining if peer '%v' can read tx %v", peer.Addresses(), tx.ID.Pretty())
+				} else if !canRead {
+					return nil, nil
+				}
+				return tx, nil
+			}
 
-				if isAllowed {
-					writeSub.EnqueueWrite(tx, state, leaves)
+			for _, tx := range earlierTxs {
+				if h.txSeenByPeer(peer, tx.StateURI, tx.ID) {
+					continue
 				}
+				txToSend, err := readableTx(tx)
+				if err != nil {
+					h.Errorf("%v", err)
+					return
+				} else if txToSend != nil {
+					writeSub.EnqueueWrite(txToSend, nil, nil, leaves)
+				}
+			}
 
-			} else {
-				writeSub.EnqueueWrite(tx, state, leaves)
+			txToSend, err := readableTx(lastTx)
+			if err != nil {
+				h.Errorf("%v", err)
+				return
 			}
+
+			var stateToSend tree.Node
+			var diffToSend *StateDiff
+			if wantsStates {
+				readableState, err := h.controllerHub.ReadableState(lastTx.StateURI, subState, keypath, peer.Addresses())
+				if errors.Cause(err) == types.Err403 {
+					readableState = nil
+				} else if err != nil {
+					h.Errorf("error pruning state for peer '%v': %v", peer.Addresses(), err)
+					return
+				}
+
+				if readableState != nil {
+					stateToSend, diffToSend, err = h.stateOrDiffForSubscriber(writeSub, diff, keypath, subState, readableState)
+					if err != nil {
+						h.Errorf("error computing state diff for peer '%v': %v", peer.Addresses(), err)
+						return
+					}
+				}
+			}
+
+			if txToSend == nil && stateToSend == nil && diffToSend == nil {
+				return
+			}
+			writeSub.EnqueueWrite(txToSend, stateToSend, diffToSend, leaves)
 		}()
 	}
 }
 
+// allTxsSeenByPeer returns true if the peer has already seen every one of the
+// given txs.
+func (h *host) allTxsSeenByPeer(peer Peer, txs []*Tx) bool {
+	for _, tx := range txs {
+		if !h.txSeenByPeer(peer, tx.StateURI, tx.ID) {
+			return false
+		}
+	}
+	return true
+}
+
 func (h *host) SendTx(ctx context.Context, tx Tx) (err error) {
 	h.Infof(0, "adding tx (%v) %v", tx.StateURI, tx.ID.Pretty())
 
@@ -1057,6 +1684,21 @@ func (h *host) SendTx(ctx context.Context, tx Tx) (err error) {
 		}
 	}()
 
+	err = h.fillTx(&tx)
+	if err != nil {
+		return err
+	}
+
+	err = h.controllerHub.AddTx(&tx, false)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// fillTx sets any of the tx's sender, parents, and signature that are
+// missing, using this node's first public identity and the current leaves.
+func (h *host) fillTx(tx *Tx) (err error) {
 	if tx.From.IsZero() {
 		publicIdentities, err := h.keyStore.PublicIdentities()
 		if err != nil {
@@ -1082,12 +1724,48 @@ func (h *host) SendTx(ctx context.Context, tx Tx) (err error) {
 			return err
 		}
 	}
+	return nil
+}
 
-	err = h.controllerHub.AddTx(&tx, false)
+// CompactHistory replaces the history of a state URI up to and including the
+// given tx with a snapshot tx containing the full state at that tx, signed by
+// this node's first public identity.  The pruned txs are removed from the tx
+// store, and peers that ask for history from before the snapshot are sent the
+// snapshot instead.  New peers only accept the snapshot if that identity is
+// one of the SnapshotSigners in their config for the state URI.
+func (h *host) CompactHistory(stateURI string, txID types.ID) (err error) {
+	defer utils.Annotate(&err, "CompactHistory(%v, %v)", stateURI, txID.Pretty())
+
+	state, err := h.controllerHub.StateAtVersion(stateURI, &txID)
 	if err != nil {
 		return err
 	}
-	return nil
+	defer state.Close()
+
+	val, _, err := state.Value(nil, nil)
+	if err != nil {
+		return err
+	}
+
+	publicIdentities, err := h.keyStore.PublicIdentities()
+	if err != nil {
+		return err
+	} else if len(publicIdentities) == 0 {
+		return errors.New("keystore has no public identities")
+	}
+
+	snapshot := Tx{
+		ID:         txID,
+		From:       publicIdentities[0].Address(),
+		StateURI:   stateURI,
+		Patches:    []Patch{{Val: val}},
+		Checkpoint: true,
+	}
+	snapshot.Sig, err = h.keyStore.SignHash(snapshot.From, snapshot.Hash())
+	if err != nil {
+		return err
+	}
+	return h.controllerHub.Compact(stateURI, &snapshot)
 }
 
 func (h *host) AddRef(reader io.ReadCloser) (types.Hash, types.Hash, error) {
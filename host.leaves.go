@@ -0,0 +1,137 @@
+package redwood
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"redwood.dev/types"
+)
+
+var (
+	// How often the host checks whether any state URI's leaves need merging
+	leafConsolidationInterval = 5 * time.Second
+	// Each peer waits for a pseudo-random delay of up to this long after a
+	// state URI's leaves need merging before it sends a merge tx.  Usually
+	// only the peer with the shortest delay sends one; the others see the
+	// leaves collapse and stand down.
+	leafConsolidationBackoff = 30 * time.Second
+)
+
+func (h *host) periodicallyConsolidateLeaves() {
+	tick := time.NewTicker(leafConsolidationInterval)
+	defer tick.Stop()
+
+	// map[stateURI]time that it first had more than one leaf
+	multipleLeavesSince := make(map[string]time.Time)
+	// map[stateURI]time that its leaves first needed merging
+	mergeNeededSince := make(map[string]time.Time)
+
+	for {
+		select {
+		case <-h.chStop:
+			return
+
+		case <-tick.C:
+			for _, stateURI := range h.config.Node.SubscribedStateURIs.Slice() {
+				config := h.config.Node.States.ForStateURI(stateURI)
+				if config.MaxLeaves == 0 && config.MaxLeafAge == 0 {
+					continue
+				}
+
+				leaves, err := h.controllerHub.Leaves(stateURI)
+				if errors.Cause(err) == ErrNoController {
+					continue
+				} else if err != nil {
+					h.Errorf("error fetching leaves: %v", err)
+					continue
+				} else if len(leaves) < 2 {
+					delete(multipleLeavesSince, stateURI)
+					delete(mergeNeededSince, stateURI)
+					continue
+				}
+
+				now := time.Now()
+				if _, exists := multipleLeavesSince[stateURI]; !exists {
+					multipleLeavesSince[stateURI] = now
+				}
+
+				tooMany := config.MaxLeaves > 0 && uint64(len(leaves)) > config.MaxLeaves
+				tooOld := config.MaxLeafAge > 0 && now.Sub(multipleLeavesSince[stateURI]) >= time.Duration(config.MaxLeafAge)
+				if !tooMany && !tooOld {
+					continue
+				}
+				if _, exists := mergeNeededSince[stateURI]; !exists {
+					mergeNeededSince[stateURI] = now
+				}
+
+				backoff, err := h.leafConsolidationBackoff(leaves)
+				if err != nil {
+					h.Errorf("error merging leaves of %v: %v", stateURI, err)
+					continue
+				} else if now.Sub(mergeNeededSince[stateURI]) < backoff {
+					continue
+				}
+
+				err = h.mergeLeaves(stateURI, leaves)
+				if err != nil {
+					h.Errorf("error merging leaves of %v: %v", stateURI, err)
+					continue
+				}
+				delete(multipleLeavesSince, stateURI)
+				delete(mergeNeededSince, stateURI)
+			}
+		}
+	}
+}
+
+// leafConsolidationBackoff returns how long this node waits before merging
+// the given leaves.  It's derived from the node's address and the leaves, so
+// every peer computes a different delay for the same set of leaves, and the
+// order in which peers volunteer changes from one merge to the next.
+func (h *host) leafConsolidationBackoff(leaves []types.ID) (time.Duration, error) {
+	publicIdentities, err := h.keyStore.PublicIdentities()
+	if err != nil {
+		return 0, err
+	} else if len(publicIdentities) == 0 {
+		return 0, errors.New("keystore has no public identities")
+	}
+	addr := publicIdentities[0].Address()
+
+	sorted := append([]types.ID(nil), leaves...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i][:], sorted[j][:]) < 0 })
+
+	bs := append([]byte(nil), addr[:]...)
+	for _, leaf := range sorted {
+		bs = append(bs, leaf[:]...)
+	}
+	hash := types.HashBytes(bs)
+	n := binary.BigEndian.Uint64(hash[:8])
+	return time.Duration(n % uint64(leafConsolidationBackoff)), nil
+}
+
+// mergeLeaves sends a tx with no patches whose parents are the given leaves.
+func (h *host) mergeLeaves(stateURI string, leaves []types.ID) error {
+	tx := Tx{
+		ID:       types.RandomID(),
+		Parents:  leaves,
+		StateURI: stateURI,
+	}
+
+	isPrivate, err := h.controllerHub.IsPrivate(stateURI)
+	if err != nil {
+		return err
+	} else if isPrivate {
+		tx.Recipients, err = h.controllerHub.Members(stateURI)
+		if err != nil {
+			return err
+		}
+	}
+
+	h.Infof(0, "merging %v leaves of %v", len(leaves), stateURI)
+	return h.SendTx(context.Background(), tx)
+}
@@ -0,0 +1,194 @@
+package redwood
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"redwood.dev/identity"
+	"redwood.dev/testutils"
+	"redwood.dev/types"
+	"redwood.dev/utils"
+)
+
+// leavesControllerHub is a ControllerHub with a fixed set of leaves for a
+// single state URI.  Each tx added to it becomes the only leaf.
+type leavesControllerHub struct {
+	ControllerHub
+	mu     sync.Mutex
+	leaves []types.ID
+	added  []*Tx
+}
+
+func (hub *leavesControllerHub) Leaves(stateURI string) ([]types.ID, error) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	return append([]types.ID(nil), hub.leaves...), nil
+}
+
+func (hub *leavesControllerHub) IsPrivate(stateURI string) (bool, error) {
+	return false, nil
+}
+
+func (hub *leavesControllerHub) AddTx(tx *Tx, force bool) error {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	hub.added = append(hub.added, tx)
+	hub.leaves = []types.ID{tx.ID}
+	return nil
+}
+
+func (hub *leavesControllerHub) setLeaves(leaves ...types.ID) {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	hub.leaves = leaves
+}
+
+func (hub *leavesControllerHub) addedTxs() []*Tx {
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	return append([]*Tx(nil), hub.added...)
+}
+
+func setupLeavesTestHost(t *testing.T, stateConfig StateConfig) (*host, *leavesControllerHub) {
+	t.Helper()
+
+	db := testutils.SetupDBTree(t)
+	t.Cleanup(func() { db.DeleteDB() })
+
+	keyStore := identity.NewBadgerKeyStore(db, identity.FastScryptParams)
+	err := keyStore.Unlock("password")
+	require.NoError(t, err)
+
+	config := &Config{Node: &NodeConfig{
+		SubscribedStateURIs: utils.NewStringSet([]string{"foo.bar/blah"}),
+		States:              StatesConfig{Default: stateConfig},
+	}}
+
+	hub := &leavesControllerHub{}
+	h, err := NewHost(nil, hub, keyStore, nil, nil, config)
+	require.NoError(t, err)
+	return h.(*host), hub
+}
+
+// withFastLeafConsolidation shortens the host's leaf consolidation timings for
+// the duration of a test.
+func withFastLeafConsolidation(t *testing.T) {
+	t.Helper()
+
+	interval, backoff := leafConsolidationInterval, leafConsolidationBackoff
+	leafConsolidationInterval, leafConsolidationBackoff = 10*time.Millisecond, 50*time.Millisecond
+	t.Cleanup(func() {
+		leafConsolidationInterval, leafConsolidationBackoff = interval, backoff
+	})
+}
+
+// runLeafConsolidation runs the host's leaf consolidation loop until the test
+// is over.
+func runLeafConsolidation(t *testing.T, h *host) {
+	t.Helper()
+
+	chDone := make(chan struct{})
+	go func() {
+		defer close(chDone)
+		h.periodicallyConsolidateLeaves()
+	}()
+	t.Cleanup(func() {
+		close(h.chStop)
+		<-chDone
+	})
+}
+
+func TestHost_LeafConsolidationBackoff(t *testing.T) {
+	h1, _ := setupLeavesTestHost(t, StateConfig{})
+	h2, _ := setupLeavesTestHost(t, StateConfig{})
+
+	leaves := []types.ID{types.RandomID(), types.RandomID(), types.RandomID()}
+
+	backoff, err := h1.leafConsolidationBackoff(leaves)
+	require.NoError(t, err)
+	require.True(t, backoff >= 0 && backoff < leafConsolidationBackoff)
+
+	// The order of the leaves doesn't matter
+	reordered, err := h1.leafConsolidationBackoff([]types.ID{leaves[2], leaves[0], leaves[1]})
+	require.NoError(t, err)
+	require.Equal(t, backoff, reordered)
+
+	// Another node waits a different amount of time for the same leaves
+	other, err := h2.leafConsolidationBackoff(leaves)
+	require.NoError(t, err)
+	require.NotEqual(t, backoff, other)
+
+	// And so does this one once the leaves change
+	changed, err := h1.leafConsolidationBackoff(append(leaves, types.RandomID()))
+	require.NoError(t, err)
+	require.NotEqual(t, backoff, changed)
+}
+
+func TestHost_PeriodicallyConsolidateLeaves(t *testing.T) {
+	t.Run("does nothing when neither threshold is set", func(t *testing.T) {
+		withFastLeafConsolidation(t)
+		h, hub := setupLeavesTestHost(t, StateConfig{})
+		hub.setLeaves(types.RandomID(), types.RandomID(), types.RandomID())
+
+		runLeafConsolidation(t, h)
+
+		require.Never(t, func() bool { return len(hub.addedTxs()) > 0 }, 200*time.Millisecond, 10*time.Millisecond)
+	})
+
+	t.Run("merges once there are more than MaxLeaves leaves", func(t *testing.T) {
+		withFastLeafConsolidation(t)
+		h, hub := setupLeavesTestHost(t, StateConfig{MaxLeaves: 2})
+		hub.setLeaves(types.RandomID(), types.RandomID())
+
+		runLeafConsolidation(t, h)
+
+		require.Never(t, func() bool { return len(hub.addedTxs()) > 0 }, 200*time.Millisecond, 10*time.Millisecond)
+
+		leaves := []types.ID{types.RandomID(), types.RandomID(), types.RandomID()}
+		hub.setLeaves(leaves...)
+
+		require.Eventually(t, func() bool { return len(hub.addedTxs()) > 0 }, 5*time.Second, 10*time.Millisecond)
+		merge := hub.addedTxs()[0]
+		require.ElementsMatch(t, leaves, merge.Parents)
+		require.Empty(t, merge.Patches)
+		require.NotEmpty(t, merge.Sig)
+
+		// The merge tx collapses the leaves, so there's nothing more to do
+		require.Never(t, func() bool { return len(hub.addedTxs()) > 1 }, 200*time.Millisecond, 10*time.Millisecond)
+	})
+
+	t.Run("merges once there have been multiple leaves for MaxLeafAge", func(t *testing.T) {
+		withFastLeafConsolidation(t)
+		h, hub := setupLeavesTestHost(t, StateConfig{MaxLeafAge: Duration(300 * time.Millisecond)})
+		leaves := []types.ID{types.RandomID(), types.RandomID()}
+		hub.setLeaves(leaves...)
+
+		start := time.Now()
+		runLeafConsolidation(t, h)
+
+		require.Eventually(t, func() bool { return len(hub.addedTxs()) > 0 }, 5*time.Second, 10*time.Millisecond)
+		require.True(t, time.Since(start) >= 300*time.Millisecond)
+		require.ElementsMatch(t, leaves, hub.addedTxs()[0].Parents)
+	})
+
+	t.Run("waits out the backoff before merging", func(t *testing.T) {
+		withFastLeafConsolidation(t)
+		leafConsolidationBackoff = time.Hour
+		h, hub := setupLeavesTestHost(t, StateConfig{MaxLeaves: 1})
+		leaves := []types.ID{types.RandomID(), types.RandomID()}
+		hub.setLeaves(leaves...)
+
+		backoff, err := h.leafConsolidationBackoff(leaves)
+		require.NoError(t, err)
+		if backoff < time.Second {
+			t.Skip("backoff happens to be too short to observe")
+		}
+
+		runLeafConsolidation(t, h)
+
+		require.Never(t, func() bool { return len(hub.addedTxs()) > 0 }, 200*time.Millisecond, 10*time.Millisecond)
+	})
+}
@@ -0,0 +1,104 @@
+package redwood
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+
+	"redwood.dev/crypto"
+	"redwood.dev/types"
+	"redwood.dev/utils"
+)
+
+// ProposeTx signs a tx that needs co-signatures (see validator/multisig) and
+// holds onto it until SendPendingTx is called.  The returned tx has its
+// sender, parents, and signature filled in, so its hash won't change as
+// co-signatures are collected.  Co-signers sign that hash and hand the
+// signature to CoSignTx.
+func (h *host) ProposeTx(tx Tx) (_ Tx, err error) {
+	defer utils.Annotate(&err, "ProposeTx")
+
+	if tx.ID == (types.ID{}) {
+		tx.ID = types.RandomID()
+	}
+
+	err = h.fillTx(&tx)
+	if err != nil {
+		return Tx{}, err
+	}
+
+	h.pendingTxsMu.Lock()
+	defer h.pendingTxsMu.Unlock()
+
+	if _, exists := h.pendingTxs[tx.StateURI]; !exists {
+		h.pendingTxs[tx.StateURI] = make(map[types.ID]*Tx)
+	} else if _, exists := h.pendingTxs[tx.StateURI][tx.ID]; exists {
+		return Tx{}, errors.Errorf("tx %v is already pending", tx.ID.Pretty())
+	}
+	h.pendingTxs[tx.StateURI][tx.ID] = &tx
+	return *tx.Copy(), nil
+}
+
+// PendingTx returns a tx passed to ProposeTx, with the co-signatures that
+// have been collected so far.
+func (h *host) PendingTx(stateURI string, txID types.ID) (Tx, error) {
+	h.pendingTxsMu.Lock()
+	defer h.pendingTxsMu.Unlock()
+
+	tx, exists := h.pendingTxs[stateURI][txID]
+	if !exists {
+		return Tx{}, errors.Wrapf(types.Err404, "no pending tx %v", txID.Pretty())
+	}
+	return *tx.Copy(), nil
+}
+
+// CoSignTx adds a co-signature to a pending tx.  A second signature from the
+// same address replaces the first.
+func (h *host) CoSignTx(stateURI string, txID types.ID, sig types.Signature) (_ Tx, err error) {
+	defer utils.Annotate(&err, "CoSignTx(%v, %v)", stateURI, txID.Pretty())
+
+	h.pendingTxsMu.Lock()
+	defer h.pendingTxsMu.Unlock()
+
+	tx, exists := h.pendingTxs[stateURI][txID]
+	if !exists {
+		return Tx{}, errors.Wrapf(types.Err404, "no pending tx %v", txID.Pretty())
+	}
+
+	hash := tx.Hash()
+	pubkey, err := crypto.RecoverSigningPubkey(hash, sig)
+	if err != nil {
+		return Tx{}, err
+	} else if pubkey.Address() == tx.From {
+		return Tx{}, errors.New("sender can't co-sign their own tx")
+	}
+
+	for i, existing := range tx.CoSigs {
+		existingPubkey, err := crypto.RecoverSigningPubkey(hash, existing)
+		if err == nil && existingPubkey.Address() == pubkey.Address() {
+			tx.CoSigs[i] = sig.Copy()
+			return *tx.Copy(), nil
+		}
+	}
+	tx.CoSigs = append(tx.CoSigs, sig.Copy())
+	return *tx.Copy(), nil
+}
+
+// SendPendingTx stops holding a pending tx and sends it along with its
+// co-signatures.
+func (h *host) SendPendingTx(ctx context.Context, stateURI string, txID types.ID) error {
+	h.pendingTxsMu.Lock()
+	tx, exists := h.pendingTxs[stateURI][txID]
+	if exists {
+		delete(h.pendingTxs[stateURI], txID)
+		if len(h.pendingTxs[stateURI]) == 0 {
+			delete(h.pendingTxs, stateURI)
+		}
+	}
+	h.pendingTxsMu.Unlock()
+
+	if !exists {
+		return errors.Wrapf(types.Err404, "no pending tx %v", txID.Pretty())
+	}
+	return h.SendTx(ctx, *tx)
+}
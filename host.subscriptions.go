@@ -1,8 +1,10 @@
 package redwood
 
 import (
+	"bytes"
 	"context"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -24,7 +26,7 @@ type (
 		StateURI() string
 		Type() SubscriptionType
 		Keypath() tree.Keypath
-		EnqueueWrite(tx *Tx, state tree.Node, leaves []types.ID)
+		EnqueueWrite(tx *Tx, state tree.Node, diff *StateDiff, leaves []types.ID)
 		Close() error
 	}
 
@@ -32,16 +34,40 @@ type (
 		Tx          *Tx          `json:"tx,omitempty"`
 		EncryptedTx *EncryptedTx `json:"encryptedTx,omitempty"`
 		State       tree.Node    `json:"state,omitempty"`
+		Diff        *StateDiff   `json:"diff,omitempty"`
 		Leaves      []types.ID   `json:"leaves,omitempty"`
 		Error       error        `json:"error,omitempty"`
 	}
 
+	// StateDiff accompanies every state or diff sent to a state diff
+	// subscriber.  Seq numbers them, starting at 1, so that the subscriber can
+	// tell whether it missed one.  When the message carries a State, there are
+	// no Patches and the State replaces the subscriber's copy.  Otherwise, the
+	// Patches apply on top of the state as of the message with Seq-1.  A
+	// subscriber that finds a gap should resubscribe, which starts over with
+	// the full state.
+	StateDiff struct {
+		Seq     uint64           `json:"seq"`
+		Patches []StateDiffPatch `json:"patches,omitempty"`
+	}
+
+	// StateDiffPatch is a single change in a StateDiff.  Deleted tells a
+	// removed subtree apart from one that was set to null.
+	StateDiffPatch struct {
+		Patch   Patch `json:"patch"`
+		Deleted bool  `json:"deleted,omitempty"`
+	}
+
 	SubscriptionType uint8
 )
 
 const (
 	SubscriptionType_Txs SubscriptionType = 1 << iota
 	SubscriptionType_States
+	// Like SubscriptionType_States, but after the first message (and each
+	// periodic resync), each message only carries the changes to the
+	// subscribed subtree, as a list of patches (see ApplyStateDiff).
+	SubscriptionType_StateDiffs
 )
 
 func (t *SubscriptionType) UnmarshalText(bs []byte) error {
@@ -54,6 +80,8 @@ func (t *SubscriptionType) UnmarshalText(bs []byte) error {
 			st |= SubscriptionType_Txs
 		case "states":
 			st |= SubscriptionType_States
+		case "state-diffs":
+			st |= SubscriptionType_StateDiffs
 		default:
 			return errors.Errorf("bad value for SubscriptionType: %v", str)
 		}
@@ -77,6 +105,9 @@ func (t SubscriptionType) String() string {
 	if t.Includes(SubscriptionType_States) {
 		strs = append(strs, "states")
 	}
+	if t.Includes(SubscriptionType_StateDiffs) {
+		strs = append(strs, "state-diffs")
+	}
 	return strings.Join(strs, ",")
 }
 
@@ -84,6 +115,142 @@ func (t SubscriptionType) Includes(x SubscriptionType) bool {
 	return t&x == x
 }
 
+const (
+	// State diff subscribers are sent the full state again after this many
+	// diffs, or after this much time, whichever comes first, so that they can
+	// recover from any messages that they missed.  @@TODO: make configurable
+	stateDiffResyncEvery    = 100
+	stateDiffResyncInterval = 1 * time.Minute
+)
+
+type stateDiffResync struct {
+	seq              uint64
+	diffsSinceResync uint64
+	lastResync       time.Time
+}
+
+// nextStateDiffSeq numbers the next state or diff sent to a state diff
+// subscriber, and decides whether it should be the full state, either because
+// the caller asked for it or because a periodic resync is due.
+func (h *host) nextStateDiffSeq(writeSub WritableSubscription, fullState bool) (seq uint64, resync bool) {
+	h.stateDiffResyncsMu.Lock()
+	defer h.stateDiffResyncsMu.Unlock()
+
+	r, exists := h.stateDiffResyncs[writeSub]
+	if !exists {
+		r = &stateDiffResync{}
+		h.stateDiffResyncs[writeSub] = r
+		fullState = true
+	}
+	r.seq++
+	r.diffsSinceResync++
+
+	if fullState || r.diffsSinceResync >= stateDiffResyncEvery || time.Since(r.lastResync) >= stateDiffResyncInterval {
+		r.diffsSinceResync = 0
+		r.lastResync = time.Now()
+		return r.seq, true
+	}
+	return r.seq, false
+}
+
+// stateOrDiffForSubscriber decides what a subscriber that wants states should
+// be sent after a tx: either the (readable) state at the subscribed keypath,
+// or just the changes to it.  state is the full state at the keypath, and
+// readable is the part of it that the subscriber may see.  Callers must
+// enqueue the result before computing the next one for the same subscriber
+// (see processSubscriberBroadcasts), so that the diffs arrive in order.
+func (h *host) stateOrDiffForSubscriber(
+	writeSub WritableSubscription,
+	diff *tree.Diff,
+	keypath tree.Keypath,
+	state tree.Node,
+	readable tree.Node,
+) (tree.Node, *StateDiff, error) {
+	if !writeSub.Type().Includes(SubscriptionType_StateDiffs) {
+		return readable, nil, nil
+	}
+
+	seq, resync := h.nextStateDiffSeq(writeSub, false)
+	if resync {
+		return readable, &StateDiff{Seq: seq}, nil
+	}
+
+	patches, err := stateDiffPatches(diff, keypath, state, readable)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nil, &StateDiff{Seq: seq, Patches: patches}, nil
+}
+
+// stateDiffPatches turns the keypaths changed by a tx into patches relative
+// to the subscribed keypath.  Each changed subtree is sent once, in full, at
+// its topmost changed keypath.  Subtrees that no longer exist are sent as
+// deletions, and subtrees that exist but aren't in readable are left out.
+func stateDiffPatches(diff *tree.Diff, keypath tree.Keypath, state tree.Node, readable tree.Node) ([]StateDiffPatch, error) {
+	var changed []tree.Keypath
+	for _, kp := range append(append([]tree.Keypath(nil), diff.AddedList...), diff.RemovedList...) {
+		if kp.StartsWith(keypath) {
+			changed = append(changed, kp.RelativeTo(keypath).Copy())
+		} else if keypath.StartsWith(kp) {
+			// An ancestor of the subscribed keypath changed
+			changed = append(changed, nil)
+		}
+	}
+	// Ancestors sort before their descendants, but not necessarily right
+	// before them ("a" < "a-x" < "a/b"), so each keypath is checked against
+	// every topmost keypath found so far
+	sort.Slice(changed, func(i, j int) bool { return bytes.Compare(changed[i], changed[j]) < 0 })
+
+	var topmost []tree.Keypath
+	var patches []StateDiffPatch
+ChangedLoop:
+	for _, kp := range changed {
+		for _, ancestor := range topmost {
+			if kp.StartsWith(ancestor) {
+				continue ChangedLoop
+			}
+		}
+		topmost = append(topmost, kp)
+
+		exists, err := state.Exists(kp)
+		if err != nil {
+			return nil, err
+		} else if !exists {
+			patches = append(patches, StateDiffPatch{Patch: Patch{Keypath: kp.Copy()}, Deleted: true})
+			continue
+		}
+
+		val, exists, err := readable.Value(kp, nil)
+		if err != nil {
+			return nil, err
+		} else if !exists {
+			continue
+		}
+		patches = append(patches, StateDiffPatch{Patch: Patch{Keypath: kp.Copy(), Val: val}})
+	}
+	return patches, nil
+}
+
+// ApplyStateDiff applies the patches in a state diff subscription message to
+// the subscriber's copy of the subscribed subtree.
+func ApplyStateDiff(state tree.Node, diff []StateDiffPatch) error {
+	for _, patch := range diff {
+		var err error
+		if patch.Deleted {
+			err = state.Delete(patch.Patch.Keypath, patch.Patch.Range)
+			if errors.Cause(err) == types.Err404 {
+				err = nil
+			}
+		} else {
+			err = state.Set(patch.Patch.Keypath, patch.Patch.Range, patch.Patch.Val)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type writableSubscription struct {
 	stateURI         string
 	keypath          tree.Keypath
@@ -99,7 +266,7 @@ type writableSubscription struct {
 
 type WritableSubscriptionImpl interface {
 	Transport() Transport
-	Put(ctx context.Context, tx *Tx, state tree.Node, leaves []types.ID) error
+	Put(ctx context.Context, tx *Tx, state tree.Node, diff *StateDiff, leaves []types.ID) error
 	UpdateConnStats(ok bool)
 	Close() error
 }
@@ -163,13 +330,17 @@ func (sub *writableSubscription) writeMessages() {
 		msg := x.(*SubscriptionMsg)
 		var tx *Tx
 		var state tree.Node
+		var diff *StateDiff
 		if sub.subscriptionType.Includes(SubscriptionType_Txs) {
 			tx = msg.Tx
 		}
-		if sub.subscriptionType.Includes(SubscriptionType_States) {
+		if sub.subscriptionType.Includes(SubscriptionType_States) || sub.subscriptionType.Includes(SubscriptionType_StateDiffs) {
 			state = msg.State
 		}
-		err = sub.subImpl.Put(context.TODO(), tx, state, msg.Leaves)
+		if sub.subscriptionType.Includes(SubscriptionType_StateDiffs) {
+			diff = msg.Diff
+		}
+		err = sub.subImpl.Put(context.TODO(), tx, state, diff, msg.Leaves)
 		if err != nil {
 			sub.host.Errorf("error writing to subscribed peer: %v", err)
 			return
@@ -188,12 +359,33 @@ func (sub *writableSubscription) destroy() {
 	}
 }
 
+// peerSubscriptionImpl is implemented by WritableSubscriptionImpls that write
+// to a remote peer.
+type peerSubscriptionImpl interface {
+	RemotePeer() Peer
+}
+
+// peerForSubscription returns the remote peer behind a writable subscription.
+// In-process subscriptions have no peer.
+func peerForSubscription(writeSub WritableSubscription) (Peer, bool) {
+	switch sub := writeSub.(type) {
+	case *writableSubscription:
+		if impl, isPeer := sub.subImpl.(peerSubscriptionImpl); isPeer {
+			return impl.RemotePeer(), true
+		}
+		return nil, false
+	case Peer:
+		return sub, true
+	}
+	return nil, false
+}
+
 func (sub *writableSubscription) StateURI() string       { return sub.stateURI }
 func (sub *writableSubscription) Type() SubscriptionType { return sub.subscriptionType }
 func (sub *writableSubscription) Keypath() tree.Keypath  { return sub.keypath }
 
-func (sub *writableSubscription) EnqueueWrite(tx *Tx, state tree.Node, leaves []types.ID) {
-	sub.messages.Deliver(&SubscriptionMsg{Tx: tx, State: state, Leaves: leaves})
+func (sub *writableSubscription) EnqueueWrite(tx *Tx, state tree.Node, diff *StateDiff, leaves []types.ID) {
+	sub.messages.Deliver(&SubscriptionMsg{Tx: tx, State: state, Diff: diff, Leaves: leaves})
 }
 
 func (sub *writableSubscription) Close() error {
@@ -269,8 +461,8 @@ func (sub *inProcessSubscription) Keypath() tree.Keypath {
 	return sub.keypath
 }
 
-func (sub *inProcessSubscription) EnqueueWrite(tx *Tx, state tree.Node, leaves []types.ID) {
-	sub.messages.Deliver(&SubscriptionMsg{Tx: tx, State: state, Leaves: leaves})
+func (sub *inProcessSubscription) EnqueueWrite(tx *Tx, state tree.Node, diff *StateDiff, leaves []types.ID) {
+	sub.messages.Deliver(&SubscriptionMsg{Tx: tx, State: state, Diff: diff, Leaves: leaves})
 }
 
 func (sub *inProcessSubscription) Read() (*SubscriptionMsg, error) {
@@ -362,6 +554,14 @@ func (s *multiReaderSubscription) Start() {
 				}
 				defer peerSub.Close()
 
+				// Catch up on anything we missed while we weren't subscribed
+				go func() {
+					err := s.host.SyncWithPeer(context.TODO(), s.stateURI, peer.DialInfo())
+					if err != nil {
+						s.host.Errorf("error syncing with %v peer (stateURI: %v): %v", peer.Transport().Name(), s.stateURI, err)
+					}
+				}()
+
 				for {
 					select {
 					case <-s.chStop:
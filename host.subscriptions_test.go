@@ -0,0 +1,86 @@
+package redwood
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"redwood.dev/tree"
+)
+
+func TestStateDiffPatches_RoundTrip(t *testing.T) {
+	state := tree.NewMemoryNode()
+	err := state.Set(nil, nil, map[string]interface{}{
+		"messages": map[string]interface{}{
+			"a":      map[string]interface{}{"x": float64(1)},
+			"b":      map[string]interface{}{"x": float64(2)},
+			"secret": map[string]interface{}{"x": float64(3)},
+		},
+		"other": float64(1),
+	})
+	require.NoError(t, err)
+	state.ResetDiff()
+
+	keypath := tree.Keypath("messages")
+	readableAt := func(state tree.Node) tree.Node {
+		node, err := state.CopyToMemory(keypath, nil)
+		require.NoError(t, err)
+		err = node.Delete(tree.Keypath("secret"), nil)
+		require.NoError(t, err)
+		return node
+	}
+
+	// The subscriber starts out with the readable state
+	subscriberState := readableAt(state)
+
+	require.NoError(t, state.Set(tree.Keypath("messages/a"), nil, map[string]interface{}{"y": float64(4)}))
+	require.NoError(t, state.Delete(tree.Keypath("messages/b"), nil))
+	require.NoError(t, state.Set(tree.Keypath("messages/c/z"), nil, float64(5)))
+	require.NoError(t, state.Set(tree.Keypath("messages/a-x"), nil, float64(7)))
+	require.NoError(t, state.Set(tree.Keypath("messages/n"), nil, nil))
+	require.NoError(t, state.Set(tree.Keypath("messages/secret/x"), nil, float64(6)))
+	require.NoError(t, state.Set(tree.Keypath("other"), nil, float64(2)))
+
+	fullState, err := state.CopyToMemory(keypath, nil)
+	require.NoError(t, err)
+	readable := readableAt(state)
+
+	patches, err := stateDiffPatches(state.Diff(), keypath, fullState, readable)
+	require.NoError(t, err)
+	for i, patch := range patches {
+		require.False(t, patch.Patch.Keypath.StartsWith(tree.Keypath("secret")), "diff leaked %v", patch.Patch.Keypath)
+
+		// Each changed subtree is only sent once, even when a sibling's key
+		// sorts between it and its children ("a" < "a-x" < "a/x")
+		for j, other := range patches {
+			if i != j {
+				require.False(t, patch.Patch.Keypath.StartsWith(other.Patch.Keypath), "%v is covered by %v", patch.Patch.Keypath, other.Patch.Keypath)
+			}
+		}
+	}
+
+	bs, err := json.Marshal(SubscriptionMsg{Diff: &StateDiff{Seq: 2, Patches: patches}})
+	require.NoError(t, err)
+	var msg SubscriptionMsg
+	err = json.Unmarshal(bs, &msg)
+	require.NoError(t, err)
+
+	require.Equal(t, uint64(2), msg.Diff.Seq)
+	err = ApplyStateDiff(subscriberState, msg.Diff.Patches)
+	require.NoError(t, err)
+
+	expected, _, err := readable.Value(nil, nil)
+	require.NoError(t, err)
+	actual, _, err := subscriberState.Value(nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, expected, actual)
+
+	// Values set to null aren't mistaken for deletions
+	exists, err := subscriberState.Exists(tree.Keypath("n"))
+	require.NoError(t, err)
+	require.True(t, exists)
+	exists, err = subscriberState.Exists(tree.Keypath("b"))
+	require.NoError(t, err)
+	require.False(t, exists)
+}
@@ -83,5 +83,19 @@ func PutRequestFromTx(
 	if tx.Checkpoint {
 		req.Header.Set("Checkpoint", "true")
 	}
+	if len(tx.Capabilities) > 0 {
+		capabilities, err := json.Marshal(tx.Capabilities)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		req.Header.Set("Capabilities", string(capabilities))
+	}
+	if len(tx.CoSigs) > 0 {
+		var coSigStrs []string
+		for _, sig := range tx.CoSigs {
+			coSigStrs = append(coSigStrs, sig.Hex())
+		}
+		req.Header.Set("Co-Signatures", strings.Join(coSigStrs, ","))
+	}
 	return req, nil
 }
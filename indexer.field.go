@@ -1,37 +1,251 @@
 package redwood
 
 import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/pkg/errors"
 
 	"redwood.dev/tree"
 )
 
+// keypathIndexer indexes the children of a node by the values at one or more
+// keypaths inside of each child.  When more than one keypath is configured,
+// the index key is a compound key with one part per keypath, in order:
+//
+//	"Indices": {
+//	    "byChannel": {
+//	        "Content-Type": "indexer/keypath",
+//	        "keypaths": ["channel", {"keypath": "timestamp", "type": "timestamp"}]
+//	    }
+//	}
+//
+// Each part is encoded according to its type so that index keys sort
+// correctly in range scans:
+//   - "string" (the default) is stored as-is, except that '%' and '/' are
+//     escaped as "%25" and "%2F" so that a string can't be taken for more
+//     than one part of the key.
+//   - "number" is stored as 16 hex digits that sort in numeric order.
+//   - "timestamp" accepts RFC 3339 strings or numbers of milliseconds since
+//     the Unix epoch, and is stored as a fixed-width UTC RFC 3339 string.
+//
+// Children that are missing a value, or whose value doesn't match its type,
+// aren't indexed.
 type keypathIndexer struct {
-	keypathName tree.Keypath
+	fields []keypathIndexerField
+}
+
+type keypathIndexerField struct {
+	keypath   tree.Keypath
+	fieldType string
 }
 
+const (
+	keypathIndexerTypeString    = "string"
+	keypathIndexerTypeNumber    = "number"
+	keypathIndexerTypeTimestamp = "timestamp"
+
+	sortableTimestampFormat = "2006-01-02T15:04:05.000000000Z"
+)
+
+var indexKeyPartEscaper = strings.NewReplacer("%", "%25", string(tree.KeypathSeparator), "%2F")
+
+// Ensure keypathIndexer conforms to the Indexer and IndexKeyEncoder interfaces
+var _ Indexer = (*keypathIndexer)(nil)
+var _ IndexKeyEncoder = (*keypathIndexer)(nil)
+
 func NewKeypathIndexer(config tree.Node) (Indexer, error) {
 	keypathStr, exists, err := config.StringValue(tree.Keypath("keypath"))
 	if err != nil {
 		return nil, err
-	} else if !exists {
-		return nil, errors.New("keypath indexer needs a 'keypath' field in its config")
+	} else if exists {
+		fieldType, _, err := config.StringValue(tree.Keypath("type"))
+		if err != nil {
+			return nil, err
+		}
+		field, err := newKeypathIndexerField(keypathStr, fieldType)
+		if err != nil {
+			return nil, err
+		}
+		return &keypathIndexer{fields: []keypathIndexerField{field}}, nil
+	}
+
+	fieldConfigsVal, exists, err := config.Value(tree.Keypath("keypaths"), nil)
+	if err != nil {
+		return nil, err
+	}
+	fieldConfigs, is := fieldConfigsVal.([]interface{})
+	if !exists || !is || len(fieldConfigs) == 0 {
+		return nil, errors.New("keypath indexer needs a 'keypath' or 'keypaths' field in its config")
+	}
+
+	fields := make([]keypathIndexerField, len(fieldConfigs))
+	for i, fieldConfig := range fieldConfigs {
+		switch fieldConfig := fieldConfig.(type) {
+		case string:
+			fields[i], err = newKeypathIndexerField(fieldConfig, "")
+		case map[string]interface{}:
+			keypathStr, _ := fieldConfig["keypath"].(string)
+			fieldType, _ := fieldConfig["type"].(string)
+			fields[i], err = newKeypathIndexerField(keypathStr, fieldType)
+		default:
+			err = errors.Errorf("bad entry in 'keypaths' (expected a string or an object, got %T)", fieldConfig)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &keypathIndexer{fields: fields}, nil
+}
+
+func newKeypathIndexerField(keypathStr string, fieldType string) (keypathIndexerField, error) {
+	if keypathStr == "" {
+		return keypathIndexerField{}, errors.New("keypath indexer field is missing its 'keypath'")
+	}
+	switch fieldType {
+	case "":
+		fieldType = keypathIndexerTypeString
+	case keypathIndexerTypeString, keypathIndexerTypeNumber, keypathIndexerTypeTimestamp:
+	default:
+		return keypathIndexerField{}, errors.Errorf("keypath indexer field '%v' has unknown type '%v'", keypathStr, fieldType)
 	}
-	return &keypathIndexer{keypathName: tree.Keypath(keypathStr)}, nil
+	return keypathIndexerField{keypath: tree.Keypath(keypathStr), fieldType: fieldType}, nil
 }
 
 func (i *keypathIndexer) IndexNode(relKeypath tree.Keypath, node tree.Node) (tree.Keypath, tree.Node, error) {
-	val, exists, err := node.Value(i.keypathName, nil)
-	if err != nil {
-		return nil, nil, err
-	} else if !exists {
-		return nil, nil, nil
+	var indexKey tree.Keypath
+	for _, field := range i.fields {
+		val, exists, err := node.Value(field.keypath, nil)
+		if err != nil {
+			return nil, nil, err
+		} else if !exists {
+			return nil, nil, nil
+		}
+
+		part, ok := field.encode(val)
+		if !ok {
+			return nil, nil, nil
+		}
+		indexKey = indexKey.Push(part)
+	}
+	return indexKey, node, nil
+}
+
+// EncodeIndexKey converts the leading parts of a compound index key, as
+// written by a client (e.g. "general/2021-03-04T00:00:00Z"), into the form
+// that's stored in the index.  Clients escape each part the way that URL path
+// segments are escaped, so a part containing a '/' is written with "%2F".
+func (i *keypathIndexer) EncodeIndexKey(key tree.Keypath) (tree.Keypath, error) {
+	parts := key.PartStrings()
+	if len(parts) > len(i.fields) {
+		return nil, errors.Errorf("index key has %v parts, but the index only has %v", len(parts), len(i.fields))
+	}
+
+	var encoded tree.Keypath
+	for idx, part := range parts {
+		field := i.fields[idx]
+
+		part, err := url.PathUnescape(part)
+		if err != nil {
+			return nil, errors.Errorf("index key part '%v' is badly escaped", parts[idx])
+		}
+
+		var val interface{} = part
+		if field.fieldType == keypathIndexerTypeNumber {
+			f, err := strconv.ParseFloat(part, 64)
+			if err != nil {
+				return nil, errors.Errorf("index key part '%v' must be a number", part)
+			}
+			val = f
+		}
+
+		encodedPart, ok := field.encode(val)
+		if !ok {
+			return nil, errors.Errorf("index key part '%v' is not a valid %v", part, field.fieldType)
+		}
+		encoded = encoded.Push(encodedPart)
 	}
+	return encoded, nil
+}
+
+func (f keypathIndexerField) encode(val interface{}) (tree.Keypath, bool) {
+	switch f.fieldType {
+	case keypathIndexerTypeString:
+		s, is := val.(string)
+		if !is {
+			return nil, false
+		}
+		return tree.Keypath(indexKeyPartEscaper.Replace(s)), true
 
-	valStr, is := val.(string)
-	if !is {
-		return nil, nil, nil
+	case keypathIndexerTypeNumber:
+		n, is := numberValue(val)
+		if !is {
+			return nil, false
+		}
+		return encodeSortableFloat(n), true
+
+	case keypathIndexerTypeTimestamp:
+		var t time.Time
+		switch val := val.(type) {
+		case string:
+			var err error
+			t, err = time.Parse(time.RFC3339Nano, val)
+			if err != nil {
+				ms, err := strconv.ParseInt(val, 10, 64)
+				if err != nil {
+					return nil, false
+				}
+				t = time.Unix(0, ms*int64(time.Millisecond))
+			}
+		default:
+			ms, is := numberValue(val)
+			if !is {
+				return nil, false
+			}
+			t = time.Unix(0, int64(ms*float64(time.Millisecond)))
+		}
+		return tree.Keypath(t.UTC().Format(sortableTimestampFormat)), true
 	}
+	return nil, false
+}
 
-	return tree.Keypath(valStr), node, nil
+func numberValue(val interface{}) (float64, bool) {
+	switch val := val.(type) {
+	case float64:
+		return val, true
+	case float32:
+		return float64(val), true
+	case int:
+		return float64(val), true
+	case int64:
+		return float64(val), true
+	case int32:
+		return float64(val), true
+	case uint:
+		return float64(val), true
+	case uint64:
+		return float64(val), true
+	case uint32:
+		return float64(val), true
+	default:
+		return 0, false
+	}
+}
+
+// encodeSortableFloat encodes a float64 as hex digits whose lexicographic
+// order matches the numeric order of the floats.  Positive numbers get their
+// sign bit flipped so that they sort after negative numbers, and negative
+// numbers get all of their bits flipped so that larger magnitudes sort first.
+func encodeSortableFloat(f float64) tree.Keypath {
+	bits := math.Float64bits(f)
+	if bits&(1<<63) == 0 {
+		bits ^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	return tree.Keypath(fmt.Sprintf("%016x", bits))
 }
@@ -0,0 +1,105 @@
+package redwood
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"redwood.dev/tree"
+)
+
+func newTestKeypathIndexer(t *testing.T, config map[string]interface{}) *keypathIndexer {
+	t.Helper()
+
+	node := tree.NewMemoryNode()
+	err := node.Set(nil, nil, config)
+	require.NoError(t, err)
+	indexer, err := NewKeypathIndexer(node)
+	require.NoError(t, err)
+	return indexer.(*keypathIndexer)
+}
+
+func indexKeyOf(t *testing.T, indexer *keypathIndexer, child map[string]interface{}) tree.Keypath {
+	t.Helper()
+
+	node := tree.NewMemoryNode()
+	err := node.Set(nil, nil, child)
+	require.NoError(t, err)
+	key, _, err := indexer.IndexNode(tree.Keypath("child"), node)
+	require.NoError(t, err)
+	return key
+}
+
+func TestKeypathIndexer_CompoundKeys(t *testing.T) {
+	indexer := newTestKeypathIndexer(t, map[string]interface{}{
+		"keypaths": []interface{}{
+			"channel",
+			map[string]interface{}{"keypath": "timestamp", "type": "timestamp"},
+		},
+	})
+
+	// A string containing a separator stays a single part
+	key := indexKeyOf(t, indexer, map[string]interface{}{"channel": "a/b%c", "timestamp": 1000.0})
+	require.Equal(t, []string{"a%2Fb%25c", "1970-01-01T00:00:01.000000000Z"}, key.PartStrings())
+
+	// Clients write the same key escaped
+	encoded, err := indexer.EncodeIndexKey(tree.Keypath("a%2Fb%25c/1970-01-01T00:00:01Z"))
+	require.NoError(t, err)
+	require.Equal(t, key, encoded)
+
+	encoded, err = indexer.EncodeIndexKey(tree.Keypath("a%2Fb%25c"))
+	require.NoError(t, err)
+	require.Equal(t, tree.Keypath("a%2Fb%25c"), encoded)
+
+	_, err = indexer.EncodeIndexKey(tree.Keypath("a/1970-01-01T00:00:01Z/extra"))
+	require.Error(t, err)
+	_, err = indexer.EncodeIndexKey(tree.Keypath("a%2/1970-01-01T00:00:01Z"))
+	require.Error(t, err)
+	_, err = indexer.EncodeIndexKey(tree.Keypath("a/yesterday"))
+	require.Error(t, err)
+
+	// Children missing a value, or with a value of the wrong type, aren't indexed
+	require.Nil(t, indexKeyOf(t, indexer, map[string]interface{}{"channel": "a"}))
+	require.Nil(t, indexKeyOf(t, indexer, map[string]interface{}{"channel": 1.0, "timestamp": 1000.0}))
+}
+
+func TestKeypathIndexer_NumberKeys(t *testing.T) {
+	indexer := newTestKeypathIndexer(t, map[string]interface{}{"keypath": "n", "type": "number"})
+
+	numbers := []float64{-100, -1.5, -0.25, 0, 0.25, 2, 100, 1e10}
+	var keys []string
+	for _, n := range numbers {
+		key := indexKeyOf(t, indexer, map[string]interface{}{"n": n})
+		require.Len(t, key.PartStrings(), 1)
+		require.Len(t, string(key), 16)
+		keys = append(keys, string(key))
+	}
+	require.True(t, sort.StringsAreSorted(keys), "keys don't sort in numeric order: %v", keys)
+
+	encoded, err := indexer.EncodeIndexKey(tree.Keypath("-1.5"))
+	require.NoError(t, err)
+	require.Equal(t, keys[1], string(encoded))
+
+	_, err = indexer.EncodeIndexKey(tree.Keypath("one"))
+	require.Error(t, err)
+	require.Nil(t, indexKeyOf(t, indexer, map[string]interface{}{"n": "1"}))
+}
+
+func TestKeypathIndexer_TimestampKeys(t *testing.T) {
+	indexer := newTestKeypathIndexer(t, map[string]interface{}{"keypath": "t", "type": "timestamp"})
+
+	// RFC 3339 strings, and milliseconds as numbers or strings, are all
+	// stored as fixed-width UTC
+	expected := tree.Keypath("2021-03-04T03:06:07.500000000Z")
+	require.Equal(t, expected, indexKeyOf(t, indexer, map[string]interface{}{"t": "2021-03-04T05:06:07.5+02:00"}))
+	require.Equal(t, expected, indexKeyOf(t, indexer, map[string]interface{}{"t": 1614827167500.0}))
+	require.Equal(t, expected, indexKeyOf(t, indexer, map[string]interface{}{"t": "1614827167500"}))
+
+	// Fixed width means that they sort in time order
+	earlier := indexKeyOf(t, indexer, map[string]interface{}{"t": "2021-03-04T03:06:07Z"})
+	require.Less(t, string(earlier), string(expected))
+
+	require.Nil(t, indexKeyOf(t, indexer, map[string]interface{}{"t": "yesterday"}))
+	require.Nil(t, indexKeyOf(t, indexer, map[string]interface{}{"t": true}))
+}
@@ -0,0 +1,79 @@
+package redwood
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"redwood.dev/tree"
+	"redwood.dev/utils"
+)
+
+type wasmIndexer struct {
+	module *wasmModule
+}
+
+// Ensure wasmIndexer conforms to the Indexer interface
+var _ Indexer = (*wasmIndexer)(nil)
+
+// NewWASMIndexer loads an indexer from the WebAssembly module in the config's
+// 'src' param.  See wasmModule for the host ABI.  The module must export:
+//
+//	index_node(keypath_ptr, keypath_len i32) i64
+//	    Called once for each child of the indexed node, with the child's
+//	    keypath.  The child is readable via state_get.  Returns a packed
+//	    buffer containing the JSON array [indexKey, value], or 0 if the
+//	    child shouldn't be indexed.
+func NewWASMIndexer(config tree.Node) (_ Indexer, err error) {
+	defer utils.Annotate(&err, "NewWASMIndexer")
+
+	module, err := newWASMModule("indexer", config)
+	if err != nil {
+		return nil, err
+	}
+	if !module.hasExport("index_node") {
+		module.Close()
+		return nil, errors.New("wasm indexer must export an 'index_node' function")
+	}
+	return &wasmIndexer{module: module}, nil
+}
+
+func (i *wasmIndexer) IndexNode(relKeypath tree.Keypath, node tree.Node) (_ tree.Keypath, _ tree.Node, err error) {
+	defer utils.WithStack(&err)
+
+	exists, err := node.Exists(nil)
+	if err != nil {
+		return nil, nil, err
+	} else if !exists {
+		return nil, nil, nil
+	}
+
+	packed, err := i.module.call("index_node", node, true, []byte(relKeypath))
+	if err != nil {
+		return nil, nil, err
+	}
+	bs, err := i.module.readPacked(packed)
+	if err != nil {
+		return nil, nil, err
+	} else if len(bs) == 0 {
+		return nil, nil, nil
+	}
+
+	var output [2]interface{}
+	err = json.Unmarshal(bs, &output)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	indexKey, ok := output[0].(string)
+	if !ok {
+		return nil, nil, errors.New("index key must be a string")
+	}
+
+	nodeToIndex := tree.NewMemoryNode()
+	err = nodeToIndex.Set(nil, nil, output[1])
+	if err != nil {
+		return nil, nil, err
+	}
+	return tree.Keypath(indexKey), nodeToIndex, nil
+}
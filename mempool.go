@@ -1,7 +1,9 @@
 package redwood
 
 import (
+	"sort"
 	"sync"
+	"time"
 
 	"redwood.dev/ctx"
 	"redwood.dev/types"
@@ -12,52 +14,131 @@ type Mempool interface {
 	Start() error
 	Close()
 	Add(tx *Tx)
+	AddMany(txs []*Tx)
 	Get() *txSortedSet
 	ForceReprocess()
+	RefsSaved(refs []types.RefID)
 }
 
+// mempool holds txs until they can be applied.  Txs that can't be applied yet
+// are parked along with the parents and refs that they're waiting on, and are
+// only retried once one of those has been resolved, rather than on every
+// pass over the mempool.
 type mempool struct {
 	ctx.Logger
 	chStop chan struct{}
 	chDone chan struct{}
 
-	sync.RWMutex
-	txs   *txSortedSet
-	chAdd chan *Tx
+	txs *txSortedSet
+
+	// These are only touched by the mempool's goroutine
+	addedAt          map[types.Hash]time.Time
+	ready            []*Tx
+	blocked          map[types.Hash]blockedTx
+	waitingOnTx      map[types.ID]map[types.Hash]struct{}
+	waitingOnRef     map[types.RefID]map[types.Hash]struct{}
+	waitingOnUnknown map[types.Hash]struct{}
+
+	maxTxs uint64
+	txTTL  time.Duration
 
 	processMempoolWorkQueue *utils.Mailbox
-	processCallback         func(tx *Tx) processTxOutcome
+	processCallback         func(tx *Tx) (processTxOutcome, txDeps)
+	processBatchCallback    func(txs []*Tx) int
+	evictCallback           func(tx *Tx)
 }
 
-func NewMempool(processCallback func(tx *Tx) processTxOutcome) *mempool {
+// maxTxBatchSize limits how many ready txs are offered to the batch callback
+// at once.
+const maxTxBatchSize = 256
+
+// txDeps describes what a tx in the mempool is waiting on.  A tx that's
+// waiting on something that isn't listed (for example, a link to another
+// state URI) is retried whenever any ref is saved or any tx is applied.
+type txDeps struct {
+	Parents []types.ID
+	Refs    []types.RefID
+}
+
+type blockedTx struct {
+	tx   *Tx
+	deps txDeps
+}
+
+// NewMempool creates a mempool that passes txs to processCallback as soon as
+// they might be applicable.  When several txs are ready at once (for example,
+// while catching up on history from a peer), they're first offered to
+// processBatchCallback, which returns how many of them, from the start, it
+// was able to apply.  processBatchCallback may be nil.  If maxTxs is nonzero, the mempool evicts its
+// oldest waiting txs when it holds more than that many.  If txTTL is
+// nonzero, txs that have been waiting for longer than that are evicted.
+// evictCallback is called with each evicted tx.
+func NewMempool(
+	processCallback func(tx *Tx) (processTxOutcome, txDeps),
+	processBatchCallback func(txs []*Tx) int,
+	evictCallback func(tx *Tx),
+	maxTxs uint64,
+	txTTL time.Duration,
+) *mempool {
 	return &mempool{
 		Logger:                  ctx.NewLogger("mempool"),
 		chStop:                  make(chan struct{}),
 		chDone:                  make(chan struct{}),
 		txs:                     newTxSortedSet(),
-		chAdd:                   make(chan *Tx, 100),
+		addedAt:                 make(map[types.Hash]time.Time),
+		blocked:                 make(map[types.Hash]blockedTx),
+		waitingOnTx:             make(map[types.ID]map[types.Hash]struct{}),
+		waitingOnRef:            make(map[types.RefID]map[types.Hash]struct{}),
+		waitingOnUnknown:        make(map[types.Hash]struct{}),
+		maxTxs:                  maxTxs,
+		txTTL:                   txTTL,
 		processMempoolWorkQueue: utils.NewMailbox(0),
 		processCallback:         processCallback,
+		processBatchCallback:    processBatchCallback,
+		evictCallback:           evictCallback,
 	}
 }
 
 func (m *mempool) Start() error {
 	go func() {
 		defer close(m.chDone)
+
+		var chExpire <-chan time.Time
+		if m.txTTL > 0 {
+			interval := m.txTTL / 10
+			if interval < time.Second {
+				interval = time.Second
+			}
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			chExpire = ticker.C
+		}
+
 		for {
 			select {
 			case <-m.chStop:
 				return
+
+			case <-chExpire:
+				m.evictExpired()
+
 			case <-m.processMempoolWorkQueue.Notify():
 				for {
 					x := m.processMempoolWorkQueue.Retrieve()
 					if x == nil {
 						break
 					}
-					switch tx := x.(type) {
+					switch x := x.(type) {
 					case *Tx:
-						m.txs.add(tx)
+						m.add(x)
+					case []*Tx:
+						for _, tx := range x {
+							m.add(tx)
+						}
+					case []types.RefID:
+						m.wakeRefDependents(x)
 					case struct{}:
+						m.wakeAll()
 					}
 				}
 				m.processMempool()
@@ -82,10 +163,25 @@ func (m *mempool) Add(tx *Tx) {
 	m.processMempoolWorkQueue.Deliver(tx)
 }
 
+// AddMany adds several txs at once, so that they're all ready to be processed
+// together.
+func (m *mempool) AddMany(txs []*Tx) {
+	if len(txs) > 0 {
+		m.processMempoolWorkQueue.Deliver(txs)
+	}
+}
+
+// ForceReprocess retries every tx in the mempool, regardless of what it's
+// waiting on.
 func (m *mempool) ForceReprocess() {
 	m.processMempoolWorkQueue.Deliver(struct{}{})
 }
 
+// RefsSaved retries the txs that were waiting on any of the given refs.
+func (m *mempool) RefsSaved(refs []types.RefID) {
+	m.processMempoolWorkQueue.Deliver(refs)
+}
+
 type processTxOutcome int
 
 const (
@@ -94,51 +190,200 @@ const (
 	processTxOutcome_Retry
 )
 
-func (m *mempool) processMempool() {
-	txs := m.txs.takeAll()
+func (m *mempool) add(tx *Tx) {
+	if !m.txs.add(tx) {
+		// Already waiting, so retry it now
+		m.unblock(tx.Hash())
+		return
+	}
+	m.addedAt[tx.Hash()] = time.Now()
+	m.ready = append(m.ready, tx.Copy())
+}
 
-	var retry []*Tx
-	for {
+func (m *mempool) processMempool() {
+	for len(m.ready) > 0 {
 		select {
 		case <-m.chStop:
 			return
 		default:
 		}
 
-		var anySucceeded bool
-		retry = nil
+		if m.processBatch() {
+			continue
+		}
 
-		for _, tx := range txs {
-			select {
-			case <-m.chStop:
-				return
-			default:
-			}
+		tx := m.ready[0]
+		m.ready = m.ready[1:]
 
-			outcome := m.processCallback(tx)
+		outcome, deps := m.processCallback(tx)
 
-			switch outcome {
-			case processTxOutcome_Failed:
-				// Discard it
+		switch outcome {
+		case processTxOutcome_Failed:
+			// Discard it.  Its dependents will discover that it's invalid.
+			m.remove(tx)
+			m.wakeTxDependents(tx.ID)
 
-			case processTxOutcome_Retry:
-				// Leave it in the mempool
-				retry = append(retry, tx)
+		case processTxOutcome_Retry:
+			// Leave it in the mempool until something it's waiting on changes
+			m.block(tx, deps)
 
-			case processTxOutcome_Succeeded:
-				anySucceeded = true
+		case processTxOutcome_Succeeded:
+			m.remove(tx)
+			m.wakeTxDependents(tx.ID)
+			m.wakeUnknownDependents()
 
-			default:
-				panic("this should never happen")
-			}
+		default:
+			panic("this should never happen")
 		}
-		txs = retry
-		if !anySucceeded {
-			break
+	}
+	m.evictOverflow()
+}
+
+// processBatch offers the ready txs to the batch callback and removes the
+// ones that it applied.  It returns false if none were applied.
+func (m *mempool) processBatch() bool {
+	if m.processBatchCallback == nil || len(m.ready) < 2 {
+		return false
+	}
+
+	batch := m.ready
+	if len(batch) > maxTxBatchSize {
+		batch = batch[:maxTxBatchSize]
+	}
+
+	n := m.processBatchCallback(batch)
+	if n == 0 {
+		return false
+	}
+
+	applied := batch[:n]
+	m.ready = m.ready[n:]
+	for _, tx := range applied {
+		m.remove(tx)
+		m.wakeTxDependents(tx.ID)
+	}
+	m.wakeUnknownDependents()
+	return true
+}
+
+func (m *mempool) block(tx *Tx, deps txDeps) {
+	hash := tx.Hash()
+	m.blocked[hash] = blockedTx{tx: tx, deps: deps}
+
+	for _, parentID := range deps.Parents {
+		if m.waitingOnTx[parentID] == nil {
+			m.waitingOnTx[parentID] = make(map[types.Hash]struct{})
+		}
+		m.waitingOnTx[parentID][hash] = struct{}{}
+	}
+	for _, refID := range deps.Refs {
+		if m.waitingOnRef[refID] == nil {
+			m.waitingOnRef[refID] = make(map[types.Hash]struct{})
 		}
+		m.waitingOnRef[refID][hash] = struct{}{}
 	}
-	if len(retry) > 0 {
-		m.txs.addMany(retry)
+	if len(deps.Parents) == 0 && len(deps.Refs) == 0 {
+		m.waitingOnUnknown[hash] = struct{}{}
+	}
+}
+
+// unblock moves a waiting tx back to the ready queue.
+func (m *mempool) unblock(hash types.Hash) {
+	blocked, exists := m.blocked[hash]
+	if !exists {
+		return
+	}
+	m.forget(blocked)
+	m.ready = append(m.ready, blocked.tx)
+}
+
+func (m *mempool) forget(blocked blockedTx) {
+	hash := blocked.tx.Hash()
+	delete(m.blocked, hash)
+	delete(m.waitingOnUnknown, hash)
+
+	for _, parentID := range blocked.deps.Parents {
+		delete(m.waitingOnTx[parentID], hash)
+		if len(m.waitingOnTx[parentID]) == 0 {
+			delete(m.waitingOnTx, parentID)
+		}
+	}
+	for _, refID := range blocked.deps.Refs {
+		delete(m.waitingOnRef[refID], hash)
+		if len(m.waitingOnRef[refID]) == 0 {
+			delete(m.waitingOnRef, refID)
+		}
+	}
+}
+
+func (m *mempool) remove(tx *Tx) {
+	m.txs.remove(tx.Hash())
+	delete(m.addedAt, tx.Hash())
+}
+
+func (m *mempool) wakeTxDependents(txID types.ID) {
+	for hash := range m.waitingOnTx[txID] {
+		m.unblock(hash)
+	}
+}
+
+func (m *mempool) wakeRefDependents(refIDs []types.RefID) {
+	for _, refID := range refIDs {
+		for hash := range m.waitingOnRef[refID] {
+			m.unblock(hash)
+		}
+	}
+	m.wakeUnknownDependents()
+}
+
+func (m *mempool) wakeUnknownDependents() {
+	for hash := range m.waitingOnUnknown {
+		m.unblock(hash)
+	}
+}
+
+func (m *mempool) wakeAll() {
+	for hash := range m.blocked {
+		m.unblock(hash)
+	}
+}
+
+// evictOverflow evicts the txs that have been waiting the longest until the
+// mempool is back under its size limit.
+func (m *mempool) evictOverflow() {
+	if m.maxTxs == 0 || uint64(len(m.blocked)) <= m.maxTxs {
+		return
+	}
+
+	waiting := make([]*Tx, 0, len(m.blocked))
+	for _, blocked := range m.blocked {
+		waiting = append(waiting, blocked.tx)
+	}
+	sort.Slice(waiting, func(i, j int) bool {
+		return m.addedAt[waiting[i].Hash()].Before(m.addedAt[waiting[j].Hash()])
+	})
+
+	for _, tx := range waiting[:uint64(len(waiting))-m.maxTxs] {
+		m.Warnf("mempool is full, evicting tx %v", tx.ID.Pretty())
+		m.evict(tx)
+	}
+}
+
+func (m *mempool) evictExpired() {
+	cutoff := time.Now().Add(-m.txTTL)
+	for hash, blocked := range m.blocked {
+		if m.addedAt[hash].Before(cutoff) {
+			m.Warnf("tx %v has waited longer than %v, evicting", blocked.tx.ID.Pretty(), m.txTTL)
+			m.evict(blocked.tx)
+		}
+	}
+}
+
+func (m *mempool) evict(tx *Tx) {
+	m.forget(m.blocked[tx.Hash()])
+	m.remove(tx)
+	if m.evictCallback != nil {
+		m.evictCallback(tx)
 	}
 }
 
@@ -155,21 +400,6 @@ func newTxSortedSet() *txSortedSet {
 	}
 }
 
-func (s *txSortedSet) takeAll() []*Tx {
-	s.Lock()
-	defer s.Unlock()
-
-	cp := make([]*Tx, len(s.order))
-	for i, hash := range s.order {
-		cp[i] = s.txs[hash].Copy()
-	}
-
-	s.txs = make(map[types.Hash]*Tx, 0)
-	s.order = make([]types.Hash, 0)
-
-	return cp
-}
-
 func (s *txSortedSet) copy() *txSortedSet {
 	s.RLock()
 	defer s.RUnlock()
@@ -185,20 +415,29 @@ func (s *txSortedSet) copy() *txSortedSet {
 	return &txSortedSet{txs: txs, order: order}
 }
 
-func (s *txSortedSet) add(tx *Tx) {
+// add returns false if the tx was already in the set.
+func (s *txSortedSet) add(tx *Tx) bool {
 	s.Lock()
 	defer s.Unlock()
-	if _, exists := s.txs[tx.Hash()]; !exists {
-		s.txs[tx.Hash()] = tx.Copy()
-		s.order = append(s.order, tx.Hash())
+	if _, exists := s.txs[tx.Hash()]; exists {
+		return false
 	}
+	s.txs[tx.Hash()] = tx.Copy()
+	s.order = append(s.order, tx.Hash())
+	return true
 }
 
-func (s *txSortedSet) addMany(txs []*Tx) {
+func (s *txSortedSet) remove(hash types.Hash) {
 	s.Lock()
 	defer s.Unlock()
-	for _, tx := range txs {
-		s.txs[tx.Hash()] = tx.Copy()
-		s.order = append(s.order, tx.Hash())
+	if _, exists := s.txs[hash]; !exists {
+		return
+	}
+	delete(s.txs, hash)
+	for i := range s.order {
+		if s.order[i] == hash {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
 	}
 }
@@ -0,0 +1,156 @@
+package redwood
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"redwood.dev/types"
+)
+
+// fakeTxProcessor applies txs once all of their parents have been applied.
+type fakeTxProcessor struct {
+	mu         sync.Mutex
+	applied    map[types.ID]bool
+	calls      int
+	batchSizes []int
+	evicted    []types.ID
+}
+
+func (p *fakeTxProcessor) process(tx *Tx) (processTxOutcome, txDeps) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.calls++
+	var missing []types.ID
+	for _, parentID := range tx.Parents {
+		if !p.applied[parentID] {
+			missing = append(missing, parentID)
+		}
+	}
+	if len(missing) > 0 {
+		return processTxOutcome_Retry, txDeps{Parents: missing}
+	}
+	p.applied[tx.ID] = true
+	return processTxOutcome_Succeeded, txDeps{}
+}
+
+func (p *fakeTxProcessor) processBatch(txs []*Tx) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var n int
+	for _, tx := range txs {
+		for _, parentID := range tx.Parents {
+			if !p.applied[parentID] {
+				p.batchSizes = append(p.batchSizes, n)
+				return n
+			}
+		}
+		p.applied[tx.ID] = true
+		n++
+	}
+	p.batchSizes = append(p.batchSizes, n)
+	return n
+}
+
+func (p *fakeTxProcessor) evict(tx *Tx) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.evicted = append(p.evicted, tx.ID)
+}
+
+func TestMempool_ProcessesDependentsWhenParentsArrive(t *testing.T) {
+	p := &fakeTxProcessor{applied: map[types.ID]bool{GenesisTxID: true}}
+	m := NewMempool(p.process, nil, p.evict, 0, 0)
+	err := m.Start()
+	require.NoError(t, err)
+	defer m.Close()
+
+	// Build a long chain and deliver it in reverse order
+	const n = 100
+	txs := make([]*Tx, n)
+	parent := GenesisTxID
+	for i := range txs {
+		txs[i] = &Tx{ID: types.RandomID(), Parents: []types.ID{parent}}
+		parent = txs[i].ID
+	}
+	for i := n - 1; i >= 0; i-- {
+		m.Add(txs[i])
+	}
+
+	require.Eventually(t, func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.applied[txs[n-1].ID]
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Each tx is tried at most once before its parent arrives and once after
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	require.LessOrEqual(t, p.calls, 2*n)
+	require.Len(t, m.Get().order, 0)
+}
+
+func TestMempool_EvictsOverflow(t *testing.T) {
+	p := &fakeTxProcessor{applied: map[types.ID]bool{}}
+	m := NewMempool(p.process, nil, p.evict, 2, 0)
+	err := m.Start()
+	require.NoError(t, err)
+	defer m.Close()
+
+	missingParent := types.RandomID()
+	var txs []*Tx
+	for i := 0; i < 3; i++ {
+		tx := &Tx{ID: types.RandomID(), Parents: []types.ID{missingParent}}
+		txs = append(txs, tx)
+		m.Add(tx)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	require.Eventually(t, func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return len(p.evicted) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	require.Equal(t, txs[0].ID, p.evicted[0])
+	require.Len(t, m.Get().order, 2)
+}
+
+func TestMempool_AppliesReadyTxsInBatches(t *testing.T) {
+	p := &fakeTxProcessor{applied: map[types.ID]bool{GenesisTxID: true}}
+	m := NewMempool(p.process, p.processBatch, p.evict, 0, 0)
+
+	// Deliver a chain in order before the mempool starts, so that it's all
+	// ready at once
+	const n = 300
+	parent := GenesisTxID
+	var last types.ID
+	for i := 0; i < n; i++ {
+		tx := &Tx{ID: types.RandomID(), Parents: []types.ID{parent}}
+		m.Add(tx)
+		parent = tx.ID
+		last = tx.ID
+	}
+
+	err := m.Start()
+	require.NoError(t, err)
+	defer m.Close()
+
+	require.Eventually(t, func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return p.applied[last]
+	}, 5*time.Second, 10*time.Millisecond)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	require.Equal(t, []int{maxTxBatchSize, n - maxTxBatchSize}, p.batchSizes)
+	require.Equal(t, 0, p.calls)
+	require.Len(t, m.Get().order, 0)
+}
@@ -33,6 +33,8 @@ type Tx struct {
 	Checkpoint           bool     `protobuf:"varint,9,opt,name=checkpoint,proto3" json:"checkpoint,omitempty"`
 	Attachment           []byte   `protobuf:"bytes,10,opt,name=attachment,proto3" json:"attachment,omitempty"`
 	Status               string   `protobuf:"bytes,11,opt,name=status,proto3" json:"status,omitempty"`
+	Capabilities         [][]byte `protobuf:"bytes,12,rep,name=capabilities,proto3" json:"capabilities,omitempty"`
+	CoSigs               [][]byte `protobuf:"bytes,13,rep,name=co_sigs,json=coSigs,proto3" json:"co_sigs,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -140,6 +142,20 @@ func (m *Tx) GetStatus() string {
 	return ""
 }
 
+func (m *Tx) GetCapabilities() [][]byte {
+	if m != nil {
+		return m.Capabilities
+	}
+	return nil
+}
+
+func (m *Tx) GetCoSigs() [][]byte {
+	if m != nil {
+		return m.CoSigs
+	}
+	return nil
+}
+
 type Patch struct {
 	Keypath              []byte   `protobuf:"bytes,1,opt,name=keypath,proto3" json:"keypath,omitempty"`
 	Range                *Range   `protobuf:"bytes,2,opt,name=range,proto3" json:"range,omitempty"`
@@ -251,27 +267,29 @@ func init() {
 func init() { proto.RegisterFile("tx.proto", fileDescriptor_0fd2153dc07d3b5c) }
 
 var fileDescriptor_0fd2153dc07d3b5c = []byte{
-	// 341 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x54, 0x51, 0xb1, 0x6e, 0xc2, 0x30,
-	0x10, 0x55, 0x62, 0x02, 0xe1, 0x40, 0xa8, 0xb2, 0x50, 0xe5, 0x32, 0x54, 0x11, 0xea, 0x10, 0x75,
-	0x08, 0x52, 0xfb, 0x05, 0xed, 0xd6, 0xad, 0xb2, 0xda, 0xa5, 0x9b, 0x49, 0x4c, 0x62, 0x01, 0x76,
-	0x64, 0x9b, 0x16, 0x7e, 0xaa, 0xdf, 0x58, 0xf9, 0x42, 0x10, 0xdd, 0xee, 0xbd, 0x77, 0xbe, 0x7b,
-	0x7e, 0x07, 0xa9, 0x3f, 0x16, 0xad, 0x35, 0xde, 0xd0, 0x91, 0x95, 0xd5, 0x8f, 0x31, 0xd5, 0xe2,
-	0xae, 0x36, 0xa6, 0xde, 0xc9, 0x15, 0xd2, 0xeb, 0xc3, 0x66, 0x25, 0xf4, 0xa9, 0xeb, 0x59, 0xfe,
-	0xc6, 0x10, 0x7f, 0x1c, 0xe9, 0x0c, 0x62, 0x55, 0xb1, 0x28, 0x8b, 0xf2, 0x29, 0x8f, 0x55, 0x45,
-	0x19, 0x8c, 0x5a, 0x61, 0xa5, 0xf6, 0x8e, 0xc5, 0x19, 0xc9, 0xa7, 0xbc, 0x87, 0x74, 0x01, 0x69,
-	0xd9, 0xa8, 0x5d, 0x65, 0xa5, 0x66, 0x04, 0xa5, 0x0b, 0xa6, 0x14, 0x06, 0x1b, 0x6b, 0xf6, 0x6c,
-	0x80, 0x73, 0xb0, 0xa6, 0x37, 0x40, 0x9c, 0xaa, 0x59, 0x82, 0x54, 0x28, 0xc3, 0x04, 0xe7, 0x85,
-	0x97, 0x9f, 0xfc, 0x8d, 0x0d, 0xb3, 0x28, 0x1f, 0xf3, 0x0b, 0xa6, 0x79, 0xd8, 0xeb, 0xcb, 0x46,
-	0x3a, 0x36, 0xca, 0x48, 0x3e, 0x79, 0x9a, 0x15, 0xe7, 0x4f, 0x14, 0xef, 0x81, 0xe7, 0xbd, 0x4c,
-	0xef, 0x01, 0xac, 0x2c, 0x55, 0xab, 0xd0, 0x64, 0x8a, 0x4e, 0xae, 0x98, 0xa0, 0x97, 0x8d, 0x2c,
-	0xb7, 0xad, 0x51, 0xda, 0xb3, 0x71, 0x16, 0xe5, 0x29, 0xbf, 0x62, 0x82, 0x2e, 0xbc, 0x17, 0x65,
-	0xb3, 0x97, 0xda, 0x33, 0x40, 0x7b, 0x57, 0x0c, 0xbd, 0x85, 0x61, 0x70, 0x75, 0x70, 0x6c, 0x82,
-	0x1e, 0xcf, 0x68, 0xe9, 0x20, 0x41, 0x27, 0x21, 0xa2, 0xad, 0x3c, 0xb5, 0xc2, 0x37, 0xe7, 0xdc,
-	0x7a, 0x48, 0x1f, 0x20, 0xb1, 0x42, 0xd7, 0x92, 0xc5, 0x59, 0xf4, 0xef, 0x0b, 0x3c, 0xb0, 0xbc,
-	0x13, 0xe9, 0x23, 0x24, 0xdf, 0x62, 0x77, 0x90, 0x8c, 0x60, 0xd7, 0xbc, 0xe8, 0x8e, 0x54, 0xf4,
-	0x47, 0x2a, 0x5e, 0xf4, 0x89, 0x77, 0x2d, 0xcb, 0x15, 0x24, 0xf8, 0x96, 0xce, 0x21, 0x71, 0x5e,
-	0x58, 0x8f, 0x2b, 0x09, 0xef, 0x40, 0xc8, 0x58, 0xea, 0x0a, 0xd7, 0x11, 0x1e, 0xca, 0xd7, 0xc1,
-	0x57, 0xdc, 0xae, 0xd7, 0x43, 0x9c, 0xf5, 0xfc, 0x17, 0x00, 0x00, 0xff, 0xff, 0x52, 0x2b, 0x73,
-	0x7b, 0x13, 0x02, 0x00, 0x00,
+	// 374 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x54, 0x51, 0x4d, 0x8f, 0xd3, 0x30,
+	0x10, 0x55, 0x92, 0xe6, 0x63, 0x67, 0xc3, 0x0a, 0x59, 0x2b, 0x30, 0x7b, 0x40, 0x51, 0xc4, 0x21,
+	0xe2, 0x90, 0x4a, 0xf0, 0x0b, 0xe0, 0xc6, 0x0d, 0x19, 0xb8, 0x70, 0x41, 0x8e, 0xe3, 0x4d, 0xac,
+	0xa6, 0x76, 0x64, 0xbb, 0xd0, 0xfe, 0x66, 0xfe, 0xc4, 0xca, 0x93, 0xa6, 0x6a, 0x6f, 0xf3, 0xde,
+	0x1b, 0xcf, 0x7b, 0xe3, 0x81, 0xc2, 0x1f, 0xdb, 0xd9, 0x1a, 0x6f, 0x48, 0x6e, 0x65, 0xff, 0xcf,
+	0x98, 0xfe, 0xe9, 0xdd, 0x60, 0xcc, 0x30, 0xc9, 0x2d, 0xd2, 0xdd, 0xe1, 0x79, 0xcb, 0xf5, 0x69,
+	0xe9, 0xa9, 0xff, 0xc7, 0x10, 0xff, 0x3c, 0x92, 0x07, 0x88, 0x55, 0x4f, 0xa3, 0x2a, 0x6a, 0x4a,
+	0x16, 0xab, 0x9e, 0x50, 0xc8, 0x67, 0x6e, 0xa5, 0xf6, 0x8e, 0xc6, 0x55, 0xd2, 0x94, 0x6c, 0x85,
+	0xe4, 0x09, 0x0a, 0x31, 0xaa, 0xa9, 0xb7, 0x52, 0xd3, 0x04, 0xa5, 0x0b, 0x26, 0x04, 0x36, 0xcf,
+	0xd6, 0xec, 0xe9, 0x06, 0xe7, 0x60, 0x4d, 0x5e, 0x43, 0xe2, 0xd4, 0x40, 0x53, 0xa4, 0x42, 0x19,
+	0x26, 0x38, 0xcf, 0xbd, 0xfc, 0xc5, 0xbe, 0xd1, 0xac, 0x8a, 0x9a, 0x3b, 0x76, 0xc1, 0xa4, 0x09,
+	0xbe, 0x5e, 0x8c, 0xd2, 0xd1, 0xbc, 0x4a, 0x9a, 0xfb, 0x4f, 0x0f, 0xed, 0x79, 0x89, 0xf6, 0x7b,
+	0xe0, 0xd9, 0x2a, 0x93, 0xf7, 0x00, 0x56, 0x0a, 0x35, 0x2b, 0x0c, 0x59, 0x60, 0x92, 0x2b, 0x26,
+	0xe8, 0x62, 0x94, 0x62, 0x37, 0x1b, 0xa5, 0x3d, 0xbd, 0xab, 0xa2, 0xa6, 0x60, 0x57, 0x4c, 0xd0,
+	0xb9, 0xf7, 0x5c, 0x8c, 0x7b, 0xa9, 0x3d, 0x05, 0x8c, 0x77, 0xc5, 0x90, 0x37, 0x90, 0x85, 0x54,
+	0x07, 0x47, 0xef, 0x31, 0xe3, 0x19, 0x91, 0x1a, 0x4a, 0xc1, 0x67, 0xde, 0xa9, 0x49, 0x79, 0x25,
+	0x1d, 0x2d, 0xd1, 0xf9, 0x86, 0x23, 0x6f, 0x21, 0x17, 0xe6, 0x8f, 0x53, 0x83, 0xa3, 0xaf, 0x50,
+	0xce, 0x84, 0xf9, 0xa1, 0x06, 0x57, 0x3b, 0x48, 0x71, 0x8d, 0xf0, 0xbf, 0x3b, 0x79, 0x9a, 0xb9,
+	0x1f, 0xcf, 0x9f, 0xbe, 0x42, 0xf2, 0x01, 0x52, 0xcb, 0xf5, 0x20, 0x69, 0x5c, 0x45, 0x37, 0xfb,
+	0xb3, 0xc0, 0xb2, 0x45, 0x24, 0x1f, 0x21, 0xfd, 0xcb, 0xa7, 0x83, 0xa4, 0x09, 0x76, 0x3d, 0xb6,
+	0xcb, 0x85, 0xdb, 0xf5, 0xc2, 0xed, 0x17, 0x7d, 0x62, 0x4b, 0x4b, 0xbd, 0x85, 0x14, 0xdf, 0x92,
+	0x47, 0x48, 0x9d, 0xe7, 0xd6, 0xa3, 0x65, 0xc2, 0x16, 0x10, 0x0e, 0x24, 0x75, 0x8f, 0x76, 0x09,
+	0x0b, 0xe5, 0xd7, 0xcd, 0xef, 0x78, 0xee, 0xba, 0x0c, 0x67, 0x7d, 0x7e, 0x19, 0x00, 0xc9, 0x96,
+	0xa6, 0x1b, 0x50, 0x02, 0x00, 0x00,
 }
@@ -34,7 +34,7 @@ type RefStore interface {
 	RefsNeeded() ([]types.RefID, error)
 	MarkRefsAsNeeded(refs []types.RefID)
 	OnRefsNeeded(fn func(refs []types.RefID))
-	OnRefsSaved(fn func())
+	OnRefsSaved(fn func(refs []types.RefID))
 }
 
 type refStore struct {
@@ -46,7 +46,7 @@ type refStore struct {
 
 	refsNeededListeners   []func(refs []types.RefID)
 	refsNeededListenersMu sync.RWMutex
-	refsSavedListeners    []func()
+	refsSavedListeners    []func(refs []types.RefID)
 	refsSavedListenersMu  sync.RWMutex
 }
 
@@ -233,11 +233,12 @@ func (s *refStore) StoreObject(reader io.ReadCloser) (sha1Hash types.Hash, sha3H
 
 	s.Successf("saved ref (sha1: %v, sha3: %v)", sha1Hash.Hex(), sha3Hash.Hex())
 
-	s.unmarkRefsAsNeeded([]types.RefID{
+	refs := []types.RefID{
 		{HashAlg: types.SHA1, Hash: sha1Hash},
 		{HashAlg: types.SHA3, Hash: sha3Hash},
-	})
-	s.notifyRefsSavedListeners()
+	}
+	s.unmarkRefsAsNeeded(refs)
+	s.notifyRefsSavedListeners(refs)
 
 	return sha1Hash, sha3Hash, nil
 }
@@ -441,13 +442,13 @@ func (s *refStore) notifyRefsNeededListeners(refs []types.RefID) {
 	wg.Wait()
 }
 
-func (s *refStore) OnRefsSaved(fn func()) {
+func (s *refStore) OnRefsSaved(fn func(refs []types.RefID)) {
 	s.refsSavedListenersMu.Lock()
 	defer s.refsSavedListenersMu.Unlock()
 	s.refsSavedListeners = append(s.refsSavedListeners, fn)
 }
 
-func (s *refStore) notifyRefsSavedListeners() {
+func (s *refStore) notifyRefsSavedListeners(refs []types.RefID) {
 	s.refsSavedListenersMu.RLock()
 	defer s.refsSavedListenersMu.RUnlock()
 
@@ -458,7 +459,7 @@ func (s *refStore) notifyRefsSavedListeners() {
 		handler := handler
 		go func() {
 			defer wg.Done()
-			handler()
+			handler(refs)
 		}()
 	}
 	wg.Wait()
@@ -169,12 +169,19 @@ func (c *client) AllTxsForStateURI(stateURI string, fromTxID types.ID) redwood.T
 }
 
 func (c *client) FetchTx(stateURI string, txID types.ID) (*redwood.Tx, error) { panic("unimplemented") }
+func (c *client) TxIDsWithStatus(stateURI string, status redwood.TxStatus) ([]types.ID, error) {
+	panic("unimplemented")
+}
 func (c *client) TxExists(stateURI string, txID types.ID) (bool, error)       { panic("unimplemented") }
 func (c *client) RemoveTx(stateURI string, txID types.ID) error               { panic("unimplemented") }
 func (c *client) KnownStateURIs() ([]string, error)                           { panic("unimplemented") }
 func (c *client) MarkLeaf(stateURI string, txID types.ID) error               { panic("unimplemented") }
 func (c *client) UnmarkLeaf(stateURI string, txID types.ID) error             { panic("unimplemented") }
 func (c *client) Leaves(stateURI string) ([]types.ID, error)                  { panic("unimplemented") }
+func (c *client) PruneTxs(snapshot *redwood.Tx, prunedTxIDs []types.ID) error { panic("unimplemented") }
+func (c *client) ResolvePrunedTxID(stateURI string, txID types.ID) (types.ID, error) {
+	panic("unimplemented")
+}
 
 func (c *client) decodeTx(txBytes []byte) (*redwood.Tx, error) {
 	var tx redwood.Tx
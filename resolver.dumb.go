@@ -7,6 +7,10 @@ import (
 
 type dumbResolver struct{}
 
+// Ensure dumbResolver conforms to the Resolver and StatelessResolver interfaces
+var _ Resolver = (*dumbResolver)(nil)
+var _ StatelessResolver = (*dumbResolver)(nil)
+
 func NewDumbResolver(config tree.Node, internalState map[string]interface{}) (Resolver, error) {
 	return &dumbResolver{}, nil
 }
@@ -15,6 +19,14 @@ func (r *dumbResolver) InternalState() map[string]interface{} {
 	return map[string]interface{}{}
 }
 
+func (r *dumbResolver) Stateless() bool {
+	return true
+}
+
+func (r *dumbResolver) MigrateFrom(oldContentType string, oldInternalState map[string]interface{}, state tree.Node) error {
+	return nil
+}
+
 func (r *dumbResolver) ResolveState(state tree.Node, refStore RefStore, sender types.Address, txID types.ID, parents []types.ID, ps []Patch) (err error) {
 	for _, p := range ps {
 		if p.Val != nil {
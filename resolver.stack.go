@@ -1,50 +1,123 @@
 package redwood
 
-//import (
-//    "github.com/pkg/errors"
-//)
-//
-//type stackResolver struct {
-//    resolver
-//    state     interface{}
-//    resolvers []Resolver
-//}
-//
-//func (r *stackResolver) InternalState() map[string]interface{} {
-//    return nil
-//}
-//
-//func NewStackResolver(params map[string]interface{}, internalState map[string]interface{}) (Resolver, error) {
-//    children, exists := getSlice(params, []string{"children"})
-//    if !exists {
-//        return nil, errors.New("stack resolver needs an array 'children' param")
-//    }
-//
-//    var resolvers []Resolver
-//    for i := range children {
-//        config, is := children[i].(map[string]interface{})
-//        if !is {
-//            return nil, errors.New("stack resolver found something that didn't look like a resolver config")
-//        }
-//
-//        resolver, err := initResolverFromConfig(config, nil) // @@TODO: stack resolver internal state...?
-//        if err != nil {
-//            return nil, err
-//        }
-//
-//        resolvers = append(resolvers, resolver)
-//    }
-//
-//    return &stackResolver{resolvers: resolvers}, nil
-//}
-//
-//func (r *stackResolver) ResolveState(state interface{}, sender Address, txID ID, parents []ID, patches []Patch) (interface{}, error) {
-//    var err error
-//    for _, resolver := range r.resolvers {
-//        state, err = resolver.ResolveState(state, sender, txID, parents, patches)
-//        if err != nil {
-//            return nil, err
-//        }
-//    }
-//    return state, nil
-//}
+import (
+	"github.com/pkg/errors"
+
+	"redwood.dev/nelson"
+	"redwood.dev/tree"
+	"redwood.dev/types"
+	"redwood.dev/utils"
+)
+
+type stackResolver struct {
+	resolvers []Resolver
+}
+
+// Ensure stackResolver conforms to the Resolver, TxDAGResolver and
+// StatelessResolver interfaces
+var _ Resolver = (*stackResolver)(nil)
+var _ TxDAGResolver = (*stackResolver)(nil)
+var _ StatelessResolver = (*stackResolver)(nil)
+
+func NewStackResolver(config tree.Node, internalState map[string]interface{}) (_ Resolver, err error) {
+	defer utils.Annotate(&err, "NewStackResolver")
+
+	childConfigs, err := stackChildConfigs(config)
+	if err != nil {
+		return nil, err
+	}
+
+	childStates, _ := internalState["children"].([]interface{})
+	if len(childStates) > 0 && len(childStates) != len(childConfigs) {
+		// The stack's children have changed shape, so there's no way to know which
+		// internal state belongs to which child.
+		return nil, errors.Errorf("stack resolver has %v children but internal state for %v", len(childConfigs), len(childStates))
+	}
+
+	var resolvers []Resolver
+	for i, childConfig := range childConfigs {
+		contentType, err := nelson.GetContentType(childConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		ctor, exists := resolverRegistry[contentType]
+		if !exists {
+			return nil, errors.Errorf("stack resolver: unknown resolver type '%v'", contentType)
+		}
+
+		childState := make(map[string]interface{})
+		if len(childStates) > 0 {
+			if s, isMap := childStates[i].(map[string]interface{}); isMap {
+				childState = s
+			}
+		}
+
+		resolver, err := ctor(childConfig, childState)
+		if err != nil {
+			return nil, errors.Wrapf(err, "stack resolver: child %v", i)
+		}
+		resolvers = append(resolvers, resolver)
+	}
+	return &stackResolver{resolvers: resolvers}, nil
+}
+
+func (r *stackResolver) InternalState() map[string]interface{} {
+	childStates := make([]interface{}, len(r.resolvers))
+	for i, resolver := range r.resolvers {
+		childStates[i] = resolver.InternalState()
+	}
+	return map[string]interface{}{"children": childStates}
+}
+
+// Stateless returns true if none of the stack's children keep internal state.
+func (r *stackResolver) Stateless() bool {
+	for _, resolver := range r.resolvers {
+		if resolver, is := resolver.(StatelessResolver); !is || !resolver.Stateless() {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *stackResolver) SetTxParentsLookup(lookup TxParentsLookup) {
+	for _, resolver := range r.resolvers {
+		if resolver, is := resolver.(TxDAGResolver); is {
+			resolver.SetTxParentsLookup(lookup)
+		}
+	}
+}
+
+func (r *stackResolver) ResolveState(state tree.Node, refStore RefStore, sender types.Address, txID types.ID, parents []types.ID, patches []Patch) (err error) {
+	for i, resolver := range r.resolvers {
+		err = resolver.ResolveState(state, refStore, sender, txID, parents, patches)
+		if err != nil {
+			return errors.Wrapf(err, "stack resolver: child %v", i)
+		}
+	}
+	return nil
+}
+
+// stackChildConfigs returns the config nodes found in the 'children' array of
+// a stack behavior's config.
+func stackChildConfigs(config tree.Node) ([]tree.Node, error) {
+	if config == nil {
+		return nil, errors.New("stack behaviors need an array 'children' param")
+	}
+	childrenNode := config.NodeAt(tree.Keypath("children"), nil)
+
+	nodeType, _, length, err := childrenNode.NodeInfo(nil)
+	if errors.Cause(err) == types.Err404 {
+		return nil, errors.New("stack behaviors need an array 'children' param")
+	} else if err != nil {
+		return nil, err
+	} else if nodeType != tree.NodeTypeSlice {
+		return nil, errors.New("stack behaviors need an array 'children' param")
+	}
+
+	childConfigs := make([]tree.Node, length)
+	for i := range childConfigs {
+		childConfigs[i] = childrenNode.NodeAt(tree.EncodeSliceIndex(uint64(i)), nil)
+	}
+	return childConfigs, nil
+}
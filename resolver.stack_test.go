@@ -0,0 +1,81 @@
+package redwood
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"redwood.dev/nelson"
+	"redwood.dev/tree"
+	"redwood.dev/types"
+)
+
+// stackTestConfig builds a behavior config the way that the controller sees
+// it, with its NelSON frames resolved.
+func stackTestConfig(t *testing.T, config map[string]interface{}) tree.Node {
+	t.Helper()
+
+	node := tree.NewMemoryNode()
+	err := node.Set(nil, nil, config)
+	require.NoError(t, err)
+	resolved, anyMissing, err := nelson.Resolve(node, nil)
+	require.NoError(t, err)
+	require.False(t, anyMissing)
+	return resolved
+}
+
+func TestStackResolver(t *testing.T) {
+	config := stackTestConfig(t, map[string]interface{}{
+		"children": []interface{}{
+			map[string]interface{}{"Content-Type": "resolver/dumb"},
+			map[string]interface{}{
+				"Content-Type": "resolver/js",
+				"value": map[string]interface{}{
+					"src": `global.init = function(internalState) { global.count = internalState.count || 0 };
+						global.resolve_state = function(state, sender, txID, parents, patches) {
+							global.count++;
+							state.count = global.count;
+							return JSON.stringify({state: state, internalState: {count: global.count}});
+						}`,
+				},
+			},
+		},
+	})
+
+	resolver, err := NewStackResolver(config, nil)
+	require.NoError(t, err)
+
+	// Each child resolves the patches in turn, against the same state
+	state := tree.NewMemoryNode()
+	err = resolver.ResolveState(state, nil, types.Address{}, types.IDFromString("one"), nil, mustParsePatches(t, `.a = 1`))
+	require.NoError(t, err)
+	val, _, err := state.Value(nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"a": 1.0, "count": 1.0}, val)
+
+	internalState := resolver.InternalState()
+	require.Equal(t, map[string]interface{}{
+		"children": []interface{}{
+			map[string]interface{}{},
+			map[string]interface{}{"count": 1.0},
+		},
+	}, internalState)
+
+	// Each child picks up from its own part of the internal state
+	resolver, err = NewStackResolver(config, internalState)
+	require.NoError(t, err)
+	err = resolver.ResolveState(state, nil, types.Address{}, types.IDFromString("two"), nil, mustParsePatches(t, `.b = 2`))
+	require.NoError(t, err)
+	val, _, err = state.Value(nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"a": 1.0, "b": 2.0, "count": 2.0}, val)
+
+	// Internal state for a different set of children can't be used
+	_, err = NewStackResolver(config, map[string]interface{}{
+		"children": []interface{}{map[string]interface{}{"count": 1.0}},
+	})
+	require.Error(t, err)
+
+	_, err = NewStackResolver(stackTestConfig(t, map[string]interface{}{}), nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,770 @@
+package redwood
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"redwood.dev/ctx"
+	"redwood.dev/tree"
+	"redwood.dev/types"
+	"redwood.dev/utils"
+)
+
+// sync9Resolver is a native port of the Sync9 CRDT found in
+// demos/desktop-chat-app/sync9.js.  It keeps the full version DAG and
+// "space DAG" for every sequence (strings and arrays) in its internal state,
+// which means that the resolved state is identical regardless of the order
+// in which concurrent transactions arrive.
+type sync9Resolver struct {
+	ctx.Logger
+	hasRun    bool
+	state     *sync9State
+	txParents TxParentsLookup
+}
+
+// Ensure sync9Resolver conforms to the Resolver, ResolverMigrator and
+// TxDAGResolver interfaces
+var _ Resolver = (*sync9Resolver)(nil)
+var _ ResolverMigrator = (*sync9Resolver)(nil)
+var _ TxDAGResolver = (*sync9Resolver)(nil)
+
+const sync9InitVersion = "init"
+
+type sync9State struct {
+	T      map[string]map[string]bool `json:"T"`
+	Leaves map[string]bool            `json:"leaves"`
+	Val    *sync9Value                `json:"val"`
+}
+
+// sync9Value is one of:
+//   - "lit": a plain value with no merge history (Lit)
+//   - "val": a single-element space DAG holding the current value (S)
+//   - "obj": a map of child values (Obj)
+//   - "arr": a space DAG of child values (S)
+//   - "str": a space DAG of runes (S)
+type sync9Value struct {
+	T   string                 `json:"t"`
+	Lit interface{}            `json:"lit,omitempty"`
+	Obj map[string]*sync9Value `json:"obj,omitempty"`
+	S   *sync9Node             `json:"S,omitempty"`
+}
+
+type sync9Node struct {
+	Vid       string          `json:"vid,omitempty"`
+	Elems     sync9Elems      `json:"elems"`
+	DeletedBy map[string]bool `json:"deletedBy,omitempty"`
+	EndCap    bool            `json:"endCap,omitempty"`
+	Gash      bool            `json:"gash,omitempty"`
+	Nexts     []*sync9Node    `json:"nexts,omitempty"`
+	Next      *sync9Node      `json:"next,omitempty"`
+}
+
+type sync9Elems struct {
+	IsStr bool          `json:"isStr,omitempty"`
+	Str   []rune        `json:"str,omitempty"`
+	Vals  []*sync9Value `json:"vals,omitempty"`
+}
+
+type sync9Splice struct {
+	start  int
+	count  int
+	insert *sync9Elems
+}
+
+func NewSync9Resolver(config tree.Node, internalState map[string]interface{}) (_ Resolver, err error) {
+	defer utils.Annotate(&err, "NewSync9Resolver")
+
+	r := &sync9Resolver{
+		Logger: ctx.NewLogger("resolver:sync9"),
+		state:  newSync9State(),
+	}
+
+	if len(internalState) > 0 {
+		bs, err := json.Marshal(internalState)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		var restored struct {
+			HasRun  bool        `json:"hasRun"`
+			S9State *sync9State `json:"s9state"`
+		}
+		err = json.Unmarshal(bs, &restored)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		r.hasRun = restored.HasRun
+		if restored.S9State != nil {
+			r.state = restored.S9State
+			if r.state.T == nil {
+				r.state.T = make(map[string]map[string]bool)
+			}
+			if r.state.Leaves == nil {
+				r.state.Leaves = make(map[string]bool)
+			}
+		}
+	}
+	return r, nil
+}
+
+func newSync9State() *sync9State {
+	return &sync9State{
+		T:      make(map[string]map[string]bool),
+		Leaves: make(map[string]bool),
+		Val:    &sync9Value{T: "lit"},
+	}
+}
+
+func (r *sync9Resolver) InternalState() map[string]interface{} {
+	bs, err := json.Marshal(struct {
+		HasRun  bool        `json:"hasRun"`
+		S9State *sync9State `json:"s9state"`
+	}{r.hasRun, r.state})
+	if err != nil {
+		r.Errorf("error marshaling sync9 internal state: %v", err)
+		return map[string]interface{}{}
+	}
+	var internalState map[string]interface{}
+	err = json.Unmarshal(bs, &internalState)
+	if err != nil {
+		r.Errorf("error marshaling sync9 internal state: %v", err)
+		return map[string]interface{}{}
+	}
+	return internalState
+}
+
+// MigrateFrom discards any history and adopts the current state of the
+// subtree as the initial version the next time a tx is resolved.
+func (r *sync9Resolver) MigrateFrom(oldContentType string, oldInternalState map[string]interface{}, state tree.Node) error {
+	r.hasRun = false
+	r.state = newSync9State()
+	return nil
+}
+
+func (r *sync9Resolver) ResolveState(state tree.Node, refStore RefStore, sender types.Address, txID types.ID, parents []types.ID, patches []Patch) (err error) {
+	defer utils.Annotate(&err, "sync9Resolver.ResolveState")
+
+	parentVids := make(map[string]bool)
+	if !r.hasRun {
+		initial, _, err := state.Value(nil, nil)
+		if err != nil {
+			return err
+		}
+		err = r.state.addVersion(sync9InitVersion, map[string]bool{}, []sync9Change{{val: initial}})
+		if err != nil {
+			return err
+		}
+		r.hasRun = true
+		parentVids[sync9InitVersion] = true
+
+	} else {
+		for _, parent := range parents {
+			vid := parent.String()
+			if _, known := r.state.T[vid]; known {
+				parentVids[vid] = true
+			} else {
+				// The parent tx didn't touch this resolver's subtree, so we have no
+				// record of its ancestry
+				ancestors, err := r.nearestKnownAncestors(parent)
+				if err != nil {
+					return err
+				}
+				for vid := range ancestors {
+					parentVids[vid] = true
+				}
+			}
+		}
+		if len(parentVids) == 0 {
+			for leaf := range r.state.Leaves {
+				parentVids[leaf] = true
+			}
+		}
+	}
+
+	changes := make([]sync9Change, len(patches))
+	for i, patch := range patches {
+		changes[i] = sync9Change{keys: patch.Keypath.PartStrings(), val: patch.Val}
+		if patch.Range != nil {
+			if patch.Range.Start < 0 || patch.Range.End < patch.Range.Start {
+				return errors.Errorf("sync9 resolver does not support range %v", patch.Range)
+			}
+			changes[i].rng = &[2]int{int(patch.Range.Start), int(patch.Range.End)}
+		}
+	}
+
+	err = r.state.addVersion(txID.String(), parentVids, changes)
+	if err != nil {
+		return err
+	}
+	return state.Set(nil, nil, r.state.read())
+}
+
+func (r *sync9Resolver) SetTxParentsLookup(lookup TxParentsLookup) {
+	r.txParents = lookup
+}
+
+// nearestKnownAncestors walks the tx DAG back from a tx that didn't touch this
+// resolver's subtree to the nearest txs that did.  If none of them did, the tx
+// only saw the subtree as it was before the first tx that we resolved.
+func (r *sync9Resolver) nearestKnownAncestors(txID types.ID) (map[string]bool, error) {
+	if r.txParents == nil {
+		// Without the tx DAG, the best we can do is to assume that the tx had
+		// seen everything that we've seen
+		ancestors := make(map[string]bool, len(r.state.Leaves))
+		for leaf := range r.state.Leaves {
+			ancestors[leaf] = true
+		}
+		return ancestors, nil
+	}
+
+	ancestors := make(map[string]bool)
+	visited := make(map[types.ID]bool)
+	stack := []types.ID{txID}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[id] {
+			continue
+		}
+		visited[id] = true
+
+		if _, known := r.state.T[id.String()]; known {
+			ancestors[id.String()] = true
+			continue
+		}
+		parents, err := r.txParents(id)
+		if errors.Cause(err) == types.Err404 {
+			// The tx has been pruned, so it's behind a snapshot that predates
+			// this resolver
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		stack = append(stack, parents...)
+	}
+	if len(ancestors) == 0 {
+		ancestors[sync9InitVersion] = true
+	}
+	return ancestors, nil
+}
+
+type sync9Change struct {
+	keys []string
+	rng  *[2]int
+	val  interface{}
+}
+
+func (x *sync9State) ancestors(vids map[string]bool) map[string]bool {
+	result := make(map[string]bool)
+	stack := make([]string, 0, len(vids))
+	for vid := range vids {
+		stack = append(stack, vid)
+	}
+	for len(stack) > 0 {
+		vid := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if result[vid] {
+			continue
+		}
+		result[vid] = true
+		for parent := range x.T[vid] {
+			stack = append(stack, parent)
+		}
+	}
+	return result
+}
+
+func (x *sync9State) addVersion(vid string, parents map[string]bool, changes []sync9Change) error {
+	if _, exists := x.T[vid]; exists {
+		return nil
+	}
+	x.T[vid] = parents
+	for parent := range parents {
+		delete(x.Leaves, parent)
+	}
+	x.Leaves[vid] = true
+
+	ancestors := x.ancestors(parents)
+	isAnc := func(v string) bool { return ancestors[v] }
+
+	for _, change := range changes {
+		err := x.applyChange(vid, change, isAnc)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *sync9State) applyChange(vid string, change sync9Change, isAnc func(string) bool) error {
+	cur := x.Val
+	if cur.T == "lit" {
+		cur = &sync9Value{T: "val", S: newSync9Node("", sync9Elems{Vals: []*sync9Value{cur}})}
+		x.Val = cur
+	}
+
+	var prevS *sync9Node
+	var prevI int
+	rng := change.rng
+	val := change.val
+
+	for i, key := range change.keys {
+		if cur.T == "val" {
+			prevS, prevI = cur.S, 0
+			cur = sync9SpaceDagGet(prevS, prevI, isAnc)
+			if cur == nil {
+				cur = &sync9Value{T: "lit"}
+			}
+		}
+
+		if cur.T == "lit" {
+			var converted *sync9Value
+			switch lit := cur.Lit.(type) {
+			case nil:
+				converted = &sync9Value{T: "obj", Obj: make(map[string]*sync9Value)}
+			case map[string]interface{}:
+				converted = sync9MakeObj(lit)
+			case []interface{}:
+				converted = &sync9Value{T: "arr", S: newSync9Node("", sync9Elems{Vals: sync9MakeLits(lit)})}
+			}
+			if converted != nil {
+				if prevS == nil {
+					return errors.Errorf("sync9: bad keypath %v", change.keys)
+				}
+				cur = converted
+				sync9SpaceDagSet(prevS, prevI, cur, isAnc)
+			}
+		}
+
+		if cur.T == "obj" {
+			child := cur.Obj[key]
+			if child == nil || child.T == "lit" {
+				if child == nil {
+					child = &sync9Value{T: "lit"}
+				}
+				child = &sync9Value{T: "val", S: newSync9Node("", sync9Elems{Vals: []*sync9Value{child}})}
+				cur.Obj[key] = child
+			}
+			cur = child
+
+		} else if i == len(change.keys)-1 && rng == nil {
+			idx, err := strconv.ParseUint(key, 10, 64)
+			if err != nil {
+				return errors.Errorf("sync9: bad keypath %v", change.keys)
+			}
+			rng = &[2]int{int(idx), int(idx) + 1}
+			if cur.T != "str" {
+				val = []interface{}{val}
+			}
+
+		} else if cur.T == "arr" {
+			idx, err := strconv.ParseUint(key, 10, 64)
+			if err != nil {
+				return errors.Errorf("sync9: bad keypath %v", change.keys)
+			}
+			prevS, prevI = cur.S, int(idx)
+			cur = sync9SpaceDagGet(prevS, prevI, isAnc)
+			if cur == nil {
+				return errors.Errorf("sync9: bad keypath %v", change.keys)
+			}
+
+		} else {
+			return errors.Errorf("sync9: bad keypath %v", change.keys)
+		}
+	}
+
+	if rng == nil {
+		if cur.T != "val" {
+			return errors.Errorf("sync9: bad keypath %v", change.keys)
+		}
+		length := sync9SpaceDagLength(cur.S, isAnc)
+		return sync9SpaceDagAddVersion(cur.S, vid, []sync9Splice{{
+			start:  0,
+			count:  length,
+			insert: &sync9Elems{Vals: []*sync9Value{sync9MakeLit(val)}},
+		}}, isAnc)
+	}
+
+	if cur.T == "val" {
+		prevS, prevI = cur.S, 0
+		cur = sync9SpaceDagGet(prevS, prevI, isAnc)
+		if cur == nil {
+			return errors.Errorf("sync9: bad keypath %v", change.keys)
+		}
+	}
+	if cur.T == "lit" {
+		switch lit := cur.Lit.(type) {
+		case string:
+			cur = &sync9Value{T: "str", S: newSync9Node("", sync9Elems{IsStr: true, Str: []rune(lit)})}
+		case []interface{}:
+			cur = &sync9Value{T: "arr", S: newSync9Node("", sync9Elems{Vals: sync9MakeLits(lit)})}
+		default:
+			return errors.Errorf("sync9: cannot splice into value of type %T", cur.Lit)
+		}
+		if prevS == nil {
+			return errors.Errorf("sync9: bad keypath %v", change.keys)
+		}
+		sync9SpaceDagSet(prevS, prevI, cur, isAnc)
+	}
+
+	splice := sync9Splice{start: rng[0], count: rng[1] - rng[0]}
+	switch cur.T {
+	case "str":
+		switch v := val.(type) {
+		case nil:
+		case string:
+			if len(v) > 0 {
+				splice.insert = &sync9Elems{IsStr: true, Str: []rune(v)}
+			}
+		default:
+			return errors.Errorf("sync9: cannot splice %T into a string", val)
+		}
+	case "arr":
+		switch v := val.(type) {
+		case nil:
+		case []interface{}:
+			if len(v) > 0 {
+				splice.insert = &sync9Elems{Vals: sync9MakeLits(v)}
+			}
+		default:
+			return errors.Errorf("sync9: cannot splice %T into an array", val)
+		}
+	default:
+		return errors.Errorf("sync9: bad keypath %v", change.keys)
+	}
+	if splice.count == 0 && splice.insert == nil {
+		return nil
+	}
+	return sync9SpaceDagAddVersion(cur.S, vid, []sync9Splice{splice}, isAnc)
+}
+
+func (x *sync9State) read() interface{} {
+	return sync9Read(x.Val)
+}
+
+func sync9Read(x *sync9Value) interface{} {
+	if x == nil {
+		return nil
+	}
+	switch x.T {
+	case "lit":
+		return x.Lit
+	case "val":
+		return sync9Read(sync9SpaceDagGet(x.S, 0, nil))
+	case "obj":
+		obj := make(map[string]interface{}, len(x.Obj))
+		for k, v := range x.Obj {
+			val := sync9Read(v)
+			if val != nil {
+				obj[k] = val
+			}
+		}
+		return obj
+	case "arr":
+		arr := []interface{}{}
+		sync9TravSpaceDag(x.S, nil, func(node *sync9Node, _ int, _ bool, _ *sync9Node, _ string) bool {
+			for _, v := range node.Elems.Vals {
+				arr = append(arr, sync9Read(v))
+			}
+			return true
+		}, false)
+		return arr
+	case "str":
+		var runes []rune
+		sync9TravSpaceDag(x.S, nil, func(node *sync9Node, _ int, _ bool, _ *sync9Node, _ string) bool {
+			runes = append(runes, node.Elems.Str...)
+			return true
+		}, false)
+		return string(runes)
+	}
+	return nil
+}
+
+func sync9MakeLit(x interface{}) *sync9Value {
+	return &sync9Value{T: "lit", Lit: x}
+}
+
+func sync9MakeLits(xs []interface{}) []*sync9Value {
+	vals := make([]*sync9Value, len(xs))
+	for i := range xs {
+		vals[i] = sync9MakeLit(xs[i])
+	}
+	return vals
+}
+
+func sync9MakeObj(m map[string]interface{}) *sync9Value {
+	obj := &sync9Value{T: "obj", Obj: make(map[string]*sync9Value, len(m))}
+	for k, v := range m {
+		obj.Obj[k] = sync9MakeLit(v)
+	}
+	return obj
+}
+
+func newSync9Node(vid string, elems sync9Elems) *sync9Node {
+	return &sync9Node{
+		Vid:       vid,
+		Elems:     elems,
+		DeletedBy: make(map[string]bool),
+	}
+}
+
+func (e sync9Elems) length() int {
+	if e.IsStr {
+		return len(e.Str)
+	}
+	return len(e.Vals)
+}
+
+func (e sync9Elems) slice(start, end int) sync9Elems {
+	if e.IsStr {
+		return sync9Elems{IsStr: true, Str: append([]rune(nil), e.Str[start:end]...)}
+	}
+	return sync9Elems{Vals: append([]*sync9Value(nil), e.Vals[start:end]...)}
+}
+
+func sync9SpaceDagGet(s *sync9Node, i int, isAnc func(string) bool) *sync9Value {
+	var found *sync9Value
+	offset := 0
+	sync9TravSpaceDag(s, isAnc, func(node *sync9Node, _ int, _ bool, _ *sync9Node, _ string) bool {
+		if i-offset < node.Elems.length() {
+			if !node.Elems.IsStr {
+				found = node.Elems.Vals[i-offset]
+			}
+			return false
+		}
+		offset += node.Elems.length()
+		return true
+	}, false)
+	return found
+}
+
+func sync9SpaceDagSet(s *sync9Node, i int, v *sync9Value, isAnc func(string) bool) {
+	offset := 0
+	sync9TravSpaceDag(s, isAnc, func(node *sync9Node, _ int, _ bool, _ *sync9Node, _ string) bool {
+		if i-offset < node.Elems.length() {
+			node.Elems.Vals[i-offset] = v
+			return false
+		}
+		offset += node.Elems.length()
+		return true
+	}, false)
+}
+
+func sync9SpaceDagLength(s *sync9Node, isAnc func(string) bool) int {
+	count := 0
+	sync9TravSpaceDag(s, isAnc, func(node *sync9Node, _ int, _ bool, _ *sync9Node, _ string) bool {
+		count += node.Elems.length()
+		return true
+	}, false)
+	return count
+}
+
+func sync9SpaceDagBreakNode(node *sync9Node, x int, endCap bool, newNext *sync9Node) *sync9Node {
+	tail := newSync9Node("", node.Elems.slice(x, node.Elems.length()))
+	tail.EndCap = node.EndCap
+	for vid := range node.DeletedBy {
+		tail.DeletedBy[vid] = true
+	}
+	tail.Nexts = node.Nexts
+	tail.Next = node.Next
+
+	node.Elems = node.Elems.slice(0, x)
+	node.EndCap = endCap
+	if endCap {
+		tail.Gash = true
+	}
+	if newNext != nil {
+		node.Nexts = []*sync9Node{newNext}
+	} else {
+		node.Nexts = nil
+	}
+	node.Next = tail
+	return tail
+}
+
+func sync9AddToNexts(nexts *[]*sync9Node, n *sync9Node) {
+	i := sort.Search(len(*nexts), func(i int) bool { return (*nexts)[i].Vid >= n.Vid })
+	*nexts = append(*nexts, nil)
+	copy((*nexts)[i+1:], (*nexts)[i:])
+	(*nexts)[i] = n
+}
+
+func sync9SpaceDagAddVersion(s *sync9Node, vid string, splices []sync9Splice, isAnc func(string) bool) error {
+	var (
+		si         int
+		deleteUpTo int
+		offset     int
+		err        error
+	)
+
+	cb := func(node *sync9Node, hasNexts bool, prev *sync9Node, deleted bool) bool {
+		if si >= len(splices) {
+			return false
+		}
+		s := splices[si]
+		nodeLen := node.Elems.length()
+
+		if deleted {
+			if s.count == 0 && s.start == offset {
+				if nodeLen == 0 && !node.EndCap && hasNexts {
+					return true
+				}
+				newNode := newSync9Node(vid, *s.insert)
+				if nodeLen == 0 && !node.EndCap {
+					sync9AddToNexts(&node.Nexts, newNode)
+				} else {
+					sync9SpaceDagBreakNode(node, 0, false, newNode)
+				}
+				si++
+			}
+			return true
+		}
+
+		if s.count == 0 {
+			d := s.start - (offset + nodeLen)
+			if d > 0 {
+				return true
+			}
+			if d == 0 && !node.EndCap && hasNexts {
+				return true
+			}
+			newNode := newSync9Node(vid, *s.insert)
+			if d == 0 && !node.EndCap {
+				sync9AddToNexts(&node.Nexts, newNode)
+			} else {
+				sync9SpaceDagBreakNode(node, s.start-offset, false, newNode)
+			}
+			si++
+			return true
+		}
+
+		if deleteUpTo <= offset {
+			d := s.start - (offset + nodeLen)
+			if d >= 0 {
+				return true
+			}
+			deleteUpTo = s.start + s.count
+
+			if s.insert != nil {
+				newNode := newSync9Node(vid, *s.insert)
+				if s.start == offset && node.Gash {
+					if prev == nil || !prev.EndCap {
+						err = errors.New("sync9: expected end cap")
+						return false
+					}
+					sync9AddToNexts(&prev.Nexts, newNode)
+				} else {
+					sync9SpaceDagBreakNode(node, s.start-offset, true, newNode)
+					return true
+				}
+			} else {
+				if s.start != offset {
+					sync9SpaceDagBreakNode(node, s.start-offset, false, nil)
+					return true
+				}
+			}
+		}
+
+		if deleteUpTo > offset {
+			if deleteUpTo <= offset+nodeLen {
+				if deleteUpTo < offset+nodeLen {
+					sync9SpaceDagBreakNode(node, deleteUpTo-offset, false, nil)
+				}
+				si++
+			}
+			node.DeletedBy[vid] = true
+		}
+		return true
+	}
+
+	var helper func(node *sync9Node, prev *sync9Node, nodeVid string) bool
+	helper = func(node *sync9Node, prev *sync9Node, nodeVid string) bool {
+		hasNexts := false
+		for _, next := range node.Nexts {
+			if isAnc(next.Vid) {
+				hasNexts = true
+				break
+			}
+		}
+		deleted := false
+		for d := range node.DeletedBy {
+			if isAnc(d) {
+				deleted = true
+				break
+			}
+		}
+		if !cb(node, hasNexts, prev, deleted) {
+			return false
+		}
+		if !deleted {
+			offset += node.Elems.length()
+		}
+		for i := 0; i < len(node.Nexts); i++ {
+			next := node.Nexts[i]
+			if isAnc(next.Vid) {
+				if !helper(next, nil, next.Vid) {
+					return false
+				}
+			}
+		}
+		if node.Next != nil {
+			return helper(node.Next, node, nodeVid)
+		}
+		return true
+	}
+	helper(s, nil, s.Vid)
+	return err
+}
+
+// sync9TravSpaceDag walks the nodes of a space DAG that are visible from the
+// version described by isAnc (or all versions, if isAnc is nil).  Traversal
+// stops as soon as the callback returns false.
+func sync9TravSpaceDag(
+	s *sync9Node,
+	isAnc func(string) bool,
+	cb func(node *sync9Node, offset int, hasNexts bool, prev *sync9Node, vid string) bool,
+	viewDeleted bool,
+) {
+	if isAnc == nil {
+		isAnc = func(string) bool { return true }
+	}
+	offset := 0
+
+	var helper func(node *sync9Node, prev *sync9Node, vid string) bool
+	helper = func(node *sync9Node, prev *sync9Node, vid string) bool {
+		hasNexts := false
+		for _, next := range node.Nexts {
+			if isAnc(next.Vid) {
+				hasNexts = true
+				break
+			}
+		}
+		deleted := false
+		for d := range node.DeletedBy {
+			if isAnc(d) {
+				deleted = true
+				break
+			}
+		}
+		if viewDeleted || !deleted {
+			if !cb(node, offset, hasNexts, prev, vid) {
+				return false
+			}
+			offset += node.Elems.length()
+		}
+		for _, next := range node.Nexts {
+			if isAnc(next.Vid) {
+				if !helper(next, nil, next.Vid) {
+					return false
+				}
+			}
+		}
+		if node.Next != nil {
+			return helper(node.Next, node, vid)
+		}
+		return true
+	}
+	helper(s, nil, s.Vid)
+}
@@ -0,0 +1,130 @@
+package redwood
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"redwood.dev/tree"
+	"redwood.dev/types"
+)
+
+func mustParsePatches(t *testing.T, strs ...string) []Patch {
+	t.Helper()
+	var patches []Patch
+	for _, s := range strs {
+		p, err := ParsePatch([]byte(s))
+		require.NoError(t, err)
+		patches = append(patches, p)
+	}
+	return patches
+}
+
+type sync9TestTx struct {
+	id      types.ID
+	parents []types.ID
+	patches []Patch
+}
+
+func resolveSync9(t *testing.T, txs []sync9TestTx) interface{} {
+	t.Helper()
+
+	resolver, err := NewSync9Resolver(nil, nil)
+	require.NoError(t, err)
+
+	state := tree.NewMemoryNode()
+	for _, tx := range txs {
+		err := resolver.ResolveState(state, nil, types.Address{}, tx.id, tx.parents, tx.patches)
+		require.NoError(t, err)
+	}
+	val, _, err := state.Value(nil, nil)
+	require.NoError(t, err)
+	return val
+}
+
+func TestSync9Resolver_ConcurrentStringInserts(t *testing.T) {
+	tx1 := sync9TestTx{types.IDFromString("one"), []types.ID{GenesisTxID}, mustParsePatches(t, `.text = "abc"`)}
+	tx2 := sync9TestTx{types.IDFromString("two"), []types.ID{tx1.id}, mustParsePatches(t, `.text[1:1] = "X"`)}
+	tx3 := sync9TestTx{types.IDFromString("three"), []types.ID{tx1.id}, mustParsePatches(t, `.text[2:3] = "YZ"`)}
+
+	a := resolveSync9(t, []sync9TestTx{tx1, tx2, tx3})
+	b := resolveSync9(t, []sync9TestTx{tx1, tx3, tx2})
+
+	require.Equal(t, map[string]interface{}{"text": "aXbYZ"}, a)
+	require.Equal(t, a, b)
+}
+
+func TestSync9Resolver_ConcurrentArrayEdits(t *testing.T) {
+	tx1 := sync9TestTx{types.IDFromString("one"), []types.ID{GenesisTxID}, mustParsePatches(t, `.msgs = [1, 2, 3]`)}
+	tx2 := sync9TestTx{types.IDFromString("two"), []types.ID{tx1.id}, mustParsePatches(t, `.msgs[3:3] = [4]`)}
+	tx3 := sync9TestTx{types.IDFromString("three"), []types.ID{tx1.id}, mustParsePatches(t, `.msgs[0:1] = []`)}
+	tx4 := sync9TestTx{types.IDFromString("four"), []types.ID{tx2.id, tx3.id}, mustParsePatches(t, `.other = "x"`)}
+
+	a := resolveSync9(t, []sync9TestTx{tx1, tx2, tx3, tx4})
+	b := resolveSync9(t, []sync9TestTx{tx1, tx3, tx2, tx4})
+
+	require.Equal(t, map[string]interface{}{
+		"msgs":  []interface{}{float64(2), float64(3), float64(4)},
+		"other": "x",
+	}, a)
+	require.Equal(t, a, b)
+}
+
+func TestSync9Resolver_UnknownParents(t *testing.T) {
+	// "two" doesn't touch the resolver's subtree, so the resolver never sees it
+	txParents := map[types.ID][]types.ID{
+		types.IDFromString("two"): {types.IDFromString("one")},
+	}
+	lookup := func(txID types.ID) ([]types.ID, error) {
+		parents, exists := txParents[txID]
+		if !exists {
+			return nil, types.Err404
+		}
+		return parents, nil
+	}
+
+	tx1 := sync9TestTx{types.IDFromString("one"), []types.ID{GenesisTxID}, mustParsePatches(t, `.text = "abc"`)}
+	tx3 := sync9TestTx{types.IDFromString("three"), []types.ID{tx1.id}, mustParsePatches(t, `.text[1:1] = "X"`)}
+	tx4 := sync9TestTx{types.IDFromString("four"), []types.ID{types.IDFromString("two")}, mustParsePatches(t, `.text[2:2] = "Y"`)}
+
+	resolve := func(txs []sync9TestTx) interface{} {
+		resolver, err := NewSync9Resolver(nil, nil)
+		require.NoError(t, err)
+		resolver.(TxDAGResolver).SetTxParentsLookup(lookup)
+
+		state := tree.NewMemoryNode()
+		for _, tx := range txs {
+			err := resolver.ResolveState(state, nil, types.Address{}, tx.id, tx.parents, tx.patches)
+			require.NoError(t, err)
+		}
+		val, _, err := state.Value(nil, nil)
+		require.NoError(t, err)
+		return val
+	}
+
+	// "four" is concurrent with "three" no matter which arrives first
+	a := resolve([]sync9TestTx{tx1, tx3, tx4})
+	b := resolve([]sync9TestTx{tx1, tx4, tx3})
+
+	require.Equal(t, map[string]interface{}{"text": "aXbYc"}, a)
+	require.Equal(t, a, b)
+}
+
+func TestSync9Resolver_InternalStateRoundtrip(t *testing.T) {
+	resolver, err := NewSync9Resolver(nil, nil)
+	require.NoError(t, err)
+
+	state := tree.NewMemoryNode()
+	err = resolver.ResolveState(state, nil, types.Address{}, types.IDFromString("one"), []types.ID{GenesisTxID}, mustParsePatches(t, `.text = "hello"`))
+	require.NoError(t, err)
+
+	restored, err := NewSync9Resolver(nil, resolver.InternalState())
+	require.NoError(t, err)
+
+	err = restored.ResolveState(state, nil, types.Address{}, types.IDFromString("two"), []types.ID{types.IDFromString("one")}, mustParsePatches(t, `.text[5:5] = " world"`))
+	require.NoError(t, err)
+
+	val, _, err := state.Value(tree.Keypath("text"), nil)
+	require.NoError(t, err)
+	require.Equal(t, "hello world", val)
+}
@@ -0,0 +1,128 @@
+package redwood
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"redwood.dev/tree"
+	"redwood.dev/types"
+	"redwood.dev/utils"
+)
+
+type wasmResolver struct {
+	module        *wasmModule
+	internalState map[string]interface{}
+}
+
+// Ensure wasmResolver conforms to the Resolver interface
+var _ Resolver = (*wasmResolver)(nil)
+
+// NewWASMResolver loads a resolver from the WebAssembly module in the config's
+// 'src' param.  See wasmModule for the host ABI.  The module must export:
+//
+//	resolve_state(args_ptr, args_len i32) i32
+//	    Called with a JSON object of the form
+//	    {"sender": "...", "txID": "...", "parents": ["..."], "patches": [{"keys": [...], "range": [start, end], "val": ...}]}.
+//	    The module applies the patches using state_get/state_set/state_delete
+//	    and returns 0 on success.
+//
+// and may optionally export:
+//
+//	init(state_ptr, state_len i32)
+//	    Called once with the JSON-encoded internal state from the last run.
+//	internal_state() i64
+//	    Returns the JSON-encoded internal state to persist.
+func NewWASMResolver(config tree.Node, internalState map[string]interface{}) (_ Resolver, err error) {
+	defer utils.Annotate(&err, "NewWASMResolver")
+
+	module, err := newWASMModule("resolver", config)
+	if err != nil {
+		return nil, err
+	}
+	if !module.hasExport("resolve_state") {
+		module.Close()
+		return nil, errors.New("wasm resolver must export a 'resolve_state' function")
+	}
+
+	if module.hasExport("init") {
+		internalStateBytes, err := json.Marshal(internalState)
+		if err != nil {
+			module.Close()
+			return nil, errors.WithStack(err)
+		}
+		_, err = module.call("init", nil, true, internalStateBytes)
+		if err != nil {
+			module.Close()
+			return nil, err
+		}
+	}
+	return &wasmResolver{module: module, internalState: internalState}, nil
+}
+
+func (r *wasmResolver) InternalState() map[string]interface{} {
+	if !r.module.hasExport("internal_state") {
+		return r.internalState
+	}
+
+	packed, err := r.module.call("internal_state", nil, true, nil)
+	if err != nil {
+		r.module.Errorf("error fetching internal state: %v", err)
+		return r.internalState
+	}
+	bs, err := r.module.readPacked(packed)
+	if err != nil {
+		r.module.Errorf("error fetching internal state: %v", err)
+		return r.internalState
+	} else if len(bs) == 0 {
+		return map[string]interface{}{}
+	}
+
+	var internalState map[string]interface{}
+	err = json.Unmarshal(bs, &internalState)
+	if err != nil {
+		r.module.Errorf("error decoding internal state: %v", err)
+		return r.internalState
+	}
+	r.internalState = internalState
+	return internalState
+}
+
+func (r *wasmResolver) ResolveState(state tree.Node, refStore RefStore, sender types.Address, txID types.ID, parents []types.ID, patches []Patch) (err error) {
+	defer utils.Annotate(&err, "wasmResolver.ResolveState")
+
+	convertedPatches := make([]interface{}, len(patches))
+	for i, patch := range patches {
+		convertedPatch := map[string]interface{}{
+			"keys": patch.Keypath.PartStrings(),
+			"val":  patch.Val,
+		}
+		if patch.Range != nil {
+			convertedPatch["range"] = []interface{}{patch.Range.Start, patch.Range.End}
+		}
+		convertedPatches[i] = convertedPatch
+	}
+
+	parentStrs := make([]string, len(parents))
+	for i := range parents {
+		parentStrs[i] = parents[i].String()
+	}
+
+	args, err := json.Marshal(map[string]interface{}{
+		"sender":  sender.String(),
+		"txID":    txID.String(),
+		"parents": parentStrs,
+		"patches": convertedPatches,
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	ret, err := r.module.call("resolve_state", state, false, args)
+	if err != nil {
+		return err
+	} else if ret != 0 {
+		return errors.Errorf("wasm resolver returned %v", ret)
+	}
+	return nil
+}
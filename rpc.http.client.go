@@ -120,6 +120,43 @@ func (c *HTTPRPCClient) KnownStateURIs() ([]string, error) {
 	return resp.StateURIs, c.rpcClient.Call("RPC.KnownStateURIs", nil, &resp)
 }
 
+func (c *HTTPRPCClient) Versions(args RPCVersionsArgs) ([]RPCVersion, error) {
+	var resp RPCVersionsResponse
+	return resp.Versions, c.rpcClient.Call("RPC.Versions", args, &resp)
+}
+
+func (c *HTTPRPCClient) CompactHistory(args RPCCompactHistoryArgs) error {
+	return c.rpcClient.Call("RPC.CompactHistory", args, nil)
+}
+
 func (c *HTTPRPCClient) SendTx(args RPCSendTxArgs) error {
 	return c.rpcClient.Call("RPC.SendTx", args, nil)
 }
+
+func (c *HTTPRPCClient) SimulateTx(args RPCSimulateTxArgs) (*TxSimulation, error) {
+	var resp RPCSimulateTxResponse
+	err := c.rpcClient.Call("RPC.SimulateTx", args, &resp)
+	return resp.Simulation, err
+}
+
+func (c *HTTPRPCClient) ProposeTx(args RPCProposeTxArgs) (Tx, error) {
+	var resp RPCProposeTxResponse
+	err := c.rpcClient.Call("RPC.ProposeTx", args, &resp)
+	return resp.Tx, err
+}
+
+func (c *HTTPRPCClient) PendingTx(args RPCPendingTxArgs) (Tx, error) {
+	var resp RPCPendingTxResponse
+	err := c.rpcClient.Call("RPC.PendingTx", args, &resp)
+	return resp.Tx, err
+}
+
+func (c *HTTPRPCClient) CoSignTx(args RPCCoSignTxArgs) (Tx, error) {
+	var resp RPCCoSignTxResponse
+	err := c.rpcClient.Call("RPC.CoSignTx", args, &resp)
+	return resp.Tx, err
+}
+
+func (c *HTTPRPCClient) SendPendingTx(args RPCSendPendingTxArgs) error {
+	return c.rpcClient.Call("RPC.SendPendingTx", args, nil)
+}
@@ -61,10 +61,11 @@ func NewHTTPRPCServer(host Host) *HTTPRPCServer {
 
 type (
 	RPCSubscribeArgs struct {
-		StateURI string
-		Txs      bool
-		States   bool
-		Keypath  string
+		StateURI   string
+		Txs        bool
+		States     bool
+		StateDiffs bool
+		Keypath    string
 	}
 	RPCSubscribeResponse struct{}
 )
@@ -83,6 +84,9 @@ func (s *HTTPRPCServer) Subscribe(r *http.Request, args *RPCSubscribeArgs, resp
 	if args.States {
 		subscriptionType |= SubscriptionType_States
 	}
+	if args.StateDiffs {
+		subscriptionType |= SubscriptionType_StateDiffs
+	}
 
 	sub, err := s.host.Subscribe(ctx, args.StateURI, subscriptionType, tree.Keypath(args.Keypath), nil)
 	if err != nil {
@@ -161,6 +165,50 @@ func (s *HTTPRPCServer) KnownStateURIs(r *http.Request, args *RPCKnownStateURIsA
 	return nil
 }
 
+type (
+	RPCVersionsArgs struct {
+		StateURI string
+	}
+	RPCVersionsResponse struct {
+		Versions []RPCVersion
+	}
+	RPCVersion struct {
+		Version types.ID
+		SavedAt time.Time
+		NumKeys uint64
+		Size    int64
+	}
+)
+
+func (s *HTTPRPCServer) Versions(r *http.Request, args *RPCVersionsArgs, resp *RPCVersionsResponse) error {
+	if args.StateURI == "" {
+		return errors.New("missing StateURI")
+	}
+	versions, err := s.host.Controllers().Versions(args.StateURI)
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		resp.Versions = append(resp.Versions, RPCVersion{v.Version, v.SavedAt, v.NumKeys, v.Size})
+	}
+	return nil
+}
+
+type (
+	RPCCompactHistoryArgs struct {
+		StateURI string
+		TxID     types.ID
+	}
+	RPCCompactHistoryResponse struct{}
+)
+
+func (s *HTTPRPCServer) CompactHistory(r *http.Request, args *RPCCompactHistoryArgs, resp *RPCCompactHistoryResponse) error {
+	if args.StateURI == "" {
+		return errors.New("missing StateURI")
+	}
+	return s.host.CompactHistory(args.StateURI, args.TxID)
+}
+
 type (
 	RPCSendTxArgs struct {
 		Tx Tx
@@ -172,6 +220,106 @@ func (s *HTTPRPCServer) SendTx(r *http.Request, args *RPCSendTxArgs, resp *RPCSe
 	return s.host.SendTx(context.Background(), args.Tx)
 }
 
+type (
+	RPCSimulateTxArgs struct {
+		Tx      Tx
+		Keypath string
+	}
+	RPCSimulateTxResponse struct {
+		Simulation *TxSimulation
+	}
+)
+
+func (s *HTTPRPCServer) SimulateTx(r *http.Request, args *RPCSimulateTxArgs, resp *RPCSimulateTxResponse) error {
+	if args.Tx.StateURI == "" {
+		return errors.New("missing StateURI")
+	}
+	sim, err := s.host.Controllers().SimulateTx(&args.Tx, tree.Keypath(args.Keypath))
+	if err != nil {
+		return err
+	}
+	resp.Simulation = sim
+	return nil
+}
+
+type (
+	RPCProposeTxArgs struct {
+		Tx Tx
+	}
+	RPCProposeTxResponse struct {
+		Tx Tx
+	}
+)
+
+func (s *HTTPRPCServer) ProposeTx(r *http.Request, args *RPCProposeTxArgs, resp *RPCProposeTxResponse) error {
+	tx, err := s.host.ProposeTx(args.Tx)
+	if err != nil {
+		return err
+	}
+	resp.Tx = tx
+	return nil
+}
+
+type (
+	RPCPendingTxArgs struct {
+		StateURI string
+		TxID     types.ID
+	}
+	RPCPendingTxResponse struct {
+		Tx Tx
+	}
+)
+
+func (s *HTTPRPCServer) PendingTx(r *http.Request, args *RPCPendingTxArgs, resp *RPCPendingTxResponse) error {
+	if args.StateURI == "" {
+		return errors.New("missing StateURI")
+	}
+	tx, err := s.host.PendingTx(args.StateURI, args.TxID)
+	if err != nil {
+		return err
+	}
+	resp.Tx = tx
+	return nil
+}
+
+type (
+	RPCCoSignTxArgs struct {
+		StateURI string
+		TxID     types.ID
+		Sig      types.Signature
+	}
+	RPCCoSignTxResponse struct {
+		Tx Tx
+	}
+)
+
+func (s *HTTPRPCServer) CoSignTx(r *http.Request, args *RPCCoSignTxArgs, resp *RPCCoSignTxResponse) error {
+	if args.StateURI == "" {
+		return errors.New("missing StateURI")
+	}
+	tx, err := s.host.CoSignTx(args.StateURI, args.TxID, args.Sig)
+	if err != nil {
+		return err
+	}
+	resp.Tx = tx
+	return nil
+}
+
+type (
+	RPCSendPendingTxArgs struct {
+		StateURI string
+		TxID     types.ID
+	}
+	RPCSendPendingTxResponse struct{}
+)
+
+func (s *HTTPRPCServer) SendPendingTx(r *http.Request, args *RPCSendPendingTxArgs, resp *RPCSendPendingTxResponse) error {
+	if args.StateURI == "" {
+		return errors.New("missing StateURI")
+	}
+	return s.host.SendPendingTx(context.Background(), args.StateURI, args.TxID)
+}
+
 type whitelistMiddleware struct {
 	permittedAddrs          map[types.Address]struct{}
 	nextHandler             http.Handler
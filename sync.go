@@ -0,0 +1,150 @@
+package redwood
+
+import (
+	"github.com/pkg/errors"
+
+	"redwood.dev/types"
+	"redwood.dev/utils"
+)
+
+// Peers that have been apart for a while catch up with one another by
+// exchanging tx locators.  A locator lists a node's leaves followed by a
+// sample of their ancestors that thins out exponentially with depth (the
+// parents of the leaves, then the txs 2, 4, 8, ... steps further back), so it
+// stays small no matter how long the history is.
+//
+// A node that receives a locator walks backwards from its own leaves and
+// stops at every tx in the locator, since the sender has that tx and its
+// entire causal past.  The txs it visited are the ones that the sender is
+// (probably) missing.  Because the samples are spaced exponentially, the walk
+// overshoots the point where the two histories diverged by at most about as
+// far again, so a sync costs bandwidth in proportion to the divergence
+// rather than to the length of the history.  If the walk misses a sample and
+// the sender ends up short of some parents anyway, they're fetched
+// individually (see host.fetchMissingParents).
+//
+// Building a locator means walking back through every depth down to the
+// deepest sample, so the walk stops at maxTxLocatorDepth and the locator ends
+// with the genesis tx instead.  Nodes whose histories diverged further back
+// than that send each other everything since genesis.
+
+const (
+	// maxTxLocatorTxsPerDepth limits how many txs a locator samples at each
+	// depth, so that wide DAGs still produce small locators.
+	maxTxLocatorTxsPerDepth = 8
+	// maxTxLocatorDepth limits how far back a locator samples, so that
+	// building one reads a bounded number of txs no matter how long the
+	// history is.
+	maxTxLocatorDepth = 1024
+)
+
+// buildTxLocator returns a locator describing the valid history that we have
+// for the given state URI.
+func buildTxLocator(txStore TxStore, stateURI string) (_ []types.ID, err error) {
+	defer utils.Annotate(&err, "stateURI=%v", stateURI)
+
+	leaves, err := txStore.Leaves(stateURI)
+	if err != nil {
+		return nil, err
+	}
+
+	locator := append([]types.ID(nil), leaves...)
+	included := make(map[types.ID]bool, len(leaves))
+	for _, txID := range leaves {
+		included[txID] = true
+	}
+
+	level := leaves
+	nextSampledDepth := 1
+	for depth := 1; len(level) > 0 && depth <= maxTxLocatorDepth; depth++ {
+		seen := make(map[types.ID]bool)
+		var nextLevel []types.ID
+		for _, txID := range level {
+			tx, err := txStore.FetchTx(stateURI, txID)
+			if errors.Cause(err) == types.Err404 {
+				// Pruned by a snapshot
+				continue
+			} else if err != nil {
+				return nil, err
+			}
+
+			for _, parentID := range tx.Parents {
+				if !seen[parentID] && len(nextLevel) < maxTxLocatorTxsPerDepth {
+					seen[parentID] = true
+					nextLevel = append(nextLevel, parentID)
+				}
+			}
+		}
+		level = nextLevel
+
+		if depth == nextSampledDepth {
+			for _, txID := range level {
+				if !included[txID] {
+					included[txID] = true
+					locator = append(locator, txID)
+				}
+			}
+			nextSampledDepth *= 2
+		}
+	}
+
+	if len(locator) > 0 && !included[GenesisTxID] {
+		exists, err := txStore.TxExists(stateURI, GenesisTxID)
+		if err != nil {
+			return nil, err
+		} else if exists {
+			locator = append(locator, GenesisTxID)
+		}
+	}
+	return locator, nil
+}
+
+// txsMissingFromLocator returns the valid txs that we have for the given
+// state URI and that the node that sent the locator probably doesn't,
+// ordered such that every tx comes after all of its parents.
+func txsMissingFromLocator(txStore TxStore, stateURI string, locator []types.ID) (_ []*Tx, err error) {
+	defer utils.Annotate(&err, "stateURI=%v", stateURI)
+
+	leaves, err := txStore.Leaves(stateURI)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[types.ID]bool, len(locator)+len(leaves))
+	for _, txID := range locator {
+		seen[txID] = true
+	}
+
+	var missing []*Tx
+	var queue []types.ID
+	for _, txID := range leaves {
+		if !seen[txID] {
+			seen[txID] = true
+			queue = append(queue, txID)
+		}
+	}
+
+	for len(queue) > 0 {
+		txID := queue[0]
+		queue = queue[1:]
+
+		tx, err := txStore.FetchTx(stateURI, txID)
+		if errors.Cause(err) == types.Err404 {
+			// Pruned by a snapshot
+			continue
+		} else if err != nil {
+			return nil, err
+		} else if tx.Status != TxStatusValid {
+			continue
+		}
+		missing = append(missing, tx)
+
+		for _, parentID := range tx.Parents {
+			if !seen[parentID] {
+				seen[parentID] = true
+				queue = append(queue, parentID)
+			}
+		}
+	}
+	return sortTxsCausally(missing), nil
+}
@@ -0,0 +1,145 @@
+package redwood
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"redwood.dev/types"
+)
+
+const testSyncStateURI = "test.dev/sync"
+
+func setupTestSyncTxStore(t *testing.T) (TxStore, func()) {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "redwood-sync-test")
+	require.NoError(t, err)
+
+	txStore := NewBadgerTxStore(dir)
+	err = txStore.Start()
+	require.NoError(t, err)
+
+	return txStore, func() {
+		txStore.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func addTestSyncTx(t *testing.T, txStore TxStore, txID types.ID, parents ...types.ID) {
+	t.Helper()
+
+	err := txStore.AddTx(&Tx{ID: txID, Parents: parents, StateURI: testSyncStateURI, Status: TxStatusValid})
+	require.NoError(t, err)
+
+	for _, parentID := range parents {
+		err = txStore.UnmarkLeaf(testSyncStateURI, parentID)
+		require.NoError(t, err)
+	}
+	err = txStore.MarkLeaf(testSyncStateURI, txID)
+	require.NoError(t, err)
+}
+
+// addTestSyncChain adds a chain of n valid txs to the store, starting from
+// the given parent, and returns their IDs.
+func addTestSyncChain(t *testing.T, txStore TxStore, parent types.ID, prefix string, n int) []types.ID {
+	t.Helper()
+
+	var txIDs []types.ID
+	for i := 0; i < n; i++ {
+		txID := types.IDFromString(fmt.Sprintf("%v-%v", prefix, i))
+		addTestSyncTx(t, txStore, txID, parent)
+		txIDs = append(txIDs, txID)
+		parent = txID
+	}
+	return txIDs
+}
+
+func TestSync_SendsOnlyTheDivergence(t *testing.T) {
+	ours, closeOurs := setupTestSyncTxStore(t)
+	defer closeOurs()
+	theirs, closeTheirs := setupTestSyncTxStore(t)
+	defer closeTheirs()
+
+	// Both nodes share a long history
+	var shared []types.ID
+	for _, txStore := range []TxStore{ours, theirs} {
+		addTestSyncTx(t, txStore, GenesisTxID)
+		shared = append([]types.ID{GenesisTxID}, addTestSyncChain(t, txStore, GenesisTxID, "shared", 500)...)
+	}
+	fork := shared[len(shared)-1]
+
+	// Then each one diverges a little
+	ourNew := addTestSyncChain(t, ours, fork, "ours", 10)
+	addTestSyncChain(t, theirs, fork, "theirs", 3)
+
+	locator, err := buildTxLocator(theirs, testSyncStateURI)
+	require.NoError(t, err)
+	require.Less(t, len(locator), 20)
+
+	missing, err := txsMissingFromLocator(ours, testSyncStateURI, locator)
+	require.NoError(t, err)
+
+	// The walk may overshoot the fork a little, but never by the whole history
+	require.GreaterOrEqual(t, len(missing), len(ourNew))
+	require.Less(t, len(missing), 2*(len(ourNew)+3)+1)
+
+	sent := make(map[types.ID]bool)
+	for _, tx := range missing {
+		for _, parentID := range tx.Parents {
+			// Parents come before their children, unless the other node already has them
+			if !sent[parentID] {
+				require.Contains(t, shared, parentID)
+			}
+		}
+		sent[tx.ID] = true
+	}
+	for _, txID := range ourNew {
+		require.True(t, sent[txID])
+	}
+
+	// A node with no history gets all of it
+	missing, err = txsMissingFromLocator(ours, testSyncStateURI, nil)
+	require.NoError(t, err)
+	require.Len(t, missing, len(shared)+len(ourNew))
+	require.Equal(t, GenesisTxID, missing[0].ID)
+}
+
+// countingTxStore counts the txs fetched from the store it wraps.
+type countingTxStore struct {
+	TxStore
+	fetched int
+}
+
+func (s *countingTxStore) FetchTx(stateURI string, txID types.ID) (*Tx, error) {
+	s.fetched++
+	return s.TxStore.FetchTx(stateURI, txID)
+}
+
+func TestSync_TxLocatorReadsBoundedHistory(t *testing.T) {
+	txStore, closeTxStore := setupTestSyncTxStore(t)
+	defer closeTxStore()
+
+	addTestSyncTx(t, txStore, GenesisTxID)
+	chain := addTestSyncChain(t, txStore, GenesisTxID, "chain", maxTxLocatorDepth+100)
+
+	counting := &countingTxStore{TxStore: txStore}
+	locator, err := buildTxLocator(counting, testSyncStateURI)
+	require.NoError(t, err)
+	require.LessOrEqual(t, counting.fetched, maxTxLocatorDepth)
+
+	// The deepest sample is maxTxLocatorDepth steps back, and then the locator
+	// skips straight to genesis
+	leaf := len(chain) - 1
+	require.Equal(t, chain[leaf], locator[0])
+	require.Equal(t, chain[leaf-maxTxLocatorDepth], locator[len(locator)-2])
+	require.Equal(t, GenesisTxID, locator[len(locator)-1])
+
+	// A node that diverged before the deepest sample still gets everything it's missing
+	missing, err := txsMissingFromLocator(txStore, testSyncStateURI, []types.ID{GenesisTxID})
+	require.NoError(t, err)
+	require.Len(t, missing, len(chain))
+}
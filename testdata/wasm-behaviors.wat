@@ -0,0 +1,102 @@
+;; A module that implements both a wasm resolver and a wasm validator, used by
+;; the tests in wasm-module_test.go.  To rebuild wasm-behaviors.wasm:
+;;
+;;     wat2wasm wasm-behaviors.wat -o wasm-behaviors.wasm
+;;
+;; resolve_state copies the value at "in" to "out", or spins forever if there
+;; is a value at "spin".
+;;
+;; validate_tx rejects the tx with set_error if there is a value at "locked".
+;; Otherwise, if there is a value at "write", it tries to set "written" (which
+;; validators aren't allowed to do).
+(module
+  (import "redwood" "state_get" (func $state_get (param i32 i32) (result i64)))
+  (import "redwood" "state_set" (func $state_set (param i32 i32 i32 i32) (result i32)))
+  (import "redwood" "set_error" (func $set_error (param i32 i32)))
+
+  (memory 1)
+  (global $heap (mut i32) (i32.const 1024))
+
+  (data (i32.const 0) "in")
+  (data (i32.const 8) "out")
+  (data (i32.const 16) "locked")
+  (data (i32.const 32) "written")
+  (data (i32.const 48) "true")
+  (data (i32.const 56) "spin")
+  (data (i32.const 64) "write")
+
+  (func $alloc (param $len i32) (result i32)
+    (local $ptr i32)
+    global.get $heap
+    local.set $ptr
+    global.get $heap
+    local.get $len
+    i32.add
+    global.set $heap
+    local.get $ptr
+  )
+
+  (func $resolve_state (param $args_ptr i32) (param $args_len i32) (result i32)
+    (local $val i64)
+    block
+      i32.const 56
+      i32.const 4
+      call $state_get
+      i64.eqz
+      br_if 0
+      loop
+        br 0
+      end
+    end
+
+    i32.const 0
+    i32.const 2
+    call $state_get
+    local.set $val
+
+    i32.const 8
+    i32.const 3
+    local.get $val
+    i64.const 32
+    i64.shr_u
+    i32.wrap_i64
+    local.get $val
+    i32.wrap_i64
+    call $state_set
+  )
+
+  (func $validate_tx (param $tx_ptr i32) (param $tx_len i32) (result i32)
+    block
+      i32.const 16
+      i32.const 6
+      call $state_get
+      i64.eqz
+      br_if 0
+      i32.const 16
+      i32.const 6
+      call $set_error
+      i32.const 0
+      return
+    end
+
+    block
+      i32.const 64
+      i32.const 5
+      call $state_get
+      i64.eqz
+      br_if 0
+      i32.const 32
+      i32.const 7
+      i32.const 48
+      i32.const 4
+      call $state_set
+      drop
+    end
+    i32.const 0
+  )
+
+  (export "memory" (memory 0))
+  (export "alloc" (func $alloc))
+  (export "resolve_state" (func $resolve_state))
+  (export "validate_tx" (func $validate_tx))
+)
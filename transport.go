@@ -35,6 +35,10 @@ type Peer interface {
 	Subscribe(ctx context.Context, stateURI string) (ReadableSubscription, error)
 	Put(ctx context.Context, tx *Tx, state tree.Node, leaves []types.ID) error
 	Ack(stateURI string, txID types.ID) error
+	FetchTxs(stateURI string, txIDs []types.ID) error
+	SendTxs(txs []Tx) error
+	ReceiveTxs() ([]Tx, error)
+	SyncTxs(stateURI string, locator []types.ID) error
 
 	// Identity/authentication
 	ChallengeIdentity(challengeMsg types.ChallengeMsg) error
@@ -73,6 +77,16 @@ type EncryptedTx struct {
 	RecipientAddress types.Address `json:"recipientAddress"`
 }
 
+type FetchTxsRequest struct {
+	StateURI string     `json:"stateURI"`
+	TxIDs    []types.ID `json:"txIDs"`
+}
+
+type SyncTxsRequest struct {
+	StateURI string     `json:"stateURI"`
+	Locator  []types.ID `json:"locator"`
+}
+
 type FetchHistoryHandler func(stateURI string, parents []types.ID, toVersion types.ID, peer Peer) error
 type AckHandler func(txID types.ID, peer Peer)
 type TxHandler func(tx Tx, peer Peer)
@@ -295,14 +295,18 @@ func (t *httpTransport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				t.serveRedwoodJS(w, r)
 			} else if strings.HasPrefix(r.URL.Path, "/__tx/") {
 				t.serveGetTx(w, r)
+			} else if r.Header.Get("Fetch-Txs") != "" {
+				t.serveFetchTxs(w, r, address)
 			} else {
-				t.serveGetState(w, r)
+				t.serveGetState(w, r, address)
 			}
 		}
 
 	case "POST":
 		if r.Header.Get("Ref") == "true" {
 			t.servePostRef(w, r)
+		} else if r.Header.Get("Sync-Txs") == "true" {
+			t.serveSyncTxs(w, r, address)
 		}
 
 	case "ACK":
@@ -533,7 +537,48 @@ func (t *httpTransport) serveGetTx(w http.ResponseWriter, r *http.Request) {
 	respondJSON(w, tx)
 }
 
-func (t *httpTransport) serveGetState(w http.ResponseWriter, r *http.Request) {
+// Respond to a request from another node for specific txs, usually the
+// missing parents of a tx that it received.
+func (t *httpTransport) serveFetchTxs(w http.ResponseWriter, r *http.Request, address types.Address) {
+	stateURI := r.Header.Get("State-URI")
+	if stateURI == "" {
+		http.Error(w, "missing State-URI header", http.StatusBadRequest)
+		return
+	}
+
+	var txIDs []types.ID
+	for _, txIDStr := range strings.Split(r.Header.Get("Fetch-Txs"), ",") {
+		txID, err := types.IDFromHex(strings.TrimSpace(txIDStr))
+		if err != nil {
+			http.Error(w, "bad Fetch-Txs header", http.StatusBadRequest)
+			return
+		}
+		txIDs = append(txIDs, txID)
+	}
+
+	t.host.HandleFetchTxsReceived(stateURI, txIDs, t.makePeer(w, nil, "", address))
+}
+
+// Respond to a request from another node that wants to catch up with our
+// history.  The body is the node's tx locator (see sync.go).
+func (t *httpTransport) serveSyncTxs(w http.ResponseWriter, r *http.Request, address types.Address) {
+	stateURI := r.Header.Get("State-URI")
+	if stateURI == "" {
+		http.Error(w, "missing State-URI header", http.StatusBadRequest)
+		return
+	}
+
+	var locator []types.ID
+	err := json.NewDecoder(r.Body).Decode(&locator)
+	if err != nil {
+		http.Error(w, "bad tx locator", http.StatusBadRequest)
+		return
+	}
+
+	t.host.HandleSyncTxsReceived(stateURI, locator, t.makePeer(w, nil, "", address))
+}
+
+func (t *httpTransport) serveGetState(w http.ResponseWriter, r *http.Request, address types.Address) {
 
 	keypathStrs := filterEmptyStrings(strings.Split(r.URL.Path[1:], "/"))
 
@@ -567,6 +612,37 @@ func (t *httpTransport) serveGetState(w http.ResponseWriter, r *http.Request) {
 	}
 	keypath = tree.JoinKeypaths(newParts, []byte("/"))
 
+	// Only members can read private state URIs
+	var readers []types.Address
+	if !address.IsZero() {
+		readers = append(readers, address)
+	}
+	isPrivate, err := t.controllerHub.IsPrivate(stateURI)
+	if err == nil && isPrivate {
+		isMember, err := t.controllerHub.IsMember(stateURI, address)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error: %+v", err), http.StatusInternalServerError)
+			return
+		} else if !isMember {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	blame, err := parseBlameParam(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error: %+v", err), http.StatusBadRequest)
+		return
+	} else if blame {
+		historyStart, historyLimit, err := parseBlamePageParams(r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error: %+v", err), http.StatusBadRequest)
+			return
+		}
+		t.serveProvenance(w, stateURI, keypath, readers, historyStart, historyLimit)
+		return
+	}
+
 	var version *types.ID
 	if vstr := r.Header.Get("Version"); vstr != "" {
 		v, err := types.IDFromHex(vstr)
@@ -647,13 +723,41 @@ func (t *httpTransport) serveGetState(w http.ResponseWriter, r *http.Request) {
 	if indexName != "" {
 		// Index query
 
-		// You can specify an index_arg of * in order to fetch the entire index
-		var indexArgKeypath tree.Keypath
-		if indexArg != "*" {
-			indexArgKeypath = tree.Keypath(indexArg)
+		// Indices can contain data from anywhere in the subtree, so the
+		// requester has to be able to read all of it
+		// @@TODO: prune index results instead
+		canRead, err := t.controllerHub.CanRead(stateURI, keypath, readers)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error: %+v", err), http.StatusInternalServerError)
+			return
+		} else if !canRead {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
 		}
 
-		state, err = t.controllerHub.QueryIndex(stateURI, version, keypath, tree.Keypath(indexName), indexArgKeypath, rng)
+		rangeQuery, err := parseIndexRangeParams(r)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("error: %+v", err), http.StatusBadRequest)
+			return
+		}
+
+		if rangeQuery != nil {
+			// Sorted range scan over the index's keys
+			var cursor string
+			state, cursor, err = t.controllerHub.QueryIndexRange(stateURI, version, keypath, tree.Keypath(indexName), *rangeQuery)
+			if cursor != "" {
+				w.Header().Set("Index-Cursor", cursor)
+			}
+
+		} else {
+			// You can specify an index_arg of * in order to fetch the entire index
+			var indexArgKeypath tree.Keypath
+			if indexArg != "*" {
+				indexArgKeypath = tree.Keypath(indexArg)
+			}
+
+			state, err = t.controllerHub.QueryIndex(stateURI, version, keypath, tree.Keypath(indexName), indexArgKeypath, rng)
+		}
 		if errors.Cause(err) == types.Err404 {
 			http.Error(w, fmt.Sprintf("not found: %+v", err), http.StatusNotFound)
 			return
@@ -672,9 +776,18 @@ func (t *httpTransport) serveGetState(w http.ResponseWriter, r *http.Request) {
 		defer state.Close()
 
 		if raw {
-			state = state.NodeAt(keypath, rng)
+			state, err = t.controllerHub.ReadableState(stateURI, state.NodeAt(keypath, rng), keypath, readers)
+			if errors.Cause(err) == types.Err403 {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			} else if err != nil {
+				http.Error(w, fmt.Sprintf("error: %+v", err), http.StatusInternalServerError)
+				return
+			}
 
 		} else {
+			stateKeypath := keypath
+
 			var exists bool
 			state, exists, err = nelson.Seek(state, keypath, t.controllerHub)
 			if err != nil {
@@ -697,6 +810,17 @@ func (t *httpTransport) serveGetState(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 
+			// Hide anything that the requester isn't allowed to read before
+			// resolving any refs
+			state, err = t.controllerHub.ReadableState(stateURI, state, stateKeypath, readers)
+			if errors.Cause(err) == types.Err403 {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			} else if err != nil {
+				http.Error(w, fmt.Sprintf("error: %+v", err), http.StatusInternalServerError)
+				return
+			}
+
 			state, anyMissing, err = nelson.Resolve(state, t.controllerHub)
 			if err != nil {
 				http.Error(w, fmt.Sprintf("error: %+v", err), http.StatusInternalServerError)
@@ -787,6 +911,30 @@ func (t *httpTransport) serveGetState(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// serveProvenance responds with the txs that touched a keypath (see
+// Controller.Provenance), for GET requests with ?blame=true.
+func (t *httpTransport) serveProvenance(w http.ResponseWriter, stateURI string, keypath tree.Keypath, readers []types.Address, historyStart, historyLimit uint64) {
+	canRead, err := t.controllerHub.CanRead(stateURI, keypath, readers)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error: %+v", err), http.StatusInternalServerError)
+		return
+	} else if !canRead {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	provenance, err := t.controllerHub.Provenance(stateURI, keypath, historyStart, historyLimit)
+	if errors.Cause(err) == types.Err404 {
+		http.Error(w, fmt.Sprintf("not found: %+v", err), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("error: %+v", err), http.StatusInternalServerError)
+		return
+	}
+
+	respondJSON(w, provenance)
+}
+
 func (t *httpTransport) serveAck(w http.ResponseWriter, r *http.Request, address types.Address) {
 	defer r.Body.Close()
 
@@ -877,11 +1025,16 @@ func (t *httpTransport) servePostTx(w http.ResponseWriter, r *http.Request, addr
 
 	var err error
 
+	// A simulated tx doesn't have to be signed yet
+	simulate := r.Header.Get("Simulate") == "true"
+
 	var sig types.Signature
 	sigHeaderStr := r.Header.Get("Signature")
 	if sigHeaderStr == "" {
-		http.Error(w, "missing Signature header", http.StatusBadRequest)
-		return
+		if !simulate {
+			http.Error(w, "missing Signature header", http.StatusBadRequest)
+			return
+		}
 	} else {
 		sig, err = types.SignatureFromHex(sigHeaderStr)
 		if err != nil {
@@ -921,6 +1074,27 @@ func (t *httpTransport) servePostTx(w http.ResponseWriter, r *http.Request, addr
 		checkpoint = true
 	}
 
+	var capabilities []CapabilityToken
+	if capabilitiesStr := r.Header.Get("Capabilities"); capabilitiesStr != "" {
+		err = json.Unmarshal([]byte(capabilitiesStr), &capabilities)
+		if err != nil {
+			http.Error(w, "bad Capabilities header", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var coSigs []types.Signature
+	if coSigsStr := r.Header.Get("Co-Signatures"); coSigsStr != "" {
+		for _, sigStr := range strings.Split(coSigsStr, ",") {
+			coSig, err := types.SignatureFromHex(strings.TrimSpace(sigStr))
+			if err != nil {
+				http.Error(w, "bad Co-Signatures header", http.StatusBadRequest)
+				return
+			}
+			coSigs = append(coSigs, coSig)
+		}
+	}
+
 	stateURI := r.Header.Get("State-URI")
 	if stateURI == "" {
 		stateURI = t.defaultStateURI
@@ -982,13 +1156,25 @@ func (t *httpTransport) servePostTx(w http.ResponseWriter, r *http.Request, addr
 	}
 
 	tx := Tx{
-		ID:         txID,
-		Parents:    parents,
-		Sig:        sig,
-		Patches:    patches,
-		Attachment: attachment,
-		StateURI:   stateURI,
-		Checkpoint: checkpoint,
+		ID:           txID,
+		Parents:      parents,
+		Sig:          sig,
+		Patches:      patches,
+		Attachment:   attachment,
+		StateURI:     stateURI,
+		Checkpoint:   checkpoint,
+		Capabilities: capabilities,
+		CoSigs:       coSigs,
+	}
+
+	if simulate && len(sig) == 0 {
+		if address.IsZero() {
+			http.Error(w, "unsigned txs can only be simulated by authenticated peers", http.StatusUnauthorized)
+			return
+		}
+		tx.From = address
+		t.serveSimulateTx(w, r, &tx, address)
+		return
 	}
 
 	// @@TODO: remove .From entirely
@@ -1000,10 +1186,70 @@ func (t *httpTransport) servePostTx(w http.ResponseWriter, r *http.Request, addr
 	tx.From = pubkey.Address()
 	////////////////////////////////
 
+	if simulate {
+		t.serveSimulateTx(w, r, &tx, address)
+		return
+	}
+
 	peer := t.makePeer(w, nil, "", address)
 	go t.host.HandleTxReceived(tx, peer)
 }
 
+// serveSimulateTx responds with what would happen if the tx were applied,
+// including the subtree at the Keypath header as it would be afterwards.
+func (t *httpTransport) serveSimulateTx(w http.ResponseWriter, r *http.Request, tx *Tx, address types.Address) {
+	keypath := tree.Keypath(r.Header.Get("Keypath"))
+
+	sim, err := t.controllerHub.SimulateTx(tx, keypath)
+	if errors.Cause(err) == ErrNoController {
+		http.Error(w, fmt.Sprintf("not found: %+v", err), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, fmt.Sprintf("error: %+v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var readers []types.Address
+	if !address.IsZero() {
+		readers = append(readers, address)
+	}
+
+	// Don't reveal changes that the peer couldn't read anyway
+	readable := func(keypaths []tree.Keypath) ([]tree.Keypath, error) {
+		var filtered []tree.Keypath
+		for _, kp := range keypaths {
+			canRead, err := t.controllerHub.CanRead(tx.StateURI, kp, readers)
+			if err != nil {
+				return nil, err
+			} else if canRead {
+				filtered = append(filtered, kp)
+			}
+		}
+		return filtered, nil
+	}
+	sim.Added, err = readable(sim.Added)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error: %+v", err), http.StatusInternalServerError)
+		return
+	}
+	sim.Removed, err = readable(sim.Removed)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error: %+v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if sim.State != nil {
+		sim.State, err = t.controllerHub.ReadableState(tx.StateURI, sim.State, keypath, readers)
+		if errors.Cause(err) == types.Err403 {
+			sim.State = nil
+		} else if err != nil {
+			http.Error(w, fmt.Sprintf("error: %+v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+	respondJSON(w, sim)
+}
+
 func (t *httpTransport) makeAltSvcHeader(peerDialInfos []PeerDialInfo) string {
 	var others []string
 	for _, tuple := range peerDialInfos {
@@ -1024,12 +1270,111 @@ func parseRawParam(r *http.Request) (bool, error) {
 	return raw, nil
 }
 
+func parseBlameParam(r *http.Request) (bool, error) {
+	blameStr := r.URL.Query().Get("blame")
+	if blameStr == "" {
+		return false, nil
+	}
+	blame, err := strconv.ParseBool(blameStr)
+	if err != nil {
+		return false, errors.New("invalid blame param")
+	}
+	return blame, nil
+}
+
+// defaultBlameLimit is how many provenance history records are returned when
+// a request doesn't give a blame_limit.
+const defaultBlameLimit = 100
+
+// parseBlamePageParams reads the params that select a page of a keypath's
+// provenance history.
+//
+//	?blame=true&blame_start=100&blame_limit=50
+//
+// The response's historyCount field tells how many records there are in all.
+func parseBlamePageParams(r *http.Request) (start uint64, limit uint64, err error) {
+	q := r.URL.Query()
+
+	if startStr := q.Get("blame_start"); startStr != "" {
+		start, err = strconv.ParseUint(startStr, 10, 64)
+		if err != nil {
+			return 0, 0, errors.New("invalid blame_start param")
+		}
+	}
+
+	limit = defaultBlameLimit
+	if limitStr := q.Get("blame_limit"); limitStr != "" {
+		limit, err = strconv.ParseUint(limitStr, 10, 64)
+		if err != nil || limit == 0 || limit > defaultBlameLimit {
+			return 0, 0, errors.Errorf("invalid blame_limit param (must be between 1 and %v)", defaultBlameLimit)
+		}
+	}
+	return start, limit, nil
+}
+
 func parseIndexParams(r *http.Request) (string, string) {
 	indexName := r.URL.Query().Get("index")
 	indexArg := r.URL.Query().Get("index_arg")
 	return indexName, indexArg
 }
 
+// parseIndexRangeParams reads the params for a sorted range scan over an
+// index.  It returns nil if none of them were given.
+//
+//	?index=byChannel&index_prefix=general&index_order=desc&index_limit=50
+//
+// index_prefix, index_start and index_end are "/"-separated index key parts.
+// index_start is inclusive and index_end is exclusive.  When a response is
+// truncated by index_limit, the Index-Cursor response header contains a
+// cursor that can be passed as index_cursor to fetch the next page.
+func parseIndexRangeParams(r *http.Request) (*IndexRangeQuery, error) {
+	q := r.URL.Query()
+
+	var query IndexRangeQuery
+	var any bool
+	if prefix := q.Get("index_prefix"); prefix != "" {
+		query.Prefix = tree.Keypath(prefix)
+		any = true
+	}
+	if start := q.Get("index_start"); start != "" {
+		query.Start = tree.Keypath(start)
+		any = true
+	}
+	if end := q.Get("index_end"); end != "" {
+		query.End = tree.Keypath(end)
+		any = true
+	}
+	if cursor := q.Get("index_cursor"); cursor != "" {
+		query.Cursor = cursor
+		any = true
+	}
+
+	switch order := q.Get("index_order"); order {
+	case "":
+	case "asc":
+		any = true
+	case "desc":
+		query.Reverse = true
+		any = true
+	default:
+		return nil, errors.Errorf("invalid index_order param '%v' (must be 'asc' or 'desc')", order)
+	}
+
+	if limitStr := q.Get("index_limit"); limitStr != "" {
+		limit, err := strconv.ParseUint(limitStr, 10, 64)
+		if err != nil {
+			return nil, errors.New("invalid index_limit param")
+		}
+		query.Limit = limit
+		any = true
+	}
+
+	if !any {
+		return nil, nil
+	}
+	return &query, nil
+}
+
 func respondJSON(resp http.ResponseWriter, data interface{}) {
 	resp.Header().Add("Content-Type", "application/json")
 
@@ -1403,6 +1748,111 @@ func (p *httpPeer) Ack(stateURI string, txID types.ID) (err error) {
 	return nil
 }
 
+func (p *httpPeer) FetchTxs(stateURI string, txIDs []types.ID) (err error) {
+	defer func() { p.UpdateConnStats(err == nil) }()
+
+	if p.DialInfo().DialAddr == "" {
+		p.t.Warn("peer has no DialAddr")
+		return nil
+	}
+
+	txIDStrs := make([]string, len(txIDs))
+	for i, txID := range txIDs {
+		txIDStrs[i] = txID.Hex()
+	}
+
+	ctx, cancel := utils.CombinedContext(10*time.Second, p.t.chStop)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", p.DialInfo().DialAddr, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("State-URI", stateURI)
+	req.Header.Set("Fetch-Txs", strings.Join(txIDStrs, ","))
+
+	resp, err := p.t.doRequest(req)
+	if err != nil {
+		return errors.Wrapf(err, "error fetching txs from peer (%v)", p.DialInfo().DialAddr)
+	}
+	defer resp.Body.Close()
+
+	// Buffer the response so that it outlives the request's context
+	bs, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	p.stream.ReadCloser = ioutil.NopCloser(bytes.NewReader(bs))
+	return nil
+}
+
+func (p *httpPeer) SyncTxs(stateURI string, locator []types.ID) (err error) {
+	defer func() { p.UpdateConnStats(err == nil) }()
+
+	if p.DialInfo().DialAddr == "" {
+		p.t.Warn("peer has no DialAddr")
+		return nil
+	}
+
+	locatorBytes, err := json.Marshal(locator)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	ctx, cancel := utils.CombinedContext(30*time.Second, p.t.chStop)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", p.DialInfo().DialAddr, bytes.NewReader(locatorBytes))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("State-URI", stateURI)
+	req.Header.Set("Sync-Txs", "true")
+
+	resp, err := p.t.doRequest(req)
+	if err != nil {
+		return errors.Wrapf(err, "error syncing txs with peer (%v)", p.DialInfo().DialAddr)
+	}
+	defer resp.Body.Close()
+
+	// Buffer the response so that it outlives the request's context
+	bs, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	p.stream.ReadCloser = ioutil.NopCloser(bytes.NewReader(bs))
+	return nil
+}
+
+func (p *httpPeer) SendTxs(txs []Tx) (err error) {
+	defer func() { p.UpdateConnStats(err == nil) }()
+
+	if txs == nil {
+		txs = []Tx{}
+	}
+	err = json.NewEncoder(p.stream.Writer).Encode(txs)
+	if err != nil {
+		http.Error(p.stream.Writer.(http.ResponseWriter), err.Error(), http.StatusInternalServerError)
+		return err
+	}
+	return nil
+}
+
+func (p *httpPeer) ReceiveTxs() (_ []Tx, err error) {
+	defer func() { p.UpdateConnStats(err == nil) }()
+
+	if p.stream.ReadCloser == nil {
+		return nil, ErrProtocol
+	}
+
+	var txs []Tx
+	err = json.NewDecoder(p.stream.ReadCloser).Decode(&txs)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return txs, nil
+}
+
 func (p *httpPeer) ChallengeIdentity(challengeMsg types.ChallengeMsg) (err error) {
 	defer utils.WithStack(&err)
 	defer func() { p.UpdateConnStats(err == nil) }()
@@ -1568,7 +2018,11 @@ type httpWritableSubscription struct {
 
 var _ WritableSubscriptionImpl = (*httpWritableSubscription)(nil)
 
-func (sub *httpWritableSubscription) Put(ctx context.Context, tx *Tx, state tree.Node, leaves []types.ID) (err error) {
+func (sub *httpWritableSubscription) RemotePeer() Peer {
+	return sub.httpPeer
+}
+
+func (sub *httpWritableSubscription) Put(ctx context.Context, tx *Tx, state tree.Node, diff *StateDiff, leaves []types.ID) (err error) {
 	defer func() { sub.UpdateConnStats(err == nil) }()
 
 	var msg *SubscriptionMsg
@@ -1613,7 +2067,7 @@ func (sub *httpWritableSubscription) Put(ctx context.Context, tx *Tx, state tree
 
 		msg = &SubscriptionMsg{EncryptedTx: etx, Leaves: leaves}
 	} else {
-		msg = &SubscriptionMsg{Tx: tx, State: state, Leaves: leaves}
+		msg = &SubscriptionMsg{Tx: tx, State: state, Diff: diff, Leaves: leaves}
 	}
 
 	bs, err := json.Marshal(msg)
@@ -1660,7 +2114,11 @@ type wsWritableSubscription struct {
 
 var _ WritableSubscriptionImpl = (*wsWritableSubscription)(nil)
 
-func (sub *wsWritableSubscription) Put(ctx context.Context, tx *Tx, state tree.Node, leaves []types.ID) (err error) {
+func (sub *wsWritableSubscription) RemotePeer() Peer {
+	return sub.httpPeer
+}
+
+func (sub *wsWritableSubscription) Put(ctx context.Context, tx *Tx, state tree.Node, diff *StateDiff, leaves []types.ID) (err error) {
 	defer func() { sub.UpdateConnStats(err == nil) }()
 
 	sub.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
@@ -1672,7 +2130,7 @@ func (sub *wsWritableSubscription) Put(ctx context.Context, tx *Tx, state tree.N
 	}
 	defer w.Close()
 
-	bs, err := json.Marshal(SubscriptionMsg{Tx: tx, State: state, Leaves: leaves})
+	bs, err := json.Marshal(SubscriptionMsg{Tx: tx, State: state, Diff: diff, Leaves: leaves})
 	if err != nil {
 		sub.t.Errorf("error marshaling message json: %v", err)
 		return err
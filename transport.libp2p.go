@@ -441,8 +441,8 @@ func (t *libp2pTransport) handleIncomingStream(stream netp2p.Stream) {
 			t.writeSubsByPeerID[peer.pinfo.ID][stream] = writeSub
 		}()
 
-		fetchHistoryOpts := &FetchHistoryOpts{} // Fetch all history (@@TODO)
-		t.host.HandleWritableSubscriptionOpened(writeSub, fetchHistoryOpts)
+		// Subscribers catch up on history separately (see host.SyncWithPeer)
+		t.host.HandleWritableSubscriptionOpened(writeSub, nil)
 
 	case MsgType_Put:
 		defer peer.Close()
@@ -464,6 +464,26 @@ func (t *libp2pTransport) handleIncomingStream(stream netp2p.Stream) {
 		}
 		t.host.HandleAckReceived(ackMsg.StateURI, ackMsg.TxID, peer)
 
+	case MsgType_FetchTxs:
+		defer peer.Close()
+
+		req, ok := msg.Payload.(FetchTxsRequest)
+		if !ok {
+			t.Errorf("FetchTxs message: bad payload: (%T) %v", msg.Payload, msg.Payload)
+			return
+		}
+		t.host.HandleFetchTxsReceived(req.StateURI, req.TxIDs, peer)
+
+	case MsgType_SyncTxs:
+		defer peer.Close()
+
+		req, ok := msg.Payload.(SyncTxsRequest)
+		if !ok {
+			t.Errorf("SyncTxs message: bad payload: (%T) %v", msg.Payload, msg.Payload)
+			return
+		}
+		t.host.HandleSyncTxsReceived(req.StateURI, req.Locator, peer)
+
 	case MsgType_ChallengeIdentityRequest:
 		defer peer.Close()
 
@@ -960,6 +980,33 @@ func (p *libp2pPeer) Ack(stateURI string, txID types.ID) error {
 	return p.writeMsg(Msg{Type: MsgType_Ack, Payload: libp2pAckMsg{stateURI, txID}})
 }
 
+func (p *libp2pPeer) FetchTxs(stateURI string, txIDs []types.ID) error {
+	return p.writeMsg(Msg{Type: MsgType_FetchTxs, Payload: FetchTxsRequest{stateURI, txIDs}})
+}
+
+func (p *libp2pPeer) SyncTxs(stateURI string, locator []types.ID) error {
+	return p.writeMsg(Msg{Type: MsgType_SyncTxs, Payload: SyncTxsRequest{stateURI, locator}})
+}
+
+func (p *libp2pPeer) SendTxs(txs []Tx) error {
+	return p.writeMsg(Msg{Type: MsgType_FetchTxsResponse, Payload: txs})
+}
+
+func (p *libp2pPeer) ReceiveTxs() ([]Tx, error) {
+	msg, err := p.readMsg()
+	if err != nil {
+		return nil, errors.Errorf("error reading from peer: %v", err)
+	} else if msg.Type != MsgType_FetchTxsResponse {
+		return nil, ErrProtocol
+	}
+
+	txs, ok := msg.Payload.([]Tx)
+	if !ok {
+		return nil, ErrProtocol
+	}
+	return txs, nil
+}
+
 func (p *libp2pPeer) ChallengeIdentity(challengeMsg types.ChallengeMsg) error {
 	return p.writeMsg(Msg{Type: MsgType_ChallengeIdentityRequest, Payload: challengeMsg})
 }
@@ -1136,7 +1183,11 @@ type libp2pWritableSubscription struct {
 	*libp2pPeer
 }
 
-func (sub *libp2pWritableSubscription) Put(ctx context.Context, tx *Tx, state tree.Node, leaves []types.ID) (err error) {
+func (sub *libp2pWritableSubscription) RemotePeer() Peer {
+	return sub.libp2pPeer
+}
+
+func (sub *libp2pWritableSubscription) Put(ctx context.Context, tx *Tx, state tree.Node, diff *StateDiff, leaves []types.ID) (err error) {
 	defer func() { sub.UpdateConnStats(err == nil) }()
 
 	err = sub.libp2pPeer.EnsureConnected(ctx)
@@ -1302,6 +1353,9 @@ const (
 	MsgType_FetchRef                  MsgType = "fetch ref"
 	MsgType_FetchRefResponse          MsgType = "fetch ref response"
 	MsgType_AnnouncePeers             MsgType = "announce peers"
+	MsgType_FetchTxs                  MsgType = "fetch txs"
+	MsgType_FetchTxsResponse          MsgType = "fetch txs response"
+	MsgType_SyncTxs                   MsgType = "sync txs"
 )
 
 func ReadUint64(r io.Reader) (uint64, error) {
@@ -1410,6 +1464,30 @@ func (msg *Msg) UnmarshalJSON(bs []byte) error {
 		}
 		msg.Payload = peerDialInfos
 
+	case MsgType_FetchTxs:
+		var req FetchTxsRequest
+		err := json.Unmarshal([]byte(m.PayloadBytes), &req)
+		if err != nil {
+			return err
+		}
+		msg.Payload = req
+
+	case MsgType_SyncTxs:
+		var req SyncTxsRequest
+		err := json.Unmarshal([]byte(m.PayloadBytes), &req)
+		if err != nil {
+			return err
+		}
+		msg.Payload = req
+
+	case MsgType_FetchTxsResponse:
+		var txs []Tx
+		err := json.Unmarshal([]byte(m.PayloadBytes), &txs)
+		if err != nil {
+			return err
+		}
+		msg.Payload = txs
+
 	default:
 		return errors.Errorf("bad msg: %v", msg.Type)
 	}
@@ -8,8 +8,10 @@ import (
 	"fmt"
 	"os"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/brynbellomy/go-structomancer"
 	"github.com/dgraph-io/badger/v2"
@@ -110,6 +112,31 @@ func (t *VersionedDBTree) makeIndexKeyPrefix(version types.ID, keypath Keypath,
 	return bytes.Join([][]byte{[]byte("i"), version[:], keypath, indexName, []byte{}}, []byte(":"))
 }
 
+func (t *VersionedDBTree) makeIndexReverseKeyPrefix(version types.ID, keypath Keypath, indexName Keypath) []byte {
+	// ir:<version>:<keypath>:<indexName>:
+	return bytes.Join([][]byte{[]byte("ir"), version[:], keypath, indexName, []byte{}}, []byte(":"))
+}
+
+func (t *VersionedDBTree) makeIndexSortKeyPrefix(version types.ID, keypath Keypath, indexName Keypath) []byte {
+	// is:<version>:<keypath>:<indexName>:
+	return bytes.Join([][]byte{[]byte("is"), version[:], keypath, indexName, []byte{}}, []byte(":"))
+}
+
+// makeIndexSortKey returns the key under which an index entry is listed in
+// the index's sort keyspace.  The parts of the index key are separated by
+// 0x00 rather than the keypath separator so that a shorter part always sorts
+// before a longer part that it's a prefix of.
+func makeIndexSortKey(indexKey Keypath, childKey Keypath) []byte {
+	sortKey := make([]byte, 0, len(indexKey)+len(childKey)+1)
+	sortKey = append(sortKey, indexKeySortPart(indexKey)...)
+	sortKey = append(sortKey, 0)
+	return append(sortKey, childKey...)
+}
+
+func indexKeySortPart(indexKey Keypath) []byte {
+	return bytes.ReplaceAll(indexKey, KeypathSeparator, []byte{0})
+}
+
 func (t *VersionedDBTree) StateAtVersion(version *types.ID, mutable bool) *DBNode {
 	if version == nil {
 		version = &CurrentVersion
@@ -170,6 +197,7 @@ func (tx *DBNode) Save() error {
 		err = innerErr
 		wg.Done()
 	})
+	wg.Wait()
 	return err
 }
 
@@ -1438,6 +1466,11 @@ func (tx *DBNode) DebugPrint(printFn func(inFormat string, args ...interface{}),
 
 func (t *VersionedDBTree) CopyVersion(dstVersion, srcVersion types.ID) error {
 	return t.db.Update(func(tx *badger.Txn) error {
+		err := tx.Set(makeVersionRegistryKey(dstVersion), encodeVersionSavedAt(time.Now()))
+		if err != nil {
+			return err
+		}
+
 		stream := t.db.NewStream()
 		stream.NumGo = 16
 		stream.Prefix = append(srcVersion[:], ':')
@@ -1458,6 +1491,113 @@ func (t *VersionedDBTree) CopyVersion(dstVersion, srcVersion types.ID) error {
 	})
 }
 
+// Saved versions (other than CurrentVersion) are listed in a registry so that
+// they can be enumerated and garbage collected without scanning the entire DB.
+// State keys always have a ':' at the end of their version prefix, so these
+// can't collide with them.
+var versionRegistryKeyPrefix = []byte("versions\x00")
+
+func makeVersionRegistryKey(version types.ID) []byte {
+	return append(append([]byte(nil), versionRegistryKeyPrefix...), version[:]...)
+}
+
+func encodeVersionSavedAt(t time.Time) []byte {
+	bs := make([]byte, 8)
+	binary.BigEndian.PutUint64(bs, uint64(t.UnixNano()))
+	return bs
+}
+
+// VersionInfo describes a saved version of the state.
+type VersionInfo struct {
+	Version types.ID
+	SavedAt time.Time
+}
+
+// VersionStats describes how much space a version of the state occupies.
+type VersionStats struct {
+	NumKeys uint64
+	Size    int64
+}
+
+// RecordVersion adds a version to the registry.  CopyVersion does this
+// automatically.  Versions written by other means should be recorded once
+// they're complete so that they're subject to garbage collection.
+func (t *VersionedDBTree) RecordVersion(version types.ID, savedAt time.Time) error {
+	return t.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(makeVersionRegistryKey(version), encodeVersionSavedAt(savedAt))
+	})
+}
+
+// Versions returns the saved versions in the registry, oldest first.
+func (t *VersionedDBTree) Versions() ([]VersionInfo, error) {
+	var versions []VersionInfo
+	err := t.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = versionRegistryKeyPrefix
+
+		iter := txn.NewIterator(opts)
+		defer iter.Close()
+
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			item := iter.Item()
+			val, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			} else if len(val) != 8 {
+				return errors.Errorf("bad version registry entry for %x", item.Key())
+			}
+			version := types.IDFromBytes(item.Key()[len(versionRegistryKeyPrefix):])
+			savedAt := time.Unix(0, int64(binary.BigEndian.Uint64(val)))
+			versions = append(versions, VersionInfo{Version: version, SavedAt: savedAt})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].SavedAt.Before(versions[j].SavedAt) })
+	return versions, nil
+}
+
+// VersionStats counts the keys under the given version and estimates the
+// space that they occupy on disk.
+func (t *VersionedDBTree) VersionStats(version types.ID) (VersionStats, error) {
+	var stats VersionStats
+	err := t.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = t.makeStateKeyPrefix(version)
+
+		iter := txn.NewIterator(opts)
+		defer iter.Close()
+
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			stats.NumKeys++
+			stats.Size += iter.Item().EstimatedSize()
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// DeleteVersion removes the entire state at the given version, along with
+// any indices of it.
+func (t *VersionedDBTree) DeleteVersion(version types.ID) error {
+	prefixes := [][]byte{t.makeStateKeyPrefix(version)}
+	for _, indexPrefix := range []string{"i", "ir", "is"} {
+		prefixes = append(prefixes, bytes.Join([][]byte{[]byte(indexPrefix), version[:], []byte{}}, []byte(":")))
+	}
+	for _, prefix := range prefixes {
+		err := t.deleteKeysWithPrefix(prefix)
+		if err != nil {
+			return err
+		}
+	}
+	return t.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(makeVersionRegistryKey(version))
+	})
+}
+
 func (n *DBNode) MarshalJSON() ([]byte, error) {
 	v, _, err := n.Value(nil, nil)
 	if err != nil {
@@ -1568,25 +1708,53 @@ func prettyJSON(x interface{}) string {
 func (t *VersionedDBTree) BuildIndex(version *types.ID, keypath Keypath, node Node, indexName Keypath, indexer Indexer) (err error) {
 	defer utils.Annotate(&err, "BuildIndex")
 
-	// @@TODO: ensure NodeType is map or slice
-	// @@TODO: don't use a map[][] to count children, put it in Badger
-	index := t.IndexAtVersion(version, keypath, indexName, true)
-	defer index.Close()
+	if version == nil {
+		version = &CurrentVersion
+	}
 
-	iter := node.ChildIterator(nil, true, 10)
-	defer iter.Close()
+	err = t.DeleteIndex(version, keypath, indexName)
+	if err != nil {
+		return err
+	}
 
 	rootNodeType, _, _, err := node.NodeInfo(nil)
 	if err != nil {
-		t.Error(err)
 		return err
 	}
 
-	children := make(map[string]map[string]struct{})
+	index := t.IndexAtVersion(version, keypath, indexName, true)
+	defer index.Close()
+
+	// Set the root value of the index to a NodeTypeMap
+	encoded, err := encodeNode(NodeTypeMap, 0, 0, nil)
+	if err != nil {
+		return err
+	}
+	err = index.tx.Set(index.addKeyPrefix(nil), encoded)
+	if err != nil {
+		return err
+	}
+
+	iter := node.ChildIterator(nil, true, 10)
+	defer iter.Close()
+
+	// @@TODO: don't use a map to count children, put it in Badger
+	counts := make(map[string]uint64)
+	reversePrefix := t.makeIndexReverseKeyPrefix(*version, keypath, indexName)
+	sortPrefix := t.makeIndexSortKeyPrefix(*version, keypath, indexName)
+
 	for iter.Rewind(); iter.Valid(); iter.Next() {
 		childNode := iter.Node()
 		relKeypath := childNode.Keypath().RelativeTo(node.Keypath())
 
+		if rootNodeType == NodeTypeMap {
+			err = t.indexMapChild(index, reversePrefix, sortPrefix, relKeypath, childNode, indexer)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
 		indexKey, indexNode, err := indexer.IndexNode(relKeypath, childNode)
 		if err != nil {
 			return err
@@ -1594,53 +1762,319 @@ func (t *VersionedDBTree) BuildIndex(version *types.ID, keypath Keypath, node No
 			continue
 		}
 
-		if _, exists := children[string(indexKey)]; !exists {
-			children[string(indexKey)] = make(map[string]struct{})
+		// If it's a slice, we have to renumber its children
+		entryKeypath := indexKey.PushIndex(counts[string(indexKey)])
+		err = index.Set(entryKeypath, nil, indexNode)
+		if err != nil {
+			return err
 		}
-		children[string(indexKey)][string(relKeypath.Part(0))] = struct{}{}
-
-		if rootNodeType == NodeTypeSlice {
-			// If it's a slice, we have to renumber its children
-			newIdx := uint64(len(children[string(indexKey)])) - 1
-			_, rest := relKeypath.Shift()
-			relKeypath = rest.Unshift(EncodeSliceIndex(newIdx))
-
-		} else if rootNodeType == NodeTypeMap {
-			// If it's a map, we have to replace the root key with the indexKey
-			_, rest := relKeypath.Shift()
-			relKeypath = rest.Unshift(indexKey)
+		err = index.tx.Set(append(append([]byte(nil), sortPrefix...), makeIndexSortKey(indexKey, relKeypath)...), entryKeypath)
+		if err != nil {
+			return err
 		}
+		counts[string(indexKey)]++
+	}
 
-		err = index.Set(relKeypath, nil, indexNode)
+	// Slices' entries are grouped into slices under each index key
+	for indexKey, count := range counts {
+		encoded, err := encodeNode(NodeTypeSlice, 0, count, nil)
+		if err != nil {
+			return err
+		}
+		err = index.tx.Set(index.addKeyPrefix(Keypath(indexKey)), encoded)
 		if err != nil {
-			t.Error(err)
 			return err
 		}
 	}
+	return index.tx.Commit()
+}
 
-	// Set the index's node types to the type of the original keypath being indexed
-	for indexKey, child := range children {
-		encoded, err := encodeNode(rootNodeType, 0, uint64(len(child)), nil)
-		if err != nil {
+// UpdateIndex re-indexes the given children of a node that was previously
+// indexed with BuildIndex, removing any entries that they used to occupy.
+// Slices are always rebuilt in full because inserting or removing an element
+// shifts the keys of every element after it.
+func (t *VersionedDBTree) UpdateIndex(version *types.ID, keypath Keypath, node Node, indexName Keypath, indexer Indexer, childKeys []Keypath) (err error) {
+	defer utils.Annotate(&err, "UpdateIndex")
+
+	if version == nil {
+		version = &CurrentVersion
+	}
+
+	rootNodeType, _, _, err := node.NodeInfo(nil)
+	if errors.Cause(err) == types.Err404 {
+		return t.DeleteIndex(version, keypath, indexName)
+	} else if err != nil {
+		return err
+	} else if rootNodeType == NodeTypeSlice {
+		return t.BuildIndex(version, keypath, node, indexName, indexer)
+	} else if rootNodeType != NodeTypeMap {
+		return t.DeleteIndex(version, keypath, indexName)
+	}
+
+	index := t.IndexAtVersion(version, keypath, indexName, true)
+	defer index.Close()
+
+	reversePrefix := t.makeIndexReverseKeyPrefix(*version, keypath, indexName)
+	sortPrefix := t.makeIndexSortKeyPrefix(*version, keypath, indexName)
+
+	for _, childKey := range childKeys {
+		reverseKey := append(append([]byte(nil), reversePrefix...), childKey...)
+
+		// Remove the child's old entry
+		item, err := index.tx.Get(reverseKey)
+		if err == nil {
+			oldIndexKey, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			err = index.Delete(Keypath(oldIndexKey).Push(childKey), nil)
+			if err != nil {
+				return err
+			}
+			err = index.tx.Delete(reverseKey)
+			if err != nil {
+				return err
+			}
+			err = index.tx.Delete(append(append([]byte(nil), sortPrefix...), makeIndexSortKey(oldIndexKey, childKey)...))
+			if err != nil {
+				return err
+			}
+
+			// Compound index keys are nested, so empty buckets have to be
+			// pruned all the way up
+			for bucket := Keypath(oldIndexKey); len(bucket) > 0; bucket, _ = bucket.Pop() {
+				if index.hasChildren(bucket) {
+					break
+				}
+				err = index.Delete(bucket, nil)
+				if err != nil {
+					return err
+				}
+			}
+		} else if err != badger.ErrKeyNotFound {
 			return err
 		}
 
-		err = index.tx.Set(index.addKeyPrefix(Keypath(indexKey)), encoded)
+		// Add its new entry, if it still exists
+		child := node.NodeAt(childKey, nil)
+		exists, err := child.Exists(nil)
+		if err != nil {
+			return err
+		} else if !exists {
+			continue
+		}
+		err = t.indexMapChild(index, reversePrefix, sortPrefix, childKey, child, indexer)
 		if err != nil {
 			return err
 		}
 	}
+	return index.tx.Commit()
+}
 
-	// Set the root value of the index to a NodeTypeMap
-	encoded, err := encodeNode(NodeTypeMap, 0, 0, nil)
+// DeleteIndex removes every entry in the given index.
+func (t *VersionedDBTree) DeleteIndex(version *types.ID, keypath Keypath, indexName Keypath) error {
+	if version == nil {
+		version = &CurrentVersion
+	}
+	err := t.deleteKeysWithPrefix(t.makeIndexKeyPrefix(*version, keypath, indexName))
 	if err != nil {
 		return err
 	}
-	err = index.tx.Set(index.addKeyPrefix(nil), encoded)
+	err = t.deleteKeysWithPrefix(t.makeIndexReverseKeyPrefix(*version, keypath, indexName))
+	if err != nil {
+		return err
+	}
+	return t.deleteKeysWithPrefix(t.makeIndexSortKeyPrefix(*version, keypath, indexName))
+}
+
+// indexMapChild stores a map child in the index as <indexKey>/<childKey>, and
+// records the index key it was stored under so that the entry can be removed
+// when the child changes.
+func (t *VersionedDBTree) indexMapChild(index *DBNode, reversePrefix, sortPrefix []byte, childKey Keypath, child Node, indexer Indexer) error {
+	indexKey, indexNode, err := indexer.IndexNode(childKey, child)
+	if err != nil {
+		return err
+	} else if indexKey == nil || indexNode == nil {
+		return nil
+	}
+
+	entryKeypath := indexKey.Push(childKey)
+	err = index.Set(entryKeypath, nil, indexNode)
+	if err != nil {
+		return err
+	}
+	err = index.tx.Set(append(append([]byte(nil), sortPrefix...), makeIndexSortKey(indexKey, childKey)...), entryKeypath)
 	if err != nil {
 		return err
 	}
-	return index.Save()
+	reverseKey := append(append([]byte(nil), reversePrefix...), childKey...)
+	return index.tx.Set(reverseKey, indexKey.Copy())
+}
+
+// IndexScan describes a range scan over the entries of an index, in the
+// order of their index keys.  Prefix, Start and End are index keys (or
+// leading parts of compound index keys).
+type IndexScan struct {
+	// Prefix restricts the scan to entries whose index key starts with these parts
+	Prefix Keypath
+	// Start is the lowest index key returned (inclusive)
+	Start Keypath
+	// End is the highest index key returned (exclusive)
+	End Keypath
+	// Reverse scans from the highest index key to the lowest
+	Reverse bool
+	// Limit caps the number of entries returned.  0 means no limit.
+	Limit uint64
+	// Cursor resumes a previous scan after the last entry that it returned
+	Cursor []byte
+}
+
+type IndexEntry struct {
+	IndexKey Keypath
+	ChildKey Keypath
+	Node     Node
+}
+
+// ScanIndex returns the entries of an index in sorted order.  If the scan
+// stopped because it hit its limit, it also returns a cursor that can be
+// passed back in to fetch the next page.
+func (t *VersionedDBTree) ScanIndex(version *types.ID, keypath Keypath, indexName Keypath, scan IndexScan) (_ []IndexEntry, _ []byte, err error) {
+	defer utils.Annotate(&err, "ScanIndex")
+
+	if version == nil {
+		version = &CurrentVersion
+	}
+
+	index := t.IndexAtVersion(version, keypath, indexName, false)
+	defer index.Close()
+
+	sortPrefix := t.makeIndexSortKeyPrefix(*version, keypath, indexName)
+	scanPrefix := append([]byte(nil), sortPrefix...)
+	if len(scan.Prefix) > 0 {
+		scanPrefix = append(append(scanPrefix, indexKeySortPart(scan.Prefix)...), 0)
+	}
+
+	lower := scanPrefix
+	if len(scan.Start) > 0 {
+		lower = append(append([]byte(nil), scanPrefix...), indexKeySortPart(scan.Start)...)
+	}
+	var upper []byte
+	if len(scan.End) > 0 {
+		upper = append(append([]byte(nil), scanPrefix...), indexKeySortPart(scan.End)...)
+	}
+
+	// The cursor is the sort key of the last entry returned, so the scan
+	// resumes just past it
+	if len(scan.Cursor) > 0 {
+		cursorKey := append(append([]byte(nil), sortPrefix...), scan.Cursor...)
+		if !scan.Reverse && bytes.Compare(cursorKey, lower) >= 0 {
+			lower = append(cursorKey, 0)
+		} else if scan.Reverse && (upper == nil || bytes.Compare(cursorKey, upper) < 0) {
+			upper = cursorKey
+		}
+	}
+
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = true
+	opts.Prefix = scanPrefix
+	opts.Reverse = scan.Reverse
+
+	iter := index.tx.NewIterator(opts)
+	defer iter.Close()
+
+	if scan.Reverse {
+		if upper != nil {
+			iter.Seek(upper)
+		} else {
+			// Keys are UTF-8 or ASCII-encoded numbers, so they never contain 0xff
+			iter.Seek(append(append([]byte(nil), scanPrefix...), 0xff))
+		}
+	} else {
+		iter.Seek(lower)
+	}
+
+	var entries []IndexEntry
+	var lastSortKey []byte
+	for ; iter.Valid(); iter.Next() {
+		item := iter.Item()
+		sortKey := item.Key()
+		if scan.Reverse && bytes.Compare(sortKey, lower) < 0 {
+			break
+		} else if upper != nil && bytes.Compare(sortKey, upper) >= 0 {
+			if scan.Reverse {
+				continue
+			}
+			break
+		}
+
+		if scan.Limit > 0 && uint64(len(entries)) == scan.Limit {
+			return entries, lastSortKey[len(sortPrefix):], nil
+		}
+
+		entryKeypath, err := item.ValueCopy(nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		indexKey, _ := Keypath(entryKeypath).Pop()
+
+		node, err := index.CopyToMemory(Keypath(entryKeypath), nil)
+		if errors.Cause(err) == types.Err404 {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		lastSortKey = item.KeyCopy(nil)
+		entries = append(entries, IndexEntry{
+			IndexKey: indexKey.Copy(),
+			ChildKey: Keypath(lastSortKey[len(sortPrefix)+len(indexKey)+1:]),
+			Node:     node,
+		})
+	}
+	return entries, nil, nil
+}
+
+func (t *VersionedDBTree) deleteKeysWithPrefix(prefix []byte) error {
+	var keys [][]byte
+	err := t.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		opts.Prefix = prefix
+
+		iter := txn.NewIterator(opts)
+		defer iter.Close()
+
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			keys = append(keys, iter.Item().KeyCopy(nil))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	batch := t.db.NewWriteBatch()
+	defer batch.Cancel()
+
+	for _, key := range keys {
+		err := batch.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+	return batch.Flush()
+}
+
+func (n *DBNode) hasChildren(keypath Keypath) bool {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	opts.Prefix = append(append([]byte(nil), n.addKeyPrefix(n.rootKeypath.Push(keypath))...), KeypathSeparator[0])
+
+	iter := n.tx.NewIterator(opts)
+	defer iter.Close()
+
+	iter.Rewind()
+	return iter.Valid()
 }
 
 func (n *DBNode) scanChildrenForward(
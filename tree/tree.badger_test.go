@@ -960,7 +960,172 @@ func TestVersionedDBTree_CopyVersion(t *testing.T) {
 		return nil
 	})
 	require.NoError(t, err)
-	require.Equal(t, len(fixture1.output)*2, count)
+	// Both copies of the state, plus the registry entry for the new version
+	require.Equal(t, len(fixture1.output)*2+1, count)
+
+	versions, err := db.Versions()
+	require.NoError(t, err)
+	require.Len(t, versions, 1)
+	require.Equal(t, dstVersion, versions[0].Version)
+
+	stats, err := db.VersionStats(dstVersion)
+	require.NoError(t, err)
+	require.Equal(t, uint64(len(fixture1.output)), stats.NumKeys)
+}
+
+type fieldIndexer struct{ field tree.Keypath }
+
+func (i fieldIndexer) IndexNode(relKeypath tree.Keypath, node tree.Node) (tree.Keypath, tree.Node, error) {
+	val, exists, err := node.StringValue(i.field)
+	if err != nil || !exists {
+		return nil, nil, err
+	}
+	return tree.Keypath(val), node, nil
+}
+
+func TestVersionedDBTree_UpdateIndex(t *testing.T) {
+	db := testutils.SetupVersionedDBTree(t)
+	defer db.DeleteDB()
+
+	indexer := fieldIndexer{field: tree.Keypath("author")}
+	keypath := tree.Keypath("messages")
+	indexName := tree.Keypath("author")
+
+	messages := tree.NewMemoryNode()
+	err := messages.Set(nil, nil, M{
+		"a": M{"author": "alice", "text": "hi"},
+		"b": M{"author": "bob", "text": "yo"},
+	})
+	require.NoError(t, err)
+
+	err = db.BuildIndex(nil, keypath, messages, indexName, indexer)
+	require.NoError(t, err)
+
+	authorIndex := func(author string) interface{} {
+		index := db.IndexAtVersion(nil, keypath, indexName, false)
+		defer index.Close()
+		val, _, err := index.Value(tree.Keypath(author), nil)
+		require.NoError(t, err)
+		return val
+	}
+
+	require.Equal(t, M{"a": M{"author": "alice", "text": "hi"}}, authorIndex("alice"))
+	require.Equal(t, M{"b": M{"author": "bob", "text": "yo"}}, authorIndex("bob"))
+
+	// Change the author of "b" and add "c"
+	err = messages.Set(tree.Keypath("b"), nil, M{"author": "alice", "text": "yo"})
+	require.NoError(t, err)
+	err = messages.Set(tree.Keypath("c"), nil, M{"author": "carol", "text": "hey"})
+	require.NoError(t, err)
+
+	err = db.UpdateIndex(nil, keypath, messages, indexName, indexer, []tree.Keypath{tree.Keypath("b"), tree.Keypath("c")})
+	require.NoError(t, err)
+
+	require.Equal(t, M{
+		"a": M{"author": "alice", "text": "hi"},
+		"b": M{"author": "alice", "text": "yo"},
+	}, authorIndex("alice"))
+	require.Nil(t, authorIndex("bob"))
+	require.Equal(t, M{"c": M{"author": "carol", "text": "hey"}}, authorIndex("carol"))
+
+	// Delete "a"
+	err = messages.Delete(tree.Keypath("a"), nil)
+	require.NoError(t, err)
+
+	err = db.UpdateIndex(nil, keypath, messages, indexName, indexer, []tree.Keypath{tree.Keypath("a")})
+	require.NoError(t, err)
+
+	require.Equal(t, M{"b": M{"author": "alice", "text": "yo"}}, authorIndex("alice"))
+}
+
+type compoundIndexer struct{}
+
+func (compoundIndexer) IndexNode(relKeypath tree.Keypath, node tree.Node) (tree.Keypath, tree.Node, error) {
+	channel, _, err := node.StringValue(tree.Keypath("channel"))
+	if err != nil {
+		return nil, nil, err
+	}
+	ts, _, err := node.StringValue(tree.Keypath("ts"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return tree.Keypath(channel).Push(tree.Keypath(ts)), node, nil
+}
+
+func TestVersionedDBTree_ScanIndex(t *testing.T) {
+	db := testutils.SetupVersionedDBTree(t)
+	defer db.DeleteDB()
+
+	keypath := tree.Keypath("messages")
+	indexName := tree.Keypath("byChannel")
+
+	messages := tree.NewMemoryNode()
+	err := messages.Set(nil, nil, M{
+		"m1": M{"channel": "general", "ts": "01"},
+		"m2": M{"channel": "general", "ts": "03"},
+		"m3": M{"channel": "general", "ts": "02"},
+		"m4": M{"channel": "general-2", "ts": "00"},
+		"m5": M{"channel": "general", "ts": "04"},
+		"m6": M{"channel": "aaa", "ts": "05"},
+	})
+	require.NoError(t, err)
+
+	err = db.BuildIndex(nil, keypath, messages, indexName, compoundIndexer{})
+	require.NoError(t, err)
+
+	childKeys := func(entries []tree.IndexEntry) []string {
+		var keys []string
+		for _, entry := range entries {
+			keys = append(keys, entry.ChildKey.String())
+		}
+		return keys
+	}
+
+	entries, cursor, err := db.ScanIndex(nil, keypath, indexName, tree.IndexScan{})
+	require.NoError(t, err)
+	require.Nil(t, cursor)
+	require.Equal(t, []string{"m6", "m1", "m3", "m2", "m5", "m4"}, childKeys(entries))
+
+	entries, cursor, err = db.ScanIndex(nil, keypath, indexName, tree.IndexScan{
+		Prefix:  tree.Keypath("general"),
+		Reverse: true,
+		Limit:   2,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, cursor)
+	require.Equal(t, []string{"m5", "m2"}, childKeys(entries))
+	require.Equal(t, tree.Keypath("general/04"), entries[0].IndexKey)
+	val, _, err := entries[0].Node.Value(nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, M{"channel": "general", "ts": "04"}, val)
+
+	entries, cursor, err = db.ScanIndex(nil, keypath, indexName, tree.IndexScan{
+		Prefix:  tree.Keypath("general"),
+		Reverse: true,
+		Limit:   2,
+		Cursor:  cursor,
+	})
+	require.NoError(t, err)
+	require.Nil(t, cursor)
+	require.Equal(t, []string{"m3", "m1"}, childKeys(entries))
+
+	entries, _, err = db.ScanIndex(nil, keypath, indexName, tree.IndexScan{
+		Prefix: tree.Keypath("general"),
+		Start:  tree.Keypath("02"),
+		End:    tree.Keypath("04"),
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"m3", "m2"}, childKeys(entries))
+
+	// Moving an entry updates its position in the sort order
+	err = messages.Set(tree.Keypath("m1/ts"), nil, "09")
+	require.NoError(t, err)
+	err = db.UpdateIndex(nil, keypath, messages, indexName, compoundIndexer{}, []tree.Keypath{tree.Keypath("m1")})
+	require.NoError(t, err)
+
+	entries, _, err = db.ScanIndex(nil, keypath, indexName, tree.IndexScan{Prefix: tree.Keypath("general")})
+	require.NoError(t, err)
+	require.Equal(t, []string{"m3", "m2", "m5", "m1"}, childKeys(entries))
 }
 
 // func TestVersionedDBTree_CopyToMemory(t *testing.T) {
@@ -1065,7 +1065,9 @@ func (s *MemoryNode) scanKeypathsWithPrefix(prefix Keypath, rng *Range, fn func(
 				if err != nil {
 					return err
 				}
-			} else if foundRange {
+			} else if foundRange && !bytes.HasPrefix(keypath, prefix) {
+				// Siblings like "a-x" sort between "a" and "a/b", so only
+				// stop once the keypaths no longer share the prefix's bytes
 				return nil
 			}
 		}
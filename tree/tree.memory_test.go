@@ -530,3 +530,17 @@ func TestMemoryNode_CopyToMemory_AtKeypath(t *testing.T) {
 	}
 	require.NoError(t, err)
 }
+
+func TestMemoryNode_Value_SiblingSortsBetweenChildren(t *testing.T) {
+	node := tree.NewMemoryNode()
+	err := node.Set(nil, nil, map[string]interface{}{
+		"a":   map[string]interface{}{"y": float64(4)},
+		"a-x": float64(7),
+	})
+	require.NoError(t, err)
+
+	val, exists, err := node.Value(tree.Keypath("a"), nil)
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.Equal(t, map[string]interface{}{"y": float64(4)}, val)
+}
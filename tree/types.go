@@ -200,7 +200,7 @@ func (d *Diff) Add(keypath Keypath) {
 	_, exists := d.Added[string(keypath)]
 	if !exists {
 		d.Added[string(keypath)] = struct{}{}
-		d.AddedList = append(d.AddedList, keypath)
+		d.AddedList = append(d.AddedList, keypath.Copy())
 	}
 }
 
@@ -220,7 +220,7 @@ func (d *Diff) Remove(keypath Keypath) {
 	_, exists := d.Removed[string(keypath)]
 	if !exists {
 		d.Removed[string(keypath)] = struct{}{}
-		d.RemovedList = append(d.RemovedList, keypath)
+		d.RemovedList = append(d.RemovedList, keypath.Copy())
 	}
 }
 
@@ -36,6 +36,15 @@ type Tx struct {
 	Checkpoint bool            `json:"checkpoint"` // @@TODO: probably not ideal
 	Attachment []byte          `json:"attachment,omitempty"`
 
+	// Capabilities are delegated write rights that the sender is exercising
+	// (see validator/capabilities).  They're signed by their issuers, so
+	// they're not part of the tx's hash.
+	Capabilities []CapabilityToken `json:"capabilities,omitempty"`
+
+	// CoSigs are signatures over the tx's hash by addresses other than the
+	// sender, collected to satisfy a validator/multisig.
+	CoSigs []types.Signature `json:"coSigs,omitempty"`
+
 	Status TxStatus   `json:"status"`
 	hash   types.Hash `json:"-"`
 }
@@ -75,6 +84,18 @@ func (tx Tx) Hash() types.Hash {
 	return tx.hash
 }
 
+// IsSnapshot returns true if the tx is a snapshot written by history
+// compaction.  A snapshot has no parents and a single patch that sets the
+// entire state, so it stands in for all of the txs in its causal past.
+func (tx Tx) IsSnapshot() bool {
+	return len(tx.Parents) == 0 &&
+		tx.ID != GenesisTxID &&
+		tx.Checkpoint &&
+		len(tx.Patches) == 1 &&
+		len(tx.Patches[0].Keypath) == 0 &&
+		tx.Patches[0].Range == nil
+}
+
 func (tx Tx) IsPrivate() bool {
 	return len(tx.Recipients) > 0
 }
@@ -115,19 +136,37 @@ func (tx *Tx) Copy() *Tx {
 	attachment := make([]byte, len(tx.Attachment))
 	copy(attachment, tx.Attachment)
 
+	var capabilities []CapabilityToken
+	if len(tx.Capabilities) > 0 {
+		capabilities = make([]CapabilityToken, len(tx.Capabilities))
+		for i, token := range tx.Capabilities {
+			capabilities[i] = token.Copy()
+		}
+	}
+
+	var coSigs []types.Signature
+	if len(tx.CoSigs) > 0 {
+		coSigs = make([]types.Signature, len(tx.CoSigs))
+		for i, sig := range tx.CoSigs {
+			coSigs[i] = sig.Copy()
+		}
+	}
+
 	return &Tx{
-		ID:         tx.ID,
-		Parents:    parents,
-		Children:   children,
-		From:       tx.From,
-		Sig:        tx.Sig.Copy(),
-		StateURI:   tx.StateURI,
-		Patches:    patches,
-		Recipients: recipients,
-		Checkpoint: tx.Checkpoint,
-		Attachment: attachment,
-		Status:     tx.Status,
-		hash:       tx.hash,
+		ID:           tx.ID,
+		Parents:      parents,
+		Children:     children,
+		From:         tx.From,
+		Sig:          tx.Sig.Copy(),
+		StateURI:     tx.StateURI,
+		Patches:      patches,
+		Recipients:   recipients,
+		Checkpoint:   tx.Checkpoint,
+		Attachment:   attachment,
+		Capabilities: capabilities,
+		CoSigs:       coSigs,
+		Status:       tx.Status,
+		hash:         tx.hash,
 	}
 }
 
@@ -182,18 +221,34 @@ func (tx Tx) MarshalProto() ([]byte, error) {
 		recipients[i] = recipient.Bytes()
 	}
 
+	var capabilities [][]byte
+	for _, token := range tx.Capabilities {
+		tokenBytes, err := json.Marshal(token)
+		if err != nil {
+			return nil, err
+		}
+		capabilities = append(capabilities, tokenBytes)
+	}
+
+	coSigs := make([][]byte, len(tx.CoSigs))
+	for i, sig := range tx.CoSigs {
+		coSigs[i] = sig
+	}
+
 	return proto.Marshal(&pb.Tx{
-		Id:         tx.ID[:],
-		Parents:    parents,
-		Children:   children,
-		From:       tx.From[:],
-		Sig:        tx.Sig,
-		StateURI:   tx.StateURI,
-		Patches:    patches,
-		Recipients: recipients,
-		Checkpoint: tx.Checkpoint,
-		Attachment: tx.Attachment,
-		Status:     string(tx.Status),
+		Id:           tx.ID[:],
+		Parents:      parents,
+		Children:     children,
+		From:         tx.From[:],
+		Sig:          tx.Sig,
+		StateURI:     tx.StateURI,
+		Patches:      patches,
+		Recipients:   recipients,
+		Checkpoint:   tx.Checkpoint,
+		Attachment:   tx.Attachment,
+		Capabilities: capabilities,
+		CoSigs:       coSigs,
+		Status:       string(tx.Status),
 	})
 }
 
@@ -242,6 +297,22 @@ func (tx *Tx) UnmarshalProto(bs []byte) error {
 
 	tx.Checkpoint = pbtx.Checkpoint
 	tx.Attachment = pbtx.Attachment
+
+	tx.Capabilities = nil
+	for _, tokenBytes := range pbtx.Capabilities {
+		var token CapabilityToken
+		err := json.Unmarshal(tokenBytes, &token)
+		if err != nil {
+			return err
+		}
+		tx.Capabilities = append(tx.Capabilities, token)
+	}
+
+	tx.CoSigs = nil
+	for _, sig := range pbtx.CoSigs {
+		tx.CoSigs = append(tx.CoSigs, types.Signature(sig))
+	}
+
 	tx.Status = TxStatus(pbtx.Status)
 	return nil
 }
@@ -31,6 +31,11 @@ func (p *badgerTxStore) Start() error {
 		return err
 	}
 	p.db = db
+
+	err = p.ensureStatusIndex()
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -58,8 +63,23 @@ func (p *badgerTxStore) AddTx(tx *Tx) (err error) {
 
 	key := makeTxKey(tx.StateURI, tx.ID)
 	err = p.db.Update(func(txn *badger.Txn) error {
+		// Move the tx to its new status in the status index
+		existing, err := fetchTxInTxn(txn, tx.StateURI, tx.ID)
+		if err == nil {
+			err = txn.Delete(makeTxStatusKey(tx.StateURI, existing.Status, tx.ID))
+			if err != nil {
+				return err
+			}
+		} else if errors.Cause(err) != types.Err404 {
+			return err
+		}
+		err = txn.Set(makeTxStatusKey(tx.StateURI, tx.Status, tx.ID), nil)
+		if err != nil {
+			return err
+		}
+
 		// Add the tx to the DB
-		err := txn.Set(key, []byte(bs))
+		err = txn.Set(key, []byte(bs))
 		if err != nil {
 			return err
 		}
@@ -67,6 +87,10 @@ func (p *badgerTxStore) AddTx(tx *Tx) (err error) {
 		// Add the new tx to the `.Children` slice on each of its parents
 		if tx.Status == TxStatusValid {
 			for _, parentID := range tx.Parents {
+				parentID, err := resolvePrunedTxID(txn, tx.StateURI, parentID)
+				if err != nil {
+					return err
+				}
 				item, err := txn.Get(makeTxKey(tx.StateURI, parentID))
 				if err != nil {
 					return errors.Wrapf(err, "can't find parent %v of tx %v", parentID, tx.ID)
@@ -109,26 +133,266 @@ func (p *badgerTxStore) AddTx(tx *Tx) (err error) {
 }
 
 func (p *badgerTxStore) RemoveTx(stateURI string, txID types.ID) error {
-	key := makeTxKey(stateURI, txID)
 	return p.db.Update(func(txn *badger.Txn) error {
-		return txn.Delete(key)
+		return removeTxInTxn(txn, stateURI, txID)
 	})
 }
 
-func (p *badgerTxStore) TxExists(stateURI string, txID types.ID) (bool, error) {
-	key := makeTxKey(stateURI, txID)
+func removeTxInTxn(txn *badger.Txn, stateURI string, txID types.ID) error {
+	tx, err := fetchTxInTxn(txn, stateURI, txID)
+	if errors.Cause(err) == types.Err404 {
+		return nil
+	} else if err != nil {
+		return err
+	}
 
-	var exists bool
+	err = txn.Delete(makeTxStatusKey(stateURI, tx.Status, txID))
+	if err != nil {
+		return err
+	}
+	return txn.Delete(makeTxKey(stateURI, txID))
+}
+
+func makeTxStatusKeyPrefix(stateURI string, status TxStatus) []byte {
+	return []byte("status:" + stateURI + ":" + string(status) + ":")
+}
+
+func makeTxStatusKey(stateURI string, status TxStatus, txID types.ID) []byte {
+	return append(makeTxStatusKeyPrefix(stateURI, status), txID[:]...)
+}
+
+// TxIDsWithStatus returns the IDs of all of the txs for the given state URI
+// that have the given status.
+func (p *badgerTxStore) TxIDsWithStatus(stateURI string, status TxStatus) ([]types.ID, error) {
+	var txIDs []types.ID
 	err := p.db.View(func(txn *badger.Txn) error {
-		_, err := txn.Get(key)
-		if err == badger.ErrKeyNotFound {
-			exists = false
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		iter := txn.NewIterator(opts)
+		defer iter.Close()
+
+		prefix := makeTxStatusKeyPrefix(stateURI, status)
+
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			txIDs = append(txIDs, types.IDFromBytes(iter.Item().Key()[len(prefix):]))
+		}
+		return nil
+	})
+	return txIDs, err
+}
+
+var statusIndexBuiltKey = []byte("meta:status-index-built")
+
+// ensureStatusIndex builds the status index for databases that were created
+// before it existed.  This only happens once.
+func (p *badgerTxStore) ensureStatusIndex() error {
+	var alreadyBuilt bool
+	var statusKeys [][]byte
+	err := p.db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(statusIndexBuiltKey)
+		if err == nil {
+			alreadyBuilt = true
 			return nil
+		} else if err != badger.ErrKeyNotFound {
+			return err
+		}
+
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+
+		prefix := []byte("tx:")
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			var tx Tx
+			err := iter.Item().Value(func(val []byte) error {
+				return tx.UnmarshalProto(val)
+			})
+			if err != nil {
+				return err
+			}
+			statusKeys = append(statusKeys, makeTxStatusKey(tx.StateURI, tx.Status, tx.ID))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	} else if alreadyBuilt {
+		return nil
+	}
+
+	batch := p.db.NewWriteBatch()
+	defer batch.Cancel()
+
+	for _, key := range append(statusKeys, statusIndexBuiltKey) {
+		err := batch.Set(key, nil)
+		if err != nil {
+			return err
+		}
+	}
+	err = batch.Flush()
+	if err != nil {
+		return err
+	}
+	if len(statusKeys) > 0 {
+		p.Infof(0, "indexed the status of %v txs", len(statusKeys))
+	}
+	return nil
+}
+
+func makePrunedTxKey(stateURI string, txID types.ID) []byte {
+	return append([]byte("pruned:"+stateURI+":"), txID[:]...)
+}
+
+func makeLeafKey(stateURI string, txID types.ID) []byte {
+	return append([]byte("leaf:"+stateURI+":"), txID[:]...)
+}
+
+// PruneTxs removes the given txs from the store and puts the snapshot tx in
+// place of the tx with the same ID.  The snapshot inherits that tx's children,
+// as well as the remaining children of the pruned txs.  Those children keep
+// their original parents, since the parents are covered by their signatures.
+// Instead, the pruned txs' IDs are remembered so that they resolve to the
+// snapshot (see ResolvePrunedTxID), and so that requests for history starting
+// from one of them begin at the snapshot.  Pruned txs that aren't in the store
+// are only remembered.
+func (p *badgerTxStore) PruneTxs(snapshot *Tx, prunedTxIDs []types.ID) (err error) {
+	defer utils.Annotate(&err, "badgerTxStore#PruneTxs")
+
+	stateURI := snapshot.StateURI
+	pruned := utils.NewIDSet(prunedTxIDs)
+
+	err = p.db.Update(func(txn *badger.Txn) error {
+		children := utils.NewIDSet(snapshot.Children)
+		existing, err := fetchTxInTxn(txn, stateURI, snapshot.ID)
+		if err == nil {
+			for _, childID := range existing.Children {
+				children.Add(childID)
+			}
+			err = txn.Delete(makeTxStatusKey(stateURI, existing.Status, snapshot.ID))
+			if err != nil {
+				return err
+			}
+		} else if errors.Cause(err) != types.Err404 {
+			return err
+		}
+
+		// The surviving children of pruned txs now descend from the snapshot
+		for prunedTxID := range pruned {
+			prunedTx, err := fetchTxInTxn(txn, stateURI, prunedTxID)
+			if errors.Cause(err) == types.Err404 {
+				continue
+			} else if err != nil {
+				return err
+			}
+
+			for _, childID := range prunedTx.Children {
+				if _, isPruned := pruned[childID]; isPruned || childID == snapshot.ID {
+					continue
+				}
+				children.Add(childID)
+			}
+		}
+
+		for prunedTxID := range pruned {
+			err := removeTxInTxn(txn, stateURI, prunedTxID)
+			if err != nil {
+				return err
+			}
+			err = txn.Delete(makeLeafKey(stateURI, prunedTxID))
+			if err != nil {
+				return err
+			}
+			err = txn.Set(makePrunedTxKey(stateURI, prunedTxID), snapshot.ID[:])
+			if err != nil {
+				return err
+			}
+		}
+
+		snapshotCopy := *snapshot
+		snapshotCopy.Children = children.Slice()
+		err = txn.Set(makeTxStatusKey(stateURI, snapshotCopy.Status, snapshotCopy.ID), nil)
+		if err != nil {
+			return err
+		}
+		return putTxInTxn(txn, &snapshotCopy)
+	})
+	if err != nil {
+		return err
+	}
+	p.Infof(0, "pruned %v txs before snapshot %v", len(pruned), snapshot.ID.Pretty())
+	return nil
+}
+
+// resolvePrunedTxID follows the chain of snapshots that have replaced the
+// given tx, returning the ID of the tx that history should start from.
+func resolvePrunedTxID(txn *badger.Txn, stateURI string, txID types.ID) (types.ID, error) {
+	for {
+		item, err := txn.Get(makePrunedTxKey(stateURI, txID))
+		if err == badger.ErrKeyNotFound {
+			return txID, nil
 		} else if err != nil {
-			return errors.WithStack(err)
+			return types.ID{}, err
 		}
+		err = item.Value(func(val []byte) error {
+			txID = types.IDFromBytes(val)
+			return nil
+		})
+		if err != nil {
+			return types.ID{}, err
+		}
+	}
+}
+
+// ResolvePrunedTxID returns the ID of the snapshot that stands in for the
+// given tx if it has been pruned, or the tx's own ID otherwise.
+func (p *badgerTxStore) ResolvePrunedTxID(stateURI string, txID types.ID) (resolved types.ID, err error) {
+	err = p.db.View(func(txn *badger.Txn) error {
+		resolved, err = resolvePrunedTxID(txn, stateURI, txID)
+		return err
+	})
+	return resolved, err
+}
+
+func fetchTxInTxn(txn *badger.Txn, stateURI string, txID types.ID) (*Tx, error) {
+	item, err := txn.Get(makeTxKey(stateURI, txID))
+	if err == badger.ErrKeyNotFound {
+		return nil, errors.WithStack(types.Err404)
+	} else if err != nil {
+		return nil, err
+	}
+
+	var tx Tx
+	err = item.Value(func(val []byte) error {
+		return tx.UnmarshalProto(val)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+func putTxInTxn(txn *badger.Txn, tx *Tx) error {
+	bs, err := tx.MarshalProto()
+	if err != nil {
+		return err
+	}
+	return txn.Set(makeTxKey(tx.StateURI, tx.ID), bs)
+}
 
-		exists = true
+// TxExists also returns true for txs that have been pruned, since their
+// effects are contained in the snapshot that replaced them.
+func (p *badgerTxStore) TxExists(stateURI string, txID types.ID) (bool, error) {
+	var exists bool
+	err := p.db.View(func(txn *badger.Txn) error {
+		for _, key := range [][]byte{makeTxKey(stateURI, txID), makePrunedTxKey(stateURI, txID)} {
+			_, err := txn.Get(key)
+			if err == badger.ErrKeyNotFound {
+				continue
+			} else if err != nil {
+				return errors.WithStack(err)
+			}
+			exists = true
+			return nil
+		}
 		return nil
 	})
 	return exists, err
@@ -171,10 +435,17 @@ func (p *badgerTxStore) AllTxsForStateURI(stateURI string, fromTxID types.ID) Tx
 	go func() {
 		defer close(txIter.ch)
 
-		stack := []types.ID{fromTxID}
+		var stack []types.ID
 		sent := make(map[types.ID]struct{})
 
 		txIter.err = p.db.View(func(txn *badger.Txn) error {
+			// History from before a prune point starts at the snapshot that replaced it
+			startTxID, err := resolvePrunedTxID(txn, stateURI, fromTxID)
+			if err != nil {
+				return err
+			}
+			stack = []types.ID{startTxID}
+
 			for len(stack) > 0 {
 				txID := stack[0]
 				stack = stack[1:]
@@ -239,13 +510,13 @@ func (s *badgerTxStore) KnownStateURIs() ([]string, error) {
 
 func (s *badgerTxStore) MarkLeaf(stateURI string, txID types.ID) error {
 	return s.db.Update(func(txn *badger.Txn) error {
-		return txn.Set(append([]byte("leaf:"+stateURI+":"), txID[:]...), nil)
+		return txn.Set(makeLeafKey(stateURI, txID), nil)
 	})
 }
 
 func (s *badgerTxStore) UnmarkLeaf(stateURI string, txID types.ID) error {
 	return s.db.Update(func(txn *badger.Txn) error {
-		return txn.Delete(append([]byte("leaf:"+stateURI+":"), txID[:]...))
+		return txn.Delete(makeLeafKey(stateURI, txID))
 	})
 }
 
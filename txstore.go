@@ -12,11 +12,14 @@ type TxStore interface {
 	RemoveTx(stateURI string, txID types.ID) error
 	TxExists(stateURI string, txID types.ID) (bool, error)
 	FetchTx(stateURI string, txID types.ID) (*Tx, error)
+	TxIDsWithStatus(stateURI string, status TxStatus) ([]types.ID, error)
 	AllTxsForStateURI(stateURI string, fromTxID types.ID) TxIterator
 	KnownStateURIs() ([]string, error)
 	MarkLeaf(stateURI string, txID types.ID) error
 	UnmarkLeaf(stateURI string, txID types.ID) error
 	Leaves(stateURI string) ([]types.ID, error)
+	PruneTxs(snapshot *Tx, prunedTxIDs []types.ID) error
+	ResolvePrunedTxID(stateURI string, txID types.ID) (types.ID, error)
 }
 
 type TxIterator interface {
@@ -0,0 +1,189 @@
+package redwood
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"redwood.dev/nelson"
+	"redwood.dev/tree"
+	"redwood.dev/types"
+	"redwood.dev/utils"
+)
+
+// RevokedCapabilitiesKeypath is where the capabilities validator looks up
+// revoked tokens, relative to the subtree that it governs.  A token is revoked
+// if Revoked/<token ID> exists.
+var RevokedCapabilitiesKeypath = tree.Keypath("Revoked")
+
+// CapabilitiesClockKeypath is where the capabilities validator looks up the
+// time (in Unix seconds) that token expiry is checked against, relative to
+// the subtree that it governs.  Only roots can set it, and until they do, no
+// token expires.
+var CapabilitiesClockKeypath = tree.Keypath("Clock")
+
+type capabilitiesValidator struct {
+	roots map[types.Address]bool
+}
+
+// Ensure capabilitiesValidator conforms to the Validator interface
+var _ Validator = (*capabilitiesValidator)(nil)
+
+// NewCapabilitiesValidator creates a validator that lets its 'roots' write
+// anywhere in the subtree, and lets anyone else write wherever a capability
+// token attached to their tx allows, e.g.:
+//
+//	{"roots": ["96216849c49358b10257cb55b28ea603c874b05e"]}
+//
+// Every token's chain of proofs must start with a root.  Revoking a token
+// (by writing it to Revoked/<token ID>) also revokes everything delegated
+// from it.  Roots may revoke any token; anyone else may only revoke tokens in
+// whose chain they are an issuer.  Tokens expire once the roots advance the
+// clock at Clock past their expiry.
+func NewCapabilitiesValidator(config tree.Node) (_ Validator, err error) {
+	defer utils.Annotate(&err, "NewCapabilitiesValidator")
+
+	rootsVal, exists, err := nelson.GetValueRecursive(config, tree.Keypath("roots"), nil)
+	if err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, errors.New("capabilities validator needs a 'roots' param")
+	}
+
+	rootsSlice, isSlice := rootsVal.([]interface{})
+	if !isSlice {
+		return nil, errors.Errorf("capabilities validator needs a 'roots' param containing a list of addresses (got %T)", rootsVal)
+	}
+
+	roots := make(map[types.Address]bool, len(rootsSlice))
+	for _, root := range rootsSlice {
+		rootStr, isString := root.(string)
+		if !isString {
+			return nil, errors.Errorf("capabilities validator needs a 'roots' param containing a list of addresses (got %T)", root)
+		}
+		addr, err := types.AddressFromHex(rootStr)
+		if err != nil {
+			return nil, err
+		}
+		roots[addr] = true
+	}
+	return &capabilitiesValidator{roots: roots}, nil
+}
+
+func (v *capabilitiesValidator) ValidateTx(state tree.Node, tx *Tx) error {
+	if v.roots[tx.From] {
+		return nil
+	}
+
+	now, err := v.clock(state)
+	if err != nil {
+		return err
+	}
+
+	var tokens []CapabilityToken
+	for _, token := range tx.Capabilities {
+		if token.Audience != tx.From {
+			return errors.Wrapf(ErrInvalidTx, "capability %v was not issued to %v", token.ID(), tx.From.Hex())
+		}
+		err := v.verify(state, token, now)
+		if err != nil {
+			return errors.Wrap(ErrInvalidTx, err.Error())
+		}
+		tokens = append(tokens, token)
+	}
+
+	for _, patch := range tx.Patches {
+		if patch.Keypath.StartsWith(CapabilitiesClockKeypath) || CapabilitiesClockKeypath.StartsWith(patch.Keypath) {
+			return errors.Wrapf(types.Err403, "only roots can set the clock (user: %v, patch: %v)", tx.From.String(), patch.String())
+		} else if v.isRevocation(patch, tx.From, now) {
+			continue
+		}
+
+		var covered bool
+		for _, token := range tokens {
+			if token.Covers(patch.Keypath) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return errors.Wrapf(types.Err403, "no capability covers this (user: %v, patch: %v)", tx.From.String(), patch.String())
+		}
+	}
+	return nil
+}
+
+// clock returns the time that token expiry is checked against.
+func (v *capabilitiesValidator) clock(state tree.Node) (time.Time, error) {
+	val, exists, err := state.Value(CapabilitiesClockKeypath, nil)
+	if err != nil {
+		return time.Time{}, err
+	} else if !exists {
+		return time.Time{}, nil
+	}
+	seconds, isNumber := numberValue(val)
+	if !isNumber {
+		return time.Time{}, errors.Wrapf(ErrInvalidTx, "capabilities clock must be a number of seconds (got %T)", val)
+	}
+	return time.Unix(int64(seconds), 0), nil
+}
+
+func (v *capabilitiesValidator) verify(state tree.Node, token CapabilityToken, now time.Time) error {
+	chain := token.Chain()
+	root := chain[len(chain)-1].Issuer
+	if !v.roots[root] {
+		return errors.Wrapf(ErrInvalidCapability, "chain was started by %v, who is not a root", root.Hex())
+	}
+
+	err := token.Verify(root, now)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range chain {
+		revoked, err := state.Exists(RevokedCapabilitiesKeypath.Pushs(t.ID()))
+		if err != nil {
+			return err
+		} else if revoked {
+			return errors.Wrapf(ErrInvalidCapability, "token %v has been revoked", t.ID())
+		}
+	}
+	return nil
+}
+
+// isRevocation returns true if the patch writes a valid token to its own entry
+// in the revocation list and the sender issued some part of its chain.
+func (v *capabilitiesValidator) isRevocation(patch Patch, sender types.Address, now time.Time) bool {
+	if patch.Range != nil || patch.Val == nil {
+		return false
+	}
+	parent, id := patch.Keypath.Pop()
+	if !parent.Equals(RevokedCapabilitiesKeypath) {
+		return false
+	}
+
+	bs, err := json.Marshal(patch.Val)
+	if err != nil {
+		return false
+	}
+	var token CapabilityToken
+	err = json.Unmarshal(bs, &token)
+	if err != nil || token.ID() != string(id) {
+		return false
+	}
+
+	// Otherwise, anyone could claim to have issued a token by forging it
+	chain := token.Chain()
+	root := chain[len(chain)-1].Issuer
+	if !v.roots[root] || token.Verify(root, now) != nil {
+		return false
+	}
+
+	for _, t := range chain {
+		if t.Issuer == sender {
+			return true
+		}
+	}
+	return false
+}
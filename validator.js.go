@@ -0,0 +1,123 @@
+package redwood
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+
+	"github.com/pkg/errors"
+	"rogchap.com/v8go"
+
+	"redwood.dev/nelson"
+	"redwood.dev/tree"
+	"redwood.dev/utils"
+)
+
+type jsValidator struct {
+	mu sync.Mutex
+	vm *v8go.Context
+}
+
+// Ensure jsValidator conforms to the Validator interface
+var _ Validator = (*jsValidator)(nil)
+
+// NewJSValidator loads a validator from the Javascript in the config's 'src'
+// param.  The script must define a function:
+//
+//	validate(state, tx)
+//	    Called with the current state and the tx (see scriptTx).  Throwing
+//	    an error rejects the tx.
+func NewJSValidator(config tree.Node) (_ Validator, err error) {
+	defer utils.Annotate(&err, "NewJSValidator")
+
+	srcval, exists, err := nelson.GetValueRecursive(config, tree.Keypath("src"), nil)
+	if err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, errors.Errorf("js validator needs a 'src' param")
+	}
+
+	readableSrc, ok := nelson.GetReadCloser(srcval)
+	if !ok {
+		return nil, errors.Errorf("js validator needs a 'src' param of type string, []byte, or io.ReadCloser (got %T)", srcval)
+	}
+	defer readableSrc.Close()
+
+	srcStr, err := ioutil.ReadAll(readableSrc)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	v8ctx, _ := v8go.NewContext(nil)
+
+	_, err = v8ctx.RunScript("var global = {}; "+string(srcStr), "validator.js")
+	if err != nil {
+		return nil, err
+	}
+
+	isFunc, err := v8ctx.RunScript("typeof validate === 'function'", "")
+	if err != nil {
+		return nil, err
+	} else if isFunc.String() != "true" {
+		return nil, errors.New("js validator must define a 'validate' function")
+	}
+	return &jsValidator{vm: v8ctx}, nil
+}
+
+func (v *jsValidator) ValidateTx(state tree.Node, tx *Tx) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	txJSON, err := json.Marshal(scriptTx(tx))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = v.vm.RunScript("validate("+string(stateJSON)+", "+string(txJSON)+")", "")
+	if jsErr, is := err.(*v8go.JSError); is {
+		return errors.Wrap(ErrInvalidTx, jsErr.Message)
+	} else if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// scriptTx converts a tx into the plain representation that script
+// validators receive.  Patches are given in the same form that script
+// resolvers receive them.
+func scriptTx(tx *Tx) map[string]interface{} {
+	parents := make([]interface{}, len(tx.Parents))
+	for i := range tx.Parents {
+		parents[i] = tx.Parents[i].Hex()
+	}
+
+	patches := make([]interface{}, len(tx.Patches))
+	for i, patch := range tx.Patches {
+		keys := make([]interface{}, 0, len(patch.Keypath.PartStrings()))
+		for _, key := range patch.Keypath.PartStrings() {
+			keys = append(keys, key)
+		}
+		converted := map[string]interface{}{
+			"keys": keys,
+			"val":  patch.Val,
+		}
+		if patch.Range != nil {
+			converted["range"] = []interface{}{patch.Range.Start, patch.Range.End}
+		}
+		patches[i] = converted
+	}
+
+	return map[string]interface{}{
+		"id":         tx.ID.Hex(),
+		"parents":    parents,
+		"from":       tx.From.Hex(),
+		"stateURI":   tx.StateURI,
+		"patches":    patches,
+		"checkpoint": tx.Checkpoint,
+	}
+}
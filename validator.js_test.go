@@ -0,0 +1,70 @@
+package redwood
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"redwood.dev/tree"
+	"redwood.dev/types"
+)
+
+func TestScriptValidators(t *testing.T) {
+	tests := []struct {
+		name         string
+		newValidator func(config tree.Node) (Validator, error)
+		src          string
+	}{
+		{"js", NewJSValidator, `
+			function validate(state, tx) {
+				for (const patch of tx.patches) {
+					var author = state.messages[patch.keys[1]].author
+					if (author !== tx.from) {
+						throw new Error("only the author may edit their message")
+					}
+				}
+			}
+		`},
+		{"lua", NewLuaValidator, `
+			function validate(state, tx)
+				for _, patch in ipairs(tx.patches) do
+					local author = state.messages[patch.keys[2]].author
+					if author ~= tx.from then
+						error("only the author may edit their message")
+					end
+				end
+			end
+		`},
+	}
+
+	alice := types.AddressFromBytes([]byte("alice"))
+	bob := types.AddressFromBytes([]byte("bob"))
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			config := tree.NewMemoryNode()
+			err := config.Set(tree.Keypath("src"), nil, test.src)
+			require.NoError(t, err)
+
+			validator, err := test.newValidator(config)
+			require.NoError(t, err)
+
+			state := tree.NewMemoryNode()
+			err = state.Set(tree.Keypath("messages/0/author"), nil, alice.Hex())
+			require.NoError(t, err)
+
+			tx := &Tx{
+				From:    alice,
+				Patches: []Patch{{Keypath: tree.Keypath("messages/0/text"), Val: "hello"}},
+			}
+			require.NoError(t, validator.ValidateTx(state, tx))
+
+			tx.From = bob
+			err = validator.ValidateTx(state, tx)
+			require.Equal(t, ErrInvalidTx, errors.Cause(err))
+			require.Contains(t, err.Error(), "only the author may edit their message")
+		})
+	}
+}
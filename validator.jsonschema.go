@@ -0,0 +1,147 @@
+package redwood
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+
+	"redwood.dev/nelson"
+	"redwood.dev/tree"
+	"redwood.dev/types"
+	"redwood.dev/utils"
+)
+
+type jsonSchemaValidator struct {
+	schema *jsonschema.Schema
+}
+
+// Ensure jsonSchemaValidator conforms to the Validator interface
+var _ Validator = (*jsonSchemaValidator)(nil)
+
+// NewJSONSchemaValidator loads a validator that checks its subtree against the
+// JSON Schema in the config's 'schema' param.  The schema can be given inline
+// or as a ref.
+func NewJSONSchemaValidator(config tree.Node) (_ Validator, err error) {
+	defer utils.Annotate(&err, "NewJSONSchemaValidator")
+
+	schemaVal, exists, err := nelson.GetValueRecursive(config, tree.Keypath("schema"), nil)
+	if err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, errors.Errorf("jsonschema validator needs a 'schema' param")
+	}
+
+	var schemaBytes []byte
+	switch schemaVal.(type) {
+	case map[string]interface{}, bool:
+		schemaBytes, err = json.Marshal(schemaVal)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	default:
+		readableSchema, ok := nelson.GetReadCloser(schemaVal)
+		if !ok {
+			return nil, errors.Errorf("jsonschema validator needs a 'schema' param of type object, string, []byte, or io.ReadCloser (got %T)", schemaVal)
+		}
+		defer readableSchema.Close()
+
+		schemaBytes, err = ioutil.ReadAll(readableSchema)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	compiler := jsonschema.NewCompiler()
+	err = compiler.AddResource("schema.json", bytes.NewReader(schemaBytes))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	schema, err := compiler.Compile("schema.json")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &jsonSchemaValidator{schema: schema}, nil
+}
+
+func (v *jsonSchemaValidator) ValidateTx(state tree.Node, tx *Tx) error {
+	if len(tx.Patches) == 0 {
+		return nil
+	}
+
+	// Apply the tx to a scratch copy of the subtree and validate the result
+	scratch, err := state.CopyToMemory(nil, nil)
+	if errors.Cause(err) == types.Err404 {
+		scratch = tree.NewMemoryNode()
+	} else if err != nil {
+		return err
+	}
+
+	for _, patch := range tx.Patches {
+		if patch.Val != nil {
+			err = scratch.Set(patch.Keypath, patch.Range, patch.Val)
+		} else {
+			err = scratch.Delete(patch.Keypath, patch.Range)
+		}
+		if err != nil {
+			return errors.Wrapf(ErrInvalidTx, "could not apply patch %v: %v", patch.String(), err)
+		}
+	}
+
+	// The subtree's behaviors aren't part of the data being described, and
+	// neither are the values of its views, which the controller computes
+	views, _, err := scratch.Value(ViewsKeypath, nil)
+	if err != nil {
+		return err
+	}
+	viewConfigs, _ := views.(map[string]interface{})
+	toStrip := append([]tree.Keypath(nil), behaviorKeypaths...)
+	for viewName := range viewConfigs {
+		toStrip = append(toStrip, tree.Keypath(viewName))
+	}
+	for _, keypath := range toStrip {
+		err = scratch.Delete(keypath, nil)
+		if err != nil && errors.Cause(err) != types.Err404 {
+			return err
+		}
+	}
+
+	bs, err := json.Marshal(scratch)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(bs))
+	decoder.UseNumber()
+	err = decoder.Decode(&doc)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	err = v.schema.Validate(doc)
+	if verr, is := err.(*jsonschema.ValidationError); is {
+		// Report the most specific failure
+		for len(verr.Causes) > 0 {
+			verr = verr.Causes[0]
+		}
+		return errors.Wrapf(ErrInvalidTx, "keypath '%v' does not match schema: %v", jsonPointerToKeypath(verr.InstanceLocation), verr.Message)
+	} else if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func jsonPointerToKeypath(ptr string) tree.Keypath {
+	var keypath tree.Keypath
+	for _, part := range strings.Split(strings.TrimPrefix(ptr, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		keypath = keypath.Push(tree.Keypath(part))
+	}
+	return keypath
+}
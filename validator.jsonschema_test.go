@@ -0,0 +1,58 @@
+package redwood_test
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"redwood.dev"
+	"redwood.dev/tree"
+)
+
+func TestJSONSchemaValidator(t *testing.T) {
+	config := tree.NewMemoryNode()
+	err := config.Set(tree.Keypath("schema"), nil, M{
+		"type":                 "object",
+		"additionalProperties": false,
+		"properties": M{
+			"messages": M{
+				"type": "object",
+				"additionalProperties": M{
+					"type":     "object",
+					"required": []interface{}{"text"},
+					"properties": M{
+						"text": M{"type": "string"},
+					},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	validator, err := redwood.NewJSONSchemaValidator(config)
+	require.NoError(t, err)
+
+	// The subtree's behaviors and views don't have to be described by the schema
+	state := tree.NewMemoryNode()
+	err = state.Set(nil, nil, M{
+		"Merge-Type": M{"Content-Type": "resolver/dumb"},
+		"Views":      M{"total": M{"Content-Type": "view/count", "source": "messages"}},
+		"total":      0.0,
+		"messages":   M{},
+	})
+	require.NoError(t, err)
+
+	tx := &redwood.Tx{Patches: []redwood.Patch{{Keypath: tree.Keypath("messages/a"), Val: M{"text": "hello"}}}}
+	require.NoError(t, validator.ValidateTx(state, tx))
+
+	tx = &redwood.Tx{Patches: []redwood.Patch{{Keypath: tree.Keypath("messages/b"), Val: M{"text": 123}}}}
+	err = validator.ValidateTx(state, tx)
+	require.Equal(t, redwood.ErrInvalidTx, errors.Cause(err))
+	require.Contains(t, err.Error(), "messages/b/text")
+
+	// The state itself is left untouched
+	exists, err := state.Exists(tree.Keypath("messages/b"))
+	require.NoError(t, err)
+	require.False(t, exists)
+}
@@ -0,0 +1,111 @@
+package redwood
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"sync"
+
+	"github.com/brynbellomy/go-luaconv"
+	"github.com/pkg/errors"
+	lua "github.com/yuin/gopher-lua"
+
+	"redwood.dev/nelson"
+	"redwood.dev/tree"
+	"redwood.dev/utils"
+)
+
+type luaValidator struct {
+	mu sync.Mutex
+	L  *lua.LState
+}
+
+// Ensure luaValidator conforms to the Validator interface
+var _ Validator = (*luaValidator)(nil)
+
+// NewLuaValidator loads a validator from the Lua in the config's 'src' param.
+// The script must define a function:
+//
+//	validate(state, tx)
+//	    Called with the current state and the tx (see scriptTx) as tables.
+//	    Raising an error rejects the tx.
+func NewLuaValidator(config tree.Node) (_ Validator, err error) {
+	defer utils.Annotate(&err, "NewLuaValidator")
+
+	srcval, exists, err := nelson.GetValueRecursive(config, tree.Keypath("src"), nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	} else if !exists {
+		return nil, errors.Errorf("lua validator needs a 'src' param")
+	}
+
+	readableSrc, ok := nelson.GetReadCloser(srcval)
+	if !ok {
+		return nil, errors.Errorf("lua validator needs a 'src' param of type string, []byte, or io.ReadCloser (got %T)", srcval)
+	}
+	defer readableSrc.Close()
+
+	srcStr, err := ioutil.ReadAll(readableSrc)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	L := lua.NewState()
+	err = L.DoString(string(srcStr))
+	if err != nil {
+		L.Close()
+		return nil, err
+	}
+
+	if L.GetGlobal("validate").Type() != lua.LTFunction {
+		L.Close()
+		return nil, errors.New("lua validator must define a 'validate' function")
+	}
+	return &luaValidator{L: L}, nil
+}
+
+func (v *luaValidator) ValidateTx(state tree.Node, tx *Tx) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	luaState, err := v.encode(state)
+	if err != nil {
+		return err
+	}
+
+	luaTx, err := v.encode(scriptTx(tx))
+	if err != nil {
+		return err
+	}
+
+	err = v.L.CallByParam(lua.P{
+		Fn:      v.L.GetGlobal("validate"),
+		NRet:    0,
+		Protect: true,
+	}, luaState, luaTx)
+	if apiErr, is := err.(*lua.ApiError); is && apiErr.Type == lua.ApiErrorRun {
+		return errors.Wrap(ErrInvalidTx, apiErr.Object.String())
+	} else if err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+// encode round-trips a value through JSON so that the script gets plain
+// tables rather than wrapped Go values (like tree.Node).
+func (v *luaValidator) encode(x interface{}) (lua.LValue, error) {
+	bs, err := json.Marshal(x)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var plain interface{}
+	err = json.Unmarshal(bs, &plain)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	lv, err := luaconv.Encode(v.L, reflect.ValueOf(plain))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return lv, nil
+}
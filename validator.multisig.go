@@ -0,0 +1,110 @@
+package redwood
+
+import (
+	"github.com/pkg/errors"
+
+	"redwood.dev/crypto"
+	"redwood.dev/nelson"
+	"redwood.dev/tree"
+	"redwood.dev/types"
+	"redwood.dev/utils"
+)
+
+type multisigValidator struct {
+	signers   map[types.Address]bool
+	threshold int
+}
+
+// Ensure multisigValidator conforms to the Validator interface
+var _ Validator = (*multisigValidator)(nil)
+
+// NewMultisigValidator creates a validator that only accepts txs approved by
+// at least 'threshold' of its 'signers', e.g.:
+//
+//	{
+//	    "signers": ["96216849c49358b10257cb55b28ea603c874b05e", "..."],
+//	    "threshold": 2
+//	}
+//
+// The sender's own signature counts as an approval if they're a signer.  The
+// rest are taken from the tx's CoSigs, which must be signatures over the tx's
+// hash (see Host.ProposeTx).
+func NewMultisigValidator(config tree.Node) (_ Validator, err error) {
+	defer utils.Annotate(&err, "NewMultisigValidator")
+
+	signersVal, exists, err := nelson.GetValueRecursive(config, tree.Keypath("signers"), nil)
+	if err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, errors.New("multisig validator needs a 'signers' param")
+	}
+	signersSlice, isSlice := signersVal.([]interface{})
+	if !isSlice {
+		return nil, errors.Errorf("multisig validator needs a 'signers' param containing a list of addresses (got %T)", signersVal)
+	}
+
+	signers := make(map[types.Address]bool, len(signersSlice))
+	for _, signer := range signersSlice {
+		signerStr, isString := signer.(string)
+		if !isString {
+			return nil, errors.Errorf("multisig validator needs a 'signers' param containing a list of addresses (got %T)", signer)
+		}
+		addr, err := types.AddressFromHex(signerStr)
+		if err != nil {
+			return nil, err
+		}
+		signers[addr] = true
+	}
+
+	thresholdVal, exists, err := nelson.GetValueRecursive(config, tree.Keypath("threshold"), nil)
+	if err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, errors.New("multisig validator needs a 'threshold' param")
+	}
+
+	var threshold int
+	switch t := thresholdVal.(type) {
+	case float64:
+		threshold = int(t)
+	case int64:
+		threshold = int(t)
+	case uint64:
+		threshold = int(t)
+	case int:
+		threshold = t
+	default:
+		return nil, errors.Errorf("multisig validator needs a numeric 'threshold' param (got %T)", thresholdVal)
+	}
+	if threshold < 1 || threshold > len(signers) {
+		return nil, errors.Errorf("multisig validator's threshold must be between 1 and the number of signers (got %v)", threshold)
+	}
+	return &multisigValidator{signers: signers, threshold: threshold}, nil
+}
+
+func (v *multisigValidator) ValidateTx(state tree.Node, tx *Tx) error {
+	if len(tx.Patches) == 0 {
+		return nil
+	}
+
+	approvals := make(map[types.Address]bool)
+	if v.signers[tx.From] {
+		approvals[tx.From] = true
+	}
+
+	hash := tx.Hash()
+	for _, sig := range tx.CoSigs {
+		pubkey, err := crypto.RecoverSigningPubkey(hash, sig)
+		if err != nil {
+			return errors.Wrapf(ErrInvalidTx, "bad co-signature: %v", err)
+		}
+		if v.signers[pubkey.Address()] {
+			approvals[pubkey.Address()] = true
+		}
+	}
+
+	if len(approvals) < v.threshold {
+		return errors.Wrapf(types.Err403, "tx has %v of the %v approvals it needs", len(approvals), v.threshold)
+	}
+	return nil
+}
@@ -13,9 +13,16 @@ import (
 )
 
 type permissionsValidator struct {
-	permissions map[string]interface{}
+	permissions   map[string]interface{}
+	restrictsRead bool
 }
 
+// Ensure permissionsValidator conforms to the Validator and ReadPermissioner interfaces
+var (
+	_ Validator        = (*permissionsValidator)(nil)
+	_ ReadPermissioner = (*permissionsValidator)(nil)
+)
+
 func NewPermissionsValidator(config tree.Node) (Validator, error) {
 	cfg, exists, err := nelson.GetValueRecursive(config, nil, nil)
 	if err != nil {
@@ -29,10 +36,16 @@ func NewPermissionsValidator(config tree.Node) (Validator, error) {
 		return nil, errors.New("permissions validator needs a map of permissions as its config")
 	}
 	lowercasePerms := make(map[string]interface{}, len(asMap))
+	var restrictsRead bool
 	for address, perms := range asMap {
 		lowercasePerms[strings.ToLower(address)] = perms
+
+		// Reads are only restricted if at least one rule mentions them
+		if rulesMentionRead(perms) {
+			restrictsRead = true
+		}
 	}
-	return &permissionsValidator{permissions: lowercasePerms}, nil
+	return &permissionsValidator{permissions: lowercasePerms, restrictsRead: restrictsRead}, nil
 }
 
 var senderRegexp = regexp.MustCompile(`\$\(sender\)`)
@@ -51,29 +64,87 @@ func (v *permissionsValidator) ValidateTx(state tree.Node, tx *Tx) error {
 	}
 
 	for _, patch := range tx.Patches {
-		var valid bool
+		if !rulesAllow(permsMap, "write", tx.From.Hex(), permissionsKeypath(patch.Keypath)) {
+			return errors.Wrapf(types.Err403, "could not find a matching rule (user: %v, patch: %v)", tx.From.String(), patch.String())
+		}
+	}
 
-		// @@TODO: hacky
-		keypath := KeypathSeparator + string(bytes.ReplaceAll(patch.Keypath, tree.KeypathSeparator, []byte(KeypathSeparator)))
-		for pattern := range permsMap {
-			expandedPattern := string(senderRegexp.ReplaceAll([]byte(pattern), []byte(tx.From.Hex())))
-			matched, err := regexp.MatchString(expandedPattern, keypath)
-			if err != nil {
-				return errors.Wrapf(types.Err403, "error executing regex")
-			}
+	return nil
+}
+
+// CanRead returns true if any of the readers has a rule with "read": true
+// matching the keypath or one of its ancestors.  Rules for "*" apply to
+// everyone.  Permission maps that don't mention "read" at all don't restrict
+// reads.
+func (v *permissionsValidator) CanRead(state tree.Node, readers []types.Address, keypath tree.Keypath) bool {
+	if !v.restrictsRead {
+		return true
+	}
 
-			if matched {
-				canWrite, _ := getValue(permsMap, []string{pattern, "write"})
-				if canWrite == true {
-					valid = true
-					break
-				}
+	ancestors := permissionsKeypathAncestors(keypath)
+	if len(readers) == 0 {
+		return rulesAllow(v.permissions["*"], "read", "", ancestors...)
+	}
+	for _, reader := range readers {
+		if rulesAllow(v.permissions[strings.ToLower(reader.Hex())], "read", reader.Hex(), ancestors...) ||
+			rulesAllow(v.permissions["*"], "read", reader.Hex(), ancestors...) {
+			return true
+		}
+	}
+	return false
+}
+
+// rulesAllow returns true if any of the given rules (a map of keypath
+// patterns to flags like {"read": true, "write": false}) grants the action on
+// one of the keypaths.  "$(sender)" in a pattern is replaced with the given
+// address.
+func rulesAllow(rules interface{}, action string, sender string, keypaths ...string) bool {
+	rulesMap, isMap := rules.(map[string]interface{})
+	if !isMap {
+		return false
+	}
+	for pattern := range rulesMap {
+		if allowed, _ := getValue(rulesMap, []string{pattern, action}); allowed != true {
+			continue
+		}
+		expandedPattern := string(senderRegexp.ReplaceAll([]byte(pattern), []byte(sender)))
+		for _, keypath := range keypaths {
+			matched, err := regexp.MatchString(expandedPattern, keypath)
+			if err == nil && matched {
+				return true
 			}
 		}
-		if !valid {
-			return errors.Wrapf(types.Err403, "could not find a matching rule (user: %v, patch: %v)", tx.From.String(), patch.String())
+	}
+	return false
+}
+
+func rulesMentionRead(rules interface{}) bool {
+	rulesMap, isMap := rules.(map[string]interface{})
+	if !isMap {
+		return false
+	}
+	for pattern := range rulesMap {
+		if _, exists := getValue(rulesMap, []string{pattern, "read"}); exists {
+			return true
 		}
 	}
+	return false
+}
 
-	return nil
+// permissionsKeypath converts a keypath into the form that permission rules
+// are matched against (e.g. ".messages.0.text").
+func permissionsKeypath(keypath tree.Keypath) string {
+	// @@TODO: hacky
+	return KeypathSeparator + string(bytes.ReplaceAll(keypath, tree.KeypathSeparator, []byte(KeypathSeparator)))
+}
+
+// permissionsKeypathAncestors returns the keypath and all of its ancestors in
+// the form that permission rules are matched against.
+func permissionsKeypathAncestors(keypath tree.Keypath) []string {
+	parts := keypath.Parts()
+	ancestors := []string{permissionsKeypath(nil)}
+	for i := range parts {
+		ancestors = append(ancestors, permissionsKeypath(tree.JoinKeypaths(parts[:i+1], tree.KeypathSeparator)))
+	}
+	return ancestors
 }
@@ -0,0 +1,122 @@
+package redwood
+
+import (
+	"github.com/pkg/errors"
+
+	"redwood.dev/nelson"
+	"redwood.dev/tree"
+	"redwood.dev/types"
+)
+
+// RolesKeypath is where the roles validator looks up role membership,
+// relative to the subtree that it governs.  An address holds a role if
+// Roles/<role>/<address> is true.
+var RolesKeypath = tree.Keypath("Roles")
+
+type rolesValidator struct {
+	rules         map[string]interface{}
+	restrictsRead bool
+}
+
+// Ensure rolesValidator conforms to the Validator and ReadPermissioner interfaces
+var (
+	_ Validator        = (*rolesValidator)(nil)
+	_ ReadPermissioner = (*rolesValidator)(nil)
+)
+
+// NewRolesValidator creates a validator whose rules are keyed by role rather
+// than by address.  The config is a map of role names to rules in the same
+// form as the permissions validator's, e.g.:
+//
+//	{
+//	    "admins":  {"^.*$": {"read": true, "write": true}},
+//	    "members": {"^\\.messages": {"read": true, "write": true}},
+//	    "*":       {"^\\.messages": {"read": true}}
+//	}
+//
+// Rules for "*" apply to everyone.  Role membership lives in the governed
+// subtree itself (see RolesKeypath), so changing it is just another write
+// that the rules have to allow.  Membership is always evaluated against the
+// state before the tx, so a tx can't grant a role and use it at once.
+func NewRolesValidator(config tree.Node) (Validator, error) {
+	cfg, exists, err := nelson.GetValueRecursive(config, nil, nil)
+	if err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, errors.New("roles validator needs a map of roles to rules as its config")
+	}
+
+	asMap, isMap := cfg.(map[string]interface{})
+	if !isMap {
+		return nil, errors.New("roles validator needs a map of roles to rules as its config")
+	}
+
+	var restrictsRead bool
+	for _, rules := range asMap {
+		if rulesMentionRead(rules) {
+			restrictsRead = true
+		}
+	}
+	return &rolesValidator{rules: asMap, restrictsRead: restrictsRead}, nil
+}
+
+func (v *rolesValidator) ValidateTx(state tree.Node, tx *Tx) error {
+	roles, err := rolesOf(state, tx.From)
+	if err != nil {
+		return err
+	}
+
+	for _, patch := range tx.Patches {
+		keypath := permissionsKeypath(patch.Keypath)
+
+		var valid bool
+		for _, role := range roles {
+			if rulesAllow(v.rules[role], "write", tx.From.Hex(), keypath) {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return errors.Wrapf(types.Err403, "none of the roles %v may write this (user: %v, patch: %v)", roles, tx.From.String(), patch.String())
+		}
+	}
+	return nil
+}
+
+func (v *rolesValidator) CanRead(state tree.Node, readers []types.Address, keypath tree.Keypath) bool {
+	if !v.restrictsRead {
+		return true
+	}
+
+	ancestors := permissionsKeypathAncestors(keypath)
+	if len(readers) == 0 {
+		return rulesAllow(v.rules["*"], "read", "", ancestors...)
+	}
+	for _, reader := range readers {
+		roles, err := rolesOf(state, reader)
+		if err != nil {
+			return false
+		}
+		for _, role := range roles {
+			if rulesAllow(v.rules[role], "read", reader.Hex(), ancestors...) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rolesOf returns the roles that the address holds in the given state,
+// including "*".
+func rolesOf(state tree.Node, addr types.Address) ([]string, error) {
+	roles := []string{"*"}
+	for _, role := range state.NodeAt(RolesKeypath, nil).Subkeys() {
+		isMember, _, err := state.BoolValue(RolesKeypath.Push(role).Pushs(addr.Hex()))
+		if err != nil {
+			return nil, err
+		} else if isMember {
+			roles = append(roles, string(role))
+		}
+	}
+	return roles, nil
+}
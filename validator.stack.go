@@ -1,53 +1,55 @@
 package redwood
 
-// import (
-// 	"github.com/pkg/errors"
-// )
-
-// type stackValidator struct {
-// 	validators []Validator
-// }
-
-// func NewStackValidator(params map[string]interface{}) (Validator, error) {
-// 	children, exists := M(params).GetSlice("children")
-// 	if !exists {
-// 		return nil, errors.New("stack validator needs an array 'children' param")
-// 	}
-
-// 	var validators []Validator
-// 	for i := range children {
-// 		config, is := children[i].(map[string]interface{})
-// 		if !is {
-// 			return nil, errors.New("stack validator found something that didn't look like a validator config")
-// 		}
-
-// 		validator, err := initValidatorFromConfig(config)
-// 		if err != nil {
-// 			return nil, err
-// 		}
-
-// 		validators = append(validators, validator)
-// 	}
-
-// 	return &stackValidator{validators: validators}, nil
-// }
-
-// func (v *stackValidator) ValidateFetch(state interface{}, keypath []string, requester Address) error {
-// 	for i := range v.validators {
-// 		err := v.validators[i].ValidateFetch(state, keypath, requester)
-// 		if err != nil {
-// 			return err
-// 		}
-// 	}
-// 	return nil
-// }
-
-// func (v *stackValidator) ValidateTx(state interface{}, txs, validTxs map[ID]*Tx, tx Tx) error {
-// 	for i := range v.validators {
-// 		err := v.validators[i].ValidateTx(state, txs, validTxs, tx)
-// 		if err != nil {
-// 			return err
-// 		}
-// 	}
-// 	return nil
-// }
+import (
+	"github.com/pkg/errors"
+
+	"redwood.dev/nelson"
+	"redwood.dev/tree"
+	"redwood.dev/utils"
+)
+
+type stackValidator struct {
+	validators []Validator
+}
+
+// Ensure stackValidator conforms to the Validator interface
+var _ Validator = (*stackValidator)(nil)
+
+func NewStackValidator(config tree.Node) (_ Validator, err error) {
+	defer utils.Annotate(&err, "NewStackValidator")
+
+	childConfigs, err := stackChildConfigs(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var validators []Validator
+	for i, childConfig := range childConfigs {
+		contentType, err := nelson.GetContentType(childConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		ctor, exists := validatorRegistry[contentType]
+		if !exists {
+			return nil, errors.Errorf("stack validator: unknown validator type '%v'", contentType)
+		}
+
+		validator, err := ctor(childConfig)
+		if err != nil {
+			return nil, errors.Wrapf(err, "stack validator: child %v", i)
+		}
+		validators = append(validators, validator)
+	}
+	return &stackValidator{validators: validators}, nil
+}
+
+func (v *stackValidator) ValidateTx(state tree.Node, tx *Tx) error {
+	for _, validator := range v.validators {
+		err := validator.ValidateTx(state, tx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package redwood
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+
+	"redwood.dev/tree"
+	"redwood.dev/types"
+)
+
+func TestStackValidator(t *testing.T) {
+	alice := types.AddressFromBytes([]byte("alice"))
+	bob := types.AddressFromBytes([]byte("bob"))
+
+	config := stackTestConfig(t, map[string]interface{}{
+		"children": []interface{}{
+			map[string]interface{}{
+				"Content-Type": "validator/permissions",
+				"value": map[string]interface{}{
+					alice.Hex(): map[string]interface{}{"^.*$": map[string]interface{}{"write": true}},
+					bob.Hex():   map[string]interface{}{"^\\.public": map[string]interface{}{"write": true}},
+				},
+			},
+			map[string]interface{}{
+				"Content-Type": "validator/js",
+				"value": map[string]interface{}{
+					"src": `function validate(state, tx) {
+						for (const patch of tx.patches) {
+							if (patch.keys[0] === 'locked') {
+								throw new Error("locked")
+							}
+						}
+					}`,
+				},
+			},
+		},
+	})
+
+	validator, err := NewStackValidator(config)
+	require.NoError(t, err)
+
+	state := tree.NewMemoryNode()
+	validate := func(from types.Address, patchStr string) error {
+		return validator.ValidateTx(state, &Tx{From: from, Patches: mustParsePatches(t, patchStr)})
+	}
+
+	require.NoError(t, validate(alice, `.a = 1`))
+	require.NoError(t, validate(bob, `.public.a = 1`))
+
+	// A tx has to get past every child
+	err = validate(bob, `.a = 1`)
+	require.Equal(t, types.Err403, errors.Cause(err))
+	err = validate(alice, `.locked = 1`)
+	require.Equal(t, ErrInvalidTx, errors.Cause(err))
+
+	_, err = NewStackValidator(stackTestConfig(t, map[string]interface{}{
+		"children": []interface{}{
+			map[string]interface{}{"Content-Type": "validator/nonexistent"},
+		},
+	}))
+	require.Error(t, err)
+}
@@ -0,0 +1,54 @@
+package redwood
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"redwood.dev/tree"
+	"redwood.dev/utils"
+)
+
+type wasmValidator struct {
+	module *wasmModule
+}
+
+// Ensure wasmValidator conforms to the Validator interface
+var _ Validator = (*wasmValidator)(nil)
+
+// NewWASMValidator loads a validator from the WebAssembly module in the
+// config's 'src' param.  See wasmModule for the host ABI.  The module must
+// export:
+//
+//	validate_tx(tx_ptr, tx_len i32) i32
+//	    Called with the JSON-encoded tx.  The state is readable via
+//	    state_get.  Returns 0 if the tx is valid.  A nonzero return value
+//	    or a call to set_error rejects the tx.
+func NewWASMValidator(config tree.Node) (_ Validator, err error) {
+	defer utils.Annotate(&err, "NewWASMValidator")
+
+	module, err := newWASMModule("validator", config)
+	if err != nil {
+		return nil, err
+	}
+	if !module.hasExport("validate_tx") {
+		module.Close()
+		return nil, errors.New("wasm validator must export a 'validate_tx' function")
+	}
+	return &wasmValidator{module: module}, nil
+}
+
+func (v *wasmValidator) ValidateTx(state tree.Node, tx *Tx) error {
+	txJSON, err := json.Marshal(tx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	ret, err := v.module.call("validate_tx", state, true, txJSON)
+	if err != nil {
+		return err
+	} else if ret != 0 {
+		return errors.Errorf("wasm validator rejected tx (code %v)", ret)
+	}
+	return nil
+}
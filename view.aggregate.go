@@ -0,0 +1,241 @@
+package redwood
+
+import (
+	"reflect"
+
+	"github.com/pkg/errors"
+
+	"redwood.dev/tree"
+)
+
+// aggregateView computes a count or a sum over the children of its source
+// (the values of a map, or the elements of a slice), optionally grouped by
+// the value at a keypath inside of each child:
+//
+//	"Views": {
+//	    "unreadCount": {
+//	        "Content-Type": "view/count",
+//	        "source": "messages",
+//	        "where": {"read": false}
+//	    },
+//	    "totalsByUser": {
+//	        "Content-Type": "view/group-by",
+//	        "source": "payments",
+//	        "groupBy": "user",
+//	        "aggregate": "sum",
+//	        "field": "amount"
+//	    }
+//	}
+//
+// Only children whose values at each of the keypaths in 'where' are equal to
+// the given values are included.  Sums skip children whose 'field' isn't a
+// number, and group-by views skip children whose 'groupBy' value isn't a
+// string.
+type aggregateView struct {
+	aggregate string
+	field     tree.Keypath
+	groupBy   tree.Keypath
+	where     map[string]interface{}
+}
+
+const (
+	aggregateViewCount = "count"
+	aggregateViewSum   = "sum"
+)
+
+// Ensure aggregateView conforms to the View and IncrementalView interfaces
+var _ View = (*aggregateView)(nil)
+var _ IncrementalView = (*aggregateView)(nil)
+
+func NewCountView(config tree.Node) (View, error) {
+	return newAggregateView(config, aggregateViewCount, false)
+}
+
+func NewSumView(config tree.Node) (View, error) {
+	return newAggregateView(config, aggregateViewSum, false)
+}
+
+func NewGroupByView(config tree.Node) (View, error) {
+	aggregate, exists, err := config.StringValue(tree.Keypath("aggregate"))
+	if err != nil {
+		return nil, err
+	} else if !exists {
+		aggregate = aggregateViewCount
+	}
+	return newAggregateView(config, aggregate, true)
+}
+
+func newAggregateView(config tree.Node, aggregate string, grouped bool) (View, error) {
+	view := &aggregateView{aggregate: aggregate}
+
+	switch aggregate {
+	case aggregateViewCount:
+	case aggregateViewSum:
+		field, exists, err := config.StringValue(tree.Keypath("field"))
+		if err != nil {
+			return nil, err
+		} else if !exists || field == "" {
+			return nil, errors.New("sum view needs a 'field' param")
+		}
+		view.field = tree.Keypath(field)
+	default:
+		return nil, errors.Errorf("unknown aggregate '%v' (must be '%v' or '%v')", aggregate, aggregateViewCount, aggregateViewSum)
+	}
+
+	if grouped {
+		groupBy, exists, err := config.StringValue(tree.Keypath("groupBy"))
+		if err != nil {
+			return nil, err
+		} else if !exists || groupBy == "" {
+			return nil, errors.New("group-by view needs a 'groupBy' param")
+		}
+		view.groupBy = tree.Keypath(groupBy)
+	}
+
+	whereVal, exists, err := config.Value(tree.Keypath("where"), nil)
+	if err != nil {
+		return nil, err
+	} else if exists {
+		where, isMap := whereVal.(map[string]interface{})
+		if !isMap {
+			return nil, errors.Errorf("view's 'where' param must be an object (got %T)", whereVal)
+		}
+		view.where = where
+	}
+	return view, nil
+}
+
+func (v *aggregateView) ComputeView(source tree.Node) (interface{}, error) {
+	sourceVal, _, err := source.Value(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var children []interface{}
+	switch sourceVal := sourceVal.(type) {
+	case map[string]interface{}:
+		for _, child := range sourceVal {
+			children = append(children, child)
+		}
+	case []interface{}:
+		children = sourceVal
+	}
+
+	groups := make(map[string]interface{})
+	var total float64
+	for _, child := range children {
+		group, n, included := v.contribution(child)
+		if !included {
+			continue
+		} else if v.groupBy == nil {
+			total += n
+			continue
+		}
+		sum, _ := groups[group].(float64)
+		groups[group] = sum + n
+	}
+
+	if v.groupBy != nil {
+		return groups, nil
+	}
+	return total, nil
+}
+
+// UpdateView takes the old values of the changed children back out of the
+// aggregate and adds their new values.  A group-by sum can't tell whether a
+// group that comes to 0 still has any children, so that case is left to
+// ComputeView.
+func (v *aggregateView) UpdateView(current interface{}, old, new map[string]interface{}) (interface{}, bool) {
+	if v.groupBy == nil {
+		total, isNumber := numberValue(current)
+		if !isNumber {
+			return nil, false
+		}
+		for _, child := range old {
+			if _, n, included := v.contribution(child); included {
+				total -= n
+			}
+		}
+		for _, child := range new {
+			if _, n, included := v.contribution(child); included {
+				total += n
+			}
+		}
+		return total, true
+	}
+
+	currentGroups, isMap := current.(map[string]interface{})
+	if !isMap {
+		return nil, false
+	}
+	groups := make(map[string]interface{}, len(currentGroups))
+	for group, sum := range currentGroups {
+		groups[group] = sum
+	}
+	for _, child := range old {
+		group, n, included := v.contribution(child)
+		if !included {
+			continue
+		}
+		sum, isNumber := numberValue(groups[group])
+		if !isNumber {
+			return nil, false
+		}
+		groups[group] = sum - n
+	}
+	for _, child := range new {
+		group, n, included := v.contribution(child)
+		if !included {
+			continue
+		}
+		sum, _ := numberValue(groups[group])
+		groups[group] = sum + n
+	}
+	for group, sum := range groups {
+		if sum, _ := numberValue(sum); sum != 0 {
+			continue
+		} else if v.aggregate != aggregateViewCount {
+			return nil, false
+		}
+		delete(groups, group)
+	}
+	return groups, true
+}
+
+// contribution returns what a child adds to the aggregate (and to which
+// group), or false if it isn't included.
+func (v *aggregateView) contribution(child interface{}) (group string, n float64, included bool) {
+	if !v.matches(child) {
+		return "", 0, false
+	}
+
+	n = 1
+	if v.aggregate == aggregateViewSum {
+		val, _ := getValue(child, v.field.PartStrings())
+		var isNumber bool
+		n, isNumber = numberValue(val)
+		if !isNumber {
+			return "", 0, false
+		}
+	}
+
+	if v.groupBy != nil {
+		groupVal, _ := getValue(child, v.groupBy.PartStrings())
+		var isString bool
+		group, isString = groupVal.(string)
+		if !isString {
+			return "", 0, false
+		}
+	}
+	return group, n, true
+}
+
+func (v *aggregateView) matches(child interface{}) bool {
+	for keypath, expected := range v.where {
+		val, exists := getValue(child, tree.Keypath(keypath).PartStrings())
+		if !exists || !reflect.DeepEqual(val, expected) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+package redwood
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+
+	"github.com/pkg/errors"
+	"rogchap.com/v8go"
+
+	"redwood.dev/nelson"
+	"redwood.dev/tree"
+	"redwood.dev/utils"
+)
+
+type jsView struct {
+	mu sync.Mutex
+	vm *v8go.Context
+}
+
+// Ensure jsView conforms to the View interface
+var _ View = (*jsView)(nil)
+
+// NewJSView loads a view from the Javascript in the config's 'src' param.
+// The script must define a function:
+//
+//	computeView(source)
+//	    Called with the current value of the view's source.  Its return
+//	    value becomes the value of the view.  Returning undefined or null
+//	    removes the view's value.
+func NewJSView(config tree.Node) (_ View, err error) {
+	defer utils.Annotate(&err, "NewJSView")
+
+	srcval, exists, err := nelson.GetValueRecursive(config, tree.Keypath("src"), nil)
+	if err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, errors.Errorf("js view needs a 'src' param")
+	}
+
+	readableSrc, ok := nelson.GetReadCloser(srcval)
+	if !ok {
+		return nil, errors.Errorf("js view needs a 'src' param of type string, []byte, or io.ReadCloser (got %T)", srcval)
+	}
+	defer readableSrc.Close()
+
+	srcStr, err := ioutil.ReadAll(readableSrc)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	v8ctx, _ := v8go.NewContext(nil)
+
+	_, err = v8ctx.RunScript("var global = {}; "+string(srcStr), "view.js")
+	if err != nil {
+		return nil, err
+	}
+
+	isFunc, err := v8ctx.RunScript("typeof computeView === 'function'", "")
+	if err != nil {
+		return nil, err
+	} else if isFunc.String() != "true" {
+		return nil, errors.New("js view must define a 'computeView' function")
+	}
+	return &jsView{vm: v8ctx}, nil
+}
+
+func (v *jsView) ComputeView(source tree.Node) (interface{}, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	sourceJSON, err := json.Marshal(source)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	result, err := v.vm.RunScript("JSON.stringify(computeView("+string(sourceJSON)+"))", "")
+	if err != nil {
+		return nil, err
+	}
+
+	switch result.String() {
+	case "undefined", "null":
+		return nil, nil
+	}
+
+	var val interface{}
+	err = json.Unmarshal([]byte(result.String()), &val)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return val, nil
+}
@@ -0,0 +1,154 @@
+package redwood
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"sync"
+
+	"github.com/brynbellomy/go-luaconv"
+	"github.com/pkg/errors"
+	lua "github.com/yuin/gopher-lua"
+
+	"redwood.dev/nelson"
+	"redwood.dev/tree"
+	"redwood.dev/utils"
+)
+
+type luaView struct {
+	mu sync.Mutex
+	L  *lua.LState
+}
+
+// Ensure luaView conforms to the View interface
+var _ View = (*luaView)(nil)
+
+// NewLuaView loads a view from the Lua in the config's 'src' param.  The
+// script must define a function:
+//
+//	compute_view(source)
+//	    Called with the current value of the view's source as a table.  Its
+//	    return value becomes the value of the view.  Returning nil removes
+//	    the view's value.
+func NewLuaView(config tree.Node) (_ View, err error) {
+	defer utils.Annotate(&err, "NewLuaView")
+
+	srcval, exists, err := nelson.GetValueRecursive(config, tree.Keypath("src"), nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	} else if !exists {
+		return nil, errors.Errorf("lua view needs a 'src' param")
+	}
+
+	readableSrc, ok := nelson.GetReadCloser(srcval)
+	if !ok {
+		return nil, errors.Errorf("lua view needs a 'src' param of type string, []byte, or io.ReadCloser (got %T)", srcval)
+	}
+	defer readableSrc.Close()
+
+	srcStr, err := ioutil.ReadAll(readableSrc)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	L := lua.NewState()
+	err = L.DoString(string(srcStr))
+	if err != nil {
+		L.Close()
+		return nil, err
+	}
+
+	if L.GetGlobal("compute_view").Type() != lua.LTFunction {
+		L.Close()
+		return nil, errors.New("lua view must define a 'compute_view' function")
+	}
+	return &luaView{L: L}, nil
+}
+
+func (v *luaView) ComputeView(source tree.Node) (interface{}, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	// Round-trip through JSON so that the script gets plain tables
+	bs, err := json.Marshal(source)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var plain interface{}
+	err = json.Unmarshal(bs, &plain)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var luaSource lua.LValue = lua.LNil
+	if plain != nil {
+		luaSource, err = luaconv.Encode(v.L, reflect.ValueOf(plain))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	err = v.L.CallByParam(lua.P{
+		Fn:      v.L.GetGlobal("compute_view"),
+		NRet:    1,
+		Protect: true,
+	}, luaSource)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	retval := v.L.Get(-1)
+	v.L.Pop(1)
+	return luaViewValue(retval)
+}
+
+// luaViewValue converts a value returned by a Lua view into a plain Go value.
+// Tables whose keys are exactly 1..n become slices, and other tables become
+// maps.
+func luaViewValue(lv lua.LValue) (interface{}, error) {
+	switch lv := lv.(type) {
+	case *lua.LNilType:
+		return nil, nil
+	case lua.LBool:
+		return bool(lv), nil
+	case lua.LNumber:
+		return float64(lv), nil
+	case lua.LString:
+		return string(lv), nil
+	case *lua.LTable:
+		n := lv.MaxN()
+		var numKeys int
+		lv.ForEach(func(lua.LValue, lua.LValue) { numKeys++ })
+
+		if n > 0 && n == numKeys {
+			slice := make([]interface{}, n)
+			for i := 0; i < n; i++ {
+				val, err := luaViewValue(lv.RawGetInt(i + 1))
+				if err != nil {
+					return nil, err
+				}
+				slice[i] = val
+			}
+			return slice, nil
+		}
+
+		m := make(map[string]interface{}, numKeys)
+		var err error
+		lv.ForEach(func(key lua.LValue, val lua.LValue) {
+			if err != nil {
+				return
+			}
+			keyStr, isString := key.(lua.LString)
+			if !isString {
+				err = errors.Errorf("lua view returned a table with a non-string key (%v)", key.Type())
+				return
+			}
+			m[string(keyStr)], err = luaViewValue(val)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	default:
+		return nil, errors.Errorf("lua view returned a value of unsupported type %v", lv.Type())
+	}
+}
@@ -0,0 +1,342 @@
+package redwood
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+
+	"redwood.dev/ctx"
+	"redwood.dev/nelson"
+	"redwood.dev/tree"
+)
+
+// wasmModule runs a WebAssembly module that implements one of the behavior
+// types (resolver, validator or indexer).  Modules are executed by wazero,
+// which is pure Go, so no cgo toolchain is needed to use them.
+//
+// # Host ABI
+//
+// Strings and JSON blobs are passed as (ptr, len) pairs of i32s that point
+// into the module's exported "memory".  When the host needs to hand the module
+// a buffer, it calls the module's exported `alloc(len i32) i32`.  Buffers
+// returned by the module (or by the host to the module) are packed into a
+// single i64 as (ptr << 32 | len).  A packed value of 0 means "nothing".
+//
+// Keypaths are "/"-separated and relative to the node that the behavior is
+// attached to.  The empty keypath refers to the node itself.
+//
+// The host provides these imports in the "redwood" namespace:
+//
+//	state_get(keypath_ptr, keypath_len i32) i64
+//	    Returns the JSON encoding of the value at the keypath, or 0 if there
+//	    isn't one.
+//	state_set(keypath_ptr, keypath_len, json_ptr, json_len i32) i32
+//	    Sets the keypath to the given JSON value.  Returns 0 on success.
+//	state_delete(keypath_ptr, keypath_len i32) i32
+//	    Deletes the keypath.  Returns 0 on success.
+//	set_error(msg_ptr, msg_len i32)
+//	    Fails the current call with the given message.
+//	log(msg_ptr, msg_len i32)
+//	    Writes a message to the node's log.
+//
+// state_set and state_delete fail when called from a validator or indexer.
+// Each call into the module has to return within wasmCallTimeout.  A module
+// that runs past it is closed, so the behavior fails every call after that.
+// wasi_snapshot_preview1 is also provided so that modules built with TinyGo or
+// Rust's wasm32-wasi target run unmodified.  If the module exports
+// `_initialize`, it's called once after instantiation.
+//
+// The exports required by each behavior type are described on
+// NewWASMResolver, NewWASMValidator and NewWASMIndexer.
+type wasmModule struct {
+	ctx.Logger
+	runtime wazero.Runtime
+	module  api.Module
+
+	mu       sync.Mutex
+	state    tree.Node
+	readOnly bool
+	callErr  error
+}
+
+// wasmCallTimeout bounds how long a single call into a module can run.
+var wasmCallTimeout = 10 * time.Second
+
+func newWASMModule(behaviorName string, config tree.Node) (*wasmModule, error) {
+	srcval, exists, err := nelson.GetValueRecursive(config, tree.Keypath("src"), nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	} else if !exists {
+		return nil, errors.Errorf("wasm %v needs a 'src' param", behaviorName)
+	}
+
+	readableSrc, ok := nelson.GetReadCloser(srcval)
+	if !ok {
+		return nil, errors.Errorf("wasm %v needs a 'src' param of type string, []byte, or io.ReadCloser (got %T)", behaviorName, srcval)
+	}
+	defer readableSrc.Close()
+
+	bytecode, err := ioutil.ReadAll(readableSrc)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	m := &wasmModule{
+		Logger:  ctx.NewLogger("wasm:" + behaviorName),
+		runtime: wazero.NewRuntimeWithConfig(context.Background(), wazero.NewRuntimeConfig().WithCloseOnContextDone(true)),
+	}
+
+	err = m.instantiate(bytecode)
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *wasmModule) instantiate(bytecode []byte) error {
+	ctx := context.Background()
+
+	_, err := wasi_snapshot_preview1.Instantiate(ctx, m.runtime)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = m.runtime.NewHostModuleBuilder("redwood").
+		NewFunctionBuilder().WithFunc(m.hostStateGet).Export("state_get").
+		NewFunctionBuilder().WithFunc(m.hostStateSet).Export("state_set").
+		NewFunctionBuilder().WithFunc(m.hostStateDelete).Export("state_delete").
+		NewFunctionBuilder().WithFunc(m.hostSetError).Export("set_error").
+		NewFunctionBuilder().WithFunc(m.hostLog).Export("log").
+		Instantiate(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	compiled, err := m.runtime.CompileModule(ctx, bytecode)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// _initialize is subject to the same time limit as every other call
+	initCtx, cancel := context.WithTimeout(ctx, wasmCallTimeout)
+	defer cancel()
+
+	m.module, err = m.runtime.InstantiateModule(initCtx, compiled, wazero.NewModuleConfig().WithStartFunctions("_initialize"))
+	if initCtx.Err() == context.DeadlineExceeded {
+		return errors.Errorf("wasm module's '_initialize' function timed out after %v", wasmCallTimeout)
+	} else if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if m.module.Memory() == nil {
+		return errors.New("wasm module must export its memory")
+	} else if m.module.ExportedFunction("alloc") == nil {
+		return errors.New("wasm module must export an 'alloc' function")
+	}
+	return nil
+}
+
+func (m *wasmModule) Close() {
+	err := m.runtime.Close(context.Background())
+	if err != nil {
+		m.Errorf("error closing wasm runtime: %v", err)
+	}
+}
+
+func (m *wasmModule) hasExport(name string) bool {
+	return m.module.ExportedFunction(name) != nil
+}
+
+// call invokes the named export with a single (ptr, len) argument containing
+// `input`.  The state node is made available to the module's state_* imports
+// for the duration of the call.
+func (m *wasmModule) call(name string, state tree.Node, readOnly bool, input []byte) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fn := m.module.ExportedFunction(name)
+	if fn == nil {
+		return 0, errors.Errorf("wasm module does not export '%v'", name)
+	}
+
+	m.state = state
+	m.readOnly = readOnly
+	m.callErr = nil
+	defer func() {
+		m.state = nil
+		m.callErr = nil
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), wasmCallTimeout)
+	defer cancel()
+
+	var params []uint64
+	if input != nil {
+		ptr, err := m.writeBuffer(ctx, m.module, input)
+		if err != nil {
+			return 0, err
+		}
+		params = []uint64{uint64(ptr), uint64(len(input))}
+	}
+
+	results, err := fn.Call(ctx, params...)
+	if ctx.Err() == context.DeadlineExceeded {
+		return 0, errors.Errorf("wasm module's '%v' function timed out after %v", name, wasmCallTimeout)
+	} else if err != nil {
+		return 0, errors.WithStack(err)
+	} else if m.callErr != nil {
+		return 0, m.callErr
+	}
+
+	if len(results) == 0 {
+		return 0, nil
+	}
+	return results[0], nil
+}
+
+// readPacked copies a (ptr << 32 | len) buffer out of the module's memory.
+func (m *wasmModule) readPacked(packed uint64) ([]byte, error) {
+	if packed == 0 {
+		return nil, nil
+	}
+	ptr, length := uint32(packed>>32), uint32(packed)
+	bs, ok := m.module.Memory().Read(ptr, length)
+	if !ok {
+		return nil, errors.Errorf("wasm module returned out of bounds buffer (ptr=%v len=%v)", ptr, length)
+	}
+	return append([]byte(nil), bs...), nil
+}
+
+func (m *wasmModule) writeBuffer(ctx context.Context, mod api.Module, bs []byte) (uint32, error) {
+	results, err := mod.ExportedFunction("alloc").Call(ctx, uint64(len(bs)))
+	if err != nil {
+		return 0, errors.WithStack(err)
+	} else if len(results) == 0 {
+		return 0, errors.New("wasm module's 'alloc' function did not return a pointer")
+	}
+	ptr := uint32(results[0])
+	if !mod.Memory().Write(ptr, bs) {
+		return 0, errors.Errorf("wasm module's 'alloc' function returned out of bounds pointer (ptr=%v len=%v)", ptr, len(bs))
+	}
+	return ptr, nil
+}
+
+func (m *wasmModule) readString(mod api.Module, ptr, length uint32) (string, bool) {
+	bs, ok := mod.Memory().Read(ptr, length)
+	if !ok {
+		return "", false
+	}
+	return string(bs), true
+}
+
+func (m *wasmModule) fail(err error) {
+	if m.callErr == nil {
+		m.callErr = err
+	}
+}
+
+func (m *wasmModule) hostStateGet(ctx context.Context, mod api.Module, keypathPtr, keypathLen uint32) uint64 {
+	if m.state == nil {
+		m.fail(errors.New("state_get called outside of a behavior call"))
+		return 0
+	}
+	keypath, ok := m.readString(mod, keypathPtr, keypathLen)
+	if !ok {
+		m.fail(errors.New("state_get: keypath out of bounds"))
+		return 0
+	}
+
+	val, exists, err := m.state.Value(tree.Keypath(keypath), nil)
+	if err != nil {
+		m.fail(err)
+		return 0
+	} else if !exists {
+		return 0
+	}
+
+	bs, err := json.Marshal(val)
+	if err != nil {
+		m.fail(errors.WithStack(err))
+		return 0
+	}
+	ptr, err := m.writeBuffer(ctx, mod, bs)
+	if err != nil {
+		m.fail(err)
+		return 0
+	}
+	return uint64(ptr)<<32 | uint64(len(bs))
+}
+
+func (m *wasmModule) hostStateSet(ctx context.Context, mod api.Module, keypathPtr, keypathLen, valPtr, valLen uint32) uint32 {
+	if m.state == nil || m.readOnly {
+		m.fail(errors.New("state_set is not permitted here"))
+		return 1
+	}
+	keypath, ok := m.readString(mod, keypathPtr, keypathLen)
+	if !ok {
+		m.fail(errors.New("state_set: keypath out of bounds"))
+		return 1
+	}
+	valJSON, ok := mod.Memory().Read(valPtr, valLen)
+	if !ok {
+		m.fail(errors.New("state_set: value out of bounds"))
+		return 1
+	}
+
+	var val interface{}
+	err := json.Unmarshal(valJSON, &val)
+	if err != nil {
+		m.fail(errors.WithStack(err))
+		return 1
+	}
+
+	err = m.state.Set(tree.Keypath(keypath), nil, val)
+	if err != nil {
+		m.fail(err)
+		return 1
+	}
+	return 0
+}
+
+func (m *wasmModule) hostStateDelete(ctx context.Context, mod api.Module, keypathPtr, keypathLen uint32) uint32 {
+	if m.state == nil || m.readOnly {
+		m.fail(errors.New("state_delete is not permitted here"))
+		return 1
+	}
+	keypath, ok := m.readString(mod, keypathPtr, keypathLen)
+	if !ok {
+		m.fail(errors.New("state_delete: keypath out of bounds"))
+		return 1
+	}
+
+	err := m.state.Delete(tree.Keypath(keypath), nil)
+	if err != nil {
+		m.fail(err)
+		return 1
+	}
+	return 0
+}
+
+func (m *wasmModule) hostSetError(ctx context.Context, mod api.Module, msgPtr, msgLen uint32) {
+	msg, ok := m.readString(mod, msgPtr, msgLen)
+	if !ok {
+		msg = "wasm module reported an error (message out of bounds)"
+	}
+	m.fail(errors.New(msg))
+}
+
+func (m *wasmModule) hostLog(ctx context.Context, mod api.Module, msgPtr, msgLen uint32) {
+	msg, ok := m.readString(mod, msgPtr, msgLen)
+	if !ok {
+		return
+	}
+	m.Infof(0, "%v", msg)
+}
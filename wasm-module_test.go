@@ -0,0 +1,91 @@
+package redwood
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"redwood.dev/tree"
+	"redwood.dev/types"
+)
+
+// wasmTestConfig returns a behavior config for testdata/wasm-behaviors.wasm,
+// which implements both a resolver and a validator (see
+// testdata/wasm-behaviors.wat).
+func wasmTestConfig(t *testing.T) tree.Node {
+	t.Helper()
+
+	bytecode, err := ioutil.ReadFile("testdata/wasm-behaviors.wasm")
+	require.NoError(t, err)
+
+	config := tree.NewMemoryNode()
+	err = config.Set(tree.Keypath("src"), nil, string(bytecode))
+	require.NoError(t, err)
+	return config
+}
+
+func TestWASMResolver(t *testing.T) {
+	resolver, err := NewWASMResolver(wasmTestConfig(t), nil)
+	require.NoError(t, err)
+
+	state := tree.NewMemoryNode()
+	err = state.Set(tree.Keypath("in"), nil, map[string]interface{}{"x": 1.0})
+	require.NoError(t, err)
+
+	// The module copies "in" to "out" using state_get and state_set
+	err = resolver.ResolveState(state, nil, types.Address{}, types.IDFromString("one"), nil, nil)
+	require.NoError(t, err)
+	val, _, err := state.Value(tree.Keypath("out"), nil)
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"x": 1.0}, val)
+}
+
+func TestWASMValidator(t *testing.T) {
+	validator, err := NewWASMValidator(wasmTestConfig(t))
+	require.NoError(t, err)
+
+	tx := &Tx{Patches: mustParsePatches(t, `.a = 1`)}
+
+	state := tree.NewMemoryNode()
+	require.NoError(t, validator.ValidateTx(state, tx))
+
+	// The module rejects the tx with set_error
+	err = state.Set(tree.Keypath("locked"), nil, true)
+	require.NoError(t, err)
+	err = validator.ValidateTx(state, tx)
+	require.EqualError(t, err, "locked")
+
+	// Validators can't write to the state
+	state = tree.NewMemoryNode()
+	err = state.Set(tree.Keypath("write"), nil, true)
+	require.NoError(t, err)
+	err = validator.ValidateTx(state, tx)
+	require.EqualError(t, err, "state_set is not permitted here")
+	exists, err := state.Exists(tree.Keypath("written"))
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
+func TestWASMModule_CallTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { wasmCallTimeout = timeout }(wasmCallTimeout)
+	wasmCallTimeout = 100 * time.Millisecond
+
+	resolver, err := NewWASMResolver(wasmTestConfig(t), nil)
+	require.NoError(t, err)
+
+	state := tree.NewMemoryNode()
+	err = state.Set(tree.Keypath("spin"), nil, true)
+	require.NoError(t, err)
+
+	err = resolver.ResolveState(state, nil, types.Address{}, types.IDFromString("one"), nil, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "timed out")
+
+	// The module was closed, so it can't be called again
+	err = state.Delete(tree.Keypath("spin"), nil)
+	require.NoError(t, err)
+	err = resolver.ResolveState(state, nil, types.Address{}, types.IDFromString("two"), nil, nil)
+	require.Error(t, err)
+}